@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var cacheVerifyFlags struct {
+	purge bool
+	json  bool
+}
+
+var cacheMigrateFlags struct {
+	json bool
+}
+
+var cacheTrimFlags struct {
+	json bool
+}
+
+var cacheRestoreFlags struct {
+	json bool
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and maintain the cache of encrypted and decrypted values.",
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:                   "verify [file|directory]...",
+	Short:                 "Check that cached plaintexts are still what the provider decrypts their values to.",
+	Long:                  "For each encrypted value in the files that has a cached plaintext, check that the provider still decrypts the value to that plaintext, reporting any stale entries, eg. from an old key. Useful for diagnosing the wrong plaintext being decrypted. Exits with a non-zero status if any stale entries are found, unless --purge is set, in which case they're removed from the cache. Supplying no args will check all encrypted files in the repo.",
+	Args:                  cobra.ArbitraryArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return CacheVerify(os.Stdout, args, cacheVerifyFlags.purge, cacheVerifyFlags.json)
+	},
+}
+
+func CacheVerify(stdout io.Writer, args []string, purge bool, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	if len(args) == 0 {
+		args = []string{config.Root}
+	}
+	files := []*actions.File{}
+	for _, arg := range args {
+		var paths []string
+		if info, err := os.Stat(arg); !os.IsNotExist(err) && info.IsDir() {
+			paths, err = config.AllEncryptedFiles(arg)
+			if err != nil {
+				return err
+			}
+		} else {
+			paths = []string{arg}
+		}
+		for _, path := range paths {
+			file, err := actions.NewFile(path, &config)
+			if err != nil {
+				return err
+			}
+			files = append(files, &file)
+		}
+	}
+	stale, err := actions.VerifyCache(files, purge, cache, &config.Provider, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		err = printJSON(stdout, stale)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, entry := range stale {
+			fmt.Fprintf(stdout, "stale: %s: %s: %s\n", entry.File, entry.Path, entry.Message)
+		}
+		if purge && len(stale) > 0 {
+			fmt.Fprintf(stdout, "purged %d stale entries\n", len(stale))
+		}
+	}
+	if len(stale) > 0 && !purge {
+		return errors.New("Stale cache entries found")
+	}
+	return nil
+}
+
+var cacheMigrateCmd = &cobra.Command{
+	Use:                   "migrate",
+	Short:                 "Rewrite the cache under the current key scheme, after upgrading yaml-crypt.",
+	Long:                  "Rewrite the cache's entries under the scheme that the current version of yaml-crypt hashes cache keys with, if it was written with another, eg. by an older version. Until it's migrated, such a cache isn't used at all. Only the latest ciphertext of each plaintext can be migrated; other entries are dropped. A cache written with a scheme this version doesn't know is purged instead.",
+	Args:                  cobra.NoArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return CacheMigrate(os.Stdout, cacheMigrateFlags.json)
+	},
+}
+
+func CacheMigrate(stdout io.Writer, asJSON bool) error {
+	config, _, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	migration, err := cache.Migrate(config)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, migration)
+	}
+	switch {
+	case migration.Purged:
+		fmt.Fprintf(stdout, "purged cache written with unknown key scheme %s\n", migration.From)
+	case migration.From == migration.To:
+		fmt.Fprintf(stdout, "cache already uses key scheme %s\n", migration.To)
+	default:
+		fmt.Fprintf(stdout, "migrated %d entries from key scheme %s to %s, dropped %d\n", migration.Migrated, migration.From, migration.To, migration.Dropped)
+	}
+	return nil
+}
+
+var cacheTrimCmd = &cobra.Command{
+	Use:                   "trim",
+	Short:                 "Reclaim space used by the cache now, rather than when a command next exits.",
+	Long:                  "Compact the cache, and if it's still bigger than cache.maxSize, start a new cache generation, dropping the oldest one, just as happens when a command exits. Useful for reclaiming space from a cache that's grown big in the meantime, eg. on a long-lived machine, or before copying the repo.",
+	Args:                  cobra.NoArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return CacheTrim(os.Stdout, cacheTrimFlags.json)
+	},
+}
+
+func CacheTrim(stdout io.Writer, asJSON bool) error {
+	config, _, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	report, err := cache.Trim()
+	if err != nil {
+		return err
+	}
+	err = cache.Close()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, report)
+	}
+	if report.Rotated {
+		fmt.Fprintf(stdout, "compacted cache from %d to %d bytes, over the max of %d, and started a new generation\n", report.Size, report.Merged, report.MaxSize)
+	} else {
+		fmt.Fprintf(stdout, "compacted cache from %d to %d bytes, within the max of %d\n", report.Size, report.Merged, report.MaxSize)
+	}
+	return nil
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:                   "export <artifact>",
+	Short:                 "Write the cache to an artifact, eg. to be restored by a later CI run.",
+	Long:                  "Write every entry of the cache to a single file, along with the fingerprint of the provider and keys they were cached under, so that it can be saved, eg. as a CI cache keyed by a hash of the encrypted files, and restored on a fresh checkout with `yaml-crypt cache restore`. The artifact holds plaintexts, just like the cache, so keep it as safe as the secrets themselves.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return CacheExport(os.Stdout, args[0])
+	},
+}
+
+func CacheExport(stdout io.Writer, path string) error {
+	config, _, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	count, err := cache.Export(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "exported %d entries to %s\n", count, path)
+	return cache.Close()
+}
+
+var cacheRestoreCmd = &cobra.Command{
+	Use:                   "restore <artifact>",
+	Short:                 "Add the entries of an artifact written by `cache export` to the cache.",
+	Long:                  "Add the entries of an artifact written by `yaml-crypt cache export` to the cache, eg. on a fresh CI runner, so that values decrypted by an earlier run don't go through the provider again. Entries the cache already has are kept. The artifact is only restored if it was exported under the provider and keys that are configured now, and by a version of yaml-crypt that hashes cache keys the same way.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return CacheRestore(os.Stdout, args[0], cacheRestoreFlags.json)
+	},
+}
+
+func CacheRestore(stdout io.Writer, path string, asJSON bool) error {
+	config, _, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	report, err := cache.Restore(f)
+	if err != nil {
+		return fmt.Errorf("Error restoring %s: %w", path, err)
+	}
+	err = cache.Close()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, report)
+	}
+	fmt.Fprintf(stdout, "restored %d entries, %d already cached\n", report.Restored, report.Skipped)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
+	cacheCmd.AddCommand(cacheMigrateCmd)
+	cacheCmd.AddCommand(cacheTrimCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheRestoreCmd)
+	cacheMigrateCmd.Flags().BoolVarP(&cacheMigrateFlags.json, "json", "", false, "print output as JSON")
+	cacheTrimCmd.Flags().BoolVarP(&cacheTrimFlags.json, "json", "", false, "print output as JSON")
+	cacheRestoreCmd.Flags().BoolVarP(&cacheRestoreFlags.json, "json", "", false, "print output as JSON")
+	cacheVerifyCmd.Flags().BoolVarP(&cacheVerifyFlags.purge, "purge", "", false, "remove stale entries from the cache")
+	cacheVerifyCmd.Flags().BoolVarP(&cacheVerifyFlags.json, "json", "", false, "print output as JSON")
+}
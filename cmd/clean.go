@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -20,7 +19,7 @@ var cleanCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		config, err := config.LoadConfig(".")
+		config, _, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
@@ -4,14 +4,22 @@ import (
 	"errors"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"os"
 )
 
 var DecryptFlags struct {
-	Stdout bool
-	Plain  bool
+	Stdout    bool
+	Plain     bool
+	Stream    bool
+	Identity  string
+	Verify    bool
+	WithPlain bool
+	SortKeys  bool
+	Resume    bool
+	InPlace   bool
 }
 
 var DecryptCmd = &cobra.Command{
@@ -22,14 +30,38 @@ var DecryptCmd = &cobra.Command{
 		if DecryptFlags.Stdout && len(args) != 1 {
 			return errors.New("requires exactly 1 arg when --stdout is set")
 		}
+		if DecryptFlags.Stream && !DecryptFlags.Stdout {
+			return errors.New("--stream requires --stdout")
+		}
+		if DecryptFlags.WithPlain && (DecryptFlags.Plain || DecryptFlags.Stdout) {
+			return errors.New("--with-plain can't be used with --plain or --stdout")
+		}
+		if DecryptFlags.SortKeys && DecryptFlags.Stream {
+			return errors.New("--sort-keys can't be used with --stream")
+		}
+		if DecryptFlags.Resume && DecryptFlags.Stdout {
+			return errors.New("--resume can't be used with --stdout")
+		}
+		if DecryptFlags.InPlace && (DecryptFlags.Stdout || DecryptFlags.Plain || DecryptFlags.WithPlain) {
+			return errors.New("--in-place can't be used with --stdout, --plain, or --with-plain")
+		}
 		return nil
 	},
 	DisableFlagsInUseLine: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := config.LoadConfig(".")
+		config, opts, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
+		if DecryptFlags.Identity != "" {
+			config.Provider, err = crypto.WithIdentity(config.Provider, DecryptFlags.Identity)
+			if err != nil {
+				return err
+			}
+			usedProviders = append(usedProviders, config.Provider)
+			// make sure every value actually gets decrypted with the given identity
+			config.CacheEnabled = false
+		}
 		cache, err := cache.Setup(config)
 		if err != nil {
 			return err
@@ -64,12 +96,50 @@ var DecryptCmd = &cobra.Command{
 				files = append(files, &file)
 			}
 		}
-		return actions.Decrypt(files, DecryptFlags.Plain, DecryptFlags.Stdout, &cache, &config.Provider, int(threads), progress)
+		opts.VerifyOutput = DecryptFlags.Verify
+		opts.SortKeys = DecryptFlags.SortKeys
+		if DecryptFlags.Resume {
+			operation := "decrypt"
+			if DecryptFlags.Plain {
+				operation = "decrypt-plain"
+			} else if DecryptFlags.WithPlain {
+				operation = "decrypt-with-plain"
+			} else if DecryptFlags.InPlace {
+				operation = "decrypt-in-place"
+			}
+			opts.ResumeManifest = resumeManifestPath(config, operation)
+		}
+		if DecryptFlags.Stream {
+			return actions.DecryptStream(files, os.Stdout, DecryptFlags.Plain, cache, &config.Provider, int(config.Threads), opts)
+		}
+		if DecryptFlags.InPlace {
+			return actions.DecryptInPlace(files, cache, &config.Provider, int(config.Threads), progress, opts)
+		}
+		if DecryptFlags.WithPlain {
+			return actions.DecryptBoth(files, cache, &config.Provider, int(config.Threads), progress, opts)
+		}
+		return actions.Decrypt(files, DecryptFlags.Plain, DecryptFlags.Stdout, cache, &config.Provider, int(config.Threads), progress, opts)
 	},
 }
 
+// Accept --key as an alias for --identity, eg. for the key printed by share.
+func keyAlias(flags *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "key" {
+		name = "identity"
+	}
+	return pflag.NormalizedName(name)
+}
+
 func init() {
 	rootCmd.AddCommand(DecryptCmd)
 	DecryptCmd.Flags().BoolVarP(&DecryptFlags.Stdout, "stdout", "s", false, "print to stdout instead of saving to file")
 	DecryptCmd.Flags().BoolVarP(&DecryptFlags.Plain, "plain", "p", false, "strip !secret tags from output yaml")
+	DecryptCmd.Flags().BoolVarP(&DecryptFlags.Stream, "stream", "", false, "with --stdout, print each top-level key as soon as its values are decrypted")
+	DecryptCmd.Flags().StringVarP(&DecryptFlags.Identity, "identity", "i", "", "decrypt using exactly the key or credentials in this file, bypassing the persistent cache (alias: --key)")
+	DecryptCmd.Flags().SetNormalizeFunc(keyAlias)
+	DecryptCmd.Flags().BoolVarP(&DecryptFlags.WithPlain, "with-plain", "", false, "write the plain version alongside the decrypted version, from a single decryption")
+	DecryptCmd.Flags().BoolVarP(&DecryptFlags.SortKeys, "sort-keys", "", false, "sort the keys of every mapping, for canonical, diff-friendly output; sequences keep their order")
+	DecryptCmd.Flags().BoolVarP(&DecryptFlags.InPlace, "in-place", "", false, "replace the encrypted values in each encrypted file with their decrypted values, rather than writing a decrypted version; encrypt them again with encrypt --in-place")
+	DecryptCmd.Flags().BoolVarP(&DecryptFlags.Resume, "resume", "", false, "record completed files, so that an interrupted run can be resumed by running it again with --resume, skipping the files it completed")
+	DecryptCmd.Flags().BoolVarP(&DecryptFlags.Verify, "verify", "", false, "fail if the decrypted yaml wouldn't read back with the same values, rather than writing a broken file")
 }
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"github.com/spf13/cobra"
 	"testing"
 )
 
@@ -50,3 +51,16 @@ func TestDecrypt(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyAlias(t *testing.T) {
+	defer func() { DecryptFlags.Identity, decryptValueFlags.identity = "", "" }()
+	for name, cmd := range map[string]*cobra.Command{"decrypt": DecryptCmd, "decrypt-value": decryptValueCmd} {
+		err := cmd.Flags().Parse([]string{"--key", "keyfile"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity, _ := cmd.Flags().GetString("identity"); identity != "keyfile" {
+			t.Errorf("%s --key set the identity to %q", name, identity)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/base64"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
@@ -13,6 +13,7 @@ import (
 )
 
 var decryptValueFlags struct {
+	identity string
 }
 
 var decryptValueCmd = &cobra.Command{
@@ -33,22 +34,30 @@ func DecryptValue(stdin io.Reader, stdout io.Writer) error {
 		return err
 	}
 	encodedCiphertext = strings.TrimSpace(encodedCiphertext)
-	ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+	ciphertext, err := yaml.EncryptedValue(encodedCiphertext).Ciphertext()
 	if err != nil {
 		return err
 	}
 	var plaintext string
-	func() error {
-		config, err := config.LoadConfig(".")
+	err = func() error {
+		config, opts, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
+		if decryptValueFlags.identity != "" {
+			config.Provider, err = crypto.WithIdentity(config.Provider, decryptValueFlags.identity)
+			if err != nil {
+				return err
+			}
+			usedProviders = append(usedProviders, config.Provider)
+			config.CacheEnabled = false
+		}
 		cache, err := cache.Setup(config)
 		if err != nil {
 			return err
 		}
 		defer cache.Close()
-		plaintext, err = actions.DecryptCiphertext(ciphertext, &cache, &config.Provider)
+		plaintext, err = actions.DecryptCiphertext(ciphertext, cache, &config.Provider, opts)
 		return err
 	}()
 	if err != nil {
@@ -61,4 +70,6 @@ func DecryptValue(stdin io.Reader, stdout io.Writer) error {
 
 func init() {
 	rootCmd.AddCommand(decryptValueCmd)
+	decryptValueCmd.Flags().StringVarP(&decryptValueFlags.identity, "identity", "i", "", "decrypt using exactly the key or credentials in this file, bypassing the persistent cache (alias: --key)")
+	decryptValueCmd.Flags().SetNormalizeFunc(keyAlias)
 }
@@ -3,7 +3,6 @@ package cmd
 import (
 	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
 	"github.com/google/shlex"
 	"github.com/spf13/cobra"
 	"os"
@@ -41,7 +40,7 @@ var editCmd = &cobra.Command{
 		}
 
 		// get file
-		config, err := config.LoadConfig(".")
+		config, opts, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
@@ -57,7 +56,7 @@ var editCmd = &cobra.Command{
 				return err
 			}
 			defer cache.Close()
-			return actions.Decrypt([]*actions.File{&file}, false, false, &cache, &config.Provider, int(threads), progress)
+			return actions.Decrypt([]*actions.File{&file}, false, false, cache, &config.Provider, int(config.Threads), progress, opts)
 		}()
 		if err != nil {
 			return err
@@ -82,7 +81,7 @@ var editCmd = &cobra.Command{
 		defer cache.Close()
 
 		// encrypt
-		err = actions.Encrypt([]*actions.File{&file}, &cache, &config.Provider, int(threads), progress)
+		err = actions.Encrypt([]*actions.File{&file}, cache, &config.Provider, int(config.Threads), progress, opts)
 		if err != nil {
 			return err
 		}
@@ -100,7 +99,7 @@ var editCmd = &cobra.Command{
 			return err
 		}
 		// update plain file
-		return actions.Decrypt([]*actions.File{&file}, true, false, &cache, &config.Provider, int(threads), progress)
+		return actions.Decrypt([]*actions.File{&file}, true, false, cache, &config.Provider, int(config.Threads), progress, opts)
 	},
 }
 
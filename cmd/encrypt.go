@@ -1,21 +1,45 @@
 package cmd
 
 import (
+	"errors"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
 	"github.com/spf13/cobra"
 	"os"
+	"strings"
 )
 
+var EncryptFlags struct {
+	Check        bool
+	Strict       bool
+	Stdin        bool
+	Resume       bool
+	InPlace      bool
+	ChangedSince string
+}
+
 var EncryptCmd = &cobra.Command{
-	Use:                   "encrypt [file|directory]...",
-	Short:                 "Encrypt one or more decrypted files in the repo, replacing the contents of the encrypted files.",
-	Long:                  "Encrypt one or more decrypted files in the repo, replacing the contents of the corresponding encrypted files. Each arg can refer to either a file, in which case the file will be encrypted, or a directory, in which case all files under the directory will be encrypted. File args can refer to encrypted, decrypted, or plain files, existant or non-existant, as long as the correponding decrypted file exists. Supplying no args will encrypt all decrypted files in the repo.",
-	Args:                  cobra.ArbitraryArgs,
+	Use:   "encrypt [file|directory]...",
+	Short: "Encrypt one or more decrypted files in the repo, replacing the contents of the encrypted files.",
+	Long:  "Encrypt one or more decrypted files in the repo, replacing the contents of the corresponding encrypted files. Each arg can refer to either a file, in which case the file will be encrypted, or a directory, in which case all files under the directory will be encrypted. File args can refer to encrypted, decrypted, or plain files, existant or non-existant, as long as the correponding decrypted file exists. Supplying no args will encrypt all decrypted files in the repo.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if EncryptFlags.Stdin && len(args) > 0 {
+			return errors.New("accepts no args when --stdin is set")
+		}
+		if EncryptFlags.Stdin && EncryptFlags.Resume {
+			return errors.New("--resume can't be used with --stdin")
+		}
+		if EncryptFlags.Stdin && EncryptFlags.InPlace {
+			return errors.New("--in-place can't be used with --stdin")
+		}
+		if EncryptFlags.ChangedSince != "" && (EncryptFlags.Stdin || EncryptFlags.InPlace) {
+			return errors.New("--changed-since can't be used with --stdin or --in-place")
+		}
+		return nil
+	},
 	DisableFlagsInUseLine: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := config.LoadConfig(".")
+		config, opts, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
@@ -24,6 +48,12 @@ var EncryptCmd = &cobra.Command{
 			return err
 		}
 		defer cache.Close()
+		if EncryptFlags.Stdin {
+			if strings.Contains(config.EncryptionContext, "{path}") {
+				return errors.New("--stdin can't be used with an encryptionContext containing {path}, since there's no file path")
+			}
+			return actions.EncryptStream(os.Stdin, os.Stdout, config.EncryptionContext, cache, &config.Provider, int(config.Threads), opts)
+		}
 		if len(args) == 0 {
 			args = []string{config.Root}
 		}
@@ -31,8 +61,12 @@ var EncryptCmd = &cobra.Command{
 		for _, arg := range args {
 			var paths []string
 			if info, err := os.Stat(arg); !os.IsNotExist(err) && info.IsDir() {
-				// if the arg is a dir, get all encrypted files in it
-				paths, err = config.AllDecryptedFiles(arg)
+				// if the arg is a dir, get all decrypted files in it, or the encrypted files, if they're what's encrypted
+				if EncryptFlags.InPlace {
+					paths, err = config.AllEncryptedFiles(arg)
+				} else {
+					paths, err = config.AllDecryptedFiles(arg)
+				}
 				if err != nil {
 					return err
 				}
@@ -48,10 +82,38 @@ var EncryptCmd = &cobra.Command{
 				files = append(files, &file)
 			}
 		}
-		return actions.Encrypt(files, &cache, &config.Provider, int(threads), progress)
+		if EncryptFlags.ChangedSince != "" {
+			files, err = actions.ChangedSince(files, config.Root, EncryptFlags.ChangedSince, cache, &config.Provider, int(config.Threads), opts)
+			if err != nil {
+				return err
+			}
+		}
+		opts.StrictPaths = EncryptFlags.Strict
+		if EncryptFlags.Resume {
+			operation := "encrypt"
+			if EncryptFlags.InPlace {
+				operation = "encrypt-in-place"
+			}
+			opts.ResumeManifest = resumeManifestPath(config, operation)
+		}
+		if EncryptFlags.InPlace {
+			err = actions.EncryptInPlace(files, cache, &config.Provider, int(config.Threads), progress, opts)
+		} else {
+			err = actions.Encrypt(files, cache, &config.Provider, int(config.Threads), progress, opts)
+		}
+		if err != nil || !EncryptFlags.Check {
+			return err
+		}
+		return actions.CheckEncrypted(files, config.SecretKeyPattern, opts)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(EncryptCmd)
+	EncryptCmd.Flags().BoolVarP(&EncryptFlags.Check, "check", "", false, "fail if any values that look like secrets were left unencrypted")
+	EncryptCmd.Flags().BoolVarP(&EncryptFlags.Strict, "strict", "", false, "fail if secrets were added to or removed from the decrypted files, rather than warning")
+	EncryptCmd.Flags().BoolVarP(&EncryptFlags.Resume, "resume", "", false, "record completed files, so that an interrupted run can be resumed by running it again with --resume, skipping the files it completed")
+	EncryptCmd.Flags().BoolVarP(&EncryptFlags.InPlace, "in-place", "", false, "encrypt files decrypted with decrypt --in-place, replacing their decrypted values with encrypted values again")
+	EncryptCmd.Flags().StringVarP(&EncryptFlags.ChangedSince, "changed-since", "", "", "only encrypt files changed since the given git ref, skipping the rest")
+	EncryptCmd.Flags().BoolVarP(&EncryptFlags.Stdin, "stdin", "", false, "read a decrypted document from stdin, and print the encrypted document to stdout")
 }
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEncryptCheck(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { EncryptFlags.Check = false }()
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, err := ioutil.ReadFile(config.ConfigFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptedPath := "check." + repo.Suffixes["decrypted"]
+		encryptedPath := "check." + repo.Suffixes["encrypted"]
+		err = ioutil.WriteFile(decryptedPath, []byte("db:\n  host: localhost\n  password: hunter2\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		EncryptFlags.Check = true
+		for _, c := range []struct {
+			encryptPaths string
+			shouldFail   bool
+		}{
+			{"[]", true},
+			{"[db.pasword]", true},
+			{"[db.password]", false},
+			{"[\"*.password\"]", false},
+		} {
+			err = ioutil.WriteFile(config.ConfigFilename, append(original, []byte("encryptPaths: "+c.encryptPaths+"\n")...), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = EncryptCmd.RunE(nil, []string{decryptedPath})
+			if c.shouldFail {
+				if err == nil {
+					t.Errorf("encrypt --check in repo %s with encryptPaths %s did not fail", repo, c.encryptPaths)
+				} else if !strings.Contains(err.Error(), "db.password") {
+					t.Errorf("encrypt --check in repo %s with encryptPaths %s did not name the unencrypted path: %s", repo, c.encryptPaths, err)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("encrypt --check in repo %s with encryptPaths %s failed: %s", repo, c.encryptPaths, err)
+				}
+				encrypted, err := ioutil.ReadFile(encryptedPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Contains(string(encrypted), "hunter2") || !strings.Contains(string(encrypted), "password: !encrypted") {
+					t.Errorf("Value matching encryptPaths %s in repo %s was not encrypted:\n%s", c.encryptPaths, repo, encrypted)
+				}
+			}
+		}
+	}
+}
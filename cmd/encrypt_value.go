@@ -2,10 +2,9 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/base64"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
 	"github.com/spf13/cobra"
 	"io"
 	"io/ioutil"
@@ -47,7 +46,7 @@ func EncryptValue(stdin io.Reader, stdout io.Writer, multiline bool) error {
 	}
 	var ciphertext []byte
 	err = func() error {
-		config, err := config.LoadConfig(".")
+		config, opts, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
@@ -56,13 +55,13 @@ func EncryptValue(stdin io.Reader, stdout io.Writer, multiline bool) error {
 			return err
 		}
 		defer cache.Close()
-		ciphertext, err = actions.EncryptPlaintext(string(plaintext), &cache, &config.Provider)
+		ciphertext, err = actions.EncryptPlaintext(string(plaintext), cache, &config.Provider, opts)
 		return err
 	}()
 	if err != nil {
 		return err
 	}
-	io.WriteString(stdout, base64.StdEncoding.EncodeToString(ciphertext)+"\n")
+	io.WriteString(stdout, string(yaml.NewEncryptedValue(ciphertext))+"\n")
 	return nil
 }
 
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptedKeys(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(`plain: value
+!secret hidden key: !secret hidden value
+!secret hidden mapping:
+  nested: !secret nested value
+  other: plain value
+list:
+  - !secret hidden list key: plain value
+`)
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		DecryptFlags.Plain = false
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptedPath := "keys." + repo.Suffixes["decrypted"]
+		encryptedPath := "keys." + repo.Suffixes["encrypted"]
+		err = ioutil.WriteFile(decryptedPath, content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = EncryptCmd.RunE(nil, []string{decryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, err := ioutil.ReadFile(encryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"hidden key", "hidden mapping", "hidden list key"} {
+			if bytes.Contains(encrypted, []byte(secret)) {
+				t.Errorf("Encrypted file in repo %s contains secret key %q:\n%s", repo, secret, encrypted)
+			}
+		}
+		// encrypting again should not change anything
+		err = EncryptCmd.RunE(nil, []string{decryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reencrypted, err := ioutil.ReadFile(encryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encrypted, reencrypted) {
+			t.Errorf("Encrypted file in repo %s changed despite no changes to decrypted file", repo)
+		}
+		// decrypt from scratch, structure should be restored
+		err = os.Remove(decryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = DecryptCmd.RunE(nil, []string{encryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := ioutil.ReadFile(decryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, content) {
+			t.Errorf("Decrypted file in repo %s differs from original:\n%s", repo, decrypted)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+)
+
+var extractFlags struct {
+	binary bool
+}
+
+var ExtractCmd = &cobra.Command{
+	Use:                   "extract <file> <path> <outfile>",
+	Short:                 "Decrypt a single value from a file, and write it to its own file.",
+	Long:                  "Decrypt a single value from a file, and write it to its own file with 0600 permissions. Useful for things like TLS keys, which applications often expect as separate files. The path is a dot-separated list of mapping keys and sequence indices, eg. \"tls.certs.0.key\".",
+	Args:                  cobra.ExactArgs(3),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, opts, err := loadConfig(".")
+		if err != nil {
+			return err
+		}
+		file, err := actions.NewFile(args[0], &config)
+		if err != nil {
+			return err
+		}
+		cache, err := cache.Setup(config)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		return actions.Extract(&file, args[1], args[2], extractFlags.binary, cache, &config.Provider, opts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ExtractCmd)
+	ExtractCmd.Flags().BoolVarP(&extractFlags.binary, "binary", "b", false, "base64-decode the value, writing out raw bytes")
+}
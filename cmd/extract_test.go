@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte{0x00, 0xff, 0x10, 0x80, '\n', 0x7f}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptedPath := "extract." + repo.Suffixes["decrypted"]
+		content := "text: !secret secret 1\nnested:\n  - !secret secret 2\nbinary: !secret " + base64.StdEncoding.EncodeToString(binary) + "\n"
+		err = ioutil.WriteFile(decryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = EncryptCmd.RunE(nil, []string{decryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []struct {
+			path     string
+			binary   bool
+			expected []byte
+		}{
+			{"text", false, []byte("secret 1")},
+			{"nested.0", false, []byte("secret 2")},
+			{"binary", true, binary},
+		} {
+			extractFlags.binary = c.binary
+			outPath := filepath.Join(repo.TmpDir, "out")
+			err = ExtractCmd.RunE(nil, []string{decryptedPath, c.path, outPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, c.expected) {
+				t.Errorf("Extracting path %s in repo %s gave %q, expected %q", c.path, repo, data, c.expected)
+			}
+			info, err := os.Stat(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("Extracted file in repo %s has permissions %o, expected 600", repo, info.Mode().Perm())
+			}
+		}
+		extractFlags.binary = false
+
+		// an existing file readable by others should have its permissions tightened, not be written to as it is
+		outPath := filepath.Join(repo.TmpDir, "existing")
+		err = ioutil.WriteFile(outPath, []byte("old contents"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chmod(outPath, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ExtractCmd.RunE(nil, []string{decryptedPath, "text", outPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "secret 1" {
+			t.Errorf("Extracting over an existing file in repo %s gave %q, expected %q", repo, data, "secret 1")
+		}
+		info, err := os.Stat(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Extracting over an existing file in repo %s left permissions %o, expected 600", repo, info.Mode().Perm())
+		}
+
+		err = ExtractCmd.RunE(nil, []string{decryptedPath, "missing", filepath.Join(repo.TmpDir, "out")})
+		if err == nil {
+			t.Errorf("Extracting a nonexistent path in repo %s did not fail", repo)
+		}
+	}
+}
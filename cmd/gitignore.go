@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +14,7 @@ var gitignoreCmd = &cobra.Command{
 	Short: "Update the .gitignore file for this repo.",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := config.LoadConfig(gitignoreFlags.dir)
+		config, _, err := loadConfig(gitignoreFlags.dir)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var infoFlags struct {
+	json bool
+}
+
+var infoCmd = &cobra.Command{
+	Use:                   "info <file>",
+	Short:                 "Show information about a file, without decrypting it.",
+	Long:                  "Show information about a file, without decrypting it: the paths of its encrypted, decrypted, and plain versions, which of them exist, and the paths of its secrets, along with who last changed each one, if recorded.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Info(os.Stdout, args[0], infoFlags.json)
+	},
+}
+
+func Info(stdout io.Writer, path string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	file, err := actions.NewFile(path, &config)
+	if err != nil {
+		return err
+	}
+	info, err := actions.Info(&file, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, info)
+	}
+	fmt.Fprintf(stdout, "encrypted: %s (exists: %t)\n", info.EncryptedPath, info.EncryptedExists)
+	fmt.Fprintf(stdout, "decrypted: %s (exists: %t)\n", info.DecryptedPath, info.DecryptedExists)
+	fmt.Fprintf(stdout, "plain: %s (exists: %t)\n", info.PlainPath, info.PlainExists)
+	fmt.Fprintln(stdout, "secrets:")
+	for _, secret := range info.Secrets {
+		if rotatedBy, ok := info.RotatedBy[secret]; ok {
+			fmt.Fprintf(stdout, "  %s (rotated by %s)\n", secret, rotatedBy)
+		} else {
+			fmt.Fprintf(stdout, "  %s\n", secret)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().BoolVarP(&infoFlags.json, "json", "", false, "print output as JSON")
+}
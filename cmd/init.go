@@ -27,28 +27,20 @@ var initCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		path, err := config.FindRepoRoot(".")
-		if err == nil {
-			return fmt.Errorf("Repo already exists at %s", strconv.Quote(path))
-		}
-		providerConfig, ok := crypto.BlankConfigs[initFlags.provider]
-		if !ok {
-			return fmt.Errorf("Invalid provider name %s", strconv.Quote(initFlags.provider))
-		}
-		content := map[string]interface{}{
-			"provider": initFlags.provider,
-			"config":   providerConfig,
-			"suffixes": config.DefaultSuffixesConfig,
-		}
-		out, err := yaml.Marshal(content)
-		if err != nil {
-			return err
-		}
-		err = ioutil.WriteFile(config.ConfigFilename, out, 0644)
-		if err != nil {
+		// re-running init in an existing repo only makes sure the .gitignore is up to date, and leaves the config alone
+		if _, err := os.Stat(config.ConfigFilename); os.IsNotExist(err) {
+			path, err := config.FindRepoRoot(".")
+			if err == nil {
+				return fmt.Errorf("Repo already exists at %s", strconv.Quote(path))
+			}
+			err = writeStarterConfig(initFlags.provider)
+			if err != nil {
+				return err
+			}
+		} else if err != nil {
 			return err
 		}
-		config, err := config.LoadConfig(".")
+		config, _, err := loadConfig(".")
 		if err != nil {
 			return err
 		}
@@ -57,6 +49,24 @@ var initCmd = &cobra.Command{
 	},
 }
 
+// Write a config file for a new repo, with blank settings for the provider.
+func writeStarterConfig(provider string) error {
+	providerConfig, ok := crypto.BlankConfig(provider)
+	if !ok {
+		return fmt.Errorf("Invalid provider name %s", strconv.Quote(provider))
+	}
+	content := map[string]interface{}{
+		"provider": provider,
+		"config":   providerConfig,
+		"suffixes": config.DefaultSuffixesConfig,
+	}
+	out, err := yaml.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(config.ConfigFilename, out, 0644)
+}
+
 func init() {
 	rootCmd.AddCommand(initCmd)
 	initCmd.Flags().StringVarP(&initFlags.provider, "provider", "p", "", "name of the provider to use")
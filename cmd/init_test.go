@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	dir, err := ioutil.TempDir("", "yamlcrypt-init-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, config.ConfigFilename)
+	gitignorePath := filepath.Join(dir, ".gitignore")
+	err = ioutil.WriteFile(gitignorePath, []byte("node_modules\n/.yamlcrypt.cache/\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initFlags.provider = "noop"
+	initFlags.dir = dir
+
+	err = initCmd.RunE(initCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Starter config is invalid: %s", err)
+	}
+	if c.ProviderName != "noop" || c.Suffixes != config.DefaultSuffixesConfig {
+		t.Errorf("Starter config has provider %s and suffixes %+v", c.ProviderName, c.Suffixes)
+	}
+	expected := "node_modules\n/.yamlcrypt.cache/\n*.decrypted.yaml\n*.plain.yaml\n"
+	gitignore, err := ioutil.ReadFile(gitignorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gitignore) != expected {
+		t.Errorf("Incorrect .gitignore:\n%s\nExpected:\n%s", gitignore, expected)
+	}
+
+	// running again leaves customized config and the .gitignore alone
+	customized := "threads: 3\n"
+	f, err := os.OpenFile(configPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(customized)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = initCmd.RunE(initCmd, []string{})
+	if err != nil {
+		t.Fatalf("Running init again failed: %s", err)
+	}
+	after, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) || !strings.HasSuffix(string(after), customized) {
+		t.Errorf("Running init again changed the config:\n%s", after)
+	}
+	gitignore, err = ioutil.ReadFile(gitignorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gitignore) != expected {
+		t.Errorf("Running init again changed the .gitignore:\n%s\nExpected:\n%s", gitignore, expected)
+	}
+
+	// init in a subdirectory of a repo is still refused
+	nested := filepath.Join(dir, "nested")
+	err = os.Mkdir(nested, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initFlags.dir = nested
+	err = initCmd.RunE(initCmd, []string{})
+	if err == nil || !strings.Contains(err.Error(), "Repo already exists") {
+		t.Errorf("Init inside an existing repo did not fail: %v", err)
+	}
+}
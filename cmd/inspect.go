@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var inspectFlags struct {
+	json bool
+}
+
+var inspectCmd = &cobra.Command{
+	Use:                   "inspect <file>",
+	Short:                 "Show the metadata of each encrypted value in a file, without decrypting it.",
+	Long:                  "Show the metadata of each encrypted value in a file, without decrypting it or needing a key: the provider and algorithm it's encrypted with, the version and parameters recorded in its ciphertext, the recipients of the provider, whether it's authenticated, and the sizes of its ciphertext and plaintext, where they can be told. Values encrypted with a provider that isn't configured are reported with what little can be told.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Inspect(os.Stdout, args[0], inspectFlags.json)
+	},
+}
+
+func Inspect(stdout io.Writer, path string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	file, err := actions.NewFile(path, &config)
+	if err != nil {
+		return err
+	}
+	result, err := actions.Inspect(&file, config.Provider, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, result)
+	}
+	fmt.Fprintf(stdout, "%s (format %d)\n", result.File, result.Format)
+	for _, value := range result.Values {
+		provider := value.Provider
+		if provider == "" {
+			provider = "unknown provider"
+		}
+		if value.Algorithm != "" {
+			provider += "/" + value.Algorithm
+		}
+		if value.Marker != "" {
+			provider = value.Marker + " (" + provider + ")"
+		}
+		details := []string{provider, value.Type, fmt.Sprintf("ciphertext %d bytes", value.Size)}
+		if value.PlaintextSize >= 0 {
+			details = append(details, fmt.Sprintf("plaintext %d bytes", value.PlaintextSize))
+		}
+		if value.Version != 0 {
+			details = append(details, fmt.Sprintf("version %d", value.Version))
+		}
+		params := make([]string, 0, len(value.Parameters))
+		for name, param := range value.Parameters {
+			params = append(params, name+"="+param)
+		}
+		sort.Strings(params)
+		details = append(details, params...)
+		if value.Authenticated {
+			details = append(details, "authenticated")
+		}
+		if len(value.Recipients) > 0 {
+			details = append(details, "recipients "+strings.Join(value.Recipients, ","))
+		}
+		fmt.Fprintf(stdout, "  %s: %s\n", value.Path, strings.Join(details, ", "))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().BoolVarP(&inspectFlags.json, "json", "", false, "print output as JSON")
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Write a value to the given writer as indented JSON, for commands supporting --json.
+func printJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestJSONOutput(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Checkout(repo.Provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Checkout("original")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := repo.Files[0]
+
+		// list
+		var list []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return List(out, []string{}, true) }, &list)
+		if len(list) != len(repo.Files) {
+			t.Errorf("list --json in repo %s gave %d entries, expected %d", repo, len(list), len(repo.Files))
+		}
+		for _, entry := range list {
+			assertKeys(t, "list", entry, "file", "secrets")
+		}
+
+		// info
+		var info map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return Info(out, file.TmpPath(repo.Provider), true) }, &info)
+		assertKeys(t, "info", info, "encrypted_path", "decrypted_path", "plain_path", "encrypted_exists", "decrypted_exists", "plain_exists", "secrets", "rotated_by")
+		if info["encrypted_exists"] != true || info["decrypted_exists"] != true || info["plain_exists"] != false {
+			t.Errorf("info --json in repo %s reported incorrect file existence: %v", repo, info)
+		}
+
+		// inspect
+		var inspect map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return Inspect(out, file.TmpPath(repo.Provider), true) }, &inspect)
+		assertKeys(t, "inspect", inspect, "file", "format", "values")
+		values, _ := inspect["values"].([]interface{})
+		if len(values) != len(info["secrets"].([]interface{})) {
+			t.Errorf("inspect --json in repo %s gave %d values, expected one per secret: %v", repo, len(values), inspect)
+		}
+		for _, value := range values {
+			assertKeys(t, "inspect value", value.(map[string]interface{}), "path", "type", "layout", "marker", "provider", "algorithm", "version", "parameters", "recipients", "authenticated", "size", "plaintext_size")
+			if value.(map[string]interface{})["provider"] != repo.Provider {
+				t.Errorf("inspect --json in repo %s gave the wrong provider: %v", repo, value)
+			}
+		}
+
+		// recipients
+		var recipients map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return Recipients(out, true) }, &recipients)
+		assertKeys(t, "recipients", recipients, "provider", "recipients")
+		if recipients["provider"] != repo.Provider {
+			t.Errorf("recipients --json in repo %s gave provider %v, expected %s", repo, recipients["provider"], repo.Provider)
+		}
+
+		// rekey-plan
+		var plans []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return RekeyPlan(out, []string{}, true) }, &plans)
+		if len(plans) != len(repo.Files) {
+			t.Errorf("rekey-plan --json in repo %s gave %d entries, expected %d", repo, len(plans), len(repo.Files))
+		}
+		for _, plan := range plans {
+			assertKeys(t, "rekey-plan", plan, "file", "rekeyed", "unchanged")
+		}
+
+		// preflight
+		var checks []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return Preflight(out, []string{}, true) }, &checks)
+		if len(checks) != len(repo.Files) {
+			t.Errorf("preflight --json in repo %s gave %d entries, expected %d", repo, len(checks), len(repo.Files))
+		}
+		for _, check := range checks {
+			assertKeys(t, "preflight", check, "file", "required", "missing")
+		}
+
+		// cache trim
+		var report map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return CacheTrim(out, true) }, &report)
+		assertKeys(t, "cache trim", report, "size", "merged", "max_size", "rotated")
+
+		// cache restore, of an artifact exported from the same cache
+		artifact := filepath.Join(repo.TmpDir, "cache.artifact")
+		err = CacheExport(&bytes.Buffer{}, artifact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var restored map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return CacheRestore(out, artifact, true) }, &restored)
+		assertKeys(t, "cache restore", restored, "restored", "skipped")
+		if restored["restored"] != float64(0) {
+			t.Errorf("cache restore --json in repo %s restored entries the cache already had: %v", repo, restored)
+		}
+
+		// verify-tree, with up to date decrypted files
+		var tree []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return VerifyTree(out, []string{}, true) }, &tree)
+		if len(tree) != len(repo.Files) {
+			t.Errorf("verify-tree --json in repo %s gave %d entries, expected %d", repo, len(tree), len(repo.Files))
+		}
+		for _, result := range tree {
+			assertKeys(t, "verify-tree", result, "file", "ok", "errors")
+			if result["ok"] != true {
+				t.Errorf("verify-tree --json in repo %s failed for up to date file: %v", repo, result)
+			}
+		}
+
+		// status, with up to date decrypted files
+		var statuses []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return Status(out, []string{}, true) }, &statuses)
+		if len(statuses) != 0 {
+			t.Errorf("status --json in repo %s reported up to date files: %v", repo, statuses)
+		}
+
+		// verify, with up to date decrypted files
+		var results []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return Verify(out, []string{}, true) }, &results)
+		for _, result := range results {
+			assertKeys(t, "verify", result, "file", "ok", "errors")
+			if result["ok"] != true {
+				t.Errorf("verify --json in repo %s failed for up to date file: %v", repo, result)
+			}
+		}
+
+		// verify, with out of date decrypted files
+		err = repo.Checkout("modified")
+		if err != nil {
+			t.Fatal(err)
+		}
+		runJSON(t, func(out *bytes.Buffer) error { return Status(out, []string{}, true) }, &statuses)
+		if len(statuses) == 0 {
+			t.Errorf("status --json in repo %s reported no out of date files", repo)
+		}
+		for _, status := range statuses {
+			assertKeys(t, "status", status, "file", "status")
+			if status["status"] != "modified" {
+				t.Errorf("status --json in repo %s gave the wrong status for an out of date file: %v", repo, status)
+			}
+		}
+		out := bytes.Buffer{}
+		err = Verify(&out, []string{}, true)
+		if err == nil {
+			t.Errorf("verify --json in repo %s did not fail for out of date files", repo)
+		}
+		err = json.Unmarshal(out.Bytes(), &results)
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := 0
+		for _, result := range results {
+			if result["ok"] == false {
+				failed++
+				for _, e := range result["errors"].([]interface{}) {
+					assertKeys(t, "verify error", e.(map[string]interface{}), "path", "message")
+				}
+			}
+		}
+		if failed == 0 {
+			t.Errorf("verify --json in repo %s reported no failures for out of date files", repo)
+		}
+
+		// rotate-matching, of every value
+		var rotated []map[string]interface{}
+		runJSON(t, func(out *bytes.Buffer) error { return RotateMatching(out, []string{"."}, true) }, &rotated)
+		if len(rotated) == 0 {
+			t.Errorf("rotate-matching --json in repo %s rotated nothing", repo)
+		}
+		for _, result := range rotated {
+			assertKeys(t, "rotate-matching", result, "file", "paths")
+		}
+	}
+}
+
+func runJSON(t *testing.T, f func(*bytes.Buffer) error, v interface{}) {
+	out := bytes.Buffer{}
+	err := f(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(out.Bytes(), v)
+	if err != nil {
+		t.Fatalf("Invalid JSON output %q: %s", out.String(), err)
+	}
+}
+
+func assertKeys(t *testing.T, name string, obj map[string]interface{}, keys ...string) {
+	actual := make([]string, 0, len(obj))
+	for k := range obj {
+		actual = append(actual, k)
+	}
+	sort.Strings(actual)
+	sort.Strings(keys)
+	if !reflect.DeepEqual(actual, keys) {
+		t.Errorf("%s --json output has keys %v, expected %v", name, actual, keys)
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var listFlags struct {
+	json bool
+}
+
+var listCmd = &cobra.Command{
+	Use:                   "list [directory]",
+	Short:                 "List the encrypted files in the repo, along with the paths of their secrets.",
+	Long:                  "List the encrypted files in the repo, along with the paths of their secrets. Supplying no args will list all encrypted files in the repo.",
+	Args:                  cobra.MaximumNArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return List(os.Stdout, args, listFlags.json)
+	},
+}
+
+type listEntry struct {
+	File    string   `json:"file"`
+	Secrets []string `json:"secrets"`
+}
+
+func List(stdout io.Writer, args []string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	dir := config.Root
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	paths, err := config.AllEncryptedFiles(dir)
+	if err != nil {
+		return err
+	}
+	entries := make([]listEntry, 0, len(paths))
+	for _, path := range paths {
+		file, err := actions.NewFile(path, &config)
+		if err != nil {
+			return err
+		}
+		info, err := actions.Info(&file, opts)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, listEntry{File: path, Secrets: info.Secrets})
+	}
+	if asJSON {
+		return printJSON(stdout, entries)
+	}
+	for _, entry := range entries {
+		fmt.Fprintln(stdout, entry.File)
+		for _, secret := range entry.Secrets {
+			fmt.Fprintf(stdout, "  %s\n", secret)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolVarP(&listFlags.json, "json", "", false, "print output as JSON")
+}
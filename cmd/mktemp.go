@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var mktempFlags struct {
+	plain bool
+	dir   string
+}
+
+var mktempCmd = &cobra.Command{
+	Use:                   "mktemp <file>",
+	Short:                 "Decrypt a file to a new temporary file, readable only by you, and print its path.",
+	Long:                  "Decrypt a file to a new temporary file and print its path, for feeding to tools that need a real file. The temporary file is only readable and writable by you, and its name ends with the name of the decrypted (or with --plain, plain) version of the file, so that tools can tell its type. Removing it once done is up to you, eg. with `trap 'rm -f \"$f\"' EXIT` in a script.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Mktemp(os.Stdout, args[0], mktempFlags.plain, mktempFlags.dir)
+	},
+}
+
+// Decrypt a file to a new temporary file in dir, or the configured temporary directory if it's empty, and print its path to stdout. The temporary file is removed if decrypting fails.
+func Mktemp(stdout io.Writer, arg string, plain bool, dir string) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	file, err := actions.NewFile(arg, &config)
+	if err != nil {
+		return err
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	name := filepath.Base(file.DecryptedPath)
+	if plain {
+		name = filepath.Base(file.PlainPath)
+	}
+	if dir == "" {
+		dir = config.TempDir
+	}
+	// created with mode 0600
+	tmp, err := ioutil.TempFile(dir, "yamlcrypt-*-"+name)
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %w", err)
+	}
+	err = actions.DecryptStream([]*actions.File{&file}, tmp, plain, cache, &config.Provider, int(config.Threads), opts)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Error writing temporary file %s: %w", tmp.Name(), closeErr)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	fmt.Fprintln(stdout, tmp.Name())
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(mktempCmd)
+	mktempCmd.Flags().BoolVarP(&mktempFlags.plain, "plain", "p", false, "decrypt to the plain version, without !secret tags")
+	mktempCmd.Flags().StringVarP(&mktempFlags.dir, "dir", "", "", "directory to create the temporary file in, rather than the configured or default one")
+}
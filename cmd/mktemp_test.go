@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMktemp(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptedPath := "mktemp." + repo.Suffixes["decrypted"]
+		content := "user: app\npassword: !secret hunter2\n"
+		err = ioutil.WriteFile(decryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = EncryptCmd.RunE(nil, []string{decryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the decrypted version doesn't need to exist
+		err = os.Remove(decryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, plain := range []bool{false, true} {
+			expected := content
+			if plain {
+				expected = strings.Replace(content, "!secret ", "", 1)
+			}
+			var out bytes.Buffer
+			err = Mktemp(&out, decryptedPath, plain, repo.TmpDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := strings.TrimSuffix(out.String(), "\n")
+			if filepath.Dir(path) != filepath.Clean(repo.TmpDir) {
+				t.Errorf("Temporary file in repo %s was created at %s, expected it in %s", repo, path, repo.TmpDir)
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != expected {
+				t.Errorf("Temporary file in repo %s (plain: %t) holds:\n%s\nexpected:\n%s", repo, plain, data, expected)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("Temporary file in repo %s has permissions %o, expected 600", repo, info.Mode().Perm())
+			}
+			os.Remove(path)
+		}
+
+		// nothing is left behind when decrypting fails
+		before, err := ioutil.ReadDir(repo.TmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Mktemp(&bytes.Buffer{}, "missing."+repo.Suffixes["decrypted"], false, repo.TmpDir)
+		if err == nil {
+			t.Errorf("Expected an error decrypting a missing file in repo %s", repo)
+		}
+		after, err := ioutil.ReadDir(repo.TmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after) != len(before) {
+			t.Errorf("Failing to decrypt in repo %s left a temporary file behind", repo)
+		}
+	}
+}
+
+func TestMktempTempDir(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptedPath := "tempdir." + repo.Suffixes["decrypted"]
+		err = ioutil.WriteFile(decryptedPath, []byte("password: !secret hunter2\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = EncryptCmd.RunE(nil, []string{decryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{"secure", "env"} {
+			err = os.Mkdir(dir, 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		original, err := ioutil.ReadFile(config.ConfigFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// relative to the root, not the working directory
+		err = ioutil.WriteFile(config.ConfigFilename, append(original, []byte("tempDir: secure\n")...), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := filepath.Abs(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []struct {
+			env      string
+			expected string
+		}{
+			{"", filepath.Join(root, "secure")},
+			{filepath.Join(root, "env"), filepath.Join(root, "env")},
+		} {
+			os.Setenv(tempDirEnv, c.env)
+			var out bytes.Buffer
+			err = Mktemp(&out, decryptedPath, false, "")
+			os.Unsetenv(tempDirEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := strings.TrimSuffix(out.String(), "\n")
+			if filepath.Dir(path) != c.expected {
+				t.Errorf("Temporary file in repo %s (env: %q) was created at %s, expected it in %s", repo, c.env, path, c.expected)
+			}
+			os.Remove(path)
+		}
+
+		// a directory that doesn't exist is an error, rather than falling back to the default one
+		err = ioutil.WriteFile(config.ConfigFilename, append(original, []byte("tempDir: missing\n")...), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Mktemp(&bytes.Buffer{}, decryptedPath, false, "")
+		if err == nil || !strings.Contains(err.Error(), "Invalid tempDir") {
+			t.Errorf("Expected an error for a missing tempDir in repo %s, got %v", repo, err)
+		}
+		err = ioutil.WriteFile(config.ConfigFilename, original, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+var preflightFlags struct {
+	json bool
+}
+
+var preflightCmd = &cobra.Command{
+	Use:                   "preflight [file|directory]...",
+	Short:                 "Check whether you have the keys to decrypt files, without decrypting them.",
+	Long:                  "Check whether the keys available to you cover the keys each encrypted file's values are encrypted to, and list any that are missing, without decrypting anything. Exits with a non-zero status if any file can't be decrypted. Whether a key can actually be used, eg. whether your credentials are allowed to use a KMS key, is only known once a value is decrypted. Supplying no args will check all encrypted files in the repo.",
+	Args:                  cobra.ArbitraryArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Preflight(os.Stdout, args, preflightFlags.json)
+	},
+}
+
+func Preflight(stdout io.Writer, args []string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{config.Root}
+	}
+	files := []*actions.File{}
+	for _, arg := range args {
+		var paths []string
+		if info, err := os.Stat(arg); !os.IsNotExist(err) && info.IsDir() {
+			paths, err = config.AllEncryptedFiles(arg)
+			if err != nil {
+				return err
+			}
+		} else {
+			paths = []string{arg}
+		}
+		for _, path := range paths {
+			file, err := actions.NewFile(path, &config)
+			if err != nil {
+				return err
+			}
+			files = append(files, &file)
+		}
+	}
+	checks, err := actions.CheckKeys(files, config.Provider, opts)
+	if err != nil {
+		return err
+	}
+	ok := true
+	for _, check := range checks {
+		ok = ok && len(check.Missing) == 0
+	}
+	if asJSON {
+		err = printJSON(stdout, checks)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, check := range checks {
+			if len(check.Missing) == 0 {
+				fmt.Fprintf(stdout, "ok: %s\n", check.File)
+			} else {
+				fmt.Fprintf(stdout, "missing keys: %s (%s)\n", check.File, strings.Join(check.Missing, ", "))
+			}
+		}
+	}
+	if !ok {
+		return errors.New("Some files can't be decrypted with the keys available")
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+	preflightCmd.Flags().BoolVarP(&preflightFlags.json, "json", "", false, "print output as JSON")
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var recipientsFlags struct {
+	json bool
+}
+
+var recipientsCmd = &cobra.Command{
+	Use:                   "recipients",
+	Short:                 "List the keys that values in this repo are encrypted to.",
+	Args:                  cobra.NoArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Recipients(os.Stdout, recipientsFlags.json)
+	},
+}
+
+type recipientsOutput struct {
+	Provider   string   `json:"provider"`
+	Recipients []string `json:"recipients"`
+}
+
+func Recipients(stdout io.Writer, asJSON bool) error {
+	config, _, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	output := recipientsOutput{
+		Provider:   config.ProviderName,
+		Recipients: config.Provider.Recipients(),
+	}
+	if asJSON {
+		return printJSON(stdout, output)
+	}
+	for _, recipient := range output.Recipients {
+		fmt.Fprintln(stdout, recipient)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(recipientsCmd)
+	recipientsCmd.Flags().BoolVarP(&recipientsFlags.json, "json", "", false, "print output as JSON")
+}
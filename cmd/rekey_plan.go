@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var rekeyPlanFlags struct {
+	json bool
+}
+
+var rekeyPlanCmd = &cobra.Command{
+	Use:                   "rekey-plan [file|directory]...",
+	Short:                 "Report which values would be re-encrypted to different recipients, without changing anything.",
+	Long:                  "Report which values in the encrypted files would be re-encrypted to different recipients the next time they're encrypted, eg. after changing providerPaths or the providers they name, and which would be left alone. Nothing is decrypted or written. A value is re-encrypted if the provider its path is mapped to has a different fingerprint from the provider it was encrypted by. Supplying no args will check all encrypted files in the repo.",
+	Args:                  cobra.ArbitraryArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RekeyPlan(os.Stdout, args, rekeyPlanFlags.json)
+	},
+}
+
+func RekeyPlan(stdout io.Writer, args []string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{config.Root}
+	}
+	files := []*actions.File{}
+	for _, arg := range args {
+		var paths []string
+		if info, err := os.Stat(arg); !os.IsNotExist(err) && info.IsDir() {
+			paths, err = config.AllEncryptedFiles(arg)
+			if err != nil {
+				return err
+			}
+		} else {
+			paths = []string{arg}
+		}
+		for _, path := range paths {
+			file, err := actions.NewFile(path, &config)
+			if err != nil {
+				return err
+			}
+			files = append(files, &file)
+		}
+	}
+	plans, err := actions.PlanRekey(files, config.Provider, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, plans)
+	}
+	rekeyed, unchanged := 0, 0
+	for _, plan := range plans {
+		for _, change := range plan.Rekeyed {
+			fmt.Fprintf(stdout, "rekey: %s %s (%s -> %s)\n", plan.File, change.Path, providerLabel(change.From), providerLabel(change.To))
+		}
+		rekeyed += len(plan.Rekeyed)
+		unchanged += len(plan.Unchanged)
+	}
+	fmt.Fprintf(stdout, "%d values would be re-encrypted, %d left alone\n", rekeyed, unchanged)
+	return nil
+}
+
+// Name a provider for display, with the default provider's empty name spelled out.
+func providerLabel(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyPlanCmd)
+	rekeyPlanCmd.Flags().BoolVarP(&rekeyPlanFlags.json, "json", "", false, "print output as JSON")
+}
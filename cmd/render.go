@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var RenderCmd = &cobra.Command{
+	Use:                   "render <template> <file>",
+	Short:                 "Render a template using the decrypted values from a file, and print the result.",
+	Long:                  "Render a Go text/template using the decrypted contents of a file as its data, and print the result to stdout. Values are referenced by their keys, eg. \"{{ .db.password }}\". Referencing a key that isn't in the file is an error.",
+	Args:                  cobra.ExactArgs(2),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, opts, err := loadConfig(".")
+		if err != nil {
+			return err
+		}
+		file, err := actions.NewFile(args[1], &config)
+		if err != nil {
+			return err
+		}
+		cache, err := cache.Setup(config)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		out, err := actions.Render(args[0], &file, cache, &config.Provider, int(config.Threads), opts)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(os.Stdout, out)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(RenderCmd)
+}
@@ -1,13 +1,18 @@
 package cmd
 
 import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
 	"github.com/spf13/cobra"
 	"os"
 )
 
-var threads uint
 var progress bool
 
+var outputFormat string
+
+// Providers used by the command, whose keys are wiped once it's finished.
+var usedProviders []crypto.Provider
+
 var rootCmd = &cobra.Command{
 	Use:   "yaml-crypt",
 	Short: "Encrypt secret values in your yaml files using a cloud-based encryption service.",
@@ -18,12 +23,19 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	for _, provider := range usedProviders {
+		crypto.Wipe(provider)
+		// everything has been encrypted or decrypted by now, so there's nothing left that failing to close it could lose
+		crypto.Close(provider)
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
-	rootCmd.PersistentFlags().UintVarP(&threads, "threads", "t", 16, "number of crypto operations to run in parallel")
+	addSettingsFlags(rootCmd.PersistentFlags())
 	rootCmd.PersistentFlags().BoolVarP(&progress, "progress", "", true, "show progress bar")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output-format", "", "", "force the yaml files written into \"block\" or \"flow\" style, rather than keeping the style of the input")
 }
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootDiscovery(t *testing.T) {
+	progress = false
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := filepath.EvalSymlinks(repo.TmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nested := filepath.Join(root, "a", "b")
+		err = os.MkdirAll(nested, 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chdir(nested)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, _, err := loadConfig(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Root != root {
+			t.Errorf("Repo %s: loading config from %s gave root %s, expected %s", repo, nested, config.Root, root)
+		}
+
+		decryptedPath := "nested." + repo.Suffixes["decrypted"]
+		err = ioutil.WriteFile(decryptedPath, []byte("a: !secret b\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = EncryptCmd.RunE(nil, []string{decryptedPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(root, cache.CacheDirName)); err != nil {
+			t.Errorf("Repo %s: cache was not created at the repo root: %s", repo, err)
+		}
+		if _, err := os.Stat(filepath.Join(nested, cache.CacheDirName)); !os.IsNotExist(err) {
+			t.Errorf("Repo %s: cache was created in the current directory instead of the repo root", repo)
+		}
+		if _, err := os.Stat(filepath.Join(nested, "nested."+repo.Suffixes["encrypted"])); err != nil {
+			t.Errorf("Repo %s: encrypted file was not written next to the decrypted file: %s", repo, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+)
+
+var RotateCmd = &cobra.Command{
+	Use:                   "rotate <file> <path>",
+	Short:                 "Re-encrypt a single value in a file, leaving the rest of the file untouched.",
+	Long:                  "Re-encrypt a single value in a file, leaving the rest of the file untouched. The value is always freshly encrypted, even if its plaintext hasn't changed. The path is a dot-separated list of mapping keys and sequence indices, eg. \"db.password\".",
+	Args:                  cobra.ExactArgs(2),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, opts, err := loadConfig(".")
+		if err != nil {
+			return err
+		}
+		file, err := actions.NewFile(args[0], &config)
+		if err != nil {
+			return err
+		}
+		cache, err := cache.Setup(config)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		return actions.Rotate(&file, args[1], cache, &config.Provider, opts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(RotateCmd)
+}
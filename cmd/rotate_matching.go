@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"regexp"
+)
+
+var rotateMatchingFlags struct {
+	json bool
+}
+
+var rotateMatchingCmd = &cobra.Command{
+	Use:                   "rotate-matching <pattern> [directory]",
+	Short:                 "Re-encrypt every value whose plaintext matches a pattern, leaving the rest untouched.",
+	Long:                  "Re-encrypt every value whose plaintext matches a pattern, eg. \"AKIA[0-9A-Z]{16}\" for everything that looks like an AWS access key, leaving every other value, and every file without a matching value, untouched. The pattern is a regular expression, matched against the decrypted values, through the cache where possible. Matching values are always freshly encrypted, even if their plaintext hasn't changed. Supplying no directory will rotate matching values in all encrypted files in the repo. Prints the path of each value rotated.",
+	Args:                  cobra.RangeArgs(1, 2),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RotateMatching(os.Stdout, args, rotateMatchingFlags.json)
+	},
+}
+
+func RotateMatching(stdout io.Writer, args []string, asJSON bool) error {
+	pattern, err := regexp.Compile(args[0])
+	if err != nil {
+		return fmt.Errorf("Invalid pattern: %w", err)
+	}
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	dir := config.Root
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	paths, err := config.AllEncryptedFiles(dir)
+	if err != nil {
+		return err
+	}
+	files := make([]*actions.File, 0, len(paths))
+	for _, path := range paths {
+		file, err := actions.NewFile(path, &config)
+		if err != nil {
+			return err
+		}
+		files = append(files, &file)
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	results, err := actions.RotateMatching(files, pattern, cache, &config.Provider, int(config.Threads), opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(stdout, results)
+	}
+	for _, result := range results {
+		for _, path := range result.Paths {
+			fmt.Fprintf(stdout, "%s: %s\n", result.File, path)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(rotateMatchingCmd)
+	rotateMatchingCmd.Flags().BoolVarP(&rotateMatchingFlags.json, "json", "", false, "print output as JSON")
+}
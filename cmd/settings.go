@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Environment variables that override settings from the config file.
+const (
+	threadsEnv      = "YAMLCRYPT_THREADS"
+	fileThreadsEnv  = "YAMLCRYPT_FILE_THREADS"
+	cacheMaxSizeEnv = "YAMLCRYPT_CACHE_MAX_SIZE"
+	cacheEnabledEnv = "YAMLCRYPT_CACHE_ENABLED"
+	identityEnv     = "YAMLCRYPT_IDENTITY"
+	tempDirEnv      = "YAMLCRYPT_TEMP_DIR"
+	sharedCacheEnv  = "YAMLCRYPT_CACHE_SHARED_DIR"
+)
+
+// Load the config for the repo containing dir, applying any overrides from CLI flags and environment variables, and get the options to pass to actions.
+func loadConfig(dir string) (config.Config, *actions.Options, error) {
+	c, err := config.LoadConfig(dir)
+	if err != nil {
+		return c, nil, err
+	}
+	usedProviders = append(usedProviders, c.Provider)
+	err = resolveSettings(&c, rootCmd.PersistentFlags(), os.Getenv)
+	if err != nil {
+		return c, nil, err
+	}
+	if c.EncryptedTag != "" {
+		if err := yaml.CheckEncryptedTag(c.EncryptedTag); err != nil {
+			return c, nil, fmt.Errorf("Invalid encryptedTag: %w", err)
+		}
+	}
+	for _, path := range c.EncryptPaths {
+		if _, err := yaml.SplitPath(path); err != nil {
+			return c, nil, fmt.Errorf("Invalid encryptPaths: %w", err)
+		}
+	}
+	for _, path := range c.PlaintextPaths {
+		if _, err := yaml.SplitPath(path); err != nil {
+			return c, nil, fmt.Errorf("Invalid plaintextPaths: %w", err)
+		}
+	}
+	if c.TempDir != "" {
+		if info, err := os.Stat(c.TempDir); err != nil {
+			return c, nil, fmt.Errorf("Invalid tempDir: %w", err)
+		} else if !info.IsDir() {
+			return c, nil, fmt.Errorf("Invalid tempDir: %s is not a directory", c.TempDir)
+		}
+	}
+	opts := actions.NewOptions(&c)
+	if opts.SchemaFile != "" {
+		if _, err := actions.LoadSchema(opts.SchemaFile); err != nil {
+			return c, nil, fmt.Errorf("Invalid schemaFile: %w", err)
+		}
+	}
+	for _, providerPath := range c.ProviderPaths {
+		if _, err := yaml.SplitPath(providerPath.Path); err != nil {
+			return c, nil, fmt.Errorf("Invalid providerPaths: %w", err)
+		}
+	}
+	if c.RecordRotatedBy {
+		identity := c.Identity
+		if identity == "" {
+			identity = actions.GitIdentity(c.Root)
+		}
+		// the identity ends up in a line comment, so it has to fit on one line
+		identity = strings.Join(strings.Fields(identity), " ")
+		if identity == "" {
+			return c, nil, fmt.Errorf("recordRotatedBy is set, but no identity is configured: set identity, %s, or git's user.email", identityEnv)
+		}
+		opts.RotatedBy = identity
+	}
+	switch outputFormat {
+	case "", yaml.BlockStyle, yaml.FlowStyle:
+		opts.OutputStyle = outputFormat
+	default:
+		return c, nil, fmt.Errorf("Invalid --output-format %q: must be %s or %s", outputFormat, yaml.BlockStyle, yaml.FlowStyle)
+	}
+	return c, opts, nil
+}
+
+// Get the path of the manifest that a resumable run of the given operation records its completed files in, eg. "encrypt". Each operation has its own, so that an interrupted run is only resumed by the same operation.
+func resumeManifestPath(c config.Config, operation string) string {
+	return filepath.Join(c.Root, cache.CacheDirName, "resume-"+operation)
+}
+
+// Override settings in the config with any given CLI flags or environment variables. Settings are resolved in order of precedence: CLI flag, environment variable, config file, built-in default. Since the config file has already been loaded, with defaults filled in, only the first two need to be checked here.
+func resolveSettings(c *config.Config, flags *pflag.FlagSet, getenv func(string) string) error {
+	var err error
+	if flags.Changed("threads") {
+		c.Threads, err = flags.GetUint("threads")
+		if err != nil {
+			return err
+		}
+	} else if env := getenv(threadsEnv); env != "" {
+		threads, err := strconv.ParseUint(env, 10, 0)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %w", threadsEnv, err)
+		}
+		c.Threads = uint(threads)
+	}
+	if flags.Changed("file-threads") {
+		c.FileThreads, err = flags.GetUint("file-threads")
+		if err != nil {
+			return err
+		}
+	} else if env := getenv(fileThreadsEnv); env != "" {
+		fileThreads, err := strconv.ParseUint(env, 10, 0)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %w", fileThreadsEnv, err)
+		}
+		c.FileThreads = uint(fileThreads)
+	}
+	if flags.Changed("cache-max-size") {
+		c.CacheMaxSize, err = flags.GetInt64("cache-max-size")
+		if err != nil {
+			return err
+		}
+	} else if env := getenv(cacheMaxSizeEnv); env != "" {
+		c.CacheMaxSize, err = strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %w", cacheMaxSizeEnv, err)
+		}
+	}
+	if flags.Changed("cache") {
+		c.CacheEnabled, err = flags.GetBool("cache")
+		if err != nil {
+			return err
+		}
+	} else if env := getenv(cacheEnabledEnv); env != "" {
+		c.CacheEnabled, err = strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %w", cacheEnabledEnv, err)
+		}
+	}
+	if env := getenv(identityEnv); env != "" {
+		c.Identity = env
+	}
+	if c.TempDir != "" && !filepath.IsAbs(c.TempDir) {
+		c.TempDir = filepath.Join(c.Root, c.TempDir)
+	}
+	if flags.Changed("temp-dir") {
+		c.TempDir, err = flags.GetString("temp-dir")
+		if err != nil {
+			return err
+		}
+	} else if env := getenv(tempDirEnv); env != "" {
+		c.TempDir = env
+	}
+	if c.CacheSharedDir != "" && !filepath.IsAbs(c.CacheSharedDir) {
+		c.CacheSharedDir = filepath.Join(c.Root, c.CacheSharedDir)
+	}
+	if flags.Changed("cache-shared-dir") {
+		c.CacheSharedDir, err = flags.GetString("cache-shared-dir")
+		if err != nil {
+			return err
+		}
+	} else if env := getenv(sharedCacheEnv); env != "" {
+		c.CacheSharedDir = env
+	}
+	// --no-cache is for a single run, so it wins over everything else
+	if noCache, _ := flags.GetBool("no-cache"); noCache {
+		c.CacheEnabled = false
+	}
+	if c.Threads == 0 {
+		return fmt.Errorf("Number of threads must be at least 1")
+	}
+	if c.FileThreads == 0 {
+		return fmt.Errorf("Number of file threads must be at least 1")
+	}
+	return nil
+}
+
+// Add the flags for settings that can also be set in the config file or the environment.
+func addSettingsFlags(flags *pflag.FlagSet) {
+	flags.UintP("threads", "t", config.DefaultThreads, "number of crypto operations to run in parallel (env: "+threadsEnv+")")
+	flags.UintP("file-threads", "", config.DefaultFileThreads, "number of files to read and write in parallel (env: "+fileThreadsEnv+")")
+	flags.Int64P("cache-max-size", "", 0, "max size of the cache in bytes before it's rotated (env: "+cacheMaxSizeEnv+")")
+	flags.BoolP("cache", "", true, "persist the cache between runs (env: "+cacheEnabledEnv+")")
+	flags.StringP("cache-shared-dir", "", "", "cache directory of another checkout to read entries from, without writing to it (env: "+sharedCacheEnv+")")
+	flags.StringP("temp-dir", "", "", "directory to create temporary files holding plaintexts in, rather than the OS's default (env: "+tempDirEnv+")")
+	flags.BoolP("no-cache", "", false, "bypass the persistent cache for this run, so every value goes through the provider, without deleting the cache")
+}
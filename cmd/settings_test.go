@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestResolveSettings(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repo fixtures.Repo
+	for _, r := range repos {
+		if !r.Skip() {
+			repo = r
+		}
+	}
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadFile(config.ConfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type source struct {
+		config string
+		env    string
+		flag   string
+	}
+	type expectation struct {
+		threads      uint
+		fileThreads  uint
+		cacheMaxSize int64
+		cacheEnabled bool
+	}
+	sources := map[string]source{
+		"threads":        {config: "threads: 2\n", env: "3", flag: "4"},
+		"file-threads":   {config: "fileThreads: 2\n", env: "3", flag: "4"},
+		"cache-max-size": {config: "cache:\n  maxSize: 2000\n", env: "3000", flag: "4000"},
+		"cache":          {config: "cache:\n  enabled: false\n", env: "true", flag: "false"},
+	}
+	envNames := map[string]string{
+		"threads":        threadsEnv,
+		"file-threads":   fileThreadsEnv,
+		"cache-max-size": cacheMaxSizeEnv,
+		"cache":          cacheEnabledEnv,
+	}
+	// each expectation is indexed by a bitmask of which sources are set: 1 for config, 2 for env, 4 for flag
+	expectations := map[string][8]expectation{
+		"threads": {
+			{config.DefaultThreads, config.DefaultFileThreads, 0, true},
+			{2, config.DefaultFileThreads, 0, true},
+			{3, config.DefaultFileThreads, 0, true},
+			{3, config.DefaultFileThreads, 0, true},
+			{4, config.DefaultFileThreads, 0, true},
+			{4, config.DefaultFileThreads, 0, true},
+			{4, config.DefaultFileThreads, 0, true},
+			{4, config.DefaultFileThreads, 0, true},
+		},
+		"file-threads": {
+			{config.DefaultThreads, config.DefaultFileThreads, 0, true},
+			{config.DefaultThreads, 2, 0, true},
+			{config.DefaultThreads, 3, 0, true},
+			{config.DefaultThreads, 3, 0, true},
+			{config.DefaultThreads, 4, 0, true},
+			{config.DefaultThreads, 4, 0, true},
+			{config.DefaultThreads, 4, 0, true},
+			{config.DefaultThreads, 4, 0, true},
+		},
+		"cache-max-size": {
+			{config.DefaultThreads, config.DefaultFileThreads, 0, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 2000, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 3000, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 3000, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 4000, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 4000, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 4000, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 4000, true},
+		},
+		"cache": {
+			{config.DefaultThreads, config.DefaultFileThreads, 0, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, false},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, true},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, false},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, false},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, false},
+			{config.DefaultThreads, config.DefaultFileThreads, 0, false},
+		},
+	}
+
+	for name, src := range sources {
+		for mask, expected := range expectations[name] {
+			t.Run(fmt.Sprintf("%s with config=%t env=%t flag=%t", name, mask&1 != 0, mask&2 != 0, mask&4 != 0), func(t *testing.T) {
+				content := string(original)
+				if mask&1 != 0 {
+					content += src.config
+				}
+				err := ioutil.WriteFile(config.ConfigFilename, []byte(content), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c, err := config.LoadConfig(".")
+				if err != nil {
+					t.Fatal(err)
+				}
+				env := map[string]string{}
+				if mask&2 != 0 {
+					env[envNames[name]] = src.env
+				}
+				flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+				addSettingsFlags(flags)
+				if mask&4 != 0 {
+					err = flags.Set(name, src.flag)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				err = resolveSettings(&c, flags, func(k string) string { return env[k] })
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual := expectation{c.Threads, c.FileThreads, c.CacheMaxSize, c.CacheEnabled}
+				if actual != expected {
+					t.Errorf("Resolved settings %+v, expected %+v", actual, expected)
+				}
+			})
+		}
+	}
+
+	// --no-cache wins over the cache being enabled everywhere else
+	err = ioutil.WriteFile(config.ConfigFilename, append(original, "cache:\n  enabled: true\n"...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addSettingsFlags(flags)
+	err = flags.Parse([]string{"--cache=true", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resolveSettings(&c, flags, func(k string) string { return map[string]string{cacheEnabledEnv: "true"}[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CacheEnabled {
+		t.Error("--no-cache did not disable the cache")
+	}
+	err = ioutil.WriteFile(config.ConfigFilename, original, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid environment variables should give an error
+	c, err = config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addSettingsFlags(flags)
+	err = resolveSettings(&c, flags, func(k string) string { return "invalid" })
+	if err == nil {
+		t.Error("Invalid environment variable values did not give an error")
+	}
+}
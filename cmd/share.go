@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var shareFlags struct {
+	multiline bool
+}
+
+var shareCmd = &cobra.Command{
+	Use:   "share [file]",
+	Short: "Encrypt a file or value with a newly generated key, for handing over to someone without access to the repo's provider",
+	Long:  "Encrypt a file or value with a newly generated key, for handing over to someone without access to the repo's provider. Given a file, its secrets are re-encrypted with the new key, and the resulting encrypted document is printed to STDOUT. Given no file, a value is read from STDIN, and its encrypted representation is printed to STDOUT. Either way, the key is printed to STDERR; hand it over separately, and the recipient can save it to a file and decrypt with --key.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Share(args, os.Stdin, os.Stdout, os.Stderr, shareFlags.multiline)
+	},
+}
+
+// Encrypt the file in args, or the value read from stdin if there's none, with a newly generated key. The encrypted output is written to stdout, and the key to keyOut.
+func Share(args []string, stdin io.Reader, stdout io.Writer, keyOut io.Writer, multiline bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	encodedKey, key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	usedProviders = append(usedProviders, key)
+	// nothing encrypted with a one-off key is worth keeping in the cache
+	keyConfig := config
+	keyConfig.Provider = key
+	keyConfig.CacheEnabled = false
+	keyCache, err := cache.Setup(keyConfig)
+	if err != nil {
+		return err
+	}
+	defer keyCache.Close()
+	var keyProvider crypto.Provider = key
+	// the key is the only provider the recipient has, whatever the repo's config maps values to
+	keyOpts := *opts
+	keyOpts.SingleProvider = true
+
+	var output bytes.Buffer
+	if len(args) == 0 {
+		plaintext, err := readValue(stdin, multiline)
+		if err != nil {
+			return err
+		}
+		ciphertext, err := actions.EncryptPlaintext(plaintext, keyCache, &keyProvider, &keyOpts)
+		if err != nil {
+			return err
+		}
+		output.WriteString(string(yaml.NewEncryptedValue(ciphertext)) + "\n")
+	} else {
+		file, err := actions.NewFile(args[0], &config)
+		if err != nil {
+			return err
+		}
+		cache, err := cache.Setup(config)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		var decrypted bytes.Buffer
+		err = actions.DecryptStream([]*actions.File{&file}, &decrypted, false, cache, &config.Provider, int(config.Threads), opts)
+		if err != nil {
+			return err
+		}
+		err = actions.EncryptStream(&decrypted, &output, "", keyCache, &keyProvider, int(config.Threads), &keyOpts)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(stdout, &output)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(keyOut, encodedKey+"\n")
+	return err
+}
+
+// Read a single line from a Reader, without its line break, or everything up to EOF if multiline is set.
+func readValue(r io.Reader, multiline bool) (string, error) {
+	if multiline {
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		if err == io.EOF {
+			return "", errors.New("No value given on STDIN")
+		}
+		return "", err
+	}
+	return strings.Trim(line, "\n"), nil
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+	shareCmd.Flags().BoolVarP(&shareFlags.multiline, "multi-line", "m", false, "when reading a value from STDIN, read multiple lines, stopping only at EOF")
+}
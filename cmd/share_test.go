@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShare(t *testing.T) {
+	progress = false
+	defer func() {
+		DecryptFlags.Identity = ""
+		decryptValueFlags.identity = ""
+	}()
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := "a: !secret one\nb:\n  - !secret two\n  - 3\n"
+		err = ioutil.WriteFile("share."+repo.Suffixes["decrypted"], []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = EncryptCmd.RunE(nil, []string{"share." + repo.Suffixes["decrypted"]})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// share the file, and decrypt the shared copy with the printed key
+		var shared, key bytes.Buffer
+		err = Share([]string{"share." + repo.Suffixes["encrypted"]}, nil, &shared, &key, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(key.String(), crypto.KeyPrefix) {
+			t.Fatalf("Sharing printed %q, expected a key", key.String())
+		}
+		keyPath := filepath.Join(repo.TmpDir, "key")
+		err = ioutil.WriteFile(keyPath, key.Bytes(), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile("shared."+repo.Suffixes["encrypted"], shared.Bytes(), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		DecryptFlags.Identity = keyPath
+		err = DecryptCmd.RunE(nil, []string{"shared." + repo.Suffixes["encrypted"]})
+		DecryptFlags.Identity = ""
+		if err != nil {
+			t.Fatalf("Decrypting a shared file in repo %s failed: %s", repo, err)
+		}
+		decrypted, err := ioutil.ReadFile("shared." + repo.Suffixes["decrypted"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != content {
+			t.Errorf("Decrypting a shared file in repo %s gave:\n%s", repo, decrypted)
+		}
+
+		// share a value, and decrypt it with the printed key
+		var value bytes.Buffer
+		key.Reset()
+		err = Share(nil, strings.NewReader("a secret\n"), &value, &key, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(keyPath, key.Bytes(), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptValueFlags.identity = keyPath
+		var plaintext bytes.Buffer
+		err = DecryptValue(&value, &plaintext)
+		decryptValueFlags.identity = ""
+		if err != nil {
+			t.Fatalf("Decrypting a shared value in repo %s failed: %s", repo, err)
+		}
+		if plaintext.String() != "a secret\n" {
+			t.Errorf("Decrypting a shared value in repo %s gave %q", repo, plaintext.String())
+		}
+	}
+}
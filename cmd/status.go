@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var statusFlags struct {
+	json bool
+}
+
+var statusCmd = &cobra.Command{
+	Use:                   "status [directory]",
+	Short:                 "List the decrypted files with changes that haven't been encrypted yet.",
+	Long:                  "List the decrypted files with changes that haven't been encrypted yet, like `git status` for secrets: new files, which have no encrypted version yet, and modified files, whose decrypted version differs from what decrypting their encrypted version gives. Encrypted values are decrypted in memory to compare them, through the cache where possible. Supplying no args will check all decrypted files in the repo.",
+	Args:                  cobra.MaximumNArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Status(os.Stdout, args, statusFlags.json)
+	},
+}
+
+func Status(stdout io.Writer, args []string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	dir := config.Root
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	paths, err := config.AllDecryptedFiles(dir)
+	if err != nil {
+		return err
+	}
+	files := make([]*actions.File, 0, len(paths))
+	for _, path := range paths {
+		file, err := actions.NewFile(path, &config)
+		if err != nil {
+			return err
+		}
+		files = append(files, &file)
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	results, err := actions.Status(files, cache, &config.Provider, int(config.Threads), opts)
+	if err != nil {
+		return err
+	}
+	pending := []actions.StatusResult{}
+	for _, result := range results {
+		if result.Status != actions.StatusClean {
+			pending = append(pending, result)
+		}
+	}
+	if asJSON {
+		return printJSON(stdout, pending)
+	}
+	for _, result := range pending {
+		fmt.Fprintf(stdout, "%s: %s\n", result.Status, result.File)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVarP(&statusFlags.json, "json", "", false, "print output as JSON")
+}
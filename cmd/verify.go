@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var verifyFlags struct {
+	json bool
+}
+
+var verifyCmd = &cobra.Command{
+	Use:                   "verify [file|directory]...",
+	Short:                 "Check that encrypted files can be decrypted, and that decrypted files are up to date.",
+	Long:                  "Check that every value in the encrypted files can be decrypted, and that any existing decrypted files are up to date with their encrypted versions. Exits with a non-zero status if any problems are found. Supplying no args will verify all encrypted files in the repo.",
+	Args:                  cobra.ArbitraryArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Verify(os.Stdout, args, verifyFlags.json)
+	},
+}
+
+func Verify(stdout io.Writer, args []string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	if len(args) == 0 {
+		args = []string{config.Root}
+	}
+	files := []*actions.File{}
+	for _, arg := range args {
+		var paths []string
+		if info, err := os.Stat(arg); !os.IsNotExist(err) && info.IsDir() {
+			paths, err = config.AllEncryptedFiles(arg)
+			if err != nil {
+				return err
+			}
+		} else {
+			paths = []string{arg}
+		}
+		for _, path := range paths {
+			file, err := actions.NewFile(path, &config)
+			if err != nil {
+				return err
+			}
+			files = append(files, &file)
+		}
+	}
+	results, err := actions.Verify(files, cache, &config.Provider, opts)
+	if err != nil {
+		return err
+	}
+	failed, err := printVerifyResults(stdout, results, asJSON)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.New("Verification failed")
+	}
+	return nil
+}
+
+// Print the results of verifying files, returning the number of files that failed.
+func printVerifyResults(stdout io.Writer, results []actions.VerifyResult, asJSON bool) (int, error) {
+	failed := 0
+	for _, result := range results {
+		if !result.Ok {
+			failed++
+		}
+	}
+	if asJSON {
+		return failed, printJSON(stdout, results)
+	}
+	for _, result := range results {
+		if result.Ok {
+			fmt.Fprintf(stdout, "ok: %s\n", result.File)
+		} else {
+			fmt.Fprintf(stdout, "failed: %s\n", result.File)
+		}
+		for _, e := range result.Errors {
+			fmt.Fprintf(stdout, "  %s: %s\n", e.Path, e.Message)
+		}
+	}
+	return failed, nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVarP(&verifyFlags.json, "json", "", false, "print output as JSON")
+}
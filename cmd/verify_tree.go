@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var verifyTreeFlags struct {
+	json bool
+}
+
+var verifyTreeCmd = &cobra.Command{
+	Use:                   "verify-tree [directory]",
+	Short:                 "Check that every file in a directory is fully encrypted and can be decrypted, eg. in CI.",
+	Long:                  "Check every file in a directory in one pass, eg. in CI: that each decrypted file has an encrypted version, that no value that looks like a secret was left unencrypted, that every encrypted value can be decrypted, and that any existing decrypted files are up to date. Files are checked in parallel, fileThreads at a time. Every problem found is reported, and the command exits with a non-zero status if there are any. Supplying no args will check the whole repo.",
+	Args:                  cobra.MaximumNArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return VerifyTree(os.Stdout, args, verifyTreeFlags.json)
+	},
+}
+
+func VerifyTree(stdout io.Writer, args []string, asJSON bool) error {
+	config, opts, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	cache, err := cache.Setup(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	dir := config.Root
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	results, err := actions.VerifyTree(dir, &config, cache, &config.Provider, opts)
+	if err != nil {
+		return err
+	}
+	failed, err := printVerifyResults(stdout, results, asJSON)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("Verification failed for %d of %d files", failed, len(results))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyTreeCmd)
+	verifyTreeCmd.Flags().BoolVarP(&verifyTreeFlags.json, "json", "", false, "print output as JSON")
+}
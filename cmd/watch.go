@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/actions"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var watchFlags struct {
+	debounce time.Duration
+}
+
+var watchCmd = &cobra.Command{
+	Use:                   "watch [directory]",
+	Short:                 "Re-encrypt decrypted files whenever they're saved, until interrupted.",
+	Long:                  "Watch the decrypted files under a directory, re-encrypting each one whenever it's saved, until interrupted. Supplying no directory watches the whole repo. A file is only re-encrypted once it has gone unchanged for the --debounce duration, and only if its contents have changed since it was last encrypted, or since watching started. Files that fail to encrypt are reported, and tried again on their next save.",
+	Args:                  cobra.MaximumNArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, opts, err := loadConfig(".")
+		if err != nil {
+			return err
+		}
+		dir := config.Root
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		cache, err := cache.Setup(config)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		// stop on interrupt, rather than exiting, so that the cache is closed and keys are wiped
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		stop := make(chan struct{})
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		return actions.Watch(dir, watchFlags.debounce, stop, func(file *actions.File, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error encrypting %s: %s\n", file.DecryptedPath, err)
+				return
+			}
+			fmt.Printf("encrypted %s\n", file.EncryptedPath)
+		}, &config, cache, &config.Provider, int(config.Threads), opts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().DurationVarP(&watchFlags.debounce, "debounce", "", 200*time.Millisecond, "how long a file must go unchanged after being saved before it's encrypted")
+}
@@ -6,12 +6,17 @@ require gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 
 require (
 	cloud.google.com/go v0.70.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
+	github.com/miekg/pkcs11 v1.0.3
 	github.com/prologic/bitcask v0.3.6
 	github.com/schollz/progressbar/v3 v3.7.3
 	github.com/sergi/go-diff v1.1.0
 	github.com/spf13/cobra v1.1.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43
 	google.golang.org/api v0.33.0
 	google.golang.org/genproto v0.0.0-20201019141844-1ed22bb0c154
+	google.golang.org/grpc v1.32.0
 )
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAnchorsAndMergeKeys(t *testing.T) {
+	provider := &testProvider{}
+	var p crypto.Provider = provider
+	config, cache, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	file, err := NewFile("anchors.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := `defaults: &defaults
+  password: !secret hunter2
+  host: localhost
+prod:
+  <<: *defaults
+  host: prod.example.com
+token: &token !secret abc
+other_token: *token
+`
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.encryptCalls != 2 {
+		t.Errorf("Expected 2 encrypt calls, got %d", provider.encryptCalls)
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"defaults: &defaults\n", "  <<: *defaults\n", "token: &token !encrypted ", "other_token: *token\n"} {
+		if !strings.Contains(string(encrypted), expected) {
+			t.Errorf("Encrypted file is missing %q:\n%s", expected, encrypted)
+		}
+	}
+	if strings.Contains(string(encrypted), "!!merge") {
+		t.Errorf("Encrypted file contains an explicit merge tag:\n%s", encrypted)
+	}
+
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != original {
+		t.Errorf("Round-trip changed the file:\n%s", decrypted)
+	}
+}
@@ -0,0 +1,76 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"os"
+	"sync"
+	"time"
+)
+
+// Serializes writes to the audit log within a process. Each record is appended in a single write, so records from other processes aren't interleaved with them either.
+var auditMutex sync.Mutex
+
+// A record in the audit log of decrypting a file, or some of its values. Plaintexts are never recorded.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	File string    `json:"file"`
+	// Paths of the values decrypted.
+	Paths []string `json:"paths"`
+	// Name of the provider the values were decrypted with, as given by crypto.Describe, and a fingerprint of the keys it decrypts with, as given by crypto.Fingerprint.
+	Provider    string `json:"provider"`
+	Fingerprint string `json:"fingerprint"`
+	Ok          bool   `json:"ok"`
+	// Why decrypting failed, if it did.
+	Error string `json:"error"`
+}
+
+// Append a record of decrypting the values at the given paths of a file to the audit log at AuditLog, if there is one, given the error decrypting them, if any. Returns that error, or else any error writing the record, so that decrypting fails if it can't be recorded.
+func (o *Options) audit(file string, paths []string, provider crypto.Provider, err error) error {
+	if o.AuditLog == "" {
+		return err
+	}
+	name, _ := crypto.Describe(provider)
+	entry := AuditEntry{
+		Time:        time.Now().UTC(),
+		File:        file,
+		Paths:       paths,
+		Provider:    name,
+		Fingerprint: crypto.Fingerprint(name, provider),
+		Ok:          err == nil,
+	}
+	if entry.Paths == nil {
+		entry.Paths = []string{}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	auditErr := appendAuditEntry(o.AuditLog, entry)
+	if err != nil {
+		return err
+	}
+	return auditErr
+}
+
+// Append an entry to the audit log at the given path, as a single line of JSON.
+func appendAuditEntry(path string, entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Error opening audit log: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing audit log: %w", err)
+	}
+	return nil
+}
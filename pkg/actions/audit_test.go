@@ -0,0 +1,134 @@
+package actions
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// A testProvider that fails to decrypt anything.
+type decryptFailingProvider struct {
+	*testProvider
+}
+
+func (p decryptFailingProvider) Decrypt(ciphertext []byte) (string, error) {
+	return "", errors.New("no access")
+}
+
+func TestAuditLog(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = crypto.NoopProvider{}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	files := []*File{}
+	for _, content := range []string{
+		"secret: !secret a\n",
+		"db:\n  user: b\n  password: !secret b\nkeys: [!secret b1, !secret b2]\n",
+		"nothing: here\n",
+	} {
+		file, err := NewFile(fmt.Sprintf("%d.decrypted.yaml", len(files)), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+	}
+	err := Encrypt(files, ca, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// encrypting isn't audited
+	opts.AuditLog = filepath.Join(c.Root, "audit.log")
+	err = Decrypt(files, false, false, ca, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := map[string][]string{
+		files[0].EncryptedPath: nil,
+		files[1].EncryptedPath: nil,
+		files[2].EncryptedPath: nil,
+	}
+	for _, file := range files {
+		for path := range encryptedValues(t, file.EncryptedPath) {
+			expectedPaths[file.EncryptedPath] = append(expectedPaths[file.EncryptedPath], path)
+		}
+	}
+	entries := readAuditLog(t, opts.AuditLog)
+	if len(entries) != len(files) {
+		t.Fatalf("Decrypting %d files wrote %d audit log entries, expected one per file: %+v", len(files), len(entries), entries)
+	}
+	for _, entry := range entries {
+		expected, ok := expectedPaths[entry.File]
+		if !ok {
+			t.Errorf("Audit log entry for unexpected file: %+v", entry)
+			continue
+		}
+		delete(expectedPaths, entry.File)
+		if expected == nil {
+			expected = []string{}
+		}
+		sort.Strings(expected)
+		sort.Strings(entry.Paths)
+		if !reflect.DeepEqual(entry.Paths, expected) {
+			t.Errorf("Audit log entry for %s has paths %v, expected %v", entry.File, entry.Paths, expected)
+		}
+		if entry.Provider != "noop" || entry.Fingerprint != crypto.Fingerprint("noop", provider) {
+			t.Errorf("Audit log entry for %s has provider %q with fingerprint %q", entry.File, entry.Provider, entry.Fingerprint)
+		}
+		if !entry.Ok || entry.Error != "" || entry.Time.IsZero() {
+			t.Errorf("Audit log entry for %s doesn't record a successful decryption: %+v", entry.File, entry)
+		}
+	}
+
+	// failures are recorded too, without a cache to fall back on
+	err = os.Remove(opts.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CacheEnabled = false
+	empty, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	provider = decryptFailingProvider{&testProvider{}}
+	err = Decrypt(files[:1], false, false, empty, &provider, 4, false, opts)
+	if err == nil {
+		t.Fatal("Expected decrypting with a failing provider to fail")
+	}
+	entries = readAuditLog(t, opts.AuditLog)
+	if len(entries) != 1 || entries[0].File != files[0].EncryptedPath || entries[0].Ok || entries[0].Error != err.Error() || !reflect.DeepEqual(entries[0].Paths, []string{"secret"}) {
+		t.Errorf("Failed decryption wrote audit log entries %+v, expected one failure for %s", entries, files[0].EncryptedPath)
+	}
+}
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("Invalid audit log line %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,127 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"time"
+)
+
+// Encrypt all of the plaintexts that aren't already in the cache with a single call to the named provider, and add them to the cache, getting the ciphertexts of all of them by key. Keys are given by scopedKey, as for encryptPlaintexts. Each value is checked as encryptPlaintext checks it, and errors about a value name it, as they do when encrypting values one at a time.
+func encryptPlaintextsBatch(keys []string, cache *cache.Cache, provider *crypto.Provider, name string, batch crypto.BatchProvider, progress bool, opts *Options) (map[string][]byte, error) {
+	results := make(map[string][]byte, len(keys))
+	missedKeys := make([]string, 0, len(keys))
+	misses := make([]string, 0, len(keys))
+	for _, key := range keys {
+		scope, plaintext := splitScopedKey(key)
+		if err := opts.checkValueSize(plaintext); err != nil {
+			return nil, newValueError(plaintext, err)
+		}
+		// empty values are never cached, so they always go to the provider
+		ciphertext, ok, err := cache.Encrypt(plaintext, []byte{}, name, scope)
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up plaintext in cache: %w", err)
+		}
+		opts.reportCacheLookup(ok)
+		if ok {
+			results[key] = ciphertext
+		} else {
+			missedKeys = append(missedKeys, key)
+			misses = append(misses, plaintext)
+		}
+	}
+	if len(misses) == 0 {
+		return results, nil
+	}
+	bar := newProgressBar(len(misses), progress)
+	var ciphertexts [][]byte
+	err := opts.withRefresh(*provider, func() (err error) {
+		start := time.Now()
+		ciphertexts, err = crypto.EncryptBatch(batch, misses)
+		opts.reportProviderCall("encrypt_batch", start, err)
+		return err
+	})
+	if errors.Is(err, crypto.ErrCredentialsRejected) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Error using provider to encrypt plaintexts: %w", err)
+	}
+	if len(ciphertexts) != len(misses) {
+		return nil, fmt.Errorf("Provider returned %d ciphertexts for %d plaintexts", len(ciphertexts), len(misses))
+	}
+	for i, ciphertext := range ciphertexts {
+		scope, _ := splitScopedKey(missedKeys[i])
+		err = addCiphertext(misses[i], ciphertext, scope, cache)
+		if err != nil {
+			return nil, newValueError(misses[i], err)
+		}
+		results[missedKeys[i]] = ciphertext
+		if bar != nil {
+			bar.Add(1)
+		}
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	return results, nil
+}
+
+// Decrypt all of the ciphertexts that aren't already in the cache with a single call to the provider, and add them to the cache, getting the plaintexts of all of them by ciphertext. Each value is checked as decryptCiphertext checks it, and errors about a value name it, as they do when decrypting values one at a time.
+func decryptCiphertextsBatch(ciphertexts []string, cache *cache.Cache, provider *crypto.Provider, batch crypto.BatchProvider, progress bool, opts *Options) (map[string]string, error) {
+	results := make(map[string]string, len(ciphertexts))
+	misses := make([][]byte, 0, len(ciphertexts))
+	for _, ciphertext := range ciphertexts {
+		// nulls aren't encrypted, see yaml.EncryptNode
+		if len(ciphertext) == 0 {
+			results[ciphertext] = ""
+			continue
+		}
+		plaintext, ok, err := cache.Decrypt([]byte(ciphertext))
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up ciphertext in cache: %w", err)
+		}
+		opts.reportCacheLookup(ok)
+		if ok {
+			results[ciphertext] = plaintext
+		} else {
+			misses = append(misses, []byte(ciphertext))
+		}
+	}
+	if len(misses) == 0 {
+		return results, nil
+	}
+	if err := checkCanDecrypt(provider); err != nil {
+		return nil, err
+	}
+	bar := newProgressBar(len(misses), progress)
+	var plaintexts []string
+	err := opts.withRefresh(*provider, func() (err error) {
+		start := time.Now()
+		plaintexts, err = crypto.DecryptBatch(batch, misses)
+		opts.reportProviderCall("decrypt_batch", start, err)
+		return err
+	})
+	if errors.Is(err, crypto.ErrCredentialsRejected) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Error using provider to decrypt ciphertexts: %w", err)
+	}
+	if len(plaintexts) != len(misses) {
+		return nil, fmt.Errorf("Provider returned %d plaintexts for %d ciphertexts", len(plaintexts), len(misses))
+	}
+	for i, plaintext := range plaintexts {
+		err = opts.addPlaintext(misses[i], plaintext, cache)
+		if err != nil {
+			return nil, newValueError(string(misses[i]), err)
+		}
+		results[string(misses[i])] = plaintext
+		if bar != nil {
+			bar.Add(1)
+		}
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	return results, nil
+}
@@ -0,0 +1,165 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A testProvider that can encrypt and decrypt in batches, and counts its batched calls.
+type batchTestProvider struct {
+	*testProvider
+	encryptBatchCalls int
+	decryptBatchCalls int
+}
+
+func (p *batchTestProvider) EncryptBatch(plaintexts []string) ([][]byte, error) {
+	p.encryptBatchCalls++
+	ciphertexts := make([][]byte, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		ciphertext, err := p.testProvider.Encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		ciphertexts[i] = ciphertext
+	}
+	p.encryptCalls -= len(plaintexts)
+	return ciphertexts, nil
+}
+
+func (p *batchTestProvider) DecryptBatch(ciphertexts [][]byte) ([]string, error) {
+	p.decryptBatchCalls++
+	plaintexts := make([]string, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		plaintext, err := p.testProvider.Decrypt(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		plaintexts[i] = plaintext
+	}
+	p.decryptCalls -= len(ciphertexts)
+	return plaintexts, nil
+}
+
+func TestBatchProvider(t *testing.T) {
+	inner := &batchTestProvider{testProvider: &testProvider{}}
+	var provider crypto.Provider = inner
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("batch.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\nb: !secret two\nc: [!secret three, !secret one]\nd: !secret \"\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.encryptBatchCalls != 1 || inner.encryptCalls != 0 {
+		t.Errorf("Encrypting made %d batched and %d single calls, rather than 1 batched call", inner.encryptBatchCalls, inner.encryptCalls)
+	}
+
+	// forget the values, so they have to be decrypted by the provider
+	for _, ciphertext := range encryptedValues(t, file.EncryptedPath) {
+		if ciphertext != "" {
+			err = cache.Remove([]byte(ciphertext))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.decryptBatchCalls != 1 || inner.decryptCalls != 0 {
+		t.Errorf("Decrypting made %d batched and %d single calls, rather than 1 batched call", inner.decryptBatchCalls, inner.decryptCalls)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "a: !secret one\nb: !secret two\nc: [!secret three, !secret one]\nd: !secret \"\"\n" {
+		t.Errorf("Batch decrypted file is incorrect:\n%s", decrypted)
+	}
+}
+
+func TestBatchProviderThroughRouter(t *testing.T) {
+	inner := &batchTestProvider{testProvider: &testProvider{}}
+	var provider crypto.Provider = crypto.Router{Default: inner, Named: map[string]crypto.Provider{"prod": &testProvider{}}}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("batch.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\nb: !secret two\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.encryptBatchCalls != 1 || inner.encryptCalls != 0 {
+		t.Errorf("Encrypting through a router made %d batched and %d single calls, rather than 1 batched call", inner.encryptBatchCalls, inner.encryptCalls)
+	}
+}
+
+// An emptyOutputProvider that encrypts in batches.
+type emptyBatchProvider struct {
+	emptyOutputProvider
+}
+
+func (p emptyBatchProvider) EncryptBatch(plaintexts []string) ([][]byte, error) {
+	ciphertexts := make([][]byte, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		ciphertext, err := p.Encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		ciphertexts[i] = ciphertext
+	}
+	return ciphertexts, nil
+}
+
+func (p emptyBatchProvider) DecryptBatch(ciphertexts [][]byte) ([]string, error) {
+	plaintexts := make([]string, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		plaintext, err := p.Decrypt(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		plaintexts[i] = plaintext
+	}
+	return plaintexts, nil
+}
+
+func TestBatchProviderEmptyCiphertext(t *testing.T) {
+	var provider crypto.Provider = &batchTestProvider{testProvider: &testProvider{}}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("batch.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret here\nlost: !secret gone\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// batch results go through the same checks as single values, so errors still name the value's path
+	var batch crypto.Provider = emptyBatchProvider{emptyOutputProvider{&testProvider{}}}
+	err = Encrypt([]*File{&file}, cache, &batch, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "path lost") || !strings.Contains(err.Error(), errEmptyCiphertext.Error()) {
+		t.Errorf("Batch encrypting with a provider returning an empty ciphertext gave error %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCachePerFile(t *testing.T) {
+	// moving a value warns about the secrets each file gained or lost
+	Warnings = ioutil.Discard
+	defer func() { Warnings = os.Stderr }()
+	for _, perFile := range []bool{false, true} {
+		provider := &testProvider{}
+		var p crypto.Provider = provider
+		config, cache, cleanup := setupTestRepo(t, p)
+		config.CachePerFile = perFile
+		files := []*File{}
+		for _, name := range []string{"app", "db"} {
+			file, err := NewFile(name+".decrypted.yaml", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(file.DecryptedPath, []byte("password: !secret hunter2\n"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, &file)
+		}
+		err := ioutil.WriteFile(files[0].DecryptedPath, []byte("password: !secret hunter2\nmoving: !secret s3cret\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Encrypt(files, cache, &p, 4, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app, db := encryptedValues(t, files[0].EncryptedPath), encryptedValues(t, files[1].EncryptedPath)
+		if perFile && app["password"] == db["password"] {
+			t.Errorf("The same plaintext in two files was given the same ciphertext, with the cache scoped per file")
+		} else if !perFile && app["password"] != db["password"] {
+			t.Errorf("The same plaintext in two files was given different ciphertexts, with the cache shared between files")
+		}
+
+		// encrypting again keeps every ciphertext, from the cache
+		calls := provider.encryptCalls
+		err = Encrypt(files, cache, &p, 4, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if provider.encryptCalls != calls {
+			t.Errorf("Encrypting unchanged files called the provider %d times, with the cache scoped per file: %t", provider.encryptCalls-calls, perFile)
+		}
+
+		// move a value from one file to the other
+		err = ioutil.WriteFile(files[0].DecryptedPath, []byte("password: !secret hunter2\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(files[1].DecryptedPath, []byte("password: !secret hunter2\nmoving: !secret s3cret\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Encrypt(files, cache, &p, 4, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved := encryptedValues(t, files[1].EncryptedPath)["moving"]
+		if perFile && moved == app["moving"] {
+			t.Errorf("A value moved to another file kept its ciphertext, with the cache scoped per file")
+		} else if !perFile && moved != app["moving"] {
+			t.Errorf("A value moved to another file was encrypted again, with the cache shared between files")
+		}
+		cleanup()
+	}
+}
+
+func TestCachePerFileBatch(t *testing.T) {
+	provider := &batchTestProvider{testProvider: &testProvider{}}
+	var p crypto.Provider = provider
+	config, cache, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	config.CachePerFile = true
+	files := []*File{}
+	for _, name := range []string{"app", "db"} {
+		file, err := NewFile(name+".decrypted.yaml", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte("password: !secret hunter2\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+	}
+	// every file's values are encrypted together, though each file gets its own ciphertext
+	err := Encrypt(files, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.encryptBatchCalls != 1 {
+		t.Errorf("Encrypting two files scoped per file made %d batched calls, rather than 1", provider.encryptBatchCalls)
+	}
+	if encryptedValues(t, files[0].EncryptedPath)["password"] == encryptedValues(t, files[1].EncryptedPath)["password"] {
+		t.Errorf("The same plaintext in two files was given the same ciphertext, with the cache scoped per file")
+	}
+}
@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+)
+
+// A cached plaintext that the provider no longer decrypts its ciphertext to, eg. because it was cached under an old key.
+type StaleCacheEntry struct {
+	File    string `json:"file"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Check that the cached plaintext of each encrypted value in the files, if there is one, is still what the provider decrypts it to. Values that aren't cached are skipped. If purge is set, stale entries are removed from the cache, so that their values are decrypted by the provider next time.
+func VerifyCache(files []*File, purge bool, cache *cache.Cache, provider *crypto.Provider, opts *Options) ([]StaleCacheEntry, error) {
+	opts = opts.withDefaults()
+	if err := checkCanDecrypt(provider); err != nil {
+		return []StaleCacheEntry{}, err
+	}
+	stale := []StaleCacheEntry{}
+	err := forEachContext(files, cache, provider, func(files []*File, provider *crypto.Provider) error {
+		var err error
+		stale, err = verifyCache(stale, files, purge, cache, provider, opts)
+		return err
+	})
+	return stale, err
+}
+
+func verifyCache(stale []StaleCacheEntry, files []*File, purge bool, cache *cache.Cache, provider *crypto.Provider, opts *Options) ([]StaleCacheEntry, error) {
+	for _, file := range files {
+		node, err := opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return stale, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		paths := []string{}
+		ciphertexts := map[string][]byte{}
+		for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+			ciphertext, valueErr := yaml.GetValue(n.YamlNode)
+			if valueErr != nil {
+				err = fmt.Errorf("Error reading encrypted value at path %s: %w", n.Path.Dotted(), valueErr)
+			}
+			paths = append(paths, n.Path.Dotted())
+			ciphertexts[n.Path.Dotted()] = []byte(ciphertext)
+		}
+		if err != nil {
+			return stale, err
+		}
+		for _, path := range paths {
+			ciphertext := ciphertexts[path]
+			cached, ok, err := cache.Cached(ciphertext)
+			if err != nil {
+				return stale, fmt.Errorf("Error looking up ciphertext in cache: %w", err)
+			}
+			if !ok {
+				continue
+			}
+			var message string
+			var plaintext string
+			err = opts.withRefresh(*provider, func() error {
+				plaintext, err = crypto.Decrypt(*provider, ciphertext)
+				return err
+			})
+			if errors.Is(err, crypto.ErrCredentialsRejected) {
+				// the entry may well be fine, so it mustn't be reported stale, let alone purged
+				return stale, err
+			}
+			if err == nil {
+				err = opts.checkPlaintext(plaintext)
+			}
+			if err != nil {
+				message = fmt.Sprintf("Provider failed to decrypt cached value: %s", err)
+			} else if plaintext != cached {
+				message = "Provider decrypts cached value to a different plaintext"
+			} else {
+				continue
+			}
+			stale = append(stale, StaleCacheEntry{file.EncryptedPath, path, message})
+			if purge {
+				err = cache.Remove(ciphertext)
+				if err != nil {
+					return stale, err
+				}
+			}
+		}
+	}
+	return stale, nil
+}
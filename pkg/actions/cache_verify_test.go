@@ -0,0 +1,74 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"testing"
+)
+
+func TestVerifyCache(t *testing.T) {
+	inner := &testProvider{}
+	var provider crypto.Provider = inner
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("stale.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("fresh: !secret 1\nstale: !secret 2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := VerifyCache([]*File{&file}, false, cache, &provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("Fresh cache reported stale entries: %v", stale)
+	}
+
+	// seed a stale entry, as if the value had been cached under an old key
+	ciphertext := []byte(encryptedValues(t, file.EncryptedPath)["stale"])
+	err = cache.Remove(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Add("old plaintext", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err = VerifyCache([]*File{&file}, false, cache, &provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].Path != "stale" {
+		t.Fatalf("Expected one stale entry at path stale, got %v", stale)
+	}
+
+	// purging removes it, so the value is decrypted by the provider again
+	_, err = VerifyCache([]*File{&file}, true, cache, &provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := cache.Decrypt(ciphertext); ok {
+		t.Errorf("Stale entry is still in the cache after purging")
+	}
+	plaintext, err := DecryptCiphertext(ciphertext, cache, &provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "2" {
+		t.Errorf("Value decrypted to %q after purging, expected %q", plaintext, "2")
+	}
+	stale, err = VerifyCache([]*File{&file}, false, cache, &provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("Stale entries remain after purging: %v", stale)
+	}
+}
@@ -0,0 +1,61 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCacheWriteFailure(t *testing.T) {
+	defer func() { cache.Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	cache.Warnings = warnings
+	inner := &testProvider{}
+	var provider crypto.Provider = inner
+	config, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("large.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bitcask refuses values over 64KiB, so writing this one to the on-disk cache fails, as it would on a full disk
+	content := "large: !secret " + strings.Repeat("x", 100*1024) + "\nsmall: !secret hunter2\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypting still succeeds, with a warning
+	err = Encrypt([]*File{&file}, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), "error writing to cache") {
+		t.Errorf("Failing to write to the cache did not warn: %q", warnings)
+	}
+
+	// and the values are still cached for the rest of the session
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.decryptCalls = 0
+	err = Decrypt([]*File{&file}, false, false, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.decryptCalls != 0 {
+		t.Errorf("Decrypting after failing to write to the cache called the provider %d times", inner.decryptCalls)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != content {
+		t.Errorf("Decrypting after failing to write to the cache gave:\n%.200s\nexpected:\n%.200s", decrypted, content)
+	}
+}
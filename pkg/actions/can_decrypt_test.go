@@ -0,0 +1,63 @@
+package actions
+
+import (
+	"errors"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptOnlyProvider(t *testing.T) {
+	inner := &testProvider{}
+	var provider crypto.Provider = inner
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("encrypt-only.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encryptOnly crypto.Provider = encryptOnlyTestProvider{inner}
+	// encrypting still works, keeping cached ciphertexts
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\nb: !secret two\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := encryptedValues(t, file.EncryptedPath)
+	err = Encrypt([]*File{&file}, cache, &encryptOnly, 4, false, nil)
+	if err != nil {
+		t.Fatalf("Encrypting with an encrypt-only provider failed: %s", err)
+	}
+	if after := encryptedValues(t, file.EncryptedPath); after["a"] != before["a"] {
+		t.Errorf("Encrypting with an encrypt-only provider changed an existing ciphertext")
+	}
+
+	// anything that needs to decrypt fails up front
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &encryptOnly, 4, false, nil)
+	if !errors.Is(err, crypto.ErrCannotDecrypt) {
+		t.Errorf("Decrypting with an encrypt-only provider gave error %v, expected %v", err, crypto.ErrCannotDecrypt)
+	}
+	if exists(file.DecryptedPath) {
+		t.Errorf("Decrypting with an encrypt-only provider wrote a decrypted file")
+	}
+	_, err = DecryptCiphertext([]byte("1:uncached"), cache, &encryptOnly, nil)
+	if !errors.Is(err, crypto.ErrCannotDecrypt) {
+		t.Errorf("Decrypting an uncached value with an encrypt-only provider gave error %v, expected %v", err, crypto.ErrCannotDecrypt)
+	}
+	if inner.decryptCalls != 0 {
+		t.Errorf("Encrypt-only provider was asked to decrypt %d times", inner.decryptCalls)
+	}
+}
@@ -0,0 +1,110 @@
+package actions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// What git knows of the files under a directory: the absolute paths of those changed since a ref, including files it doesn't track yet, and of those it tracks.
+type gitChanges struct {
+	changed map[string]bool
+	tracked map[string]bool
+}
+
+// Get the files under dir changed since ref, according to git. A variable so that tests can stub git out.
+var gitChangedSince = func(dir, ref string) (gitChanges, error) {
+	changes := gitChanges{}
+	var err error
+	changes.changed, err = gitPaths(dir, "diff", "--name-only", "--relative", "-z", ref, "--", ".")
+	if err != nil {
+		return changes, fmt.Errorf("Error getting files changed since %s: %w", ref, err)
+	}
+	untracked, err := gitPaths(dir, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return changes, fmt.Errorf("Error getting untracked files: %w", err)
+	}
+	for path := range untracked {
+		changes.changed[path] = true
+	}
+	changes.tracked, err = gitPaths(dir, "ls-files", "-z")
+	if err != nil {
+		return changes, fmt.Errorf("Error getting tracked files: %w", err)
+	}
+	return changes, nil
+}
+
+// Run a git command in dir listing NUL-separated paths relative to it, and get their absolute paths.
+func gitPaths(dir string, args ...string) (map[string]bool, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	paths := map[string]bool{}
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			paths[filepath.Join(dir, filepath.FromSlash(path))] = true
+		}
+	}
+	return paths, nil
+}
+
+// Pick out the files that need encrypting since the given git ref, so that encrypting a large repo in CI only touches what changed. A file is picked if git reports its encrypted or decrypted version changed since ref, or doesn't track one of them yet. Git can't see into decrypted versions it ignores, as they are by default, so those are compared with their encrypted versions instead, as Status does, which only calls the provider for values that aren't cached; one that's unchanged is skipped all the same.
+func ChangedSince(files []*File, root, ref string, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) ([]*File, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := gitChangedSince(root, ref)
+	if err != nil {
+		return nil, err
+	}
+	changed := []*File{}
+	unknown := []*File{}
+	picked := map[*File]bool{}
+	for _, file := range files {
+		decrypted, err := filepath.Abs(file.DecryptedPath)
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := filepath.Abs(file.EncryptedPath)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case changes.changed[decrypted] || changes.changed[encrypted] || !exists(file.EncryptedPath):
+			picked[file] = true
+		case !changes.tracked[decrypted]:
+			unknown = append(unknown, file)
+		}
+	}
+	if len(unknown) > 0 {
+		results, err := Status(unknown, cache, provider, threads, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i, result := range results {
+			if result.Status != StatusClean {
+				picked[unknown[i]] = true
+			}
+		}
+	}
+	// keep the order the files were given in
+	for _, file := range files {
+		if picked[file] {
+			changed = append(changed, file)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestChangedSince(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	files := map[string]*File{}
+	for _, name := range []string{"tracked-changed", "tracked-unchanged", "ignored-changed", "ignored-unchanged", "new"} {
+		file, err := NewFile(name+".decrypted.yaml", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte("password: !secret hunter2\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = &file
+	}
+	all := []*File{files["tracked-changed"], files["tracked-unchanged"], files["ignored-changed"], files["ignored-unchanged"]}
+	err := Encrypt(all, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all = append(all, files["new"])
+	for _, name := range []string{"tracked-changed", "tracked-unchanged", "ignored-changed"} {
+		err = ioutil.WriteFile(files[name].DecryptedPath, []byte("password: !secret changed\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	abs := func(path string) string {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return abs
+	}
+	var dir, ref string
+	defer func(original func(string, string) (gitChanges, error)) { gitChangedSince = original }(gitChangedSince)
+	gitChangedSince = func(d, r string) (gitChanges, error) {
+		dir, ref = d, r
+		// the tracked decrypted files are tracked, but git only reports one of them as changed, so the other is trusted to be unchanged
+		return gitChanges{
+			changed: map[string]bool{abs(files["tracked-changed"].DecryptedPath): true},
+			tracked: map[string]bool{abs(files["tracked-changed"].DecryptedPath): true, abs(files["tracked-unchanged"].DecryptedPath): true},
+		}, nil
+	}
+	changed, err := ChangedSince(all, c.Root, "main", cache, &provider, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != abs(c.Root) || ref != "main" {
+		t.Errorf("Expected git to be asked about main in %s, got %s in %s", abs(c.Root), ref, dir)
+	}
+	expected := []*File{files["tracked-changed"], files["ignored-changed"], files["new"]}
+	if len(changed) != len(expected) {
+		t.Fatalf("Expected %d changed files, got %d", len(expected), len(changed))
+	}
+	for i := range expected {
+		if changed[i] != expected[i] {
+			t.Errorf("Expected changed file %d to be %s, got %s", i, expected[i].DecryptedPath, changed[i].DecryptedPath)
+		}
+	}
+}
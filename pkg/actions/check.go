@@ -0,0 +1,43 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+// Make sure that no values that look like secrets were left unencrypted in the files' encrypted versions, ie. values whose mapping keys match the given pattern, or values still tagged as secrets, eg. in a file left decrypted by DecryptInPlace. Guards against secrets that were meant to be encrypted being missed, eg. due to a typo in EncryptPaths. Values in opts.PlaintextPaths were left unencrypted on purpose, so they're allowed, unless they're tagged as secrets.
+func CheckEncrypted(files []*File, pattern *regexp.Regexp, opts *Options) error {
+	opts = opts.withDefaults()
+	problems := []string{}
+	for _, file := range files {
+		node, err := opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		for _, path := range opts.unencryptedSecretPaths(&node, pattern) {
+			problems = append(problems, fmt.Sprintf("%s: %s", file.EncryptedPath, path))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Values that look like secrets were left unencrypted:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Get the paths of the values in an encrypted node that look like secrets but aren't encrypted: those tagged as secrets, and those whose mapping keys match the given pattern, other than those left unencrypted on purpose by PlaintextPaths.
+func (o *Options) unencryptedSecretPaths(node *yamlv3.Node, pattern *regexp.Regexp) []string {
+	paths := yaml.GetTaggedChildrenPaths(node, yaml.DecryptedTag)
+	tagged := map[string]bool{}
+	for _, path := range paths {
+		tagged[path] = true
+	}
+	for _, path := range yaml.GetPlaintextPathsMatchingKey(node, pattern) {
+		if !tagged[path] && !yaml.MatchAnyPath(o.PlaintextPaths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
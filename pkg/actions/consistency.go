@@ -0,0 +1,179 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Where warnings are written.
+var Warnings io.Writer = os.Stderr
+
+// The secret paths added and removed in a decrypted file, relative to its encrypted version.
+type PathChanges struct {
+	Added   []string
+	Removed []string
+}
+
+func (c PathChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+func (c PathChanges) String() string {
+	var parts []string
+	if len(c.Added) > 0 {
+		parts = append(parts, "added: "+strings.Join(c.Added, ", "))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, "removed: "+strings.Join(c.Removed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Compare the secret paths of an encrypted file with those of its decrypted version.
+func comparePaths(encrypted, decrypted []string) PathChanges {
+	changes := PathChanges{Added: []string{}, Removed: []string{}}
+	encryptedSet := map[string]bool{}
+	for _, path := range encrypted {
+		encryptedSet[path] = true
+	}
+	decryptedSet := map[string]bool{}
+	for _, path := range decrypted {
+		decryptedSet[path] = true
+		if !encryptedSet[path] {
+			changes.Added = append(changes.Added, path)
+		}
+	}
+	for _, path := range encrypted {
+		if !decryptedSet[path] {
+			changes.Removed = append(changes.Removed, path)
+		}
+	}
+	return changes
+}
+
+// Warn about, or if StrictPaths is set, fail on, any secrets added to or removed from a decrypted file relative to its encrypted version. Removed secrets also fail if RemovedSecrets says so.
+func (o *Options) reportPathChanges(file *File, changes PathChanges) error {
+	if changes.Empty() {
+		return nil
+	}
+	if o.StrictPaths {
+		return fmt.Errorf("Secrets in %s differ from those in %s (%s)", file.DecryptedPath, file.EncryptedPath, changes)
+	}
+	if len(changes.Removed) > 0 && o.RemovedSecrets == config.RemovedSecretsError {
+		return fmt.Errorf("Secrets were removed from %s (%s). Remove them from %s as well, or set removedSecrets to %s", file.DecryptedPath, strings.Join(changes.Removed, ", "), file.EncryptedPath, config.RemovedSecretsDrop)
+	}
+	fmt.Fprintf(Warnings, "Warning: secrets in %s differ from those in %s (%s)\n", file.DecryptedPath, file.EncryptedPath, changes)
+	if len(changes.Removed) > 0 && o.RemovedSecrets == config.RemovedSecretsKeep {
+		fmt.Fprintf(Warnings, "Warning: keeping secrets removed from %s in %s\n", file.DecryptedPath, file.EncryptedPath)
+	}
+	return nil
+}
+
+// Copy the encrypted values that were removed from a decrypted node over into it, so that they're kept as-is when it's encrypted. Values are matched by their decrypted path, ie. with any encrypted keys on the way to them decrypted, given the plaintexts of existing ciphertexts. Only values whose parent mapping is still present in the decrypted node can be kept, since there's no telling where a removed sequence item belonged; the decrypted paths of the others are returned.
+func keepRemovedSecrets(encrypted, decrypted *yamlv3.Node, plaintexts map[string]string) ([]string, error) {
+	encryptedDoc, err := walkDecryptedPaths(encrypted, plaintexts)
+	if err != nil {
+		return nil, err
+	}
+	decryptedDoc, err := walkDecryptedPaths(decrypted, plaintexts)
+	if err != nil {
+		return nil, err
+	}
+	unkept := []string{}
+	for _, path := range encryptedDoc.secrets {
+		if _, ok := decryptedDoc.values[path]; ok {
+			continue
+		}
+		e := encryptedDoc.values[path]
+		parent, ok := decryptedDoc.containers[e.parentPath]
+		if !ok || parent.Kind != yamlv3.MappingNode || e.parent.Kind != yamlv3.MappingNode {
+			unkept = append(unkept, path)
+			continue
+		}
+		parent.Content = append(parent.Content, e.parent.Content[e.index-1], e.parent.Content[e.index])
+	}
+	return unkept, nil
+}
+
+// Where a value is in a document: the mapping or sequence holding it, its index in that's contents, and that's decrypted path.
+type valueEntry struct {
+	parent     *yamlv3.Node
+	index      int
+	parentPath string
+}
+
+// The values, mappings and sequences of a document by decrypted path, along with the paths of its secrets, in order. Secrets are values tagged !secret or !encrypted, or with keys that are.
+type decryptedPaths struct {
+	values     map[string]valueEntry
+	containers map[string]*yamlv3.Node
+	secrets    []string
+}
+
+// Get the decrypted paths of a document. Anything under an encrypted key whose plaintext isn't known is left out, since its decrypted path can't be told.
+func walkDecryptedPaths(node *yamlv3.Node, plaintexts map[string]string) (decryptedPaths, error) {
+	out := decryptedPaths{values: map[string]valueEntry{}, containers: map[string]*yamlv3.Node{}, secrets: []string{}}
+	var walk func(node *yamlv3.Node, segments []string) error
+	add := func(parent *yamlv3.Node, index int, segments []string, secret bool) error {
+		path := yaml.JoinPath(segments)
+		out.values[path] = valueEntry{parent, index, yaml.JoinPath(segments[:len(segments)-1])}
+		child := parent.Content[index]
+		if secret || child.Tag == yaml.DecryptedTag || child.Tag == yaml.EncryptedTag {
+			out.secrets = append(out.secrets, path)
+			// the contents of a secret are encrypted as a whole
+			return nil
+		}
+		return walk(child, segments)
+	}
+	walk = func(node *yamlv3.Node, segments []string) error {
+		switch node.Kind {
+		case yamlv3.DocumentNode:
+			for _, child := range node.Content {
+				err := walk(child, segments)
+				if err != nil {
+					return err
+				}
+			}
+		case yamlv3.MappingNode:
+			out.containers[yaml.JoinPath(segments)] = node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				name := key.Value
+				var err error
+				switch key.Tag {
+				case yaml.DecryptedTag:
+					name, err = yaml.GetValue(key)
+				case yaml.EncryptedTag:
+					var ciphertext string
+					var ok bool
+					ciphertext, err = yaml.GetValue(key)
+					if name, ok = plaintexts[ciphertext]; !ok && err == nil {
+						continue
+					}
+				}
+				if err != nil {
+					return err
+				}
+				err = add(node, i+1, append(append([]string{}, segments...), name), key.Tag == yaml.DecryptedTag || key.Tag == yaml.EncryptedTag)
+				if err != nil {
+					return err
+				}
+			}
+		case yamlv3.SequenceNode:
+			out.containers[yaml.JoinPath(segments)] = node
+			for i := range node.Content {
+				err := add(node, i, append(append([]string{}, segments...), strconv.Itoa(i)), false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return out, walk(node, []string{})
+}
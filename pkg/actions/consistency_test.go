@@ -0,0 +1,230 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComparePaths(t *testing.T) {
+	changes := comparePaths([]string{"a", "b", "c.0"}, []string{"a", "c.0", "c.1", "d"})
+	if !reflect.DeepEqual(changes.Added, []string{"c.1", "d"}) {
+		t.Errorf("Incorrect added paths %v", changes.Added)
+	}
+	if !reflect.DeepEqual(changes.Removed, []string{"b"}) {
+		t.Errorf("Incorrect removed paths %v", changes.Removed)
+	}
+	if !comparePaths([]string{"a"}, []string{"a"}).Empty() {
+		t.Errorf("Identical paths reported as changed")
+	}
+}
+
+func TestPathChanges(t *testing.T) {
+	opts := &Options{}
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Warnings = os.Stderr }()
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := config.LoadConfig(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := cache.Setup(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		file, err := NewFile("changes."+repo.Suffixes["decrypted"], &config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		warnings := &bytes.Buffer{}
+		Warnings = warnings
+
+		// initial encryption: nothing to compare against
+		err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret 1\nremoved: !secret 2\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Encrypt([]*File{&file}, c, &config.Provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if warnings.Len() > 0 {
+			t.Errorf("Initial encryption in repo %s gave warnings: %s", repo, warnings)
+		}
+		original, err := ioutil.ReadFile(file.EncryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// add and remove keys
+		err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret 1\nadded: !secret 3\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.StrictPaths = true
+		err = Encrypt([]*File{&file}, c, &config.Provider, 4, false, opts)
+		if err == nil {
+			t.Errorf("Strict encryption in repo %s did not fail despite changed secrets", repo)
+		} else if !strings.Contains(err.Error(), "added: added") || !strings.Contains(err.Error(), "removed: removed") {
+			t.Errorf("Strict encryption error in repo %s does not list changed paths: %s", repo, err)
+		}
+		encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encrypted, original) {
+			t.Errorf("Strict encryption in repo %s modified the encrypted file despite failing", repo)
+		}
+
+		opts.StrictPaths = false
+		err = Encrypt([]*File{&file}, c, &config.Provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(warnings.String(), "added: added") || !strings.Contains(warnings.String(), "removed: removed") {
+			t.Errorf("Encryption in repo %s did not warn about changed paths: %q", repo, warnings)
+		}
+	}
+}
+
+func TestRemovedSecrets(t *testing.T) {
+	opts := &Options{}
+	defer func() { Warnings = os.Stderr }()
+	for _, policy := range []string{config.RemovedSecretsDrop, config.RemovedSecretsKeep, config.RemovedSecretsError} {
+		t.Run(policy, func(t *testing.T) {
+			var provider crypto.Provider = &testProvider{}
+			c, ca, cleanup := setupTestRepo(t, provider)
+			defer cleanup()
+			file, err := NewFile("removed.decrypted.yaml", c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			warnings := &bytes.Buffer{}
+			Warnings = warnings
+			opts.RemovedSecrets = policy
+
+			err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret 1\nnested:\n  removed: !secret 2\n"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Encrypt([]*File{&file}, ca, &provider, 4, false, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := encryptedValues(t, file.EncryptedPath)
+
+			err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret 1\nnested:\n  added: !secret 3\n"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Encrypt([]*File{&file}, ca, &provider, 4, false, opts)
+			after := encryptedValues(t, file.EncryptedPath)
+			switch policy {
+			case config.RemovedSecretsDrop:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := after["nested.removed"]; ok {
+					t.Errorf("Removed secret was kept in the encrypted file")
+				}
+				if !strings.Contains(warnings.String(), "removed: nested.removed") {
+					t.Errorf("Dropping a removed secret did not warn: %q", warnings)
+				}
+			case config.RemovedSecretsKeep:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if after["nested.removed"] != before["nested.removed"] {
+					t.Errorf("Removed secret was not kept as-is in the encrypted file: %v", after)
+				}
+			case config.RemovedSecretsError:
+				if err == nil {
+					t.Fatal("Encrypting did not fail despite a removed secret")
+				} else if !strings.Contains(err.Error(), "nested.removed") {
+					t.Errorf("Error does not list the removed secret: %s", err)
+				}
+				if !reflect.DeepEqual(after, before) {
+					t.Errorf("Failed encryption changed the encrypted file: %v", after)
+				}
+				return
+			}
+			// added secrets are encrypted whatever the policy
+			if _, ok := after["nested.added"]; !ok {
+				t.Errorf("Added secret is missing from the encrypted file: %v", after)
+			}
+			if after["kept"] != before["kept"] {
+				t.Errorf("Unchanged secret was re-encrypted")
+			}
+		})
+	}
+}
+
+func TestKeepRemovedSecrets(t *testing.T) {
+	defer func() { Warnings = os.Stderr }()
+	var provider crypto.Provider = &testProvider{}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("removed.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := &bytes.Buffer{}
+	Warnings = warnings
+	opts := &Options{RemovedSecrets: config.RemovedSecretsKeep}
+
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret 1\nnested:\n  removed: !secret 2\n  !secret hidden key: !secret 3\n  !secret hidden plain: visible\nlist:\n  - !secret a\n  - !secret b\ngone:\n  inner: !secret 4\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, ca, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("kept: !secret 1\nnested:\n  other: x\nlist:\n  - !secret a\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, ca, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// secrets are kept by their decrypted paths, including those with encrypted keys
+	err = Decrypt([]*File{&file}, false, false, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "kept: !secret 1\nnested:\n  other: x\n  removed: !secret 2\n  !secret hidden key: !secret 3\n  !secret hidden plain: visible\nlist:\n  - !secret a\n"
+	if string(decrypted) != expected {
+		t.Errorf("Keeping removed secrets gave:\n%s\nexpected:\n%s", decrypted, expected)
+	}
+	// those that can't be kept are dropped with a warning, rather than failing
+	for _, path := range []string{"list.1", "gone.inner"} {
+		if !strings.Contains(warnings.String(), "can't keep secret "+path+" ") {
+			t.Errorf("Dropping secret %s that couldn't be kept did not warn: %q", path, warnings)
+		}
+	}
+}
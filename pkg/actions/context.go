@@ -0,0 +1,39 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+)
+
+// Group files by their encryption context, keeping the groups in order of first appearance.
+func groupByContext(files []*File) [][]*File {
+	groups := [][]*File{}
+	indices := map[string]int{}
+	for _, file := range files {
+		i, ok := indices[file.Context]
+		if !ok {
+			i = len(groups)
+			indices[file.Context] = i
+			groups = append(groups, []*File{})
+		}
+		groups[i] = append(groups[i], file)
+	}
+	return groups
+}
+
+// Call a function on each group of files sharing an encryption context, with the provider bound to that context, and the cache scoped to it.
+func forEachContext(files []*File, cache *cache.Cache, provider *crypto.Provider, function func([]*File, *crypto.Provider) error) error {
+	defer cache.SetContext("")
+	for _, group := range groupByContext(files) {
+		bound, err := crypto.WithContext(*provider, group[0].Context)
+		if err != nil {
+			return err
+		}
+		cache.SetContext(group[0].Context)
+		err = function(group, &bound)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
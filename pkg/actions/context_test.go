@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptionContext(t *testing.T) {
+	provider := &testProvider{}
+	var p crypto.Provider = provider
+	config, cache, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	config.EncryptionContext = "test:{path}"
+	a, err := NewFile("a.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFile("b.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Context != "test:a" || b.Context != "test:b" {
+		t.Fatalf("Unexpected contexts %q and %q", a.Context, b.Context)
+	}
+	for _, file := range []File{a, b} {
+		err = ioutil.WriteFile(file.DecryptedPath, []byte("x: !secret same\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Encrypt([]*File{&a, &b}, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same plaintext can't share a ciphertext across contexts
+	if provider.encryptCalls != 2 {
+		t.Errorf("Expected 2 encrypt calls, got %d", provider.encryptCalls)
+	}
+	aValues := encryptedValues(t, a.EncryptedPath)
+	bValues := encryptedValues(t, b.EncryptedPath)
+	if aValues["x"] == bValues["x"] {
+		t.Errorf("Files with different contexts were given the same ciphertext")
+	}
+
+	// both files decrypt under their own context
+	for _, file := range []File{a, b} {
+		err = os.Remove(file.DecryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Decrypt([]*File{&a, &b}, false, false, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a value copied from one file into another doesn't decrypt
+	encrypted, err := ioutil.ReadFile(a.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(b.EncryptedPath, encrypted, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&b}, false, false, cache, &p, 4, false, nil)
+	if err == nil {
+		t.Errorf("Decrypting a value moved to a different context did not fail")
+	}
+}
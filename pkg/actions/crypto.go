@@ -1,122 +1,531 @@
 package actions
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
 	"github.com/schollz/progressbar/v3"
 	yamlv3 "gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 type nothing struct{}
 
-func Decrypt(files []*File, plain bool, stdout bool, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool) error {
-	// read in files, populate the set of ciphertexts
-	var err error
-	nodes := make([]yamlv3.Node, len(files))
-	ciphertextSet := map[string]nothing{}
-	for i, file := range files {
-		nodes[i], err = yaml.ReadFile(file.EncryptedPath)
+// Returned when the provider encrypts a plaintext to nothing, which would be written as a null.
+var errEmptyCiphertext = errors.New("Provider returned an empty ciphertext")
+
+// Returned when the provider decrypts a ciphertext to nothing, if EmptyPlaintexts says so.
+var errEmptyPlaintext = fmt.Errorf("Provider returned an empty plaintext; if the value really is empty, set emptyPlaintexts: %s", config.EmptyPlaintextsAllow)
+
+// Check that a non-empty ciphertext didn't decrypt to nothing, if EmptyPlaintexts says to fail.
+func (o *Options) checkPlaintext(plaintext string) error {
+	if plaintext == "" && o.EmptyPlaintexts == config.EmptyPlaintextsError {
+		return errEmptyPlaintext
+	}
+	return nil
+}
+
+// Versions of a file written when decrypting it.
+type decryptOutputs int
+
+const (
+	decryptedOutput decryptOutputs = 1 << iota
+	plainOutput
+)
+
+func Decrypt(files []*File, plain bool, stdout bool, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	opts = opts.withDefaults()
+	outputs := decryptedOutput
+	if plain {
+		outputs = plainOutput
+	}
+	if stdout {
+		// files printed to stdout mustn't be interleaved
+		return decryptDocuments(decryptFileDocuments(files, outputs, stdout), outputs, 1, cache, provider, threads, progress, opts)
+	}
+	return opts.resumable(files, encryptedPath, func(files []*File) error {
+		err := decryptDocuments(decryptFileDocuments(files, outputs, stdout), outputs, opts.FileThreads, cache, provider, threads, progress, opts)
 		if err != nil {
-			return fmt.Errorf("Error reading yaml file %s: %w", file.DecryptedPath, err)
+			return err
+		}
+		return opts.gitignoreOutputs(files, outputs)
+	})
+}
+
+// Decrypt files in place, replacing the encrypted values in each encrypted version with their decrypted values.
+func DecryptInPlace(files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	opts = opts.withDefaults()
+	return opts.resumable(files, encryptedPath, func(files []*File) error {
+		documents := make([]*document, len(files))
+		for i, file := range files {
+			documents[i] = &document{file: file, encrypted: fileReader(file.EncryptedPath), output: replacingWriter(file.EncryptedPath, 0600)}
 		}
+		return decryptDocuments(documents, decryptedOutput, opts.FileThreads, cache, provider, threads, progress, opts)
+	})
+}
+
+// Decrypt files, writing both the decrypted and plain versions of each from a single decryption.
+func DecryptBoth(files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	opts = opts.withDefaults()
+	outputs := decryptedOutput | plainOutput
+	return opts.resumable(files, encryptedPath, func(files []*File) error {
+		err := decryptDocuments(decryptFileDocuments(files, outputs, false), outputs, opts.FileThreads, cache, provider, threads, progress, opts)
+		if err != nil {
+			return err
+		}
+		return opts.gitignoreOutputs(files, outputs)
+	})
+}
+
+func decryptDocuments(documents []*document, outputs decryptOutputs, fileThreads int, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	if err := checkCanDecrypt(provider); err != nil {
+		return err
+	}
+	return forEachDocumentContext(documents, cache, provider, func(documents []*document, provider *crypto.Provider) error {
+		return decrypt(documents, outputs, fileThreads, cache, provider, threads, progress, opts)
+	})
+}
+
+func decrypt(documents []*document, outputs decryptOutputs, fileThreads int, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	// read in documents, populate the set of ciphertexts
+	nodes := make([]yamlv3.Node, len(documents))
+	// decrypted documents keep the line endings of the encrypted documents
+	lineEndings := make([]string, len(documents))
+	err := parallelFiles(len(documents), fileThreads, func(i int) (err error) {
+		nodes[i], lineEndings[i], err = opts.readDocument(documents[i].encrypted)
+		if err != nil {
+			return opts.audit(documents[i].file.EncryptedPath, nil, *provider, fmt.Errorf("Error reading yaml file %s: %w", documents[i].file.EncryptedPath, err))
+		}
+		err = checkHeader(&nodes[i], *provider, true)
+		if err != nil {
+			return opts.audit(documents[i].file.EncryptedPath, nil, *provider, fmt.Errorf("Error decrypting file %s: %w", documents[i].file.EncryptedPath, err))
+		}
+		setDecryptedHeader(&nodes[i])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ciphertextSet := map[string]nothing{}
+	for i, d := range documents {
 		err = addTaggedValuesToSet(&ciphertextSet, &nodes[i], yaml.EncryptedTag)
 		if err != nil {
-			return fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
+			return fmt.Errorf("Error getting encrypted values from file %s: %w", d.file.EncryptedPath, err)
 		}
 	}
-	// fill in the cache with decryptions of all ciphertexts in the set
-	err = decryptCiphertexts(&ciphertextSet, cache, provider, threads, progress)
+	// decrypt all ciphertexts in the set, filling in the cache along the way
+	plaintexts, err := decryptCiphertexts(&ciphertextSet, cache, provider, threads, progress, opts)
 	if err != nil {
-		return fmt.Errorf("Error decrypting existing ciphertexts: %w", err)
+		paths := make([]string, len(documents))
+		nodePointers := make([]*yamlv3.Node, len(documents))
+		for i, d := range documents {
+			paths[i], nodePointers[i] = d.file.EncryptedPath, &nodes[i]
+		}
+		err = fmt.Errorf("Error decrypting existing ciphertexts: %w", locateValue(err, yaml.EncryptedTag, paths, nodePointers))
+		// none of the documents are written
+		for i, d := range documents {
+			opts.audit(d.file.EncryptedPath, yaml.GetTaggedChildrenPaths(&nodes[i], yaml.EncryptedTag), *provider, err)
+		}
+		return err
 	}
-	for i, file := range files {
-		// decrypt encrypted child nodes using now-loaded cache
-		for node := range yaml.GetTaggedChildren(&nodes[i], yaml.EncryptedTag) {
-			err = yaml.DecryptNode(node.YamlNode, cache, !plain)
-			if err != nil {
-				return fmt.Errorf("Error decrypting node %s using cache: %w", node.Path.String(), err)
-			}
+	return parallelFiles(len(documents), fileThreads, func(i int) error {
+		paths := yaml.GetTaggedChildrenPaths(&nodes[i], yaml.EncryptedTag)
+		err := decryptDocument(documents[i], &nodes[i], lineEndings[i], outputs, plaintexts, opts)
+		return opts.audit(documents[i].file.EncryptedPath, paths, *provider, err)
+	})
+}
+
+// Decrypt a document's encrypted values, and write it out to each of the given outputs.
+func decryptDocument(d *document, node *yamlv3.Node, lineEnding string, outputs decryptOutputs, plaintexts map[string]string, opts *Options) error {
+	tagged := outputs&decryptedOutput != 0
+	var err error
+	values := map[*yamlv3.Node]string{}
+	for n := range yaml.GetTaggedChildren(node, yaml.EncryptedTag) {
+		if err != nil {
+			// keep draining the iterator after an error
+			continue
+		}
+		if opts.VerifyOutput {
+			var ciphertext string
+			ciphertext, err = yaml.GetValue(n.YamlNode)
+			values[n.YamlNode] = plaintexts[ciphertext]
+		}
+		if err == nil {
+			err = yaml.DecryptNode(n.YamlNode, plaintexts, tagged)
+		}
+		if err != nil {
+			err = fmt.Errorf("Error decrypting node %s: %w", n.Path.String(), err)
 		}
-		// write modified root node out to file
-		var outPath string
-		if stdout {
-			outPath = ""
-		} else if plain {
-			outPath = file.PlainPath
-		} else {
-			outPath = file.DecryptedPath
+	}
+	if err != nil {
+		return err
+	}
+	if opts.VerifyOutput {
+		err = yaml.CheckRoundTrip(*node, values)
+		if err != nil {
+			return fmt.Errorf("Error decrypting file %s: %w", d.file.EncryptedPath, err)
+		}
+	}
+	err = opts.transformDocument(d.file.EncryptedPath, node)
+	if err != nil {
+		return err
+	}
+	// write modified root node out to each output
+	options := opts.saveOptions(lineEnding)
+	options.SortKeys = opts.SortKeys
+	if outputs&decryptedOutput != 0 {
+		err = writeDocument(d.output, *node, options)
+		if err != nil {
+			return fmt.Errorf("Error writing yaml file %s: %w", d.file.EncryptedPath, err)
 		}
-		err = yaml.SaveFile(outPath, nodes[i])
+	}
+	if outputs&plainOutput != 0 {
+		output := d.plainOutput
+		if tagged {
+			// the plain version is the decrypted version without its tags, so reuse the same tree
+			for n := range yaml.GetTaggedChildren(node, yaml.DecryptedTag) {
+				n.YamlNode.Tag = ""
+			}
+		} else if output == nil {
+			// only the plain version is being written, to the document's only output
+			output = d.output
+		}
+		err = writeDocument(output, *node, options)
 		if err != nil {
-			return fmt.Errorf("Error writing yaml file %s: %w", file.EncryptedPath, err)
+			return fmt.Errorf("Error writing yaml file %s: %w", d.file.EncryptedPath, err)
 		}
 	}
-	return err
+	return nil
 }
 
-func Encrypt(files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool) error {
-	// read in decrypted files, populate the set of plaintexts
-	var err error
-	decryptedNodes := make([]yamlv3.Node, len(files))
-	ciphertextPathMaps := make([]map[string]string, len(files))
-	ciphertextSet := map[string]nothing{}
-	plaintextSet := map[string]nothing{}
-	for i, file := range files {
-		decryptedNodes[i], err = yaml.ReadFile(file.DecryptedPath)
+func Encrypt(files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	opts = opts.withDefaults()
+	return opts.resumable(files, decryptedPath, func(files []*File) error {
+		return encryptDocuments(encryptFileDocuments(files), cache, provider, threads, progress, opts)
+	})
+}
+
+// Encrypt files that were decrypted in place by DecryptInPlace, in place.
+func EncryptInPlace(files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	opts = opts.withDefaults()
+	return opts.resumable(files, encryptedPath, func(files []*File) error {
+		documents := make([]*document, len(files))
+		for i, file := range files {
+			documents[i] = &document{file: file, decrypted: fileReader(file.EncryptedPath), output: replacingWriter(file.EncryptedPath, 0)}
+		}
+		return encryptDocuments(documents, cache, provider, threads, progress, opts)
+	})
+}
+
+func encryptDocuments(documents []*document, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	return forEachDocumentContext(documents, cache, provider, func(documents []*document, provider *crypto.Provider) error {
+		return encrypt(documents, cache, provider, threads, progress, opts)
+	})
+}
+
+func encrypt(documents []*document, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	// read in decrypted documents, populate the set of plaintexts
+	decryptedNodes := make([]yamlv3.Node, len(documents))
+	// encrypted documents keep the line endings of the decrypted documents
+	lineEndings := make([]string, len(documents))
+	// existing encrypted versions of the documents, if any
+	encryptedNodes := make([]*yamlv3.Node, len(documents))
+	// providers the documents declare as their recipients, if any
+	recipients := make([]string, len(documents))
+	err := parallelFiles(len(documents), opts.FileThreads, func(i int) (err error) {
+		decryptedNodes[i], lineEndings[i], err = opts.readDocument(documents[i].decrypted)
 		if err != nil {
-			return fmt.Errorf("Error reading yaml file %s: %w", file.DecryptedPath, err)
+			return fmt.Errorf("Error reading yaml file %s: %w", documents[i].file.DecryptedPath, err)
 		}
-		err = addTaggedValuesToSet(&plaintextSet, &decryptedNodes[i], yaml.DecryptedTag)
+		recipients[i], err = opts.declaredRecipients(&decryptedNodes[i], *provider)
 		if err != nil {
-			return fmt.Errorf("Error getting decrypted values from file %s: %w", file.DecryptedPath, err)
+			return fmt.Errorf("Error encrypting file %s: %w", documents[i].file.DecryptedPath, err)
 		}
-		// if an encrypted version exists, load its encrypted values and add them to the ciphertext set, in order to later preload the cache with existing ciphertexts
-		if exists(file.EncryptedPath) {
-			var node yamlv3.Node
-			node, err = yaml.ReadFile(file.EncryptedPath)
+		if documents[i].encrypted != nil {
+			node, _, err := opts.readDocument(documents[i].encrypted)
 			if err != nil {
-				return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+				return fmt.Errorf("Error reading yaml file %s: %w", documents[i].file.EncryptedPath, err)
 			}
-			ciphertextPathMaps[i], err = yaml.GetTaggedChildrenValues(&node, yaml.EncryptedTag)
+			// refuse to overwrite a file written by a newer version
+			err = checkHeader(&node, *provider, false)
+			if err != nil {
+				return fmt.Errorf("Error encrypting file %s: %w", documents[i].file.EncryptedPath, err)
+			}
+			encryptedNodes[i] = &node
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	paths, err := opts.encryptPaths()
+	if err != nil {
+		return err
+	}
+	ciphertextPathMaps := make([]map[string]string, len(documents))
+	ciphertextSet := map[string]nothing{}
+	for i, d := range documents {
+		file := d.file
+		opts.tagPathsToEncrypt(&decryptedNodes[i], paths)
+		err = opts.checkValueSizes(&decryptedNodes[i])
+		if err != nil {
+			return fmt.Errorf("Error encrypting file %s: %w", file.DecryptedPath, err)
+		}
+		// if an encrypted version exists, load its encrypted values and add them to the ciphertext set, in order to later preload the cache with existing ciphertexts
+		if encryptedNodes[i] != nil {
+			node := encryptedNodes[i]
+			ciphertextPathMaps[i], err = yaml.GetTaggedChildrenValues(node, yaml.EncryptedTag)
 			if err != nil {
 				return fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
 			}
-			err = addTaggedValuesToSet(&ciphertextSet, &node, yaml.EncryptedTag)
+			err = addTaggedValuesToSet(&ciphertextSet, node, yaml.EncryptedTag)
 			if err != nil {
 				return fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
 			}
+			changes := comparePaths(
+				yaml.GetTaggedChildrenPaths(node, yaml.EncryptedTag),
+				yaml.GetTaggedChildrenPaths(&decryptedNodes[i], yaml.DecryptedTag),
+			)
+			err = opts.reportPathChanges(file, changes)
+			if err != nil {
+				return err
+			}
+			keepManagedValues(node, &decryptedNodes[i])
 		}
 	}
-	// decrypt any encrypted values first, to pre-fill the cache with their existing versions
-	err = decryptCiphertexts(&ciphertextSet, cache, provider, threads, progress)
-	if err != nil {
-		return fmt.Errorf("Error decrypting existing ciphertexts: %w", err)
+	// decrypt any encrypted values first, so that unchanged values can keep their existing versions. If the provider can't decrypt, only values already in the cache can.
+	var existing map[string]string
+	if crypto.CanDecrypt(*provider) {
+		existing, err = decryptCiphertexts(&ciphertextSet, cache, provider, threads, progress, opts)
+		if err != nil {
+			paths := make([]string, len(documents))
+			for i, d := range documents {
+				paths[i] = d.file.EncryptedPath
+			}
+			return fmt.Errorf("Error decrypting existing ciphertexts: %w", locateValue(err, yaml.EncryptedTag, paths, encryptedNodes))
+		}
+	} else {
+		existing, err = cachedPlaintexts(&ciphertextSet, cache)
+		if err != nil {
+			return err
+		}
 	}
-	// now we can encrypt any plaintexts that still don't have ciphertexts in the cache
-	err = encryptPlaintexts(&plaintextSet, cache, provider, threads, progress)
+	if opts.RemovedSecrets == config.RemovedSecretsKeep {
+		for i, d := range documents {
+			if encryptedNodes[i] == nil {
+				continue
+			}
+			unkept, err := keepRemovedSecrets(encryptedNodes[i], &decryptedNodes[i], existing)
+			if err != nil {
+				return fmt.Errorf("Error encrypting file %s: %w", d.file.DecryptedPath, err)
+			}
+			for _, path := range unkept {
+				fmt.Fprintf(Warnings, "Warning: can't keep secret %s removed from %s in %s, since it was in a sequence, or its parent mapping was removed as well; dropping it\n", path, d.file.DecryptedPath, d.file.EncryptedPath)
+			}
+		}
+	}
+	// now we can encrypt any plaintexts that can't keep their existing ciphertexts, and encrypt decrypted child nodes with the results
+	scopes := make([]string, len(documents))
+	for i, d := range documents {
+		scopes[i] = d.file.CacheScope
+	}
+	err = encryptNodes(decryptedNodes, scopes, recipients, ciphertextPathMaps, existing, cache, provider, threads, progress, opts)
 	if err != nil {
-		return fmt.Errorf("Error encrypting plaintexts: %w", err)
+		paths := make([]string, len(documents))
+		nodePointers := make([]*yamlv3.Node, len(documents))
+		for i, d := range documents {
+			paths[i], nodePointers[i] = d.file.DecryptedPath, &decryptedNodes[i]
+		}
+		return locateValue(err, yaml.DecryptedTag, paths, nodePointers)
+	}
+	for i := range documents {
+		if encryptedNodes[i] != nil {
+			keepUntypedValues(encryptedNodes[i], &decryptedNodes[i])
+		}
+		opts.recordRotatedBy(encryptedNodes[i], &decryptedNodes[i])
+		err = setHeader(&decryptedNodes[i], encryptedNodes[i], *provider, recipients[i], opts.saveOptions(lineEndings[i]))
+		if err != nil {
+			return fmt.Errorf("Error writing yaml file %s: %w", documents[i].file.EncryptedPath, err)
+		}
 	}
 
-	for i, file := range files {
-		// encrypt decrypted child nodes using now-loaded cache
-		for node := range yaml.GetTaggedChildren(&decryptedNodes[i], yaml.DecryptedTag) {
-			possibleCiphertext, _ := ciphertextPathMaps[i][node.Path.String()]
-			err = yaml.EncryptNode(node.YamlNode, []byte(possibleCiphertext), cache)
-			if err != nil {
-				return fmt.Errorf("Error encrypting node %s using cache: %w", node.Path.String(), err)
+	// write output
+	return parallelFiles(len(documents), opts.FileThreads, func(i int) error {
+		err := writeDocument(documents[i].output, decryptedNodes[i], opts.saveOptions(lineEndings[i]))
+		if err != nil {
+			return fmt.Errorf("Error writing yaml file %s: %w", documents[i].file.EncryptedPath, err)
+		}
+		return nil
+	})
+}
+
+// Copy encrypted values marked as managed into the decrypted node, so that they're kept as-is.
+func keepManagedValues(encrypted, decrypted *yamlv3.Node) {
+	encryptedNodes := map[string]*yamlv3.Node{}
+	for n := range yaml.GetTaggedChildren(encrypted, yaml.EncryptedTag) {
+		encryptedNodes[n.Path.String()] = n.YamlNode
+	}
+	for n := range yaml.GetTaggedChildren(decrypted, yaml.DecryptedTag) {
+		e, ok := encryptedNodes[n.Path.String()]
+		if ok && (yaml.IsManaged(e) || yaml.IsManaged(n.YamlNode)) {
+			// a !secret mapping or sequence is replaced by its encrypted value as a whole
+			n.YamlNode.Kind = e.Kind
+			n.YamlNode.Content = nil
+			n.YamlNode.Tag = e.Tag
+			n.YamlNode.Value = e.Value
+			n.YamlNode.Style = e.Style
+			if n.YamlNode.LineComment == "" {
+				n.YamlNode.LineComment = e.LineComment
 			}
 		}
-		// write output
-		err = yaml.SaveFile(file.EncryptedPath, decryptedNodes[i])
+	}
+}
+
+// Copy version 1 encrypted values into the newly encrypted node wherever they hold the same ciphertext.
+func keepUntypedValues(encrypted, reencrypted *yamlv3.Node) {
+	existing := map[string]*yamlv3.Node{}
+	for n := range yaml.GetTaggedChildren(encrypted, yaml.EncryptedTag) {
+		existing[n.Path.String()] = n.YamlNode
+	}
+	for n := range yaml.GetTaggedChildren(reencrypted, yaml.EncryptedTag) {
+		e, ok := existing[n.Path.String()]
+		if !ok || e.Kind != yamlv3.ScalarNode || yaml.EncryptedValue(e.Value).Version() != 1 || yaml.EncryptedValue(n.YamlNode.Value).Version() == 1 {
+			continue
+		}
+		old, err := yaml.EncryptedValue(e.Value).Ciphertext()
 		if err != nil {
-			return fmt.Errorf("Error writing yaml file %s: %w", file.EncryptedPath, err)
+			continue
+		}
+		ciphertext, err := yaml.EncryptedValue(n.YamlNode.Value).Ciphertext()
+		if err == nil && bytes.Equal(old, ciphertext) {
+			n.YamlNode.Value = e.Value
 		}
 	}
-	return err
+}
+
+// Encrypt the secrets in each node, reusing existing ciphertexts where their plaintexts haven't changed.
+func encryptNodes(nodes []yamlv3.Node, scopes []string, recipients []string, ciphertextPathMaps []map[string]string, existing map[string]string, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	for _, name := range opts.providerNames(recipients) {
+		namedProvider, err := crypto.ForName(*provider, name)
+		if err != nil {
+			return err
+		}
+		plaintextSet := map[string]nothing{}
+		// values whose existing ciphertexts were encrypted by this provider, and decrypt to the same value, keep them
+		reused := map[*yamlv3.Node][]byte{}
+		for i := range nodes {
+			for n := range yaml.GetTaggedChildren(&nodes[i], yaml.DecryptedTag) {
+				// keep draining the iterator after an error
+				if err != nil || opts.providerIn(recipients[i], n.Path.Dotted()) != name || yaml.IsNull(n.YamlNode) {
+					continue
+				}
+				var value string
+				value, err = yaml.GetValue(n.YamlNode)
+				if ciphertextPathMaps != nil {
+					possibleCiphertext := ciphertextPathMaps[i][n.Path.String()]
+					if plaintext, ok := existing[possibleCiphertext]; ok && possibleCiphertext != "" && plaintext == value && crypto.Marker([]byte(possibleCiphertext)) == name {
+						reused[n.YamlNode] = []byte(possibleCiphertext)
+						continue
+					}
+				}
+				plaintextSet[scopedKey(scopes[i], value)] = nothing{}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Error getting decrypted values: %w", err)
+		}
+		ciphertexts, err := encryptPlaintexts(&plaintextSet, cache, &namedProvider, name, threads, progress, opts)
+		if err != nil {
+			return fmt.Errorf("Error encrypting plaintexts: %w", err)
+		}
+		for i := range nodes {
+			for n := range yaml.GetTaggedChildren(&nodes[i], yaml.DecryptedTag) {
+				if err != nil || opts.providerIn(recipients[i], n.Path.Dotted()) != name {
+					continue
+				}
+				ciphertext, ok := reused[n.YamlNode]
+				if !ok && !yaml.IsNull(n.YamlNode) {
+					var value string
+					value, err = yaml.GetValue(n.YamlNode)
+					ciphertext, ok = ciphertexts[scopedKey(scopes[i], value)]
+					if err == nil && !ok {
+						err = errors.New("Plaintext was not encrypted. This should never happen.")
+					}
+				}
+				if err == nil {
+					err = yaml.EncryptNode(n.YamlNode, ciphertext)
+				}
+				if err != nil {
+					err = fmt.Errorf("Error encrypting node %s: %w", n.Path.String(), err)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the key of a plaintext to encrypt in the given scope, see File.CacheScope.
+func scopedKey(scope, plaintext string) string {
+	return scope + "\x00" + plaintext
+}
+
+// Split a key given by scopedKey into its scope and plaintext.
+func splitScopedKey(key string) (scope string, plaintext string) {
+	parts := strings.SplitN(key, "\x00", 2)
+	return parts[0], parts[1]
+}
+
+// Get the name of the provider that the value at the given dotted path is encrypted with, or an empty string for the default provider.
+func (o *Options) providerFor(path string) string {
+	if o.SingleProvider {
+		return ""
+	}
+	for _, providerPath := range o.ProviderPaths {
+		if yaml.MatchPath(providerPath.Path, path) {
+			return providerPath.Provider
+		}
+	}
+	return ""
+}
+
+// Get the name of the provider that the value at the given dotted path in a file with the given recipients is encrypted with.
+func (o *Options) providerIn(recipients string, path string) string {
+	if recipients != "" {
+		return recipients
+	}
+	return o.providerFor(path)
+}
+
+// Get the names of all providers that values can be encrypted with, including files' recipients.
+func (o *Options) providerNames(recipients []string) []string {
+	names := []string{""}
+	if o.SingleProvider {
+		return names
+	}
+	seen := map[string]bool{"": true}
+	for _, providerPath := range o.ProviderPaths {
+		if !seen[providerPath.Provider] {
+			seen[providerPath.Provider] = true
+			names = append(names, providerPath.Provider)
+		}
+	}
+	for _, name := range recipients {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 func addTaggedValuesToSet(set *map[string]nothing, node *yamlv3.Node, tag string) (err error) {
@@ -130,118 +539,318 @@ func addTaggedValuesToSet(set *map[string]nothing, node *yamlv3.Node, tag string
 	return
 }
 
-func encryptPlaintexts(set *map[string]nothing, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool) error {
-	plaintexts := make([]string, 0, len(*set))
+// Encrypt each plaintext in a set of scoped keys with the named provider, getting their ciphertexts by key.
+func encryptPlaintexts(set *map[string]nothing, cache *cache.Cache, provider *crypto.Provider, name string, threads int, progress bool, opts *Options) (map[string][]byte, error) {
+	keys := make([]string, 0, len(*set))
 	for k := range *set {
-		plaintexts = append(plaintexts, k)
+		keys = append(keys, k)
 	}
-	_, err := parallelMap(plaintexts, func(plaintext string) (string, error) {
-		_, err := EncryptPlaintext(plaintext, cache, provider)
-		return "", err
+	// map iteration order is random, so sort to make dispatch order deterministic
+	sort.Strings(keys)
+	if batch, ok := crypto.AsBatch(*provider); ok {
+		return encryptPlaintextsBatch(keys, cache, provider, name, batch, progress, opts)
+	}
+	outputs, err := parallelMap(keys, func(key string) (string, error) {
+		scope, plaintext := splitScopedKey(key)
+		ciphertext, err := encryptPlaintext(plaintext, scope, cache, provider, name, opts)
+		if err != nil {
+			return "", newValueError(plaintext, err)
+		}
+		return string(ciphertext), nil
 	}, threads, progress)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	ciphertexts := make(map[string][]byte, len(outputs))
+	for key, ciphertext := range outputs {
+		ciphertexts[key] = []byte(ciphertext)
+	}
+	return ciphertexts, nil
+}
+
+// Make sure none of the values to be encrypted in a node are larger than MaxValueSize.
+func (o *Options) checkValueSizes(node *yamlv3.Node) (err error) {
+	for n := range yaml.GetTaggedChildren(node, yaml.DecryptedTag) {
+		value, valueErr := yaml.GetValue(n.YamlNode)
+		if err != nil {
+			// keep draining the iterator, only the first error is reported
+			continue
+		} else if valueErr != nil {
+			err = fmt.Errorf("Error reading value at path %s: %w", n.Path.Dotted(), valueErr)
+		} else if int64(len(value)) > o.MaxValueSize {
+			err = fmt.Errorf("Value at path %s is %d bytes, larger than the max value size of %d bytes", n.Path.Dotted(), len(value), o.MaxValueSize)
+		}
+	}
+	return
+}
+
+func EncryptPlaintext(plaintext string, cache *cache.Cache, provider *crypto.Provider, opts *Options) ([]byte, error) {
+	return encryptPlaintext(plaintext, "", cache, provider, "", opts.withDefaults())
 }
 
-func EncryptPlaintext(plaintext string, cache *cache.Cache, provider *crypto.Provider) ([]byte, error) {
-	ciphertext, ok, err := cache.Encrypt(plaintext, []byte{})
+// Encrypt a plaintext with the named provider through the cache, in the given scope.
+func encryptPlaintext(plaintext string, scope string, cache *cache.Cache, provider *crypto.Provider, name string, opts *Options) ([]byte, error) {
+	if err := opts.checkValueSize(plaintext); err != nil {
+		return []byte{}, err
+	}
+	// empty values are never cached, so they always go to the provider
+	ciphertext, ok, err := cache.Encrypt(plaintext, []byte{}, name, scope)
 	if err != nil {
 		return []byte{}, fmt.Errorf("Error looking up plaintext in cache: %w", err)
 	}
+	opts.reportCacheLookup(ok)
 	if ok {
 		return ciphertext, nil
 	}
-	ciphertext, err = (*provider).Encrypt(plaintext)
-	if err != nil {
+	err = opts.withRefresh(*provider, func() error {
+		start := time.Now()
+		ciphertext, err = crypto.Encrypt(*provider, plaintext)
+		opts.reportProviderCall("encrypt", start, err)
+		return err
+	})
+	if errors.Is(err, crypto.ErrCredentialsRejected) {
+		return []byte{}, err
+	} else if err != nil {
 		return []byte{}, fmt.Errorf("Error using provider to encrypt plaintext: %w", err)
 	}
-	err = cache.Add(plaintext, ciphertext)
+	err = addCiphertext(plaintext, ciphertext, scope, cache)
 	if err != nil {
-		return []byte{}, fmt.Errorf("Error adding item to cache: %w", err)
+		return []byte{}, err
 	}
 	return ciphertext, nil
 }
 
-func decryptCiphertexts(set *map[string]nothing, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool) error {
+// Make sure a plaintext isn't larger than MaxValueSize before it's encrypted.
+func (o *Options) checkValueSize(plaintext string) error {
+	if int64(len(plaintext)) > o.MaxValueSize {
+		return fmt.Errorf("Value is %d bytes, larger than the max value size of %d bytes", len(plaintext), o.MaxValueSize)
+	}
+	return nil
+}
+
+// Check the ciphertext the provider encrypted a plaintext to, and add the pair to the cache, in the given scope.
+func addCiphertext(plaintext string, ciphertext []byte, scope string, cache *cache.Cache) error {
+	if len(ciphertext) == 0 {
+		return errEmptyCiphertext
+	}
+	err := cache.AddScoped(plaintext, ciphertext, scope)
+	if err != nil {
+		return fmt.Errorf("Error adding item to cache: %w", err)
+	}
+	return nil
+}
+
+// Check the plaintext the provider decrypted a ciphertext to, and add the pair to the cache.
+func (o *Options) addPlaintext(ciphertext []byte, plaintext string, cache *cache.Cache) error {
+	err := o.checkPlaintext(plaintext)
+	if err != nil {
+		return err
+	}
+	if plaintext == "" {
+		cache.AddEmpty(ciphertext)
+		return nil
+	}
+	err = cache.Add(plaintext, ciphertext)
+	if err != nil {
+		return fmt.Errorf("Error adding item to cache: %w", err)
+	}
+	return nil
+}
+
+// Fail early if the provider can't decrypt, rather than on the first value that isn't cached.
+func checkCanDecrypt(provider *crypto.Provider) error {
+	if !crypto.CanDecrypt(*provider) {
+		return crypto.ErrCannotDecrypt
+	}
+	return nil
+}
+
+// Decrypt each ciphertext in a set, getting their plaintexts by ciphertext.
+func decryptCiphertexts(set *map[string]nothing, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) (map[string]string, error) {
 	ciphertexts := make([]string, 0, len(*set))
 	for k := range *set {
 		ciphertexts = append(ciphertexts, k)
 	}
-	_, err := parallelMap(ciphertexts, func(ciphertext string) (string, error) {
-		_, err := DecryptCiphertext([]byte(ciphertext), cache, provider)
-		return "", err
+	sort.Strings(ciphertexts)
+	if batch, ok := crypto.AsBatch(*provider); ok {
+		return decryptCiphertextsBatch(ciphertexts, cache, provider, batch, progress, opts)
+	}
+	return parallelMap(ciphertexts, func(ciphertext string) (string, error) {
+		plaintext, err := decryptCiphertext([]byte(ciphertext), cache, provider, opts)
+		if err != nil {
+			return "", newValueError(ciphertext, err)
+		}
+		return plaintext, nil
 	}, threads, progress)
-	return err
 }
 
-func DecryptCiphertext(ciphertext []byte, cache *cache.Cache, provider *crypto.Provider) (string, error) {
+// Get the plaintexts of the ciphertexts in a set that are already in the cache, by ciphertext, without using the provider.
+func cachedPlaintexts(set *map[string]nothing, cache *cache.Cache) (map[string]string, error) {
+	plaintexts := map[string]string{}
+	for ciphertext := range *set {
+		plaintext, ok, err := cache.Decrypt([]byte(ciphertext))
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up ciphertext in cache: %w", err)
+		}
+		if ok {
+			plaintexts[ciphertext] = plaintext
+		}
+	}
+	return plaintexts, nil
+}
+
+func DecryptCiphertext(ciphertext []byte, cache *cache.Cache, provider *crypto.Provider, opts *Options) (string, error) {
+	return decryptCiphertext(ciphertext, cache, provider, opts.withDefaults())
+}
+
+func decryptCiphertext(ciphertext []byte, cache *cache.Cache, provider *crypto.Provider, opts *Options) (string, error) {
+	// nulls aren't encrypted, see yaml.EncryptNode
+	if len(ciphertext) == 0 {
+		return "", nil
+	}
 	plaintext, ok, err := cache.Decrypt(ciphertext)
 	if err != nil {
 		return "", fmt.Errorf("Error looking up ciphertext in cache: %w", err)
 	}
+	opts.reportCacheLookup(ok)
 	if ok {
 		return plaintext, nil
 	}
-	plaintext, err = (*provider).Decrypt(ciphertext)
-	if err != nil {
+	if err := checkCanDecrypt(provider); err != nil {
+		return "", err
+	}
+	err = opts.withRefresh(*provider, func() error {
+		start := time.Now()
+		plaintext, err = crypto.Decrypt(*provider, ciphertext)
+		opts.reportProviderCall("decrypt", start, err)
+		return err
+	})
+	if errors.Is(err, crypto.ErrCredentialsRejected) {
+		return "", err
+	} else if err != nil {
 		return "", fmt.Errorf("Error using provider to decrypt ciphertext: %w", err)
 	}
-	err = cache.Add(plaintext, ciphertext)
+	err = opts.addPlaintext(ciphertext, plaintext, cache)
 	if err != nil {
-		return "", fmt.Errorf("Error adding item to cache: %w", err)
+		return "", err
 	}
 	return plaintext, nil
 }
 
+// Run a function on each of the inputs, with at most threads running at once, returning the first error in input order.
 func parallelMap(inputs []string, function func(string) (string, error), threads int, progress bool) (outputs map[string]string, err error) {
-	inputChannel := make(chan string)
-	outputChannel := make(chan mapResult)
-	var bar *progressbar.ProgressBar
-	if progress {
-		bar = progressbar.NewOptions(
-			len(inputs),
-			progressbar.OptionThrottle(100*time.Millisecond),
-			progressbar.OptionShowCount(),
-			progressbar.OptionSetPredictTime(false),
-		)
-	}
 	outputs = map[string]string{}
-	// spin up workers
+	// most files in a repo-wide run have nothing left to encrypt or decrypt, so don't spin up workers, or draw a progress bar, for nothing
+	if len(inputs) == 0 {
+		return outputs, nil
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	inputChannel := make(chan int)
+	outputChannel := make(chan mapResult)
+	stop := make(chan nothing)
+	bar := newProgressBar(len(inputs), progress)
+	// spin up workers, closing the output channel once they're all done
+	var workers sync.WaitGroup
+	workers.Add(threads)
 	for i := 0; i < threads; i++ {
 		go func() {
-			for input := range inputChannel {
-				output, err := function(input)
-				outputChannel <- mapResult{input, output, err}
+			defer workers.Done()
+			for index := range inputChannel {
+				output, err := function(inputs[index])
+				outputChannel <- mapResult{index, output, err}
 			}
 		}()
 	}
-	// feed workers
 	go func() {
-		for _, input := range inputs {
-			inputChannel <- input
+		workers.Wait()
+		close(outputChannel)
+	}()
+	// feed workers until an input fails
+	go func() {
+		defer close(inputChannel)
+		for i := range inputs {
+			select {
+			case inputChannel <- i:
+			case <-stop:
+				return
+			}
 		}
 	}()
-	// consume results
-	for i := 0; i < len(inputs); i++ {
-		result := <-outputChannel
-		err = result.err
-		if err != nil {
-			return
+	// consume results, keeping the errors by input so the first can be picked once all are in. Inputs are fed in order, so every input before one that failed has been run.
+	errs := make([]error, len(inputs))
+	failed := false
+	for result := range outputChannel {
+		if result.err != nil {
+			errs[result.index] = result.err
+			if !failed {
+				failed = true
+				close(stop)
+			}
+			continue
 		}
-		outputs[result.input] = result.output
-		if progress {
+		outputs[inputs[result.index]] = result.output
+		if bar != nil {
 			bar.Add(1)
 		}
 	}
-	if progress {
+	if bar != nil {
 		bar.Finish()
 	}
-	close(inputChannel)
-	close(outputChannel)
-	return
+	for _, err := range errs {
+		if err != nil {
+			return outputs, err
+		}
+	}
+	return outputs, nil
+}
+
+// Get a progress bar for a number of values, or nil if progress isn't shown.
+func newProgressBar(count int, progress bool) *progressbar.ProgressBar {
+	if !progress {
+		return nil
+	}
+	return progressbar.NewOptions(
+		count,
+		progressbar.OptionThrottle(100*time.Millisecond),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetPredictTime(false),
+	)
+}
+
+// Run a function on the indices of a number of files, with at most threads running at once.
+func parallelFiles(count int, threads int, function func(int) error) error {
+	if threads < 1 {
+		threads = 1
+	}
+	errs := make([]error, count)
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < threads && i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				errs[index] = function(index)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type mapResult struct {
-	input  string
+	index  int
 	output string
 	err    error
 }
@@ -0,0 +1,109 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaxValueSize(t *testing.T) {
+	opts := &Options{}
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.MaxValueSize = 16
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := config.LoadConfig(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := cache.Setup(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		file, err := NewFile("big."+repo.Suffixes["decrypted"], &config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := "small: !secret under the limit\nnested:\n  big: !secret " + strings.Repeat("x", 17) + "\n"
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Encrypt([]*File{&file}, c, &config.Provider, 4, false, opts)
+		if err == nil {
+			t.Errorf("Encrypting an over-limit value in repo %s did not fail", repo)
+		} else if !strings.Contains(err.Error(), "nested.big") {
+			t.Errorf("Error encrypting an over-limit value in repo %s does not name its path: %s", repo, err)
+		}
+		if exists(file.EncryptedPath) {
+			t.Errorf("Encrypted file was written in repo %s despite an over-limit value", repo)
+		}
+		_, err = EncryptPlaintext(strings.Repeat("x", 17), c, &config.Provider, opts)
+		if err == nil {
+			t.Errorf("Encrypting an over-limit plaintext in repo %s did not fail", repo)
+		}
+		_, err = EncryptPlaintext(strings.Repeat("x", 16), c, &config.Provider, opts)
+		if err != nil {
+			t.Errorf("Encrypting a plaintext at the limit in repo %s failed: %s", repo, err)
+		}
+	}
+}
+
+func TestEmptyValues(t *testing.T) {
+	opts := &Options{}
+	provider := &testProvider{}
+	var p crypto.Provider = provider
+	config, cache, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	file, err := NewFile("empty.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "a: !secret \"\"\nb: !secret not empty\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &p, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// empty values go to the provider like any other, they just aren't cached
+	if provider.encryptCalls != 2 {
+		t.Errorf("Expected 2 encrypt calls, got %d", provider.encryptCalls)
+	}
+	if values := encryptedValues(t, file.EncryptedPath); values["a"] == "" {
+		t.Errorf("Empty value wasn't encrypted: %v", values)
+	}
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &p, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != original {
+		t.Errorf("Round-trip changed the file:\n%s", decrypted)
+	}
+}
@@ -0,0 +1,65 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecryptBoth(t *testing.T) {
+	inner := &testProvider{}
+	var provider crypto.Provider = inner
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("both.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "a: !secret one\n!secret b: [!secret two, 3]\nport: !secret 8080\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decrypt separately for reference, then both at once with an empty cache
+	err = Decrypt([]*File{&file}, true, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPlain, err := ioutil.ReadFile(file.PlainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ciphertext := range encryptedValues(t, file.EncryptedPath) {
+		err = cache.Remove([]byte(ciphertext))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	inner.decryptCalls = 0
+	err = DecryptBoth([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.decryptCalls != 4 {
+		t.Errorf("Decrypting both versions called the provider %d times, expected once per value", inner.decryptCalls)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != content {
+		t.Errorf("Decrypted version is:\n%s\nexpected:\n%s", decrypted, content)
+	}
+	plain, err := ioutil.ReadFile(file.PlainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != string(expectedPlain) || string(plain) != "a: one\nb: [two, 3]\nport: 8080\n" {
+		t.Errorf("Plain version is:\n%s\nexpected:\n%s", plain, expectedPlain)
+	}
+}
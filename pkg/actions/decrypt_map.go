@@ -0,0 +1,39 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+)
+
+// Decrypt an encrypted document read from a Reader into Go values, as yaml.v3 decodes them: mappings become map[string]interface{}, sequences []interface{}, and secrets keep their types, eg. int or bool. Lets applications consume their secrets without plaintexts ever being written to disk: values are only cached in memory, for the duration of the call, rather than in a repo's cache. The document must be a mapping.
+func DecryptToMap(r io.Reader, p *crypto.Provider) (map[string]interface{}, error) {
+	c, err := cache.Setup(config.Config{CacheEnabled: false})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	var buf bytes.Buffer
+	err = DecryptDocuments([]*Document{{Name: "document", Encrypted: r, Output: &buf}}, true, c, p, config.DefaultThreads, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	node, err := yaml.Read(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading decrypted document: %w", err)
+	}
+	if len(node.Content) == 0 || node.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("Document must be a mapping")
+	}
+	values := map[string]interface{}{}
+	err = node.Decode(&values)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding decrypted document: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,65 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecryptToMap(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	_, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	original := "db:\n  password: !secret hunter2\n  port: !secret 5432\n  replicas: [a, b]\ndebug: !secret true\nratio: !secret 1.5\nquoted: !secret \"8080\"\nempty: !secret null\nuser: app\nlimits: !secret\n  cpu: 2\n"
+	var encrypted bytes.Buffer
+	err := EncryptDocuments([]*Document{{Name: "doc", Decrypted: strings.NewReader(original), Output: &encrypted}}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := DecryptToMap(bytes.NewReader(encrypted.Bytes()), &provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"db": map[string]interface{}{
+			"password": "hunter2",
+			"port":     5432,
+			"replicas": []interface{}{"a", "b"},
+		},
+		"debug":  true,
+		"ratio":  1.5,
+		"quoted": "8080",
+		"empty":  nil,
+		"user":   "app",
+		"limits": map[string]interface{}{"cpu": 2},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Decrypting to a map gave:\n%#v\nexpected:\n%#v", values, expected)
+	}
+
+	// nothing is written to disk
+	after, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("Decrypting to a map wrote %d files", len(after)-len(before))
+	}
+
+	_, err = DecryptToMap(strings.NewReader("- a\n- b\n"), &provider)
+	if err == nil {
+		t.Error("Decrypting a document that isn't a mapping didn't fail")
+	}
+	_, err = DecryptToMap(strings.NewReader("key: !encrypted bm90IGEgY2lwaGVydGV4dA==\n"), &provider)
+	if err == nil {
+		t.Error("Decrypting an invalid ciphertext didn't fail")
+	}
+}
@@ -0,0 +1,88 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDirectives(t *testing.T) {
+	opts := &Options{}
+	for name, lineEnding := range map[string]string{"LF": yaml.LF, "CRLF": yaml.CRLF} {
+		t.Run(name, func(t *testing.T) {
+			var provider crypto.Provider = &testProvider{}
+			config, cache, cleanup := setupTestRepo(t, provider)
+			defer cleanup()
+			file, err := NewFile("directives.decrypted.yaml", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// yaml.v3 only reads %YAML 1.1 on its own
+			original := strings.ReplaceAll("%YAML 1.2\n--- !config\npassword: !secret hunter2\nuser: app\n", "\n", lineEnding)
+			err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedStart := strings.ReplaceAll("%YAML 1.2\n---\n# yaml-crypt:", "\n", lineEnding)
+			if !strings.HasPrefix(string(encrypted), expectedStart) || !strings.Contains(string(encrypted), "!config"+lineEnding+"password: !encrypted") {
+				t.Errorf("Encrypted file did not keep its directive and document tag:\n%q", encrypted)
+			}
+			// the header is still read as the document's
+			node, err := yaml.ReadFile(file.EncryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header, ok, err := yaml.GetHeader(&node); !ok || err != nil || header.Format != yaml.FormatVersion {
+				t.Errorf("Header of encrypted file with a directive read as %+v, %t, %v", header, ok, err)
+			}
+
+			err = os.Remove(file.DecryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := strings.ReplaceAll("%YAML 1.2\n---\n!config\npassword: !secret hunter2\nuser: app\n", "\n", lineEnding)
+			if string(decrypted) != expected {
+				t.Errorf("Decrypting gave:\n%q\nexpected:\n%q", decrypted, expected)
+			}
+
+			// encrypting again leaves the encrypted version as it was
+			err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reencrypted, err := ioutil.ReadFile(file.EncryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(reencrypted) != string(encrypted) {
+				t.Errorf("Re-encrypting changed the file:\n%q\nexpected:\n%q", reencrypted, encrypted)
+			}
+		})
+	}
+
+	// line numbers in errors still match the file, despite the directive being set aside
+	opts.StrictKeys = true
+	_, err := opts.read(strings.NewReader("%YAML 1.2\n---\na: 1\na: 2\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Reading a duplicate key gave error %v, expected it to be at line 4", err)
+	}
+}
@@ -0,0 +1,222 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// A yaml document to encrypt or decrypt, read from and written to Readers and Writers rather than files.
+type Document struct {
+	// Identifies the document in errors and warnings.
+	Name string
+	// The context the document's ciphertexts are bound to, if any.
+	Context string
+	// The encrypted version of the document, read when decrypting, and to reuse ciphertexts when encrypting.
+	Encrypted io.Reader
+	// The decrypted version of the document, read when encrypting.
+	Decrypted io.Reader
+	// Where the encrypted or decrypted version is written, once every document has succeeded.
+	Output io.Writer
+}
+
+// Encrypt documents, as Encrypt does files.
+func EncryptDocuments(documents []*Document, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	buffers := make([]bytes.Buffer, len(documents))
+	err := encryptDocuments(newDocuments(documents, buffers), cache, provider, threads, progress, opts.withDefaults())
+	if err != nil {
+		return err
+	}
+	return writeOutputs(documents, buffers)
+}
+
+// Decrypt documents, as Decrypt does files. Plain leaves out the !secret tags.
+func DecryptDocuments(documents []*Document, plain bool, cache *cache.Cache, provider *crypto.Provider, threads int, progress bool, opts *Options) error {
+	opts = opts.withDefaults()
+	outputs := decryptedOutput
+	if plain {
+		outputs = plainOutput
+	}
+	buffers := make([]bytes.Buffer, len(documents))
+	err := decryptDocuments(newDocuments(documents, buffers), outputs, opts.FileThreads, cache, provider, threads, progress, opts)
+	if err != nil {
+		return err
+	}
+	return writeOutputs(documents, buffers)
+}
+
+// Write each document's buffered output to its Output, once every document has been encrypted or decrypted.
+func writeOutputs(documents []*Document, outputs []bytes.Buffer) error {
+	for i, d := range documents {
+		_, err := outputs[i].WriteTo(d.Output)
+		if err != nil {
+			return fmt.Errorf("Error writing document %s: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// A document being encrypted or decrypted, whose versions are only opened once they're read or written.
+type document struct {
+	// Names the document in errors and warnings, and holds its encryption context.
+	file      *File
+	encrypted reader
+	decrypted reader
+	output    writer
+	// Where the plain version is written, when decrypting both versions.
+	plainOutput writer
+}
+
+// Opens a version of a document for reading. Nil means there's no such version.
+type reader func() (io.ReadCloser, error)
+
+// Opens a version of a document for writing.
+type writer func() (io.WriteCloser, error)
+
+// Get the documents to encrypt or decrypt, writing their outputs to the given buffers, rather than straight to their Outputs.
+func newDocuments(documents []*Document, outputs []bytes.Buffer) []*document {
+	out := make([]*document, len(documents))
+	for i, d := range documents {
+		out[i] = &document{
+			file:      &File{EncryptedPath: d.Name, DecryptedPath: d.Name, PlainPath: d.Name, Context: d.Context},
+			encrypted: streamReader(d.Encrypted),
+			decrypted: streamReader(d.Decrypted),
+			output:    streamWriter(&outputs[i]),
+		}
+	}
+	return out
+}
+
+// Get documents for files, to be decrypted to the given outputs. Stdout writes the decrypted versions to stdout instead of to files.
+func decryptFileDocuments(files []*File, outputs decryptOutputs, stdout bool) []*document {
+	documents := make([]*document, len(files))
+	for i, file := range files {
+		documents[i] = &document{file: file, encrypted: fileReader(file.EncryptedPath)}
+		if outputs&decryptedOutput != 0 {
+			documents[i].output = fileWriter(file.DecryptedPath)
+		}
+		if outputs&plainOutput != 0 {
+			documents[i].plainOutput = fileWriter(file.PlainPath)
+		}
+		if stdout {
+			documents[i].output = streamWriter(os.Stdout)
+			documents[i].plainOutput = streamWriter(os.Stdout)
+		}
+	}
+	return documents
+}
+
+// Get documents for files, to be encrypted. The encrypted versions are only read if they exist.
+func encryptFileDocuments(files []*File) []*document {
+	documents := make([]*document, len(files))
+	for i, file := range files {
+		documents[i] = &document{file: file, decrypted: fileReader(file.DecryptedPath), output: fileWriter(file.EncryptedPath)}
+		if exists(file.EncryptedPath) {
+			documents[i].encrypted = fileReader(file.EncryptedPath)
+		}
+	}
+	return documents
+}
+
+func fileReader(path string) reader {
+	return func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+}
+
+func fileWriter(path string) writer {
+	return func() (io.WriteCloser, error) {
+		return yaml.CreateFile(path)
+	}
+}
+
+// Writes a file in place of the one that's there, once it's been written in full.
+func replacingWriter(path string, perm os.FileMode) writer {
+	return func() (io.WriteCloser, error) {
+		return yaml.ReplaceFile(path, perm)
+	}
+}
+
+// A Writer that can give up on what it's written, eg. a yaml.ReplacementFile, leaving things as they were before it was opened.
+type aborter interface {
+	Abort() error
+}
+
+// Readers and Writers passed in are never closed, since they belong to the caller.
+func streamReader(r io.Reader) reader {
+	if r == nil {
+		return nil
+	}
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(r), nil
+	}
+}
+
+func streamWriter(w io.Writer) writer {
+	return func() (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Read a version of a document, along with the line ending it uses.
+func (o *Options) readDocument(open reader) (yamlv3.Node, string, error) {
+	r, err := open()
+	if err != nil {
+		return yamlv3.Node{}, "", err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return yamlv3.Node{}, "", err
+	}
+	node, err := o.read(bytes.NewReader(data))
+	return node, yaml.LineEndingOf(data), err
+}
+
+// Write a version of a document.
+func writeDocument(open writer, node yamlv3.Node, options yaml.SaveOptions) error {
+	w, err := open()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	err = yaml.WriteWithOptions(w, node, options)
+	if err != nil {
+		if a, ok := w.(aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return w.Close()
+}
+
+// Call a function on each group of documents sharing an encryption context, as forEachContext does for files.
+func forEachDocumentContext(documents []*document, cache *cache.Cache, provider *crypto.Provider, function func([]*document, *crypto.Provider) error) error {
+	files := make([]*File, len(documents))
+	byFile := map[*File]*document{}
+	for i, d := range documents {
+		files[i] = d.file
+		byFile[d.file] = d
+	}
+	return forEachContext(files, cache, provider, func(files []*File, provider *crypto.Provider) error {
+		group := make([]*document, len(files))
+		for i, file := range files {
+			group[i] = byFile[file]
+		}
+		return function(group, provider)
+	})
+}
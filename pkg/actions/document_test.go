@@ -0,0 +1,129 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDocuments(t *testing.T) {
+	provider := &testProvider{}
+	var p crypto.Provider = provider
+	_, cache, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	before, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originals := []string{
+		"db:\n  password: !secret hunter2\n",
+		"token: !secret abc\r\nuser: app\r\n",
+	}
+	encrypted := make([]bytes.Buffer, len(originals))
+	documents := make([]*Document, len(originals))
+	for i, original := range originals {
+		documents[i] = &Document{Name: "doc", Decrypted: strings.NewReader(original), Output: &encrypted[i]}
+	}
+	err = EncryptDocuments(documents, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, secret := range []string{"hunter2", "abc"} {
+		if strings.Contains(encrypted[i].String(), secret) || !strings.Contains(encrypted[i].String(), "!encrypted") {
+			t.Errorf("Document %d was not encrypted:\n%s", i, encrypted[i].String())
+		}
+	}
+	if !strings.HasSuffix(encrypted[1].String(), "user: app\r\n") {
+		t.Errorf("Document did not keep its CRLF line endings:\n%q", encrypted[1].String())
+	}
+
+	// re-encrypting against the existing encrypted version keeps the ciphertexts of unchanged values
+	calls := provider.encryptCalls
+	var reencrypted bytes.Buffer
+	err = EncryptDocuments([]*Document{{
+		Name:      "doc",
+		Encrypted: bytes.NewReader(encrypted[0].Bytes()),
+		Decrypted: strings.NewReader(originals[0]),
+		Output:    &reencrypted,
+	}}, cache, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reencrypted.String() != encrypted[0].String() || provider.encryptCalls != calls {
+		t.Errorf("Re-encrypting an unchanged document gave:\n%s\nexpected:\n%s", reencrypted.String(), encrypted[0].String())
+	}
+
+	for _, plain := range []bool{false, true} {
+		decrypted := make([]bytes.Buffer, len(originals))
+		for i := range originals {
+			documents[i] = &Document{Name: "doc", Encrypted: bytes.NewReader(encrypted[i].Bytes()), Output: &decrypted[i]}
+		}
+		err = DecryptDocuments(documents, plain, cache, &p, 4, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, original := range originals {
+			expected := original
+			if plain {
+				expected = strings.ReplaceAll(original, "!secret ", "")
+			}
+			if decrypted[i].String() != expected {
+				t.Errorf("Decrypting document %d (plain: %t) gave:\n%q\nexpected:\n%q", i, plain, decrypted[i].String(), expected)
+			}
+		}
+	}
+
+	after, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("Expected documents not to touch the filesystem, but the directory went from %d to %d entries", len(before), len(after))
+	}
+}
+
+func TestDocumentsFailure(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	_, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	var good, bad bytes.Buffer
+	err := EncryptDocuments([]*Document{
+		{Name: "good", Decrypted: strings.NewReader("a: !secret b\n"), Output: &good},
+		{Name: "bad", Decrypted: strings.NewReader("a: [\n"), Output: &bad},
+	}, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Fatalf("Expected an error naming the invalid document, got %v", err)
+	}
+	if good.Len() != 0 || bad.Len() != 0 {
+		t.Errorf("Expected nothing to be written when a document fails, got:\n%s\n%s", good.String(), bad.String())
+	}
+}
+
+func TestDocumentsFailureWhileWriting(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	_, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	var good, unsortable bytes.Buffer
+	err := EncryptDocuments([]*Document{
+		{Name: "good", Decrypted: strings.NewReader("a: !secret b\n"), Output: &good},
+		{Name: "unsortable", Decrypted: strings.NewReader("b: &b !secret value\na: *b\n"), Output: &unsortable},
+	}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second document only fails once it's decrypted, as it's written out, by when the first may have been written already
+	var goodOut, unsortableOut bytes.Buffer
+	err = DecryptDocuments([]*Document{
+		{Name: "good", Encrypted: &good, Output: &goodOut},
+		{Name: "unsortable", Encrypted: &unsortable, Output: &unsortableOut},
+	}, false, cache, &provider, 4, false, &Options{SortKeys: true})
+	if err == nil || !strings.Contains(err.Error(), "alias") {
+		t.Fatalf("Expected an error about the alias, got %v", err)
+	}
+	if goodOut.Len() != 0 || unsortableOut.Len() != 0 {
+		t.Errorf("Expected nothing to be written when a document fails, got:\n%s\n%s", goodOut.String(), unsortableOut.String())
+	}
+}
@@ -0,0 +1,59 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateKeys(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("duplicates.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a:\n  b: !secret one\n  c: 2\n  b: !secret two\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// strict mode points at both definitions of the key
+	opts.StrictKeys = true
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err == nil {
+		t.Fatal("Encrypting a file with a duplicate key did not fail in strict mode")
+	}
+	if !strings.Contains(err.Error(), `Duplicate key "b" at line 4, first defined at line 2`) {
+		t.Errorf("Unexpected error for a duplicate key: %s", err)
+	}
+
+	// lenient mode keeps both, as yaml-crypt always has
+	opts.StrictKeys = false
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatalf("Encrypting a file with a duplicate key failed in lenient mode: %s", err)
+	}
+	node, err := yaml.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Lenient mode kept %d secrets, expected both", count)
+	}
+
+	// encrypted files are checked too
+	opts.StrictKeys = true
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err == nil || !strings.Contains(err.Error(), "Duplicate key") {
+		t.Errorf("Decrypting a file with a duplicate key gave error %v in strict mode", err)
+	}
+}
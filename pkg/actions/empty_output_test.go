@@ -0,0 +1,92 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A testProvider that returns nothing for the plaintext "gone", whether encrypting it or decrypting its ciphertexts.
+type emptyOutputProvider struct {
+	*testProvider
+}
+
+func (p emptyOutputProvider) Encrypt(plaintext string) ([]byte, error) {
+	if plaintext == "gone" {
+		return []byte{}, nil
+	}
+	return p.testProvider.Encrypt(plaintext)
+}
+
+func (p emptyOutputProvider) Decrypt(ciphertext []byte) (string, error) {
+	plaintext, err := p.testProvider.Decrypt(ciphertext)
+	if plaintext == "gone" {
+		return "", err
+	}
+	return plaintext, err
+}
+
+func TestEmptyProviderOutput(t *testing.T) {
+	var provider crypto.Provider = emptyOutputProvider{&testProvider{}}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("empty.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "kept: !secret here\nlost: !secret gone\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty ciphertext is an error naming the path, rather than an empty secret
+	err = Encrypt([]*File{&file}, ca, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "path lost") || !strings.Contains(err.Error(), errEmptyCiphertext.Error()) {
+		t.Errorf("Encrypting with a provider returning an empty ciphertext gave error %v", err)
+	}
+	if exists(file.EncryptedPath) {
+		t.Errorf("Encrypting with a provider returning an empty ciphertext wrote %s", file.EncryptedPath)
+	}
+
+	// so is an empty plaintext, without a cache to fall back on, if empty plaintexts are set to fail
+	working := crypto.Provider(&testProvider{})
+	err = Encrypt([]*File{&file}, ca, &working, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CacheEnabled = false
+	empty, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	err = Decrypt([]*File{&file}, false, false, empty, &provider, 4, false, &Options{EmptyPlaintexts: config.EmptyPlaintextsError})
+	if err == nil || !strings.Contains(err.Error(), "path lost") || !strings.Contains(err.Error(), "empty plaintext") {
+		t.Errorf("Decrypting with a provider returning an empty plaintext gave error %v", err)
+	}
+	if exists(file.DecryptedPath) {
+		t.Errorf("Decrypting with a provider returning an empty plaintext wrote %s", file.DecryptedPath)
+	}
+
+	// by default, it's decrypted to an empty value
+	err = Decrypt([]*File{&file}, false, false, empty, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "kept: !secret here\nlost: !secret \"\"\n"; string(decrypted) != expected {
+		t.Errorf("Decrypting an allowed empty plaintext gave:\n%s\nexpected:\n%s", decrypted, expected)
+	}
+}
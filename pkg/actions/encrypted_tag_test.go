@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedTag(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("vault.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted := "db:\n  user: app\n  password: !secret hunter2\nports:\n  - !secret 5432\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(decrypted), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{EncryptedTag: "!vault"}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(encrypted), "!vault ") != 2 || strings.Contains(string(encrypted), yaml.EncryptedTag) {
+		t.Errorf("Encrypted file doesn't mark its values with the configured tag:\n%s", encrypted)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != decrypted {
+		t.Errorf("Decrypting with the configured tag gave:\n%s\nexpected:\n%s", output, decrypted)
+	}
+
+	// streamed output is marked with the configured tag too
+	var streamed strings.Builder
+	err = EncryptStream(strings.NewReader(decrypted), &streamed, "", cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(streamed.String(), "!vault ") != 2 {
+		t.Errorf("Streamed encrypted output doesn't mark its values with the configured tag:\n%s", streamed.String())
+	}
+
+	// under the default tag, the values aren't recognized as encrypted
+	info, err := Info(&file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Secrets) != 0 {
+		t.Errorf("Values marked with another tag were taken to be encrypted: %v", info.Secrets)
+	}
+
+	for tag, valid := range map[string]bool{"!vault": true, "!enc/v1": true, "vault": false, "!!str": false, "!": false, "! vault": false, yaml.DecryptedTag: false} {
+		if err := yaml.CheckEncryptedTag(tag); (err == nil) != valid {
+			t.Errorf("Checking encrypted tag %q gave %v", tag, err)
+		}
+	}
+}
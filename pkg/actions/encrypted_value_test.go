@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// An encrypted file written before encrypted values were versioned, holding the testProvider ciphertexts "1:one" and "2:two".
+const legacyEncryptedFile = "a: !encrypted MTpvbmU=\nb: !encrypted Mjp0d28=\n"
+
+func TestEncryptedValueVersions(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("versions.encrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"legacy":   legacyEncryptedFile,
+		"explicit": "a: !encrypted v1:MTpvbmU=\nb: !encrypted Mjp0d28=\n",
+	} {
+		err = ioutil.WriteFile(file.EncryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+		if err != nil {
+			t.Fatalf("Decrypting %s values failed: %s", name, err)
+		}
+		decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != "a: !secret one\nb: !secret two\n" {
+			t.Errorf("Decrypting %s values gave:\n%s", name, decrypted)
+		}
+	}
+
+	// re-encrypting leaves legacy values as they were, without adding a header to the otherwise unchanged file
+	err = ioutil.WriteFile(file.EncryptedPath, []byte(legacyEncryptedFile), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encrypted) != legacyEncryptedFile {
+		t.Errorf("Re-encrypting changed legacy values:\n%s", encrypted)
+	}
+
+	// values from a newer version fail clearly
+	err = ioutil.WriteFile(file.EncryptedPath, []byte("a: !encrypted v99:MTpvbmU=\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "layout version 99") {
+		t.Errorf("Decrypting a value from a newer version did not fail clearly: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A testProvider whose credentials can be rejected, eg. once they expire, failing every call until they're refreshed, if refreshing fixes them.
+type expiringProvider struct {
+	*testProvider
+	mutex     sync.Mutex
+	expired   bool
+	fixable   bool
+	refreshes int
+	// Recipients, if not the testProvider's.
+	recipient string
+}
+
+func (p *expiringProvider) Recipients() []string {
+	if p.recipient != "" {
+		return []string{p.recipient}
+	}
+	return p.testProvider.Recipients()
+}
+
+func (p *expiringProvider) check() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.expired {
+		return crypto.CredentialsRejected(errors.New("token has expired"))
+	}
+	return nil
+}
+
+func (p *expiringProvider) Encrypt(plaintext string) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return []byte{}, err
+	}
+	return p.testProvider.Encrypt(plaintext)
+}
+
+func (p *expiringProvider) Decrypt(ciphertext []byte) (string, error) {
+	if err := p.check(); err != nil {
+		return "", err
+	}
+	return p.testProvider.Decrypt(ciphertext)
+}
+
+func (p *expiringProvider) Refresh() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.refreshes++
+	if p.fixable {
+		p.expired = false
+	}
+	return nil
+}
+
+func (p *expiringProvider) expire(fixable bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.expired, p.fixable = true, fixable
+}
+
+// An expiringProvider that can't refresh its credentials.
+type unrefreshableProvider struct {
+	crypto.Provider
+}
+
+func TestExpiredCredentials(t *testing.T) {
+	provider := &expiringProvider{testProvider: &testProvider{}}
+	var p crypto.Provider = provider
+	c, ca, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	file, err := NewFile("app.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := ""
+	for i := 0; i < 20; i++ {
+		content += fmt.Sprintf("key%d: !secret value%d\n", i, i)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// however many workers run into the expired credentials at once, they're refreshed once, and every value then goes through
+	provider.expire(true)
+	err = Encrypt([]*File{&file}, ca, &p, 8, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.refreshes != 1 {
+		t.Errorf("Credentials were refreshed %d times, expected once", provider.refreshes)
+	}
+
+	// credentials can expire again in a later run
+	empty := func() *cache.Cache {
+		c.CacheEnabled = false
+		emptyCache, err := cache.Setup(*c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return emptyCache
+	}
+	provider.expire(true)
+	err = Decrypt([]*File{&file}, false, false, empty(), &p, 8, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.refreshes != 2 {
+		t.Errorf("Credentials were refreshed %d times, expected twice", provider.refreshes)
+	}
+
+	// credentials that refreshing doesn't fix are only refreshed once, and the error says so, rather than being about a value
+	for name, p := range map[string]crypto.Provider{"refreshable": provider, "unrefreshable": unrefreshableProvider{provider}} {
+		provider.expire(false)
+		refreshes := provider.refreshes
+		err = Decrypt([]*File{&file}, false, false, empty(), &p, 8, false, nil)
+		if err == nil || !strings.Contains(err.Error(), "Provider credentials were rejected") || strings.Contains(err.Error(), "at path") || !errors.Is(err, crypto.ErrCredentialsRejected) {
+			t.Errorf("Decrypting with expired credentials (%s) gave error: %v", name, err)
+		}
+		expected := refreshes
+		if name == "refreshable" {
+			expected++
+		}
+		if provider.refreshes != expected {
+			t.Errorf("Credentials (%s) were refreshed %d times, expected %d", name, provider.refreshes-refreshes, expected-refreshes)
+		}
+	}
+
+	// each provider's credentials are refreshed on their own, so one whose refresh didn't help doesn't stop another's being refreshed
+	opts := (&Options{}).withDefaults()
+	provider.expire(false)
+	refreshes := provider.refreshes
+	for i := 0; i < 2; i++ {
+		if err = opts.withRefresh(provider, provider.check); !errors.Is(err, crypto.ErrCredentialsRejected) {
+			t.Errorf("Calling a provider with rejected credentials gave error %v", err)
+		}
+	}
+	if provider.refreshes != refreshes+1 {
+		t.Errorf("Credentials were refreshed %d times, expected once", provider.refreshes-refreshes)
+	}
+	other := &expiringProvider{testProvider: &testProvider{}, recipient: "other"}
+	other.expire(true)
+	if err = opts.withRefresh(other, other.check); err != nil || other.refreshes != 1 {
+		t.Errorf("Another provider's credentials were refreshed %d times, with error %v, expected once", other.refreshes, err)
+	}
+}
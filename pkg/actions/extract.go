@@ -0,0 +1,67 @@
+package actions
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"os"
+)
+
+// Decrypt the single value at the given path in a file's encrypted version, and write it out to its own file. If binary is set, the plaintext is treated as base64 and written out as raw bytes.
+func Extract(file *File, path string, outPath string, binary bool, cache *cache.Cache, provider *crypto.Provider, opts *Options) error {
+	opts = opts.withDefaults()
+	if err := checkCanDecrypt(provider); err != nil {
+		return err
+	}
+	return forEachContext([]*File{file}, cache, provider, func(_ []*File, provider *crypto.Provider) error {
+		return extract(file, path, outPath, binary, cache, provider, opts)
+	})
+}
+
+func extract(file *File, path string, outPath string, binary bool, cache *cache.Cache, provider *crypto.Provider, opts *Options) error {
+	root, err := opts.readFile(file.EncryptedPath)
+	if err != nil {
+		return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	node, err := yaml.GetNodeAtPath(&root, path)
+	if err != nil {
+		return err
+	}
+	if node.Tag != yaml.EncryptedTag {
+		return fmt.Errorf("Value at path %s is not tagged %s", path, yaml.EncryptedTag)
+	}
+	ciphertext, err := yaml.GetValue(node)
+	if err != nil {
+		return fmt.Errorf("Error reading encrypted value at path %s: %w", path, err)
+	}
+	plaintext, err := decryptCiphertext([]byte(ciphertext), cache, provider, opts)
+	if err != nil {
+		err = fmt.Errorf("Error decrypting value at path %s: %w", path, err)
+	}
+	err = opts.audit(file.EncryptedPath, []string{path}, *provider, err)
+	if err != nil {
+		return err
+	}
+	data := []byte(plaintext)
+	if binary {
+		data, err = base64.StdEncoding.DecodeString(plaintext)
+		if err != nil {
+			return fmt.Errorf("Error base64-decoding value at path %s: %w", path, err)
+		}
+	}
+	f, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// an existing file keeps its permissions when opened, so tighten them before writing the plaintext to it
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
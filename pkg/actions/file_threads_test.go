@@ -0,0 +1,142 @@
+package actions
+
+import (
+	"errors"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelFiles(t *testing.T) {
+	for _, threads := range []int{1, 3, 100} {
+		var active, maxActive int32
+		var mutex sync.Mutex
+		calls := map[int]int{}
+		err := parallelFiles(50, threads, func(i int) error {
+			current := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			mutex.Lock()
+			calls[i]++
+			if current > maxActive {
+				maxActive = current
+			}
+			mutex.Unlock()
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(maxActive) > threads {
+			t.Errorf("%d files were processed at once, despite a limit of %d", maxActive, threads)
+		}
+		if len(calls) != 50 {
+			t.Errorf("%d of 50 files were processed with %d threads", len(calls), threads)
+		}
+		for i, n := range calls {
+			if n != 1 {
+				t.Errorf("File %d was processed %d times with %d threads", i, n, threads)
+			}
+		}
+	}
+
+	// the first error in file order is returned, whichever finishes first
+	err := parallelFiles(10, 4, func(i int) error {
+		if i == 3 || i == 7 {
+			time.Sleep(time.Duration(10-i) * time.Millisecond)
+			return errors.New(string(rune('0' + i)))
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "3" {
+		t.Errorf("Got error %v, expected the error from file 3", err)
+	}
+}
+
+func TestParallelMapErrors(t *testing.T) {
+	inputs := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	failing := map[string]time.Duration{"c": 4 * time.Millisecond, "e": 2 * time.Millisecond, "h": 0}
+	var calls int32
+	// later inputs fail sooner, so the first error to arrive is rarely the first in input order
+	for run := 0; run < 20; run++ {
+		outputs, err := parallelMap(inputs, func(input string) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			if delay, ok := failing[input]; ok {
+				time.Sleep(delay)
+				return "", errors.New(input)
+			}
+			return input + input, nil
+		}, 4, false)
+		if err == nil || err.Error() != "c" {
+			t.Fatalf("Run %d got error %v, expected the error from input c", run, err)
+		}
+		if outputs["a"] != "aa" || outputs["b"] != "bb" {
+			t.Errorf("Run %d got outputs %v, expected those of every input before the first failure", run, outputs)
+		}
+	}
+
+	// once an input fails, no more are started
+	calls = 0
+	many := make([]string, 100)
+	for i := range many {
+		many[i] = string(rune('A' + i))
+	}
+	_, err := parallelMap(many, func(input string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		if input == "A" {
+			return "", errors.New(input)
+		}
+		time.Sleep(time.Millisecond)
+		return input, nil
+	}, 1, false)
+	if err == nil || err.Error() != "A" {
+		t.Errorf("Got error %v, expected the error from input A", err)
+	}
+	if calls >= 10 {
+		t.Errorf("Expected inputs to stop being processed after the first failure, got %d calls", calls)
+	}
+}
+
+func TestParallelMapNoInputs(t *testing.T) {
+	before := runtime.NumGoroutine()
+	outputs, err := parallelMap(nil, func(input string) (string, error) {
+		t.Errorf("Function called with %q, despite there being no inputs", input)
+		return "", nil
+	}, 4, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 0 {
+		t.Errorf("Expected no outputs, got %v", outputs)
+	}
+	// goroutines left over from other tests may exit in the meantime, but none should be started
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines were started for no inputs", after-before)
+	}
+}
+
+// Files without any secrets, as most files in a repo-wide run are.
+func BenchmarkEncryptNoValues(b *testing.B) {
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(b, provider)
+	defer cleanup()
+	file, err := NewFile("plain.decrypted.yaml", c)
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("replicas: 3\nimage: app:1.2.3\n"), 0600)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = Encrypt([]*File{&file}, cache, &provider, 8, false, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -12,17 +12,60 @@ type File struct {
 	EncryptedPath string
 	DecryptedPath string
 	PlainPath     string
+	// The context the file's ciphertexts are bound to, if any.
+	Context string
+	// The path, relative to the root of the repo, that the file's plaintexts are cached under, if the cache is scoped per file. Empty if plaintexts are cached across every file.
+	CacheScope string
 }
 
 func NewFile(path string, config *config.Config) (File, error) {
 	path, err := barePath(path, config)
+	if err != nil {
+		return File{}, err
+	}
+	context, err := fileContext(path, config)
+	if err != nil {
+		return File{}, err
+	}
+	var scope string
+	if config.CachePerFile {
+		scope, err = repoPath(path, config)
+	}
 	return File{
 		EncryptedPath: path + config.Suffixes.Encrypted,
 		DecryptedPath: path + config.Suffixes.Decrypted,
 		PlainPath:     path + config.Suffixes.Plain,
+		Context:       context,
+		CacheScope:    scope,
 	}, err
 }
 
+// Get the encryption context for a file, given its bare path.
+func fileContext(path string, config *config.Config) (string, error) {
+	if !strings.Contains(config.EncryptionContext, "{path}") {
+		return config.EncryptionContext, nil
+	}
+	rel, err := repoPath(path, config)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(config.EncryptionContext, "{path}", rel), nil
+}
+
+// Get a file's bare path relative to the root of the repo, with forward slashes, so that it's the same whichever directory it's given relative to, and on every OS.
+func repoPath(path string, config *config.Config) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(config.Root, abs)
+	if err != nil {
+		return "", err
+	}
+	// the bare path keeps the dot that separated it from the suffix
+	return strings.TrimSuffix(filepath.ToSlash(rel), "."), nil
+}
+
 func barePath(path string, config *config.Config) (string, error) {
 	dir := filepath.Dir(path)
 	name := filepath.Base(path)
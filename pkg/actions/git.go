@@ -5,15 +5,79 @@ import (
 	"fmt"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 func UpdateGitignore(c *config.Config) error {
-	path := filepath.Join(c.Root, ".gitignore")
 	ignores := c.Suffixes.GitignoreSet()
 	ignores["/"+cache.CacheDirName] = true
+	return addGitignoreEntries(c.Root, ignores)
+}
+
+// Add the files written when decrypting to the .gitignore at GitignoreRoot, if it's set. Each file gets its own entry anchored to the root, eg. "/secrets/db.decrypted.yaml", rather than a pattern that might match more than intended, and only if git doesn't already ignore it.
+func (o *Options) gitignoreOutputs(files []*File, outputs decryptOutputs) error {
+	if o.GitignoreRoot == "" {
+		return nil
+	}
+	paths := []string{}
+	for _, file := range files {
+		for _, output := range []struct {
+			output decryptOutputs
+			path   string
+		}{{decryptedOutput, file.DecryptedPath}, {plainOutput, file.PlainPath}} {
+			if outputs&output.output == 0 {
+				continue
+			}
+			path, err := filepath.Abs(output.path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+		}
+	}
+	ignored := gitIgnored(o.GitignoreRoot, paths)
+	entries := map[string]bool{}
+	for _, path := range paths {
+		rel, err := filepath.Rel(o.GitignoreRoot, path)
+		if err != nil || ignored[path] || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		entries["/"+filepath.ToSlash(rel)] = true
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return addGitignoreEntries(o.GitignoreRoot, entries)
+}
+
+// Get which of the given absolute paths git ignores, in the repo at dir. If git isn't available, or dir isn't in a git repo, none are.
+func gitIgnored(dir string, paths []string) map[string]bool {
+	ignored := map[string]bool{}
+	cmd := exec.Command("git", "check-ignore", "--stdin")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\n") + "\n")
+	// exits 1 when nothing is ignored
+	out, _ := cmd.Output()
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			ignored[line] = true
+		}
+	}
+	return ignored
+}
+
+// Append the given entries to the .gitignore in dir, skipping any it already has, creating it if it doesn't exist.
+func addGitignoreEntries(dir string, ignores map[string]bool) error {
+	// write through a symlinked .gitignore, rather than replacing it
+	path, err := yaml.RealPath(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return err
+	}
 	if exists(path) {
 		existingFile, err := os.Open(path)
 		defer existingFile.Close()
@@ -29,9 +93,9 @@ func UpdateGitignore(c *config.Config) error {
 		scanner := bufio.NewScanner(existingFile)
 		for scanner.Scan() {
 			line := strings.Trim(scanner.Text(), "\r\n")
-			if _, ok := ignores[line]; ok {
-				delete(ignores, line)
-			}
+			// a trailing slash only restricts the pattern to directories, so it's the same entry either way
+			delete(ignores, line)
+			delete(ignores, strings.TrimSuffix(line, "/"))
 			_, err = fmt.Fprintln(newFile, line)
 			if err != nil {
 				return err
@@ -40,7 +104,7 @@ func UpdateGitignore(c *config.Config) error {
 		if err = scanner.Err(); err != nil {
 			return err
 		}
-		for ignore := range ignores {
+		for _, ignore := range sortedKeys(ignores) {
 			_, err = fmt.Fprintln(newFile, ignore)
 			if err != nil {
 				return err
@@ -61,7 +125,7 @@ func UpdateGitignore(c *config.Config) error {
 		if err != nil {
 			return err
 		}
-		for ignore := range ignores {
+		for _, ignore := range sortedKeys(ignores) {
 			_, err := fmt.Fprintln(newFile, ignore)
 			if err != nil {
 				return err
@@ -71,3 +135,25 @@ func UpdateGitignore(c *config.Config) error {
 		return err
 	}
 }
+
+func sortedKeys(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Get the identity of the git user in the given directory: their user.email, or failing that their user.name. Returns an empty string if git has neither, or isn't available.
+func GitIdentity(dir string) string {
+	for _, key := range []string{"user.email", "user.name"} {
+		cmd := exec.Command("git", "config", key)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err == nil && strings.TrimSpace(string(out)) != "" {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitignoreOutputs(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	err := os.Mkdir("secrets", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewFile("secrets/db.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("password: !secret hunter2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitignore := filepath.Join(c.Root, ".gitignore")
+	original := "/build\n"
+	err = ioutil.WriteFile(gitignore, []byte(original), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(entry string) int {
+		data, err := ioutil.ReadFile(gitignore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == entry {
+				n++
+			}
+		}
+		return n
+	}
+
+	// off by default
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count("/secrets/db.decrypted.yaml") != 0 {
+		t.Errorf("Decrypted file was added to .gitignore without being enabled")
+	}
+
+	opts.GitignoreRoot = c.Root
+	for run := 0; run < 2; run++ {
+		err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := count("/secrets/db.decrypted.yaml"); n != 1 {
+			t.Errorf("Decrypted file was added to .gitignore %d times after %d runs, expected once", n, run+1)
+		}
+		if count("/secrets/db.plain.yaml") != 0 {
+			t.Errorf("Plain file was added to .gitignore without being written")
+		}
+	}
+	err = Decrypt([]*File{&file}, true, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count("/secrets/db.plain.yaml") != 1 || count("/secrets/db.decrypted.yaml") != 1 || count("/build") != 1 {
+		t.Errorf("Expected the plain file to be added alongside the existing entries")
+	}
+
+	// files git already ignores aren't added again
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't available")
+	}
+	err = exec.Command("git", "init", "-q", c.Root).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(gitignore, []byte("*.decrypted.yaml\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DecryptBoth([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count("/secrets/db.decrypted.yaml") != 0 || count("/secrets/db.plain.yaml") != 1 {
+		data, _ := ioutil.ReadFile(gitignore)
+		t.Errorf("Expected only the plain file, which git doesn't already ignore, to be added, got:\n%s", data)
+	}
+}
@@ -0,0 +1,129 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroups(t *testing.T) {
+	base := &testProvider{}
+	var provider crypto.Provider = base
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("groups.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := strings.Join([]string{
+		"name: app",
+		"db: !secret",
+		"  # the primary",
+		"  host: db.internal",
+		"  port: 5432",
+		"  creds:",
+		"    user: admin",
+		"    password: \"hunter2\" # rotated monthly",
+		"  replicas: [a, b]",
+		"hosts: !secret",
+		"  - one",
+		"  - two: 2",
+		"flow: !secret {a: 1, b: [x, y]}",
+		"token: !secret abc",
+		"",
+	}, "\n")
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each sub-tree is encrypted as a single value, structure and all
+	encrypted, err := yaml.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{}
+	for n := range yaml.GetTaggedChildren(&encrypted, yaml.EncryptedTag) {
+		tags[n.Path.Dotted()], err = yaml.EncryptedValue(n.YamlNode.Value).Tag()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedTags := map[string]string{"db": "!!map", "hosts": "!!seq", "flow": "!!map", "token": "!!str"}
+	if !reflect.DeepEqual(tags, expectedTags) {
+		t.Errorf("Encrypting sub-trees gave encrypted values %v, expected %v", tags, expectedTags)
+	}
+	if base.encryptCalls != 4 {
+		t.Errorf("Encrypting 4 values made %d encrypt calls", base.encryptCalls)
+	}
+	data, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"db.internal", "creds", "replicas", "one", "two"} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("Encrypted file gives away %q:\n%s", leaked, data)
+		}
+	}
+
+	// decrypting restores each sub-tree exactly, comments, styles and all
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != original {
+		t.Errorf("Decrypting sub-trees gave:\n%s\nexpected:\n%s", decrypted, original)
+	}
+	err = Decrypt([]*File{&file}, true, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadFile(file.PlainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := strings.ReplaceAll(original, " !secret", ""); string(plain) != expected {
+		t.Errorf("Decrypting sub-trees to the plain version gave:\n%s\nexpected:\n%s", plain, expected)
+	}
+
+	// unchanged sub-trees keep their ciphertexts
+	before := encryptedValues(t, file.EncryptedPath)
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := encryptedValues(t, file.EncryptedPath); !reflect.DeepEqual(before, after) || base.encryptCalls != 4 {
+		t.Errorf("Re-encrypting unchanged sub-trees changed their ciphertexts from %v to %v", before, after)
+	}
+
+	// values inside a sub-tree can't be encrypted on their own as well
+	for _, content := range []string{
+		"db: !secret\n  password: !secret hunter2\n",
+		"user: &user admin\ndb: !secret\n  user: *user\n",
+	} {
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+		if err == nil || !strings.Contains(err.Error(), "path db") {
+			t.Errorf("Encrypting an invalid sub-tree gave error %v:\n%s", err, content)
+		}
+	}
+}
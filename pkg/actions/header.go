@@ -0,0 +1,141 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"strings"
+)
+
+// Check from its header that an encrypted document is in a format this version of yaml-crypt understands, and when decrypting, that it was encrypted with the kind of provider that's configured, so that a mismatch gets a clear error rather than a failure on the first value that isn't cached. Documents without a header predate headers, and are assumed to be compatible.
+func checkHeader(node *yamlv3.Node, provider crypto.Provider, decrypting bool) error {
+	header, ok, err := yaml.GetHeader(node)
+	if err != nil || !ok {
+		return err
+	}
+	err = header.Check()
+	if err != nil || !decrypting {
+		return err
+	}
+	if ok, configured := hasConfiguredProvider(header, provider); !ok {
+		return fmt.Errorf("File was encrypted with the %s provider, but the %s provider is configured", header.Provider, strings.Join(configured, ","))
+	}
+	return nil
+}
+
+// Whether any of the kinds of provider a header lists is configured, along with the kinds that are. Only false if the header lists some, and every configured provider is one yaml-crypt can describe, since an undescribed one could be any kind. Values of any other provider may still be cached, so it isn't known that none can be decrypted.
+func hasConfiguredProvider(header yaml.Header, provider crypto.Provider) (bool, []string) {
+	configured := []string{}
+	if header.Provider == "" {
+		return true, configured
+	}
+	providers := []crypto.Provider{provider}
+	if router, ok := provider.(crypto.Router); ok {
+		providers = []crypto.Provider{router.Default}
+		for _, p := range router.Named {
+			providers = append(providers, p)
+		}
+	}
+	for _, p := range providers {
+		name, _ := crypto.Describe(p)
+		if name == "" {
+			return true, configured
+		}
+		configured = append(configured, name)
+	}
+	for _, name := range strings.Split(header.Provider, ",") {
+		for _, c := range configured {
+			if name == c {
+				return true, configured
+			}
+		}
+	}
+	return false, configured
+}
+
+// Get the header of a newly encrypted document, describing every provider its values were encrypted with, in the order they first appear, along with the recipients it declares, if any. A document without any values is described by the default provider.
+func newHeader(node *yamlv3.Node, provider crypto.Provider, recipients string) yaml.Header {
+	names := []string{}
+	algorithms := []string{}
+	described := map[string]bool{}
+	describe := func(p crypto.Provider) {
+		name, algorithm := crypto.Describe(p)
+		if name != "" && !described[name+" "+algorithm] {
+			described[name+" "+algorithm] = true
+			names = append(names, name)
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	for n := range yaml.GetTaggedChildren(node, yaml.EncryptedTag) {
+		ciphertext, err := yaml.GetValue(n.YamlNode)
+		// nulls aren't encrypted by any provider
+		if err != nil || ciphertext == "" {
+			continue
+		}
+		// values of providers that aren't configured, eg. kept as managed, are left out
+		if p, err := crypto.ForName(provider, crypto.Marker([]byte(ciphertext))); err == nil {
+			describe(p)
+		}
+	}
+	if len(described) == 0 {
+		describe(provider)
+	}
+	header := yaml.NewHeader(strings.Join(names, ","), strings.Join(algorithms, ","))
+	header.Recipients = recipients
+	return header
+}
+
+// Set the header of a newly encrypted document, as newHeader gives it. If the document is otherwise the same as its existing encrypted version, that keeps its header, or lack of one, so that encrypting an unchanged file never rewrites it just for its header.
+func setHeader(node *yamlv3.Node, existing *yamlv3.Node, provider crypto.Provider, recipients string, options yaml.SaveOptions) error {
+	if existing != nil {
+		header, ok, _ := yaml.GetHeader(existing)
+		header.Recipients = recipients
+		kept := *node
+		yaml.RemoveHeader(&kept)
+		if ok || recipients != "" {
+			yaml.SetHeader(&kept, header)
+		}
+		var before, after bytes.Buffer
+		err := yaml.WriteWithOptions(&before, *existing, options)
+		if err != nil {
+			return err
+		}
+		err = yaml.WriteWithOptions(&after, kept, options)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(before.Bytes(), after.Bytes()) {
+			node.HeadComment = kept.HeadComment
+			return nil
+		}
+	}
+	yaml.SetHeader(node, newHeader(node, provider, recipients))
+	return nil
+}
+
+// Replace the header of an encrypted document being decrypted with the header of its decrypted version, which only declares its recipients. The rest describes the encrypted version only.
+func setDecryptedHeader(node *yamlv3.Node) {
+	header, _, _ := yaml.GetHeader(node)
+	yaml.RemoveHeader(node)
+	if header.Recipients != "" {
+		yaml.SetHeader(node, yaml.Header{Recipients: header.Recipients})
+	}
+}
+
+// Get the name of the provider a document declares as its recipients in its header, if any, checking that it's configured. Declared recipients are ignored if SingleProvider is set.
+func (o *Options) declaredRecipients(node *yamlv3.Node, provider crypto.Provider) (string, error) {
+	if o.SingleProvider {
+		return "", nil
+	}
+	// encrypted documents have their header checked first, so either kind can be read as a decrypted one
+	header, _, err := yaml.GetDecryptedHeader(node)
+	if err != nil || header.Recipients == "" {
+		return "", err
+	}
+	if _, err := crypto.ForName(provider, header.Recipients); err != nil {
+		return "", fmt.Errorf("Invalid recipients: %w", err)
+	}
+	return header.Recipients, nil
+}
@@ -0,0 +1,159 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	var provider crypto.Provider = crypto.NewPassphraseProvider("correct horse", []byte("0123456789abcdef"), 1, 1024, 1)
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("header.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "# database settings\n\nuser: app\npassword: !secret hunter2\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# yaml-crypt: format=1 provider=passphrase algorithm=aes-256-gcm-argon2id\n\n# database settings\n\nuser: app\n"
+	if !strings.HasPrefix(string(encrypted), expected) {
+		t.Errorf("Expected the encrypted file to start with:\n%s\ngot:\n%s", expected, encrypted)
+	}
+
+	// the header stays out of the decrypted version, and encrypting again doesn't add a second one
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != original {
+		t.Errorf("Decrypting gave:\n%s\nexpected:\n%s", decrypted, original)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(encrypted) {
+		t.Errorf("Encrypting again changed the file:\n%s", again)
+	}
+
+	// a file from a newer version fails clearly, and isn't overwritten
+	future := strings.Replace(string(encrypted), "format=1", "format=2 compression=zstd", 1)
+	err = ioutil.WriteFile(file.EncryptedPath, []byte(future), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "written by a newer yaml-crypt") {
+		t.Errorf("Expected decrypting a file from a newer version to fail clearly, got %v", err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "written by a newer yaml-crypt") {
+		t.Errorf("Expected encrypting over a file from a newer version to fail clearly, got %v", err)
+	}
+	after, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != future {
+		t.Errorf("Encrypting overwrote a file from a newer version")
+	}
+
+	// as does a file encrypted with a different kind of provider
+	err = ioutil.WriteFile(file.EncryptedPath, []byte(strings.Replace(string(encrypted), "provider=passphrase", "provider=google", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "encrypted with the google provider, but the passphrase provider is configured") {
+		t.Errorf("Expected decrypting a file from another provider to fail clearly, got %v", err)
+	}
+
+	// an encrypted file's header must give its format, even though a decrypted file's doesn't
+	err = ioutil.WriteFile(file.EncryptedPath, []byte(strings.Replace(string(encrypted), "format=1 ", "", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "missing its format version") {
+		t.Errorf("Expected decrypting a file whose header is missing its format to fail, got %v", err)
+	}
+}
+
+func TestHeaderProviders(t *testing.T) {
+	passphrase := crypto.NewPassphraseProvider("correct horse", []byte("0123456789abcdef"), 1, 1024, 1)
+	var provider crypto.Provider = crypto.Router{Default: passphrase, Named: map[string]crypto.Provider{"public": crypto.NoopProvider{}}}
+	opts := &Options{ProviderPaths: []config.ProviderPath{{Path: "public.*", Provider: "public"}}}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("header.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("password: !secret hunter2\npublic:\n  key: !secret abc\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every provider the values were encrypted with is described
+	node, err := yaml.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, ok, err := yaml.GetHeader(&node)
+	if err != nil || !ok {
+		t.Fatalf("Reading header gave %t, %v", ok, err)
+	}
+	if header.Provider != "passphrase,noop" || header.Algorithm != "aes-256-gcm-argon2id,none" {
+		t.Errorf("Header described providers %s and algorithms %s", header.Provider, header.Algorithm)
+	}
+
+	// a document is accepted as long as one of its providers is configured, whichever is the default
+	for _, configured := range []crypto.Provider{
+		provider,
+		crypto.NoopProvider{},
+		crypto.Router{Default: crypto.NoopProvider{}, Named: map[string]crypto.Provider{"secret": passphrase}},
+		&testProvider{},
+	} {
+		err = checkHeader(&node, configured, true)
+		if err != nil {
+			t.Errorf("Checking header against %T gave error %v", configured, err)
+		}
+	}
+	// or if there's a provider that can't be described, since it could be any
+	err = checkHeader(&node, crypto.Router{Default: passphrase, Named: map[string]crypto.Provider{"other": &testProvider{}}}, true)
+	if err != nil {
+		t.Errorf("Checking header with an undescribed provider configured gave error %v", err)
+	}
+	yaml.SetHeader(&node, yaml.NewHeader("google", "google-kms"))
+	err = checkHeader(&node, provider, true)
+	if err == nil || !strings.Contains(err.Error(), "the passphrase,noop provider is configured") {
+		t.Errorf("Checking header of a document from an unconfigured provider gave error %v", err)
+	}
+}
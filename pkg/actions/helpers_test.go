@@ -0,0 +1,115 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A provider for tests, which produces a different ciphertext every time a value is encrypted, and counts its calls.
+type testProvider struct {
+	mutex        sync.Mutex
+	counter      int
+	encryptCalls int
+	decryptCalls int
+}
+
+func (p *testProvider) Encrypt(plaintext string) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.counter++
+	p.encryptCalls++
+	return []byte(fmt.Sprintf("%d:%s", p.counter, plaintext)), nil
+}
+
+func (p *testProvider) Decrypt(ciphertext []byte) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.decryptCalls++
+	parts := strings.SplitN(string(ciphertext), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Invalid ciphertext %q", ciphertext)
+	}
+	return parts[1], nil
+}
+
+func (p *testProvider) Recipients() []string {
+	return []string{"test"}
+}
+
+func (p *testProvider) AvailableKeys() []string {
+	return p.Recipients()
+}
+
+func (p *testProvider) WithContext(context string) crypto.Provider {
+	return &contextTestProvider{p, context}
+}
+
+// A testProvider bound to an encryption context, whose ciphertexts only decrypt under the same context.
+type contextTestProvider struct {
+	*testProvider
+	context string
+}
+
+func (p *contextTestProvider) Encrypt(plaintext string) ([]byte, error) {
+	return p.testProvider.Encrypt(p.context + "\x00" + plaintext)
+}
+
+func (p *contextTestProvider) Decrypt(ciphertext []byte) (string, error) {
+	plaintext, err := p.testProvider.Decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(plaintext, p.context+"\x00") {
+		return "", fmt.Errorf("Ciphertext %q was not encrypted under context %q", ciphertext, p.context)
+	}
+	return strings.TrimPrefix(plaintext, p.context+"\x00"), nil
+}
+
+// Set up a temporary repo using the given provider, with an open cache. The returned function cleans everything up.
+func setupTestRepo(t testing.TB, provider crypto.Provider) (*config.Config, *cache.Cache, func()) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repo fixtures.Repo
+	for _, r := range repos {
+		if r.Provider == "noop" && r.Note == "" {
+			repo = r
+		}
+	}
+	err = repo.Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(".")
+	if err != nil {
+		repo.Destroy()
+		t.Fatal(err)
+	}
+	c.Provider = provider
+	c.ProviderName = "test"
+	ca, err := cache.Setup(c)
+	if err != nil {
+		repo.Destroy()
+		t.Fatal(err)
+	}
+	return &c, ca, func() {
+		ca.Close()
+		repo.Destroy()
+	}
+}
+
+// A testProvider that can only encrypt, like one configured with only a public key.
+type encryptOnlyTestProvider struct {
+	*testProvider
+}
+
+func (p encryptOnlyTestProvider) CanDecrypt() bool {
+	return false
+}
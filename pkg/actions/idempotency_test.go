@@ -0,0 +1,94 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdempotency(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		// only deterministic providers produce identical output from scratch
+		if repo.Skip() || repo.Provider != "noop" {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Checkout("original")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := config.LoadConfig(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var outputs [2][][]byte
+		var gitignores [2][]byte
+		for run := 0; run < 2; run++ {
+			// start each run from scratch
+			for _, f := range repo.Files {
+				os.Remove(f.TmpPath(repo.Provider))
+			}
+			os.Remove(filepath.Join(config.Root, ".gitignore"))
+			err = os.RemoveAll(filepath.Join(config.Root, cache.CacheDirName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = UpdateGitignore(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gitignores[run], err = ioutil.ReadFile(filepath.Join(config.Root, ".gitignore"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := cache.Setup(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := make([]*File, len(repo.Files))
+			for i, f := range repo.Files {
+				file, err := NewFile(f.TmpPath("original"), &config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				files[i] = &file
+			}
+			err = Encrypt(files, c, &config.Provider, 8, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range repo.Files {
+				data, err := ioutil.ReadFile(f.TmpPath(repo.Provider))
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputs[run] = append(outputs[run], data)
+			}
+		}
+		for i, f := range repo.Files {
+			if !bytes.Equal(outputs[0][i], outputs[1][i]) {
+				t.Errorf("Encrypting %s in repo %s twice from scratch gave different output", f.TmpPath("original"), repo)
+			}
+		}
+		if !bytes.Equal(gitignores[0], gitignores[1]) {
+			t.Errorf("Generating .gitignore in repo %s twice from scratch gave different output", repo)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestInPlace(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("inplace.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "# database\ndb:\n  user: app\n  password: !secret hunter2 # rotated yearly\nhosts:\n  - !secret a.internal\n  - b.internal\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(file.EncryptedPath, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decrypting twice leaves the file decrypted, just as it was
+	for i := 0; i < 2; i++ {
+		err = DecryptInPlace([]*File{&file}, cache, &provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := ioutil.ReadFile(file.EncryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != original {
+			t.Errorf("Decrypting in place gave:\n%s\nexpected:\n%s", decrypted, original)
+		}
+	}
+	if exists(file.DecryptedPath) {
+		t.Error("Decrypting in place wrote a decrypted version")
+	}
+	// like any decrypted file, it's only readable by its owner, whatever the encrypted file's permissions were
+	if info, err := os.Stat(file.EncryptedPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Decrypting in place gave the file permissions %v, %v, expected 0600", info.Mode(), err)
+	}
+	assertNoTempFiles(t, filepath.Dir(file.EncryptedPath))
+	// checking catches the file while it's decrypted, even where its keys don't look like secrets
+	err = CheckEncrypted([]*File{&file}, regexp.MustCompile("password"), opts)
+	if err == nil || !strings.Contains(err.Error(), "db.password") || !strings.Contains(err.Error(), "hosts.0") {
+		t.Errorf("Checking a file decrypted in place gave error %v", err)
+	}
+
+	// encrypting twice restores the file as it was, with its values' existing ciphertexts
+	for i := 0; i < 2; i++ {
+		err = EncryptInPlace([]*File{&file}, cache, &provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reencrypted, err := ioutil.ReadFile(file.EncryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(reencrypted) != string(encrypted) {
+			t.Errorf("Encrypting in place gave:\n%s\nexpected:\n%s", reencrypted, encrypted)
+		}
+	}
+	assertNoTempFiles(t, filepath.Dir(file.EncryptedPath))
+	err = CheckEncrypted([]*File{&file}, regexp.MustCompile("password"), opts)
+	if err != nil {
+		t.Errorf("Checking a file encrypted in place again failed: %s", err)
+	}
+
+	// a file that fails to be written is left as it was
+	opts.SortKeys = true
+	unsortable, err := NewFile("unsortable.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(unsortable.DecryptedPath, []byte("b: &b !secret value\na: *b\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&unsortable}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err = ioutil.ReadFile(unsortable.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DecryptInPlace([]*File{&unsortable}, cache, &provider, 4, false, opts)
+	if err == nil || !strings.Contains(err.Error(), "alias") {
+		t.Errorf("Decrypting a file in place with an alias that sorts before its anchor gave error %v", err)
+	}
+	after, err := ioutil.ReadFile(unsortable.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(encrypted) {
+		t.Errorf("A file that failed to be decrypted in place was changed to:\n%s", after)
+	}
+	assertNoTempFiles(t, filepath.Dir(unsortable.EncryptedPath))
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.Contains(info.Name(), ".tmp-") {
+			t.Errorf("Temporary file %s was left behind", info.Name())
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestInlineRecipients(t *testing.T) {
+	opts := &Options{}
+	// declared recipients win over providerPaths
+	opts.ProviderPaths = []config.ProviderPath{{Path: "shared.*", Provider: "team"}}
+	dev, ops, team := &testProvider{}, &testProvider{}, &testProvider{}
+	var provider crypto.Provider = crypto.Router{Default: dev, Named: map[string]crypto.Provider{"ops": ops, "team": team}}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	contents := map[string]string{
+		"infra":   "# yaml-crypt: recipients=ops\n\npassword: !secret a\nshared:\n  token: !secret b\n",
+		"app":     "# yaml-crypt: recipients=team\n\n# app settings\n\npassword: !secret c\n",
+		"default": "password: !secret d\nshared:\n  token: !secret e\n",
+	}
+	expected := map[string]map[string]string{
+		"infra":   {"password": "ops", "shared.token": "ops"},
+		"app":     {"password": "team"},
+		"default": {"password": "", "shared.token": "team"},
+	}
+	files := map[string]*File{}
+	list := []*File{}
+	for name, content := range contents {
+		file, err := NewFile(name+".decrypted.yaml", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = &file
+		list = append(list, &file)
+	}
+	err := Encrypt(list, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, file := range files {
+		values := encryptedValues(t, file.EncryptedPath)
+		for path, to := range expected[name] {
+			if marker := crypto.Marker([]byte(values[path])); marker != to {
+				t.Errorf("Value at %s in %s was encrypted by provider %q, expected %q", path, name, marker, to)
+			}
+		}
+	}
+	if dev.encryptCalls != 1 || ops.encryptCalls != 2 || team.encryptCalls != 2 {
+		t.Errorf("Expected 1, 2 and 2 values encrypted by each provider, got %d, %d and %d", dev.encryptCalls, ops.encryptCalls, team.encryptCalls)
+	}
+	encrypted, err := ioutil.ReadFile(files["app"].EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(encrypted), "# yaml-crypt: format=1 recipients=team\n\n# app settings\n\n") {
+		t.Errorf("Expected the encrypted header to keep the declared recipients:\n%s", encrypted)
+	}
+
+	// the declaration is kept in the decrypted version, so encrypting it again targets the same recipients
+	err = Decrypt(list, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, file := range files {
+		decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != contents[name] {
+			t.Errorf("Decrypting %s gave:\n%s\nexpected:\n%s", name, decrypted, contents[name])
+		}
+	}
+	plans, err := PlanRekey(list, provider, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if len(plan.Rekeyed) != 0 {
+			t.Errorf("Expected nothing to rekey in %s, got %+v", plan.File, plan.Rekeyed)
+		}
+	}
+
+	// recipients must be a configured provider
+	err = ioutil.WriteFile(files["app"].DecryptedPath, []byte("# yaml-crypt: recipients=nobody\n\npassword: !secret c\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{files["app"]}, cache, &provider, 4, false, opts)
+	if err == nil || !strings.Contains(err.Error(), "No provider named nobody") {
+		t.Errorf("Expected an error for recipients that aren't configured, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+)
+
+// Information about a file, which can be gathered without decrypting anything.
+type FileInfo struct {
+	EncryptedPath   string   `json:"encrypted_path"`
+	DecryptedPath   string   `json:"decrypted_path"`
+	PlainPath       string   `json:"plain_path"`
+	EncryptedExists bool     `json:"encrypted_exists"`
+	DecryptedExists bool     `json:"decrypted_exists"`
+	PlainExists     bool     `json:"plain_exists"`
+	Secrets         []string `json:"secrets"`
+	// Who last changed each secret, by path, for the secrets that record it.
+	RotatedBy map[string]string `json:"rotated_by"`
+}
+
+// The result of verifying a single file.
+type VerifyResult struct {
+	File   string        `json:"file"`
+	Ok     bool          `json:"ok"`
+	Errors []VerifyError `json:"errors"`
+}
+
+// A problem found with a single value while verifying a file.
+type VerifyError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Gather information about a file, without decrypting anything.
+func Info(file *File, opts *Options) (FileInfo, error) {
+	opts = opts.withDefaults()
+	info := FileInfo{
+		EncryptedPath:   file.EncryptedPath,
+		DecryptedPath:   file.DecryptedPath,
+		PlainPath:       file.PlainPath,
+		EncryptedExists: exists(file.EncryptedPath),
+		DecryptedExists: exists(file.DecryptedPath),
+		PlainExists:     exists(file.PlainPath),
+		Secrets:         []string{},
+		RotatedBy:       map[string]string{},
+	}
+	if info.EncryptedExists {
+		node, err := opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return info, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		info.Secrets = yaml.GetTaggedChildrenPaths(&node, yaml.EncryptedTag)
+		for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+			if rotatedBy := yaml.GetRotatedBy(n.YamlNode); rotatedBy != "" {
+				info.RotatedBy[n.Path.Dotted()] = rotatedBy
+			}
+		}
+	} else if info.DecryptedExists {
+		node, err := opts.readFile(file.DecryptedPath)
+		if err != nil {
+			return info, fmt.Errorf("Error reading yaml file %s: %w", file.DecryptedPath, err)
+		}
+		info.Secrets = yaml.GetTaggedChildrenPaths(&node, yaml.DecryptedTag)
+	}
+	return info, nil
+}
+
+// Check that every encrypted value in each file can be decrypted, and that any existing decrypted version of the file is up to date with its encrypted version.
+func Verify(files []*File, cache *cache.Cache, provider *crypto.Provider, opts *Options) ([]VerifyResult, error) {
+	opts = opts.withDefaults()
+	if err := checkCanDecrypt(provider); err != nil {
+		return []VerifyResult{}, err
+	}
+	results := make([]VerifyResult, 0, len(files))
+	err := forEachContext(files, cache, provider, func(files []*File, provider *crypto.Provider) error {
+		var err error
+		results, err = verify(results, files, cache, provider, opts)
+		return err
+	})
+	return results, err
+}
+
+func verify(results []VerifyResult, files []*File, cache *cache.Cache, provider *crypto.Provider, opts *Options) ([]VerifyResult, error) {
+	for _, file := range files {
+		result, err := verifyFile(file, cache, provider, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Verify a single file, as Verify does. Problems with its values are reported in the result; only problems reading the file are returned as errors.
+func verifyFile(file *File, cache *cache.Cache, provider *crypto.Provider, opts *Options) (VerifyResult, error) {
+	result := VerifyResult{File: file.EncryptedPath, Errors: []VerifyError{}}
+	node, err := opts.readFile(file.EncryptedPath)
+	if err != nil {
+		return result, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	plaintexts := map[string]string{}
+	for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+		path := n.Path.Dotted()
+		ciphertext, err := yaml.GetValue(n.YamlNode)
+		if err == nil {
+			plaintexts[path], err = decryptCiphertext([]byte(ciphertext), cache, provider, opts)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, VerifyError{path, err.Error()})
+		}
+	}
+	if exists(file.DecryptedPath) {
+		decryptedNode, err := opts.readFile(file.DecryptedPath)
+		if err != nil {
+			return result, fmt.Errorf("Error reading yaml file %s: %w", file.DecryptedPath, err)
+		}
+		seen := map[string]bool{}
+		for n := range yaml.GetTaggedChildren(&decryptedNode, yaml.DecryptedTag) {
+			path := n.Path.Dotted()
+			seen[path] = true
+			value, err := yaml.GetValue(n.YamlNode)
+			if err != nil {
+				result.Errors = append(result.Errors, VerifyError{path, err.Error()})
+			} else if plaintext, ok := plaintexts[path]; !ok {
+				result.Errors = append(result.Errors, VerifyError{path, "Secret is not present in encrypted file"})
+			} else if plaintext != value {
+				result.Errors = append(result.Errors, VerifyError{path, "Decrypted file differs from encrypted file"})
+			}
+		}
+		for _, path := range yaml.GetTaggedChildrenPaths(&node, yaml.EncryptedTag) {
+			if _, ok := plaintexts[path]; ok && !seen[path] {
+				result.Errors = append(result.Errors, VerifyError{path, "Secret is not present in decrypted file"})
+			}
+		}
+	}
+	result.Ok = len(result.Errors) == 0
+	return result, nil
+}
+
+// What can be told about each encrypted value in a file without decrypting it.
+type InspectResult struct {
+	File string `json:"file"`
+	// Version of the file's format, from its header, or zero if it has none.
+	Format int         `json:"format"`
+	Values []ValueInfo `json:"values"`
+}
+
+// What can be told about a single encrypted value without decrypting it.
+type ValueInfo struct {
+	Path string `json:"path"`
+	// Yaml tag of the value's plaintext, eg. "!!int".
+	Type string `json:"type"`
+	// Version of the layout of the encrypted value in the file. See yaml.EncryptedValue.
+	Layout int `json:"layout"`
+	crypto.CiphertextInfo
+}
+
+// Gather what can be told about each encrypted value in a file from its ciphertext, its header and the configured provider, without decrypting anything, so that no key is needed. Values in a file whose header only names kinds of provider that aren't configured are described by the header alone.
+func Inspect(file *File, provider crypto.Provider, opts *Options) (InspectResult, error) {
+	result := InspectResult{File: file.EncryptedPath, Values: []ValueInfo{}}
+	node, err := opts.withDefaults().readFile(file.EncryptedPath)
+	if err != nil {
+		return result, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	header, _, err := yaml.GetHeader(&node)
+	if err != nil {
+		return result, fmt.Errorf("Error reading header of %s: %w", file.EncryptedPath, err)
+	}
+	result.Format = header.Format
+	if ok, _ := hasConfiguredProvider(header, provider); !ok {
+		provider = nil
+	}
+	for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+		path := n.Path.Dotted()
+		var encoded string
+		err = n.YamlNode.Decode(&encoded)
+		if err != nil {
+			return result, fmt.Errorf("Error reading encrypted value at path %s: %w", path, err)
+		}
+		value := yaml.EncryptedValue(encoded)
+		tag, err := value.Tag()
+		if err != nil {
+			return result, fmt.Errorf("Error reading encrypted value at path %s: %w", path, err)
+		}
+		ciphertext, err := value.Ciphertext()
+		if err != nil {
+			return result, fmt.Errorf("Error reading encrypted value at path %s: %w", path, err)
+		}
+		info := ValueInfo{Path: path, Type: tag, Layout: value.Version(), CiphertextInfo: crypto.Inspect(provider, ciphertext)}
+		if info.Provider == "" && info.Marker == "" {
+			info.Provider, info.Algorithm = header.Provider, header.Algorithm
+		}
+		result.Values = append(result.Values, info)
+	}
+	return result, nil
+}
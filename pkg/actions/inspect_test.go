@@ -0,0 +1,69 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	_, key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var provider crypto.Provider = key
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("inspect.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("password: !secret hunter2\nport: !secret 5432\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Inspect(&file, provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.File != file.EncryptedPath || result.Format == 0 || len(result.Values) != 2 {
+		t.Fatalf("Inspecting the file gave %+v", result)
+	}
+	for i, expected := range []struct {
+		path, tag    string
+		layout, size int
+	}{
+		{"password", "!!str", 1, 7},
+		{"port", "!!int", 2, 4},
+	} {
+		value := result.Values[i]
+		if value.Path != expected.path || value.Type != expected.tag || value.Layout != expected.layout {
+			t.Errorf("Inspecting %s gave %+v", expected.path, value)
+		}
+		if value.Provider != "key" || value.Algorithm != "aes-256-gcm" || !value.Authenticated {
+			t.Errorf("Inspecting %s gave the wrong provider: %+v", expected.path, value)
+		}
+		if value.PlaintextSize != expected.size || value.Size != expected.size+28 {
+			t.Errorf("Inspecting %s gave sizes %d and %d, expected %d and %d", expected.path, value.Size, value.PlaintextSize, expected.size+28, expected.size)
+		}
+		if !reflect.DeepEqual(value.Recipients, key.Recipients()) {
+			t.Errorf("Inspecting %s gave recipients %v, expected %v", expected.path, value.Recipients, key.Recipients())
+		}
+	}
+
+	// with another provider configured, the header still tells which one the values are encrypted with
+	result, err = Inspect(&file, crypto.NoopProvider{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range result.Values {
+		if value.Provider != "key" || value.Algorithm != "aes-256-gcm" || value.Authenticated || value.PlaintextSize != -1 || len(value.Recipients) != 0 {
+			t.Errorf("Inspecting %s with another provider configured gave %+v", value.Path, value)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLineEndings(t *testing.T) {
+	for name, lineEnding := range map[string]string{"LF": yaml.LF, "CRLF": yaml.CRLF} {
+		t.Run(name, func(t *testing.T) {
+			var provider crypto.Provider = &testProvider{}
+			config, cache, cleanup := setupTestRepo(t, provider)
+			defer cleanup()
+			file, err := NewFile("endings.decrypted.yaml", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := strings.ReplaceAll("a: !secret one\nb:\n  c: |\n    multiple\n    lines\n  d: !secret two\n", "\n", lineEnding)
+			err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.ReplaceAll(strings.ReplaceAll(string(encrypted), yaml.CRLF, yaml.LF), yaml.LF, lineEnding) != string(encrypted) {
+				t.Errorf("Encrypted file does not use %s line endings: %q", name, encrypted)
+			}
+
+			err = os.Remove(file.DecryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != original {
+				t.Errorf("Round trip did not preserve %s line endings: %q", name, decrypted)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestManagedValues(t *testing.T) {
+	inner := &testProvider{}
+	var provider crypto.Provider = inner
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("managed.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret 1 # yamlcrypt:managed\nb: !secret 2\nc: !secret 3\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := encryptedValues(t, file.EncryptedPath)
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encrypted), "# yamlcrypt:managed") {
+		t.Fatalf("Managed marker was not kept in the encrypted file:\n%s", encrypted)
+	}
+
+	// another system marks c as managed, in the encrypted file
+	err = ioutil.WriteFile(file.EncryptedPath, []byte(strings.Replace(string(encrypted), "\nc: !encrypted "+lineValue(string(encrypted), "c"), "\nc: !encrypted "+lineValue(string(encrypted), "c")+" # yamlcrypt:managed", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret changed # yamlcrypt:managed\nb: !secret changed\nc: !secret changed\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := encryptedValues(t, file.EncryptedPath)
+	if after["a"] != before["a"] {
+		t.Errorf("Value marked as managed in the decrypted file was re-encrypted")
+	}
+	if after["c"] != before["c"] {
+		t.Errorf("Value marked as managed in the encrypted file was re-encrypted")
+	}
+	if after["b"] == before["b"] {
+		t.Errorf("Unmanaged value was not re-encrypted after changing")
+	}
+}
+
+// Get the rest of the line following "key: !encrypted " in a yaml document.
+func lineValue(document, key string) string {
+	for _, line := range strings.Split(document, "\n") {
+		if strings.HasPrefix(line, key+": !encrypted ") {
+			return strings.TrimPrefix(line, key+": !encrypted ")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Receives measurements of cache and provider use, for embedding yaml-crypt in a long-running service. Implementations must be safe for concurrent use.
+type MetricsCollector interface {
+	// Called on every cache lookup made before falling back to the provider.
+	CacheLookup(hit bool)
+	// Called after every call to the provider. The operation is "encrypt" or "decrypt".
+	ProviderCall(operation string, duration time.Duration, err error)
+}
+
+func (o *Options) reportCacheLookup(hit bool) {
+	if o.Metrics != nil {
+		o.Metrics.CacheLookup(hit)
+	}
+}
+
+func (o *Options) reportProviderCall(operation string, start time.Time, err error) {
+	if o.Metrics != nil {
+		o.Metrics.ProviderCall(operation, time.Since(start), err)
+	}
+}
+
+// A MetricsCollector that keeps running totals, which can be written out in the OpenMetrics text format.
+type Counters struct {
+	mutex           sync.Mutex
+	CacheHits       uint64
+	CacheMisses     uint64
+	ProviderCalls   map[string]uint64
+	ProviderErrors  map[string]uint64
+	ProviderSeconds map[string]float64
+}
+
+func (c *Counters) CacheLookup(hit bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if hit {
+		c.CacheHits++
+	} else {
+		c.CacheMisses++
+	}
+}
+
+func (c *Counters) ProviderCall(operation string, duration time.Duration, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ProviderCalls == nil {
+		c.ProviderCalls = map[string]uint64{}
+		c.ProviderErrors = map[string]uint64{}
+		c.ProviderSeconds = map[string]float64{}
+	}
+	c.ProviderCalls[operation]++
+	c.ProviderSeconds[operation] += duration.Seconds()
+	if err != nil {
+		c.ProviderErrors[operation]++
+	}
+}
+
+// Fraction of cache lookups that were hits, or zero if there haven't been any lookups.
+func (c *Counters) CacheHitRatio() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.CacheHits+c.CacheMisses == 0 {
+		return 0
+	}
+	return float64(c.CacheHits) / float64(c.CacheHits+c.CacheMisses)
+}
+
+// Write the totals out in the OpenMetrics text format, as served from a /metrics endpoint.
+func (c *Counters) WriteOpenMetrics(w io.Writer) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	lines := []string{
+		"# TYPE yamlcrypt_cache_lookups counter",
+		fmt.Sprintf("yamlcrypt_cache_lookups_total{result=\"hit\"} %d", c.CacheHits),
+		fmt.Sprintf("yamlcrypt_cache_lookups_total{result=\"miss\"} %d", c.CacheMisses),
+		"# TYPE yamlcrypt_provider_calls counter",
+	}
+	for _, operation := range []string{"encrypt", "decrypt"} {
+		lines = append(lines, fmt.Sprintf("yamlcrypt_provider_calls_total{operation=%q} %d", operation, c.ProviderCalls[operation]))
+	}
+	lines = append(lines, "# TYPE yamlcrypt_provider_errors counter")
+	for _, operation := range []string{"encrypt", "decrypt"} {
+		lines = append(lines, fmt.Sprintf("yamlcrypt_provider_errors_total{operation=%q} %d", operation, c.ProviderErrors[operation]))
+	}
+	lines = append(lines, "# TYPE yamlcrypt_provider_seconds counter", "# UNIT yamlcrypt_provider_seconds seconds")
+	for _, operation := range []string{"encrypt", "decrypt"} {
+		lines = append(lines, fmt.Sprintf("yamlcrypt_provider_seconds_total{operation=%q} %g", operation, c.ProviderSeconds[operation]))
+	}
+	lines = append(lines, "# EOF")
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
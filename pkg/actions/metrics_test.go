@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	opts := &Options{}
+	counters := &Counters{}
+	opts.Metrics = counters
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("metrics.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\nb: !secret two\nc: !secret one\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptCiphertext([]byte("invalid"), cache, &provider, opts)
+	if err == nil {
+		t.Fatal("Decrypting an invalid ciphertext did not fail")
+	}
+
+	// encrypting misses for both distinct values, decrypting hits for both, and the invalid ciphertext misses
+	if counters.CacheHits != 2 || counters.CacheMisses != 3 {
+		t.Errorf("Expected 2 cache hits and 3 misses, got %d and %d", counters.CacheHits, counters.CacheMisses)
+	}
+	if counters.ProviderCalls["encrypt"] != 2 || counters.ProviderCalls["decrypt"] != 1 {
+		t.Errorf("Expected 2 encrypt and 1 decrypt calls, got %v", counters.ProviderCalls)
+	}
+	if counters.ProviderErrors["encrypt"] != 0 || counters.ProviderErrors["decrypt"] != 1 {
+		t.Errorf("Expected 1 decrypt error, got %v", counters.ProviderErrors)
+	}
+	if ratio := counters.CacheHitRatio(); ratio != 0.4 {
+		t.Errorf("Expected a cache hit ratio of 0.4, got %g", ratio)
+	}
+
+	var out bytes.Buffer
+	err = counters.WriteOpenMetrics(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"yamlcrypt_cache_lookups_total{result=\"hit\"} 2\n",
+		"yamlcrypt_provider_calls_total{operation=\"encrypt\"} 2\n",
+		"yamlcrypt_provider_errors_total{operation=\"decrypt\"} 1\n",
+		"# EOF\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Metrics output is missing %q:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNoCache(t *testing.T) {
+	provider := &testProvider{}
+	var p crypto.Provider = provider
+	config, persistent, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("fresh.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\nb: !secret two\nc: [!secret three]\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, persistent, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the persistent cache, everything is already cached
+	provider.decryptCalls = 0
+	err = Decrypt([]*File{&file}, false, false, persistent, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.decryptCalls != 0 {
+		t.Errorf("Decrypting with the persistent cache called the provider %d times", provider.decryptCalls)
+	}
+
+	// bypassing it, as --no-cache does, goes through the provider for every value
+	uncachedConfig := *config
+	uncachedConfig.CacheEnabled = false
+	uncached, err := cache.Setup(uncachedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uncached.Close()
+	err = Decrypt([]*File{&file}, false, false, uncached, &p, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.decryptCalls != 3 {
+		t.Errorf("Decrypting without the cache called the provider %d times, expected once per value", provider.decryptCalls)
+	}
+}
@@ -0,0 +1,95 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNullValues(t *testing.T) {
+	opts := &Options{}
+	opts.VerifyOutput = true
+	provider := &testProvider{}
+	var p crypto.Provider = provider
+	config, cache, cleanup := setupTestRepo(t, p)
+	defer cleanup()
+	file, err := NewFile("null.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "bare: !secret\nnull: !secret null\ntilde: !secret ~\nempty: !secret \"\"\nblock: !secret |\nquoted: !secret \"null\"\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &p, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nulls have nothing to encrypt, but empty strings are encrypted like any other value
+	if provider.encryptCalls != 2 {
+		t.Errorf("Expected the empty string and the string \"null\" to be sent to the provider, got %d values", provider.encryptCalls)
+	}
+	encrypted, err := yaml.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{}
+	for n := range yaml.GetTaggedChildren(&encrypted, yaml.EncryptedTag) {
+		value := yaml.EncryptedValue(n.YamlNode.Value)
+		tags[n.Path.Dotted()], err = value.Tag()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ciphertext, _ := value.Ciphertext(); (len(ciphertext) == 0) != (tags[n.Path.Dotted()] == "!!null") {
+			t.Errorf("Unexpected ciphertext for value at %s: %q", n.Path.Dotted(), ciphertext)
+		}
+	}
+	expectedTags := map[string]string{"bare": "!!null", "null": "!!null", "tilde": "!!null", "empty": "!!str", "block": "!!str", "quoted": "!!str"}
+	for path, tag := range expectedTags {
+		if tags[path] != tag {
+			t.Errorf("Encrypted value at %s has tag %s, expected %s", path, tags[path], tag)
+		}
+	}
+
+	// nulls decrypt to null, and empty strings, including empty block scalars, to empty strings
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DecryptBoth([]*File{&file}, cache, &p, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		file.DecryptedPath: "bare: !secret null\nnull: !secret null\ntilde: !secret null\nempty: !secret \"\"\nblock: !secret \"\"\nquoted: !secret \"null\"\n",
+		file.PlainPath:     "bare: null\nnull: null\ntilde: null\nempty: \"\"\nblock: \"\"\nquoted: \"null\"\n",
+	} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("Decrypting to %s gave:\n%s\nexpected:\n%s", path, data, expected)
+		}
+	}
+
+	// and re-encrypting what was decrypted changes nothing
+	before, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &p, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("Re-encrypting changed the encrypted version:\n%s\nexpected:\n%s", after, before)
+	}
+}
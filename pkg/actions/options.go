@@ -0,0 +1,107 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"path/filepath"
+	"sync"
+)
+
+// Settings that change how files are encrypted and decrypted, usually read from a repo's config by NewOptions. Passing nil, or leaving a field at its zero value, means the default.
+type Options struct {
+	// Largest plaintext value, in bytes, that will be encrypted. Guards against accidentally encrypting huge blobs. Zero means config.DefaultMaxValueSize.
+	MaxValueSize int64
+	// Number of files to read and write in parallel. Independent of the number of values encrypted or decrypted in parallel. Zero means config.DefaultFileThreads.
+	FileThreads int
+	// Dotted path patterns of values to encrypt, even if they aren't tagged.
+	EncryptPaths []string
+	// Dotted path patterns of values to leave unencrypted, even if they match EncryptPaths or the schema file, or EncryptAll is set, eg. public keys. Values tagged !secret are still encrypted.
+	PlaintextPaths []string
+	// If set, every value is encrypted, except for those matching PlaintextPaths, rather than only those that are tagged or match EncryptPaths or the schema file.
+	EncryptAll bool
+	// Path of a schema file listing the paths of values to encrypt, alongside EncryptPaths. Lets one list of secrets apply across many files, eg. one per environment, without tagging each of them. Empty means there's no schema.
+	SchemaFile string
+	// Values to encrypt with one of the named providers of a crypto.Router, rather than the default provider.
+	ProviderPaths []config.ProviderPath
+	// If set, every value is encrypted with the provider given, ignoring ProviderPaths and the recipients that files declare, eg. to encrypt a copy of a file with a one-off key.
+	SingleProvider bool
+	// Style that written files are forced into, one of yaml.BlockStyle or yaml.FlowStyle. Empty means keep the style of the file they were written from.
+	OutputStyle string
+	// Whether decrypted files are written with the keys of every mapping sorted, for canonical output.
+	SortKeys bool
+	// Whether decrypted files are checked to read back with the same values before they're written, rather than risking writing out a value that yaml can't represent.
+	VerifyOutput bool
+	// Whether reading a yaml document with the same key twice in a mapping fails, rather than keeping both.
+	StrictKeys bool
+	// If set, Encrypt fails rather than warning when the secrets in a decrypted file differ from those in its encrypted version.
+	StrictPaths bool
+	// What Encrypt does with secrets that were removed from a decrypted file. One of the config.RemovedSecrets constants; empty means config.RemovedSecretsDrop.
+	RemovedSecrets string
+	// What happens when the provider decrypts a ciphertext to an empty plaintext. One of the config.EmptyPlaintexts constants; empty means config.EmptyPlaintextsAllow.
+	EmptyPlaintexts string
+	// Identity recorded as having last changed each secret whose value changes when encrypting, eg. "alice@example.com". Empty means nothing new is recorded.
+	RotatedBy string
+	// Path of a file that a record of every file decrypted is appended to, one line of JSON each, eg. to keep for compliance. Empty disables the audit log.
+	AuditLog string
+	// Root of the repo whose .gitignore the decrypted and plain files written by Decrypt are added to, unless git already ignores them, so that they can't be committed by accident. Empty means .gitignore is left alone.
+	GitignoreRoot string
+	// Path of the manifest that encrypting or decrypting records completed files in, so that an interrupted run can be resumed by running it again, skipping the files it completed. Empty means runs aren't resumable.
+	ResumeManifest string
+	// Tag that encrypted files mark their encrypted values with, in place of yaml.EncryptedTag, eg. "!vault". See yaml.CheckEncryptedTag. Empty means yaml.EncryptedTag.
+	EncryptedTag string
+	// Where measurements of cache and provider use are reported. Nil disables metrics.
+	Metrics MetricsCollector
+	// Transforms run, in order, on every document decrypted, before it's written. DecryptStream doesn't support them, since it never reads a document as a whole.
+	DecryptTransforms []DecryptTransform
+	// Refreshers of the providers' credentials, by provider, shared by every call to a provider in a run. Set by withDefaults, so that each run can refresh rejected credentials, even if refreshing didn't help the last one, eg. in watch mode, once they've been renewed in the meantime.
+	refreshers *sync.Map
+}
+
+// Get the options set by a repo's config. Options that aren't part of the config, eg. ResumeManifest or RotatedBy, are left for the caller to set.
+func NewOptions(c *config.Config) *Options {
+	o := Options{
+		MaxValueSize:    c.MaxValueSize,
+		FileThreads:     int(c.FileThreads),
+		EncryptPaths:    c.EncryptPaths,
+		PlaintextPaths:  c.PlaintextPaths,
+		EncryptAll:      c.EncryptAll,
+		ProviderPaths:   c.ProviderPaths,
+		StrictKeys:      c.StrictKeys,
+		RemovedSecrets:  c.RemovedSecrets,
+		EmptyPlaintexts: c.EmptyPlaintexts,
+		EncryptedTag:    c.EncryptedTag,
+	}
+	if c.SchemaFile != "" {
+		o.SchemaFile = filepath.Join(c.Root, c.SchemaFile)
+	}
+	if c.AuditLog != "" {
+		o.AuditLog = filepath.Join(c.Root, c.AuditLog)
+	}
+	if c.GitignoreDecrypted {
+		o.GitignoreRoot = c.Root
+	}
+	return &o
+}
+
+// Get a copy of the options with the defaults filled in, so that nil can be passed for the defaults.
+func (o *Options) withDefaults() *Options {
+	out := Options{}
+	if o != nil {
+		out = *o
+	}
+	if out.MaxValueSize <= 0 {
+		out.MaxValueSize = config.DefaultMaxValueSize
+	}
+	if out.FileThreads <= 0 {
+		out.FileThreads = config.DefaultFileThreads
+	}
+	if out.RemovedSecrets == "" {
+		out.RemovedSecrets = config.RemovedSecretsDrop
+	}
+	if out.EmptyPlaintexts == "" {
+		out.EmptyPlaintexts = config.EmptyPlaintextsAllow
+	}
+	if out.refreshers == nil {
+		out.refreshers = &sync.Map{}
+	}
+	return &out
+}
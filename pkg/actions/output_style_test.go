@@ -0,0 +1,54 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOutputStyle(t *testing.T) {
+	opts := &Options{}
+	input := "a: {b: !secret one, c: [1, 2]}\nd:\n  - e: !secret two\n  - [3, {f: 4}]\n"
+	expected := map[string]string{
+		"":              input,
+		yaml.BlockStyle: "a:\n  b: !secret one\n  c:\n    - 1\n    - 2\nd:\n  - e: !secret two\n  - - 3\n    - f: 4\n",
+		yaml.FlowStyle:  "{a: {b: !secret one, c: [1, 2]}, d: [{e: !secret two}, [3, {f: 4}]]}\n",
+	}
+	for style, output := range expected {
+		t.Run(style, func(t *testing.T) {
+			var provider crypto.Provider = &testProvider{}
+			config, cache, cleanup := setupTestRepo(t, provider)
+			defer cleanup()
+			file, err := NewFile("style.decrypted.yaml", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(file.DecryptedPath, []byte(input), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.OutputStyle = style
+			err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Remove(file.DecryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != output {
+				t.Errorf("Decrypted file in %q style is incorrect:\n%s\nExpected:\n%s", style, decrypted, output)
+			}
+		})
+	}
+}
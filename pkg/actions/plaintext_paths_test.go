@@ -0,0 +1,119 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPlaintextPaths(t *testing.T) {
+	opts := &Options{}
+	opts.EncryptPaths = []string{"tls.*", "*.*.token"}
+	opts.PlaintextPaths = []string{"tls.publicKey", "services.*.token"}
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("plaintext.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "tls:\n  privateKey: private\n  publicKey: public\n  caKey: !secret tagged\nservices:\n  api:\n    token: api token\nclients:\n  web:\n    token: web token\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := encryptedValues(t, file.EncryptedPath)
+	// values tagged !secret are encrypted whatever their path
+	for _, path := range []string{"tls.privateKey", "tls.caKey", "clients.web.token"} {
+		if _, ok := values[path]; !ok {
+			t.Errorf("Value at %s was not encrypted", path)
+		}
+	}
+	for _, path := range []string{"tls.publicKey", "services.api.token"} {
+		if _, ok := values[path]; ok {
+			t.Errorf("Value at %s was encrypted, despite matching plaintextPaths", path)
+		}
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encrypted), "publicKey: public\n") {
+		t.Errorf("Expected the public key to be left as it was:\n%s", encrypted)
+	}
+
+	// values left unencrypted on purpose don't fail the check for unencrypted secrets
+	err = CheckEncrypted([]*File{&file}, regexp.MustCompile(`(?i)key|token`), opts)
+	if err != nil {
+		t.Errorf("Expected values in plaintextPaths to pass the check, got %s", err)
+	}
+	opts.PlaintextPaths = []string{"tls.publicKey"}
+	err = CheckEncrypted([]*File{&file}, regexp.MustCompile(`(?i)key|token`), opts)
+	if err == nil || !strings.Contains(err.Error(), "services.api.token") || strings.Contains(err.Error(), "publicKey") {
+		t.Errorf("Expected only the value no longer in plaintextPaths to fail the check, got %v", err)
+	}
+}
+
+func TestEncryptAll(t *testing.T) {
+	opts := &Options{}
+	opts.EncryptAll = true
+	opts.PlaintextPaths = []string{"tls.publicKey", "replicas", "hosts.*"}
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("all.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "tls:\n  privateKey: private\n  publicKey: public\nreplicas: 3\nhosts:\n- a.example.com\n- b.example.com\ndb:\n  user: app\n  password: !secret hunter2\n  port: 5432\n  options:\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := encryptedValues(t, file.EncryptedPath)
+	for _, path := range []string{"tls.privateKey", "db.user", "db.password", "db.port"} {
+		if _, ok := values[path]; !ok {
+			t.Errorf("Value at %s was not encrypted", path)
+		}
+	}
+	// excluded values, and null values, are left as they are
+	for _, path := range []string{"tls.publicKey", "replicas", "hosts.0", "hosts.1", "db.options"} {
+		if _, ok := values[path]; ok {
+			t.Errorf("Value at %s was encrypted, despite being excluded", path)
+		}
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"publicKey: public\n", "replicas: 3\n", "- a.example.com\n", "options:\n"} {
+		if !strings.Contains(string(encrypted), expected) {
+			t.Errorf("Expected %q to be left as it was:\n%s", expected, encrypted)
+		}
+	}
+
+	// the decrypted version is the same, with every encrypted value tagged
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"privateKey: !secret private\n", "user: !secret app\n", "port: !secret 5432\n", "publicKey: public\n"} {
+		if !strings.Contains(string(decrypted), expected) {
+			t.Errorf("Expected %q in the decrypted version:\n%s", expected, decrypted)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"sort"
+)
+
+// Which keys decrypting a file needs, and which of those aren't available.
+type KeyCheck struct {
+	File string `json:"file"`
+	// Keys the file's values are encrypted to, as in Recipients.
+	Required []string `json:"required"`
+	// Required keys that the provider can't decrypt with. The file can be decrypted if there are none.
+	Missing []string `json:"missing"`
+}
+
+// Work out, without decrypting anything, whether each file's encrypted version can be decrypted with the keys available to the provider. The keys a value needs are the recipients of the provider its ciphertext is marked with; a value marked with a provider that isn't configured needs a key that's never available.
+func CheckKeys(files []*File, provider crypto.Provider, opts *Options) ([]KeyCheck, error) {
+	opts = opts.withDefaults()
+	available := map[string]bool{}
+	for _, key := range provider.AvailableKeys() {
+		available[key] = true
+	}
+	checks := make([]KeyCheck, len(files))
+	for i, file := range files {
+		checks[i] = KeyCheck{File: file.EncryptedPath, Required: []string{}, Missing: []string{}}
+		node, err := opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return checks, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		required := map[string]bool{}
+		for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+			// keep draining the iterator after an error
+			if err != nil {
+				continue
+			}
+			var ciphertext string
+			ciphertext, err = yaml.GetValue(n.YamlNode)
+			// empty values are never encrypted, so they don't need a key
+			if err != nil || ciphertext == "" {
+				continue
+			}
+			name := crypto.Marker([]byte(ciphertext))
+			p := namedProvider(provider, name)
+			if p == nil {
+				required["provider:"+name] = true
+				continue
+			}
+			for _, key := range p.Recipients() {
+				required[key] = true
+			}
+		}
+		if err != nil {
+			return checks, fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
+		}
+		for key := range required {
+			checks[i].Required = append(checks[i].Required, key)
+			if !available[key] {
+				checks[i].Missing = append(checks[i].Missing, key)
+			}
+		}
+		sort.Strings(checks[i].Required)
+		sort.Strings(checks[i].Missing)
+	}
+	return checks, nil
+}
@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// A testProvider with a given key, which may or may not be available.
+type keyedTestProvider struct {
+	*testProvider
+	key       string
+	available bool
+}
+
+func (p keyedTestProvider) Recipients() []string {
+	return []string{p.key}
+}
+
+func (p keyedTestProvider) AvailableKeys() []string {
+	if !p.available {
+		return []string{}
+	}
+	return p.Recipients()
+}
+
+func TestCheckKeys(t *testing.T) {
+	opts := &Options{}
+	opts.ProviderPaths = []config.ProviderPath{{Path: "ops.*", Provider: "ops"}, {Path: "team.*", Provider: "team"}}
+	var provider crypto.Provider = crypto.Router{
+		Default: keyedTestProvider{&testProvider{}, "dev-key", true},
+		Named: map[string]crypto.Provider{
+			"ops":  keyedTestProvider{&testProvider{}, "ops-key", false},
+			"team": keyedTestProvider{&testProvider{}, "team-key", true},
+		},
+	}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	contents := map[string]string{
+		"app":   "password: !secret a\nteam:\n  token: !secret b\n  empty: !secret ''\n",
+		"infra": "password: !secret c\nops:\n  token: !secret d\n",
+		"plain": "replicas: 3\n",
+	}
+	files := []*File{}
+	for _, name := range []string{"app", "infra", "plain"} {
+		file, err := NewFile(name+".decrypted.yaml", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(contents[name]), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+	}
+	err := Encrypt(files, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks, err := CheckKeys(files, provider, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []KeyCheck{
+		{File: files[0].EncryptedPath, Required: []string{"dev-key", "team-key"}, Missing: []string{}},
+		{File: files[1].EncryptedPath, Required: []string{"dev-key", "ops-key"}, Missing: []string{"ops-key"}},
+		{File: files[2].EncryptedPath, Required: []string{}, Missing: []string{}},
+	}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Errorf("Expected key checks %+v, got %+v", expected, checks)
+	}
+
+	// values from a provider that's no longer configured can't be decrypted at all
+	router := provider.(crypto.Router)
+	delete(router.Named, "team")
+	checks, err = CheckKeys(files[:1], router, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(checks[0].Missing, []string{"provider:team"}) {
+		t.Errorf("Expected the unconfigured provider to be missing, got %v", checks[0].Missing)
+	}
+}
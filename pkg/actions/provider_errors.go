@@ -0,0 +1,58 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// An error encrypting or decrypting a value, remembering the value, so that where it came from can be reported. Values are deduplicated across files before they're handed to the provider, so the provider's error alone doesn't say which file or path to look at.
+type valueError struct {
+	value string
+	err   error
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *valueError) Unwrap() error {
+	return e.err
+}
+
+// Wrap an error encrypting or decrypting a value in a valueError, unless it's about the provider's credentials being rejected, which has nothing to do with the value, and is reported as it is.
+func newValueError(value string, err error) error {
+	if errors.Is(err, crypto.ErrCredentialsRejected) {
+		return err
+	}
+	return &valueError{value, err}
+}
+
+// Add where the value an error is about came from: the first value tagged with tag, in the given documents, whose value matches it. Each document is read from the file at the same index of paths, and can be nil. Errors that aren't about a value, or whose value can't be found, are returned as they are.
+func locateValue(err error, tag string, paths []string, nodes []*yamlv3.Node) error {
+	var valueErr *valueError
+	if !errors.As(err, &valueErr) {
+		return err
+	}
+	for i, node := range nodes {
+		if node == nil {
+			continue
+		}
+		found := ""
+		for n := range yaml.GetTaggedChildren(node, tag) {
+			// keep draining the iterator once found
+			if found != "" {
+				continue
+			}
+			if value, getErr := yaml.GetValue(n.YamlNode); getErr == nil && value == valueErr.value {
+				found = n.Path.Dotted()
+			}
+		}
+		if found != "" {
+			return fmt.Errorf("Error at path %s in file %s: %w", found, paths[i], err)
+		}
+	}
+	return err
+}
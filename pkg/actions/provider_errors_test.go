@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProviderErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	var provider crypto.Provider = &testProvider{}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	files := []*File{}
+	for name, content := range map[string]string{
+		"app.decrypted.yaml": "user: !secret app\n",
+		"db.decrypted.yaml":  "db:\n  user: !secret app\n  password: !secret hunter2\n",
+	} {
+		file, err := NewFile(name, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+	}
+	err := Encrypt(files, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.Close()
+
+	// a helper that understands the test provider's ciphertexts, but has no key for one of the values, and says so on stderr
+	var failing crypto.Provider = crypto.ExecProvider{Command: []string{"sh", "-c", `
+		input=$(cat)
+		case "$input" in
+		*hunt*) echo "gpg: $1 failed: No secret key" >&2; exit 2 ;;
+		*) if [ "$1" = encrypt ]; then printf '1:%s' "$input"; else printf %s "${input#*:}"; fi ;;
+		esac
+	`, "sh"}}
+	// with no cache, every value goes through the helper
+	c.CacheEnabled = false
+	empty, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	err = Decrypt(files, false, false, empty, &failing, 4, false, nil)
+	if err == nil {
+		t.Fatal("Expected decrypting with a failing helper to fail")
+	}
+	for _, expected := range []string{"gpg: decrypt failed: No secret key", "db.password", "db.encrypted.yaml"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in the error decrypting, got: %s", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("The error decrypting gave away the plaintext: %s", err)
+	}
+
+	// the same goes for encrypting a new value
+	file, err := NewFile("new.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("token: !secret fine\nlist:\n- !secret hunter2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, empty, &failing, 4, false, nil)
+	if err == nil {
+		t.Fatal("Expected encrypting with a failing helper to fail")
+	}
+	for _, expected := range []string{"gpg: encrypt failed: No secret key", "list.0", "new.decrypted.yaml"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in the error encrypting, got: %s", expected, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProviderPaths(t *testing.T) {
+	opts := &Options{}
+	opts.ProviderPaths = []config.ProviderPath{{Path: "prod.*", Provider: "prod"}}
+	dev := &testProvider{}
+	prod := &testProvider{}
+	var provider crypto.Provider = crypto.Router{Default: dev, Named: map[string]crypto.Provider{"prod": prod}}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("providers.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "dev:\n  password: !secret same\nprod:\n  password: !secret same\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, ca, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.encryptCalls != 1 || prod.encryptCalls != 1 {
+		t.Errorf("Expected each provider to encrypt once, got %d and %d", dev.encryptCalls, prod.encryptCalls)
+	}
+	values := encryptedValues(t, file.EncryptedPath)
+	if !strings.HasPrefix(values["prod.password"], "yamlcrypt:prod:") {
+		t.Errorf("Value at prod.password was not encrypted with the prod provider: %q", values["prod.password"])
+	}
+	if crypto.Marker([]byte(values["dev.password"])) != "" {
+		t.Errorf("Value at dev.password was not encrypted with the default provider: %q", values["dev.password"])
+	}
+
+	// re-encrypting reuses both ciphertexts
+	err = Encrypt([]*File{&file}, ca, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.encryptCalls != 1 || prod.encryptCalls != 1 {
+		t.Errorf("Re-encrypting called the providers again: %d and %d", dev.encryptCalls, prod.encryptCalls)
+	}
+
+	// decrypting with an empty cache goes to the right provider for each value
+	c.CacheEnabled = false
+	emptyCache, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emptyCache.Close()
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, emptyCache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.decryptCalls != 1 || prod.decryptCalls != 1 {
+		t.Errorf("Expected each provider to decrypt once, got %d and %d", dev.decryptCalls, prod.decryptCalls)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != original {
+		t.Errorf("Round-trip changed the file:\n%s", decrypted)
+	}
+}
@@ -0,0 +1,85 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQuotedPaths(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("labels.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a key with dots, alongside nested keys that the same path would address if it were split on every dot
+	content := "metadata:\n  labels:\n    app.kubernetes.io/name: web\n    app:\n      kubernetes:\n        io/name: decoy\n  \"*\": star\n  other: value\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.EncryptPaths = []string{`metadata.labels."app.kubernetes.io/name"`, `metadata."*"`}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := yaml.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := yaml.GetTaggedChildrenPaths(&node, yaml.EncryptedTag)
+	expected := []string{`metadata.labels."app.kubernetes.io/name"`, `metadata."*"`}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Encrypted paths %q, expected %q", paths, expected)
+	}
+
+	// reported paths address the same values
+	for path, value := range map[string]string{expected[0]: "web", expected[1]: "star"} {
+		outPath := filepath.Join(config.Root, "out")
+		err = Extract(&file, path, outPath, false, cache, &provider, opts)
+		if err != nil {
+			t.Fatalf("Extracting %s failed: %s", path, err)
+		}
+		extracted, err := ioutil.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(extracted) != value {
+			t.Errorf("Extracting %s gave %q, expected %q", path, extracted, value)
+		}
+	}
+
+	// without quotes, every dot separates segments
+	decoy, err := yaml.GetNodeAtPath(&node, "metadata.labels.app.kubernetes.io/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoy.Value != "decoy" {
+		t.Errorf("Unquoted path addressed %q, expected the nested decoy", decoy.Value)
+	}
+
+	for _, c := range []struct {
+		pattern, path string
+		match         bool
+	}{
+		{`metadata.*`, `metadata.other`, true},
+		{`metadata.*`, `metadata."app.kubernetes.io/name"`, true},
+		{`metadata."*"`, `metadata.other`, false},
+		{`metadata."*"`, `metadata."*"`, true},
+		{`a."b.c"`, `a.b.c`, false},
+		{`a."b`, `a."b`, false},
+	} {
+		if yaml.MatchPath(c.pattern, c.path) != c.match {
+			t.Errorf("Matching %s against pattern %s gave %t", c.path, c.pattern, !c.match)
+		}
+	}
+	if _, err := yaml.SplitPath(`a."b"c`); err == nil {
+		t.Error("A quoted segment followed by more than a dot was accepted")
+	}
+}
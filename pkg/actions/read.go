@@ -0,0 +1,32 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+)
+
+// Read a yaml file, checking for duplicate keys if StrictKeys is set. Values tagged with EncryptedTag are read as yaml.EncryptedTag.
+func (o *Options) readFile(path string) (yamlv3.Node, error) {
+	node, err := yaml.ReadFile(path)
+	if err == nil && o.StrictKeys {
+		err = yaml.CheckDuplicateKeys(&node)
+	}
+	yaml.SwapTags(&node, yaml.EncryptedTag, o.EncryptedTag)
+	return node, err
+}
+
+// Read a yaml document from a Reader, as readFile does.
+func (o *Options) read(r io.Reader) (yamlv3.Node, error) {
+	node, err := yaml.Read(r)
+	if err == nil && o.StrictKeys {
+		err = yaml.CheckDuplicateKeys(&node)
+	}
+	yaml.SwapTags(&node, yaml.EncryptedTag, o.EncryptedTag)
+	return node, err
+}
+
+// Get the options to write a yaml document with, given its line ending.
+func (o *Options) saveOptions(lineEnding string) yaml.SaveOptions {
+	return yaml.SaveOptions{LineEnding: lineEnding, Style: o.OutputStyle, EncryptedTag: o.EncryptedTag}
+}
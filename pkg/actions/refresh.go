@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"sync"
+	"sync/atomic"
+)
+
+// Tracks refreshing a provider's credentials when they're rejected partway through a run, eg. a short-lived cloud token in a long CI job, so that however many workers run into rejected credentials at once, they're only refreshed once between them.
+type refresher struct {
+	// Bumped by every refresh, so that a worker can tell whether the credentials it failed with have been refreshed since. Read atomically, so that calls which succeed never wait on the mutex.
+	generation uint64
+	// Whether no call to the provider has succeeded since the last refresh, as 0 or 1. Credentials aren't refreshed again until one has, so that credentials which refreshing doesn't fix are only refreshed once, rather than once per value. Read atomically, as generation is.
+	stuck int32
+	mutex sync.Mutex
+	err   error
+}
+
+// Get the provider's refresher for this run. Refreshers are kept by the keys a provider encrypts to, so that copies of a provider bound to other contexts, which share its credentials, share its refresher too, while other providers' credentials are refreshed on their own.
+func (o *Options) refresherFor(provider crypto.Provider) *refresher {
+	key := crypto.Fingerprint("", provider)
+	if r, ok := o.refreshers.Load(key); ok {
+		return r.(*refresher)
+	}
+	r, _ := o.refreshers.LoadOrStore(key, &refresher{})
+	return r.(*refresher)
+}
+
+// Call the provider, through the given function. If the call fails since the provider's credentials were rejected, they're refreshed, if the provider is able to, and the call is tried once more; if that fails too, or they can't be refreshed, the error says the credentials were rejected, rather than being about the value.
+func (o *Options) withRefresh(provider crypto.Provider, call func() error) error {
+	r := o.refresherFor(provider)
+	generation := atomic.LoadUint64(&r.generation)
+	err := call()
+	if !errors.Is(err, crypto.ErrCredentialsRejected) {
+		r.noteCall(err)
+		return err
+	}
+	refreshed, refreshErr := r.refresh(provider, generation)
+	if refreshErr != nil {
+		return fmt.Errorf("Provider credentials were rejected, and refreshing them failed (%s); renew them and run again: %w", refreshErr, err)
+	}
+	if refreshed {
+		err = call()
+		r.noteCall(err)
+	}
+	if errors.Is(err, crypto.ErrCredentialsRejected) {
+		return fmt.Errorf("Provider credentials were rejected; renew them and run again: %w", err)
+	}
+	return err
+}
+
+// Refresh the provider's credentials, unless they've already been refreshed since the given generation, or refreshing them last time didn't help. Returns whether the call that failed should be tried again.
+func (r *refresher) refresh(provider crypto.Provider, generation uint64) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if atomic.LoadUint64(&r.generation) != generation {
+		return r.err == nil, r.err
+	}
+	if atomic.LoadInt32(&r.stuck) != 0 {
+		return false, nil
+	}
+	ok, err := crypto.Refresh(provider)
+	if !ok {
+		return false, nil
+	}
+	r.err = err
+	atomic.StoreInt32(&r.stuck, 1)
+	atomic.AddUint64(&r.generation, 1)
+	return err == nil, err
+}
+
+// Note that a call to the provider succeeded, so that its credentials can be refreshed again the next time they're rejected.
+func (r *refresher) noteCall(err error) {
+	if err == nil && atomic.LoadInt32(&r.stuck) != 0 {
+		atomic.StoreInt32(&r.stuck, 0)
+	}
+}
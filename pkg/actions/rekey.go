@@ -0,0 +1,90 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+)
+
+// What encrypting a file would re-encrypt to different recipients, after Options.ProviderPaths or the providers they name have changed.
+type RekeyPlan struct {
+	File string `json:"file"`
+	// Values that would be re-encrypted, since the provider they're mapped to has a different fingerprint from the one they were encrypted by.
+	Rekeyed []RekeyChange `json:"rekeyed"`
+	// Paths of values that are already encrypted by the provider they're mapped to, and would be left alone.
+	Unchanged []string `json:"unchanged"`
+}
+
+// A single value that would be re-encrypted. Providers are named as in Options.ProviderPaths, with an empty name for the default provider.
+type RekeyChange struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Work out which values in each file's encrypted version would be re-encrypted to different recipients the next time it's encrypted, without decrypting or writing anything. A value is re-encrypted if the fingerprint of the provider its ciphertext is marked with differs from that of the provider it would be encrypted with, ie. the recipients the file declares, or the provider its path is mapped to.
+func PlanRekey(files []*File, provider crypto.Provider, opts *Options) ([]RekeyPlan, error) {
+	opts = opts.withDefaults()
+	plans := make([]RekeyPlan, len(files))
+	fingerprints := map[string]string{}
+	fingerprint := func(name string) string {
+		if _, ok := fingerprints[name]; !ok {
+			fingerprints[name] = crypto.Fingerprint(name, namedProvider(provider, name))
+		}
+		return fingerprints[name]
+	}
+	for i, file := range files {
+		plans[i] = RekeyPlan{File: file.EncryptedPath, Rekeyed: []RekeyChange{}, Unchanged: []string{}}
+		node, err := opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return plans, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		recipients, err := opts.declaredRecipients(&node, provider)
+		if err != nil {
+			return plans, fmt.Errorf("Error planning rekey of file %s: %w", file.EncryptedPath, err)
+		}
+		for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+			// keep draining the iterator after an error
+			if err != nil {
+				continue
+			}
+			var ciphertext string
+			ciphertext, err = yaml.GetValue(n.YamlNode)
+			if err != nil {
+				continue
+			}
+			path := n.Path.Dotted()
+			// nulls aren't encrypted, so they're never re-encrypted either
+			if ciphertext == "" {
+				plans[i].Unchanged = append(plans[i].Unchanged, path)
+				continue
+			}
+			from := crypto.Marker([]byte(ciphertext))
+			to := opts.providerIn(recipients, path)
+			if fingerprint(from) == fingerprint(to) {
+				plans[i].Unchanged = append(plans[i].Unchanged, path)
+			} else {
+				plans[i].Rekeyed = append(plans[i].Rekeyed, RekeyChange{Path: path, From: from, To: to})
+			}
+		}
+		if err != nil {
+			return plans, fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
+		}
+	}
+	return plans, nil
+}
+
+// Get the provider with the given name, without the marking that ForName adds: the default provider for an empty name, or nil if there's no such provider.
+func namedProvider(provider crypto.Provider, name string) crypto.Provider {
+	router, ok := provider.(crypto.Router)
+	if !ok {
+		if name == "" {
+			return provider
+		}
+		return nil
+	}
+	if name == "" {
+		return router.Default
+	}
+	return router.Named[name]
+}
@@ -0,0 +1,84 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestPlanRekey(t *testing.T) {
+	opts := &Options{}
+	opts.ProviderPaths = []config.ProviderPath{{Path: "prod.*", Provider: "prod"}}
+	dev := &testProvider{}
+	prod := &testProvider{}
+	var provider crypto.Provider = crypto.Router{Default: dev, Named: map[string]crypto.Provider{"prod": prod}}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("rekey.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("dev:\n  password: !secret a\nprod:\n  password: !secret b\n  unset: !secret ~\nshared:\n  token: !secret c\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plans, err := PlanRekey([]*File{&file}, provider, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 || len(plans[0].Rekeyed) != 0 || len(plans[0].Unchanged) != 4 {
+		t.Errorf("Expected nothing to be rekeyed before the provider paths change, got %+v", plans)
+	}
+
+	// move prod's values back to the default provider, and shared's to prod
+	opts.ProviderPaths = []config.ProviderPath{{Path: "shared.*", Provider: "prod"}}
+	data, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, err = PlanRekey([]*File{&file}, provider, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RekeyChange{
+		{Path: "prod.password", From: "prod", To: ""},
+		{Path: "shared.token", From: "", To: "prod"},
+	}
+	if !reflect.DeepEqual(plans[0].Rekeyed, expected) {
+		t.Errorf("Expected rekeyed values %+v, got %+v", expected, plans[0].Rekeyed)
+	}
+	if !reflect.DeepEqual(plans[0].Unchanged, []string{"dev.password", "prod.unset"}) {
+		t.Errorf("Expected dev.password and prod.unset to be left alone, got %v", plans[0].Unchanged)
+	}
+
+	// planning doesn't write anything, and encrypting does what was planned
+	after, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(data) {
+		t.Errorf("Planning changed the encrypted file")
+	}
+	devCalls, prodCalls := dev.encryptCalls, prod.encryptCalls
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.encryptCalls != devCalls+1 || prod.encryptCalls != prodCalls+1 {
+		t.Errorf("Expected each provider to encrypt one value, got %d and %d", dev.encryptCalls-devCalls, prod.encryptCalls-prodCalls)
+	}
+	plans, err = PlanRekey([]*File{&file}, provider, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans[0].Rekeyed) != 0 {
+		t.Errorf("Expected nothing left to rekey after encrypting, got %+v", plans[0].Rekeyed)
+	}
+}
@@ -0,0 +1,74 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Render a text/template using the decrypted contents of a file as its data, eg. "{{ .db.password }}". Referencing a key that isn't in the file is an error, rather than rendering as "<no value>".
+func Render(templatePath string, file *File, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) (string, error) {
+	opts = opts.withDefaults()
+	if err := checkCanDecrypt(provider); err != nil {
+		return "", err
+	}
+	var out string
+	err := forEachContext([]*File{file}, cache, provider, func(_ []*File, provider *crypto.Provider) error {
+		var err error
+		out, err = render(templatePath, file, cache, provider, threads, opts)
+		return err
+	})
+	return out, err
+}
+
+func render(templatePath string, file *File, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) (string, error) {
+	tmpl, err := template.New(filepath.Base(templatePath)).Option("missingkey=error").ParseFiles(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing template %s: %w", templatePath, err)
+	}
+	node, err := opts.readFile(file.EncryptedPath)
+	if err != nil {
+		return "", opts.audit(file.EncryptedPath, nil, *provider, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err))
+	}
+	err = checkHeader(&node, *provider, true)
+	if err != nil {
+		return "", opts.audit(file.EncryptedPath, nil, *provider, fmt.Errorf("Error decrypting file %s: %w", file.EncryptedPath, err))
+	}
+	paths := yaml.GetTaggedChildrenPaths(&node, yaml.EncryptedTag)
+	ciphertextSet := map[string]nothing{}
+	err = addTaggedValuesToSet(&ciphertextSet, &node, yaml.EncryptedTag)
+	if err != nil {
+		return "", opts.audit(file.EncryptedPath, paths, *provider, fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err))
+	}
+	plaintexts, err := decryptCiphertexts(&ciphertextSet, cache, provider, threads, false, opts)
+	if err != nil {
+		err = locateValue(err, yaml.EncryptedTag, []string{file.EncryptedPath}, []*yamlv3.Node{&node})
+		return "", opts.audit(file.EncryptedPath, paths, *provider, fmt.Errorf("Error decrypting existing ciphertexts: %w", err))
+	}
+	for n := range yaml.GetTaggedChildren(&node, yaml.EncryptedTag) {
+		err = yaml.DecryptNode(n.YamlNode, plaintexts, false)
+		if err != nil {
+			return "", opts.audit(file.EncryptedPath, paths, *provider, fmt.Errorf("Error decrypting node %s: %w", n.Path.String(), err))
+		}
+	}
+	err = opts.audit(file.EncryptedPath, paths, *provider, nil)
+	if err != nil {
+		return "", err
+	}
+	var data interface{}
+	err = node.Decode(&data)
+	if err != nil {
+		return "", fmt.Errorf("Error reading decrypted values from file %s: %w", file.EncryptedPath, err)
+	}
+	var out strings.Builder
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("Error rendering template %s: %w", templatePath, err)
+	}
+	return out.String(), nil
+}
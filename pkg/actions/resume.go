@@ -0,0 +1,132 @@
+package actions
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Number of files processed at a time by a resumable run. Files are only recorded as completed once their whole batch is written, so smaller batches lose less work to an interruption, but make fewer provider calls in parallel.
+var resumeBatchSize = 50
+
+// Files completed by an interrupted run, each recorded with a digest of the file it was produced from, so that files changed since aren't skipped.
+type resumeManifest struct {
+	path string
+	done map[string]bool
+	file *os.File
+}
+
+// Open the manifest at the given path, creating it if it doesn't exist yet.
+func openResumeManifest(path string) (*resumeManifest, error) {
+	m := resumeManifest{path: path, done: map[string]bool{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		m.done[scanner.Text()] = true
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	m.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Whether the file with the given input was completed by an earlier run.
+func (m *resumeManifest) completed(path, digest string) bool {
+	return m.done[path+"\t"+digest]
+}
+
+// Record files as completed, along with the digests of their inputs, syncing so that they're recorded even if the run is killed.
+func (m *resumeManifest) record(paths, digests []string) error {
+	lines := ""
+	for i := range paths {
+		lines += paths[i] + "\t" + digests[i] + "\n"
+	}
+	_, err := m.file.WriteString(lines)
+	if err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
+
+func (m *resumeManifest) Close() error {
+	return m.file.Close()
+}
+
+// Run an operation over files in batches, skipping files the manifest at ResumeManifest records as completed, and recording each batch in it once it's completed. The manifest is removed once every file is completed, so that the next run starts afresh. Each file is identified by its input, the version of it that's read, eg. the decrypted version when encrypting. If ResumeManifest isn't set, the operation just runs over all files at once.
+func (o *Options) resumable(files []*File, input func(*File) string, operation func([]*File) error) error {
+	if o.ResumeManifest == "" {
+		return operation(files)
+	}
+	manifest, err := openResumeManifest(o.ResumeManifest)
+	if err != nil {
+		return fmt.Errorf("Error opening resume manifest: %w", err)
+	}
+	defer manifest.Close()
+	remaining := []*File{}
+	digests := []string{}
+	for _, file := range files {
+		// a file that can't be read isn't skipped, so that the operation reports why
+		digest, _ := fileDigest(input(file))
+		if !manifest.completed(input(file), digest) {
+			remaining = append(remaining, file)
+			digests = append(digests, digest)
+		}
+	}
+	for start := 0; start < len(remaining); start += resumeBatchSize {
+		end := start + resumeBatchSize
+		if end > len(remaining) {
+			end = len(remaining)
+		}
+		batch := remaining[start:end]
+		err = operation(batch)
+		if err != nil {
+			return err
+		}
+		paths := make([]string, len(batch))
+		for i, file := range batch {
+			paths[i] = input(file)
+		}
+		err = manifest.record(paths, digests[start:end])
+		if err != nil {
+			return fmt.Errorf("Error recording completed files in resume manifest: %w", err)
+		}
+	}
+	err = manifest.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(o.ResumeManifest)
+}
+
+// Get the path of the encrypted version of a file.
+func encryptedPath(file *File) string {
+	return file.EncryptedPath
+}
+
+// Get the path of the decrypted version of a file.
+func decryptedPath(file *File) string {
+	return file.DecryptedPath
+}
+
+// Get a hex-encoded SHA-256 digest of a file's contents.
+func fileDigest(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,89 @@
+package actions
+
+import (
+	"errors"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A testProvider that fails to encrypt one plaintext, as if the run were interrupted there.
+type interruptingProvider struct {
+	*testProvider
+	plaintext string
+}
+
+func (p *interruptingProvider) Encrypt(plaintext string) ([]byte, error) {
+	if plaintext == p.plaintext {
+		return nil, errors.New("interrupted")
+	}
+	return p.testProvider.Encrypt(plaintext)
+}
+
+func TestResume(t *testing.T) {
+	opts := &Options{}
+	defer func(size int) { resumeBatchSize = size }(resumeBatchSize)
+	resumeBatchSize = 1
+	base := &testProvider{}
+	var provider crypto.Provider = &interruptingProvider{base, "c"}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	opts.ResumeManifest = filepath.Join(c.Root, cache.CacheDirName, "resume-test")
+	files := []*File{}
+	for _, name := range []string{"a", "b", "c"} {
+		file, err := NewFile(name+".decrypted.yaml", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte("secret: !secret "+name+"\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+	}
+	err := Encrypt(files, ca, &provider, 4, false, opts)
+	if err == nil {
+		t.Fatal("Expected the interrupted run to fail")
+	}
+	if _, err := os.Stat(opts.ResumeManifest); err != nil {
+		t.Fatalf("Interrupted run left no resume manifest: %s", err)
+	}
+	for _, file := range files[:2] {
+		if !exists(file.EncryptedPath) {
+			t.Errorf("Interrupted run didn't complete %s", file.EncryptedPath)
+		}
+	}
+
+	// with no cache to fall back on, only the values of files that weren't completed, or were changed since, go through the provider
+	err = ioutil.WriteFile(files[1].DecryptedPath, []byte("secret: !secret changed\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CacheEnabled = false
+	empty, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	base.encryptCalls = 0
+	provider = base
+	err = Encrypt(files, empty, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.encryptCalls != 2 {
+		t.Errorf("Resumed run made %d encrypt calls, expected 2", base.encryptCalls)
+	}
+	for path, expected := range map[string]string{files[1].EncryptedPath: "changed", files[2].EncryptedPath: "c"} {
+		if ciphertext := encryptedValues(t, path)["secret"]; !strings.HasSuffix(ciphertext, ":"+expected) {
+			t.Errorf("Resumed run encrypted %s to %q, expected a ciphertext of %q", path, ciphertext, expected)
+		}
+	}
+	if _, err := os.Stat(opts.ResumeManifest); !os.IsNotExist(err) {
+		t.Errorf("Completed run left its resume manifest behind: %v", err)
+	}
+}
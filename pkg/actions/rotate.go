@@ -0,0 +1,239 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Re-encrypt the single value at the given path in a file's encrypted version, leaving the rest of the file untouched. Unlike Encrypt, the existing ciphertext is never reused, so the value is always freshly encrypted by the provider.
+func Rotate(file *File, path string, cache *cache.Cache, provider *crypto.Provider, opts *Options) error {
+	opts = opts.withDefaults()
+	if err := checkCanDecrypt(provider); err != nil {
+		return err
+	}
+	return forEachContext([]*File{file}, cache, provider, func(_ []*File, provider *crypto.Provider) error {
+		return rotate(file, path, cache, provider, opts)
+	})
+}
+
+func rotate(file *File, path string, cache *cache.Cache, provider *crypto.Provider, opts *Options) error {
+	root, err := opts.readFile(file.EncryptedPath)
+	if err != nil {
+		return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	err = checkHeader(&root, *provider, true)
+	if err != nil {
+		return fmt.Errorf("Error rotating value in file %s: %w", file.EncryptedPath, err)
+	}
+	recipients, err := opts.declaredRecipients(&root, *provider)
+	if err != nil {
+		return fmt.Errorf("Error rotating value in file %s: %w", file.EncryptedPath, err)
+	}
+	lineEnding, err := yaml.DetectLineEnding(file.EncryptedPath)
+	if err != nil {
+		return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	node, err := yaml.GetNodeAtPath(&root, path)
+	if err != nil {
+		return err
+	}
+	if node.Tag != yaml.EncryptedTag {
+		return fmt.Errorf("Value at path %s is not tagged %s", path, yaml.EncryptedTag)
+	}
+	oldCiphertext, err := yaml.GetValue(node)
+	if err != nil {
+		return fmt.Errorf("Error reading encrypted value at path %s: %w", path, err)
+	}
+	plaintext, err := decryptCiphertext([]byte(oldCiphertext), cache, provider, opts)
+	if err != nil {
+		return fmt.Errorf("Error decrypting value at path %s: %w", path, err)
+	}
+	namedProvider, err := crypto.ForName(*provider, opts.providerIn(recipients, path))
+	if err != nil {
+		return err
+	}
+	ciphertext, err := freshlyEncrypt(plaintext, namedProvider, cache, opts)
+	if err != nil {
+		return fmt.Errorf("Error rotating value at path %s: %w", path, err)
+	}
+	value, err := yaml.EncryptedValue(node.Value).WithCiphertext(ciphertext)
+	if err != nil {
+		return fmt.Errorf("Error reading encrypted value at path %s: %w", path, err)
+	}
+	node.Value = string(value)
+	err = yaml.SaveFile(file.EncryptedPath, root, opts.saveOptions(lineEnding))
+	if err != nil {
+		return fmt.Errorf("Error writing yaml file %s: %w", file.EncryptedPath, err)
+	}
+	return nil
+}
+
+// Encrypt a plaintext with the given provider, never reusing a cached ciphertext, and add the new ciphertext to the cache.
+func freshlyEncrypt(plaintext string, provider crypto.Provider, cache *cache.Cache, opts *Options) ([]byte, error) {
+	var ciphertext []byte
+	err := opts.withRefresh(provider, func() (err error) {
+		start := time.Now()
+		ciphertext, err = crypto.Encrypt(provider, plaintext)
+		opts.reportProviderCall("encrypt", start, err)
+		return err
+	})
+	if err == nil && len(ciphertext) == 0 {
+		err = errEmptyCiphertext
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error using provider to encrypt value: %w", err)
+	}
+	err = cache.Add(plaintext, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Error adding item to cache: %w", err)
+	}
+	return ciphertext, nil
+}
+
+// The values RotateMatching re-encrypted in a file.
+type RotateResult struct {
+	File  string   `json:"file"`
+	Paths []string `json:"paths"`
+}
+
+// Re-encrypt every value in the files' encrypted versions whose plaintext matches a pattern, eg. everything that looks like an AWS access key, leaving the rest of each file untouched. As with Rotate, matching values are always freshly encrypted by the provider, though values with the same plaintext, going to the same provider, share their new ciphertext, as they would when encrypting. Nothing is written unless every matching value was rotated, and files without any are never written. Only files with matching values get a result.
+func RotateMatching(files []*File, pattern *regexp.Regexp, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) ([]RotateResult, error) {
+	opts = opts.withDefaults()
+	if err := checkCanDecrypt(provider); err != nil {
+		return []RotateResult{}, err
+	}
+	rotated := map[*File][]string{}
+	err := forEachContext(files, cache, provider, func(files []*File, provider *crypto.Provider) error {
+		return rotateMatching(rotated, files, pattern, cache, provider, threads, opts)
+	})
+	if err != nil {
+		return []RotateResult{}, err
+	}
+	results := []RotateResult{}
+	for _, file := range files {
+		if paths, ok := rotated[file]; ok {
+			results = append(results, RotateResult{file.EncryptedPath, paths})
+		}
+	}
+	return results, nil
+}
+
+// A value to rotate, found by RotateMatching.
+type matchingValue struct {
+	file int
+	node *yamlv3.Node
+	path string
+	// Identifies the plaintext and the provider it's going to, so that values sharing both share their new ciphertext.
+	key string
+}
+
+func rotateMatching(rotated map[*File][]string, files []*File, pattern *regexp.Regexp, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	roots := make([]yamlv3.Node, len(files))
+	lineEndings := make([]string, len(files))
+	recipients := make([]string, len(files))
+	ciphertextSet := map[string]nothing{}
+	for i, file := range files {
+		var err error
+		roots[i], err = opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		err = checkHeader(&roots[i], *provider, true)
+		if err == nil {
+			recipients[i], err = opts.declaredRecipients(&roots[i], *provider)
+		}
+		if err != nil {
+			return fmt.Errorf("Error rotating values in file %s: %w", file.EncryptedPath, err)
+		}
+		lineEndings[i], err = yaml.DetectLineEnding(file.EncryptedPath)
+		if err != nil {
+			return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		err = addTaggedValuesToSet(&ciphertextSet, &roots[i], yaml.EncryptedTag)
+		if err != nil {
+			return fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
+		}
+	}
+	existing, err := decryptCiphertexts(&ciphertextSet, cache, provider, threads, false, opts)
+	if err != nil {
+		paths := make([]string, len(files))
+		nodes := make([]*yamlv3.Node, len(files))
+		for i, file := range files {
+			paths[i], nodes[i] = file.EncryptedPath, &roots[i]
+		}
+		return fmt.Errorf("Error decrypting existing ciphertexts: %w", locateValue(err, yaml.EncryptedTag, paths, nodes))
+	}
+
+	matches := []matchingValue{}
+	plaintexts := map[string]string{}
+	for i := range files {
+		for n := range yaml.GetTaggedChildren(&roots[i], yaml.EncryptedTag) {
+			// keep draining the iterator after an error
+			if err != nil {
+				continue
+			}
+			var ciphertext string
+			ciphertext, err = yaml.GetValue(n.YamlNode)
+			// nulls aren't encrypted, so there's nothing to rotate
+			if err != nil || ciphertext == "" {
+				continue
+			}
+			plaintext := existing[ciphertext]
+			if !pattern.MatchString(plaintext) {
+				continue
+			}
+			path := n.Path.Dotted()
+			name := opts.providerIn(recipients[i], path)
+			key := name + "\x00" + plaintext
+			plaintexts[key] = plaintext
+			matches = append(matches, matchingValue{i, n.YamlNode, path, key})
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading encrypted values in file %s: %w", files[i].EncryptedPath, err)
+		}
+	}
+	keys := make([]string, 0, len(plaintexts))
+	for key := range plaintexts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ciphertexts, err := parallelMap(keys, func(key string) (string, error) {
+		namedProvider, err := crypto.ForName(*provider, strings.SplitN(key, "\x00", 2)[0])
+		if err != nil {
+			return "", err
+		}
+		ciphertext, err := freshlyEncrypt(plaintexts[key], namedProvider, cache, opts)
+		return string(ciphertext), err
+	}, threads, false)
+	if err != nil {
+		return fmt.Errorf("Error rotating values: %w", err)
+	}
+
+	changed := map[int]bool{}
+	for _, match := range matches {
+		value, err := yaml.EncryptedValue(match.node.Value).WithCiphertext([]byte(ciphertexts[match.key]))
+		if err != nil {
+			return fmt.Errorf("Error reading encrypted value at path %s in file %s: %w", match.path, files[match.file].EncryptedPath, err)
+		}
+		match.node.Value = string(value)
+		changed[match.file] = true
+		rotated[files[match.file]] = append(rotated[files[match.file]], match.path)
+	}
+	for i, file := range files {
+		if !changed[i] {
+			continue
+		}
+		err = yaml.SaveFile(file.EncryptedPath, roots[i], opts.saveOptions(lineEndings[i]))
+		if err != nil {
+			return fmt.Errorf("Error writing yaml file %s: %w", file.EncryptedPath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Record who last changed each secret in a newly encrypted node. Secrets whose encrypted values differ from those at the same paths in the existing encrypted version are recorded as changed by RotatedBy, or lose any record they had if it's empty, since it's no longer accurate. The rest keep whoever the existing version records, whatever the decrypted version's comments say, so that encrypting a file again doesn't churn. Secrets in mapping keys aren't recorded, since a key shares its line comment with its value.
+func (o *Options) recordRotatedBy(existing, encrypted *yamlv3.Node) {
+	previous := map[string]*yamlv3.Node{}
+	if existing != nil {
+		for n := range yaml.GetTaggedChildren(existing, yaml.EncryptedTag) {
+			previous[n.Path.String()] = n.YamlNode
+		}
+	}
+	for n := range yaml.GetTaggedChildren(encrypted, yaml.EncryptedTag) {
+		if n.Path.IsKey() {
+			continue
+		}
+		if old, ok := previous[n.Path.String()]; ok && old.Value == n.YamlNode.Value {
+			yaml.SetRotatedBy(n.YamlNode, yaml.GetRotatedBy(old))
+		} else {
+			yaml.SetRotatedBy(n.YamlNode, o.RotatedBy)
+		}
+	}
+}
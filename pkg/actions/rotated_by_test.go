@@ -0,0 +1,80 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRotatedBy(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("rotated.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(identity, decrypted string) string {
+		opts.RotatedBy = identity
+		err := ioutil.WriteFile(file.DecryptedPath, []byte(decrypted), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(file.EncryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	rotatedBy := func() map[string]string {
+		info, err := Info(&file, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.RotatedBy
+	}
+
+	encrypted := encrypt("alice@example.com", "a: !secret one\nb: !secret two # note\nc: plain\n")
+	if got := rotatedBy(); len(got) != 2 || got["a"] != "alice@example.com" || got["b"] != "alice@example.com" {
+		t.Errorf("Expected both new secrets to be recorded as rotated by alice, got %v", got)
+	}
+	if !strings.Contains(encrypted, "# note yamlcrypt:rotatedBy=alice@example.com\n") {
+		t.Errorf("Expected the record to be appended to the existing comment:\n%s", encrypted)
+	}
+
+	// encrypting unchanged values again, by someone else, changes nothing
+	if again := encrypt("bob@example.com", "a: !secret one\nb: !secret two # note\nc: plain\n"); again != encrypted {
+		t.Errorf("Encrypting unchanged values churned the encrypted version:\n%s\nexpected:\n%s", again, encrypted)
+	}
+
+	// only the changed value is recorded as rotated, even though the decrypted version's comments still name alice
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt("bob@example.com", strings.Replace(string(decrypted), "one", "uno", 1))
+	if got := rotatedBy(); got["a"] != "bob@example.com" || got["b"] != "alice@example.com" {
+		t.Errorf("Expected only the changed secret to be recorded as rotated by bob, got %v", got)
+	}
+
+	// with nothing to record, existing records are left as they are
+	encrypt("", "a: !secret uno\nb: !secret two # note\nc: plain\n")
+	if got := rotatedBy(); got["a"] != "bob@example.com" || got["b"] != "alice@example.com" {
+		t.Errorf("Expected existing records to be kept, got %v", got)
+	}
+	// a changed value's record is no longer accurate, so it's dropped rather than copied from the decrypted version
+	encrypt("", "a: !secret changed # yamlcrypt:rotatedBy=bob@example.com\nb: !secret two # note\nc: plain\n")
+	if got := rotatedBy(); len(got) != 1 || got["b"] != "alice@example.com" {
+		t.Errorf("Expected the changed secret's record to be dropped, got %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"os"
+)
+
+// A schema file, listing dotted path patterns of values to encrypt, in the same format as EncryptPaths.
+type Schema struct {
+	Paths []string
+}
+
+// Load a schema file, checking that its paths are valid.
+func LoadSchema(path string) (Schema, error) {
+	var schema Schema
+	f, err := os.Open(path)
+	if err != nil {
+		return schema, err
+	}
+	defer f.Close()
+	decoder := yamlv3.NewDecoder(f)
+	decoder.KnownFields(true)
+	err = decoder.Decode(&schema)
+	if err != nil {
+		return schema, err
+	}
+	for _, path := range schema.Paths {
+		if _, err := yaml.SplitPath(path); err != nil {
+			return schema, err
+		}
+	}
+	return schema, nil
+}
+
+// Get the path patterns of values to encrypt whether or not they're tagged: those in EncryptPaths, and those in SchemaFile, if there is one. The schema file is read again each time, so that it can change between calls.
+func (o *Options) encryptPaths() ([]string, error) {
+	if o.SchemaFile == "" {
+		return o.EncryptPaths, nil
+	}
+	schema, err := LoadSchema(o.SchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading schema file %s: %w", o.SchemaFile, err)
+	}
+	return append(append([]string{}, o.EncryptPaths...), schema.Paths...), nil
+}
+
+// Tag the values of a decrypted document that are to be encrypted whether or not they're tagged: every value if EncryptAll is set, or those matching the given path patterns otherwise, except for those matching PlaintextPaths.
+func (o *Options) tagPathsToEncrypt(node *yamlv3.Node, paths []string) {
+	if o.EncryptAll {
+		yaml.TagAllPaths(node, o.PlaintextPaths, yaml.DecryptedTag)
+		return
+	}
+	yaml.TagMatchingPaths(node, paths, o.PlaintextPaths, yaml.DecryptedTag)
+}
@@ -0,0 +1,82 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	opts.SchemaFile = filepath.Join(c.Root, "secrets.schema.yaml")
+	err := ioutil.WriteFile(opts.SchemaFile, []byte("paths:\n  - db.password\n  - \"*.apiKey\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same schema applies to every environment's file
+	environments := map[string]string{
+		"dev":  "db:\n  host: localhost\n  password: devpass\nsearch:\n  apiKey: devkey\n",
+		"prod": "db:\n  host: db.example.com\n  password: prodpass\nsearch:\n  apiKey: prodkey\n  url: https://example.com\nextra: !secret tagged\n",
+	}
+	files := []*File{}
+	byName := map[string]*File{}
+	for name, decrypted := range environments {
+		file, err := NewFile(name+".decrypted.yaml", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(decrypted), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+		byName[name] = &file
+	}
+	err = Encrypt(files, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		values := encryptedValues(t, file.EncryptedPath)
+		for _, path := range []string{"db.password", "search.apiKey"} {
+			if _, ok := values[path]; !ok {
+				t.Errorf("Value at %s in %s was not encrypted: %v", path, file.EncryptedPath, values)
+			}
+		}
+		for _, path := range []string{"db.host", "search.url"} {
+			if _, ok := values[path]; ok {
+				t.Errorf("Value at %s in %s was encrypted, but isn't in the schema", path, file.EncryptedPath)
+			}
+		}
+	}
+	if _, ok := encryptedValues(t, byName["prod"].EncryptedPath)["extra"]; !ok {
+		t.Error("Expected values tagged !secret to be encrypted alongside those in the schema")
+	}
+
+	// streams use the schema too
+	var out bytes.Buffer
+	err = EncryptStream(strings.NewReader("db:\n  password: streamed\n"), &out, "", cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "streamed") {
+		t.Errorf("Streamed value in the schema was not encrypted:\n%s", out.String())
+	}
+
+	// an invalid schema fails, rather than leaving its paths unencrypted
+	err = ioutil.WriteFile(opts.SchemaFile, []byte("paths: [db.password]\nfiles: [dev]\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt(files, cache, &provider, 4, false, opts)
+	if err == nil || !strings.Contains(err.Error(), "schema") {
+		t.Errorf("Expected an error loading the schema, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSortKeys(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("sorted.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "zone: eu\n# the database\ndb:\n  user: app # not secret\n  password: !secret hunter2\nhosts:\n- web\n- api\n- db\nusers:\n- name: bob\n  key: !secret b\n- name: alice\n  key: !secret a\n"
+	expected := "# the database\ndb:\n  password: !secret hunter2\n  user: app # not secret\nhosts:\n  - web\n  - api\n  - db\nusers:\n  - key: !secret b\n    name: bob\n  - key: !secret a\n    name: alice\nzone: eu\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(input), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.SortKeys = true
+	// the same output every time
+	for i := 0; i < 2; i++ {
+		err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != expected {
+			t.Errorf("Decrypted file with sorted keys is incorrect:\n%s\nExpected:\n%s", decrypted, expected)
+		}
+	}
+
+	// an alias can't be moved before its anchor
+	file, err = NewFile("alias.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("b: &password !secret hunter2\na: *password\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.SortKeys = false
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.SortKeys = true
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err == nil || !strings.Contains(err.Error(), "alias *password would come before its anchor") {
+		t.Errorf("Expected an error sorting an alias before its anchor, got %v", err)
+	}
+}
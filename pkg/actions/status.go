@@ -0,0 +1,149 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// The decrypted version has no encrypted version yet.
+	StatusNew = "new"
+	// The decrypted version differs from what decrypting the encrypted version gives.
+	StatusModified = "modified"
+	// The encrypted version is up to date with the decrypted version.
+	StatusClean = "clean"
+)
+
+// Whether a decrypted file has changes that haven't been encrypted yet.
+type StatusResult struct {
+	File string `json:"file"`
+	// One of the Status constants.
+	Status string `json:"status"`
+}
+
+// Find out which of the files' decrypted versions have changes that haven't been encrypted yet, by decrypting their encrypted versions in memory and comparing them with the decrypted versions, as they'd be written out. Modification times aren't compared, since decrypting always leaves the decrypted version newer than the encrypted one. Every file must have a decrypted version.
+func Status(files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) ([]StatusResult, error) {
+	opts = opts.withDefaults()
+	statuses := map[*File]string{}
+	existing := []*File{}
+	for _, file := range files {
+		if exists(file.EncryptedPath) {
+			existing = append(existing, file)
+		} else {
+			statuses[file] = StatusNew
+		}
+	}
+	if len(existing) > 0 {
+		if err := checkCanDecrypt(provider); err != nil {
+			return []StatusResult{}, err
+		}
+		err := forEachContext(existing, cache, provider, func(files []*File, provider *crypto.Provider) error {
+			return status(statuses, files, cache, provider, threads, opts)
+		})
+		if err != nil {
+			return []StatusResult{}, err
+		}
+	}
+	results := make([]StatusResult, len(files))
+	for i, file := range files {
+		results[i] = StatusResult{file.DecryptedPath, statuses[file]}
+	}
+	return results, nil
+}
+
+func status(statuses map[*File]string, files []*File, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	nodes := make([]yamlv3.Node, len(files))
+	ciphertextSet := map[string]nothing{}
+	for i, file := range files {
+		var err error
+		nodes[i], err = opts.readFile(file.EncryptedPath)
+		if err != nil {
+			return fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+		}
+		err = checkHeader(&nodes[i], *provider, true)
+		if err != nil {
+			return fmt.Errorf("Error decrypting file %s: %w", file.EncryptedPath, err)
+		}
+		setDecryptedHeader(&nodes[i])
+		err = addTaggedValuesToSet(&ciphertextSet, &nodes[i], yaml.EncryptedTag)
+		if err != nil {
+			return fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
+		}
+	}
+	plaintexts, err := decryptCiphertexts(&ciphertextSet, cache, provider, threads, false, opts)
+	if err != nil {
+		paths := make([]string, len(files))
+		nodePointers := make([]*yamlv3.Node, len(files))
+		for i, file := range files {
+			paths[i], nodePointers[i] = file.EncryptedPath, &nodes[i]
+		}
+		return fmt.Errorf("Error decrypting existing ciphertexts: %w", locateValue(err, yaml.EncryptedTag, paths, nodePointers))
+	}
+	patterns, err := opts.encryptPaths()
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		paths := yaml.GetTaggedChildrenPaths(&nodes[i], yaml.EncryptedTag)
+		for n := range yaml.GetTaggedChildren(&nodes[i], yaml.EncryptedTag) {
+			if err != nil {
+				// keep draining the iterator after an error
+				continue
+			}
+			err = yaml.DecryptNode(n.YamlNode, plaintexts, true)
+			if err != nil {
+				err = fmt.Errorf("Error decrypting node %s: %w", n.Path.String(), err)
+			}
+		}
+		err = opts.audit(file.EncryptedPath, paths, *provider, err)
+		if err != nil {
+			return err
+		}
+		// compare against the encrypted version as decrypting would write it
+		err = opts.transformDocument(file.EncryptedPath, &nodes[i])
+		if err != nil {
+			return err
+		}
+		decrypted, err := opts.readFile(file.DecryptedPath)
+		if err != nil {
+			return fmt.Errorf("Error reading yaml file %s: %w", file.DecryptedPath, err)
+		}
+		// the decrypted version is compared as it'd be decrypted once it's encrypted, with any values to encrypt by path tagged, and its secrets written out the way decrypting writes them
+		opts.tagPathsToEncrypt(&decrypted, patterns)
+		for n := range yaml.GetTaggedChildren(&decrypted, yaml.DecryptedTag) {
+			if err == nil {
+				err = yaml.NormalizeSecret(n.YamlNode)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading yaml file %s: %w", file.DecryptedPath, err)
+		}
+		same, err := sameDocuments(nodes[i], decrypted)
+		if err != nil {
+			return fmt.Errorf("Error comparing %s with %s: %w", file.DecryptedPath, file.EncryptedPath, err)
+		}
+		statuses[file] = StatusModified
+		if same {
+			statuses[file] = StatusClean
+		}
+	}
+	return nil
+}
+
+// Whether two documents are written out the same, so that differences in formatting that writing them would undo, eg. indentation, are ignored.
+func sameDocuments(a, b yamlv3.Node) (bool, error) {
+	var bufA, bufB bytes.Buffer
+	err := yaml.Write(&bufA, a)
+	if err != nil {
+		return false, err
+	}
+	err = yaml.Write(&bufB, b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes()), nil
+}
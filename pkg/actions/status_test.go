@@ -0,0 +1,103 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	original := "# config\nname: app\nport: !secret 5432\npassword: !secret \"hunter2\"\nempty: !secret\nnote: !secret |\n  line one\n  line two\ndb: !secret {host: db.internal, port: 5432}\n"
+	// what each file's decrypted version is changed to after encrypting, and the status that gives it
+	changes := []struct {
+		content  string
+		expected string
+	}{
+		{original, StatusClean},
+		// formatting that writing the file would undo, and quoting that isn't kept in the encrypted values
+		{"# config\nname:   app\nport: !secret 5432\npassword: !secret 'hunter2'\nempty: !secret null\nnote: !secret |\n    line one\n    line two\ndb: !secret {host: db.internal,  port: 5432}\n", StatusClean},
+		{"# config\nname: app\nport: !secret 5433\npassword: !secret \"hunter2\"\nempty: !secret\nnote: !secret |\n  line one\n  line two\ndb: !secret {host: db.internal, port: 5432}\n", StatusModified},
+		{"# config\nname: other\nport: !secret 5432\npassword: !secret \"hunter2\"\nempty: !secret\nnote: !secret |\n  line one\n  line two\ndb: !secret {host: db.internal, port: 5432}\n", StatusModified},
+		// quoting a number changes its type
+		{"# config\nname: app\nport: !secret \"5432\"\npassword: !secret \"hunter2\"\nempty: !secret\nnote: !secret |\n  line one\n  line two\ndb: !secret {host: db.internal, port: 5432}\n", StatusModified},
+		{"# config\nname: app\nport: !secret 5432\npassword: !secret \"hunter2\"\nempty: !secret\nnote: !secret |\n  line one\n  line two\ndb: !secret {host: db.internal, port: 5433}\n", StatusModified},
+		{"# config\nname: app\nport: !secret 5432\npassword: !secret \"hunter2\"\nnote: !secret |\n  line one\n  line two\ndb: !secret {host: db.internal, port: 5432}\n", StatusModified},
+	}
+	files := []*File{}
+	for i := range changes {
+		file, err := NewFile(fmt.Sprintf("%d.decrypted.yaml", i), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &file)
+	}
+	err := Encrypt(files, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, change := range changes {
+		err = ioutil.WriteFile(files[i].DecryptedPath, []byte(change.content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	added, err := NewFile("added.decrypted.yaml", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(added.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, &added)
+
+	// the same statuses are found whether the plaintexts are cached or not
+	c.CacheEnabled = false
+	empty, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	for _, ca := range []*cache.Cache{ca, empty} {
+		results, err := Status(files, ca, &provider, 4, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(files) {
+			t.Fatalf("Status of %d files gave %d results", len(files), len(results))
+		}
+		for i, result := range results {
+			expected := StatusNew
+			if i < len(changes) {
+				expected = changes[i].expected
+			}
+			if result.File != files[i].DecryptedPath || result.Status != expected {
+				t.Errorf("Status gave %+v, expected %s for %s", result, expected, files[i].DecryptedPath)
+			}
+		}
+	}
+
+	// encrypting leaves every file clean
+	err = Encrypt(files, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Status(files, ca, &provider, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Status != StatusClean {
+			t.Errorf("Status after encrypting gave %+v", result)
+		}
+	}
+}
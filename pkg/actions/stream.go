@@ -0,0 +1,131 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+)
+
+// Decrypt files, writing them to a Writer one top-level key at a time, as soon as the values under each key have been decrypted. Unlike Decrypt, output starts before all values are decrypted, while remaining in document order.
+func DecryptStream(files []*File, w io.Writer, plain bool, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	opts = opts.withDefaults()
+	if len(opts.DecryptTransforms) > 0 {
+		return errors.New("Decrypt transforms can't be applied when streaming, since documents are never read as a whole")
+	}
+	if err := checkCanDecrypt(provider); err != nil {
+		return err
+	}
+	return forEachContext(files, cache, provider, func(files []*File, provider *crypto.Provider) error {
+		return decryptStream(files, w, plain, cache, provider, threads, opts)
+	})
+}
+
+func decryptStream(files []*File, w io.Writer, plain bool, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	for _, file := range files {
+		paths, err := decryptStreamFile(file, w, plain, cache, provider, threads, opts)
+		err = opts.audit(file.EncryptedPath, paths, *provider, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decrypt a single file to a Writer, as DecryptStream does, returning the paths of the values decrypted.
+func decryptStreamFile(file *File, w io.Writer, plain bool, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) ([]string, error) {
+	node, err := opts.readFile(file.EncryptedPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	err = checkHeader(&node, *provider, true)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting file %s: %w", file.EncryptedPath, err)
+	}
+	lineEnding, err := yaml.DetectLineEnding(file.EncryptedPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading yaml file %s: %w", file.EncryptedPath, err)
+	}
+	paths := yaml.GetTaggedChildrenPaths(&node, yaml.EncryptedTag)
+	setDecryptedHeader(&node)
+	chunks := yaml.SplitTopLevel(&node)
+	if opts.OutputStyle == yaml.FlowStyle {
+		// a flow style document can't be written out one key at a time
+		chunks = []*yamlv3.Node{&node}
+	}
+	for _, chunk := range chunks {
+		ciphertextSet := map[string]nothing{}
+		err = addTaggedValuesToSet(&ciphertextSet, chunk, yaml.EncryptedTag)
+		if err != nil {
+			return paths, fmt.Errorf("Error getting encrypted values from file %s: %w", file.EncryptedPath, err)
+		}
+		plaintexts, err := decryptCiphertexts(&ciphertextSet, cache, provider, threads, false, opts)
+		if err != nil {
+			err = locateValue(err, yaml.EncryptedTag, []string{file.EncryptedPath}, []*yamlv3.Node{chunk})
+			return paths, fmt.Errorf("Error decrypting existing ciphertexts: %w", err)
+		}
+		values := map[*yamlv3.Node]string{}
+		for n := range yaml.GetTaggedChildren(chunk, yaml.EncryptedTag) {
+			if opts.VerifyOutput {
+				var ciphertext string
+				ciphertext, err = yaml.GetValue(n.YamlNode)
+				values[n.YamlNode] = plaintexts[ciphertext]
+			}
+			if err == nil {
+				err = yaml.DecryptNode(n.YamlNode, plaintexts, !plain)
+			}
+			if err != nil {
+				return paths, fmt.Errorf("Error decrypting node %s: %w", n.Path.String(), err)
+			}
+		}
+		if opts.VerifyOutput {
+			err = yaml.CheckRoundTrip(*chunk, values)
+			if err != nil {
+				return paths, fmt.Errorf("Error decrypting file %s: %w", file.EncryptedPath, err)
+			}
+		}
+		err = yaml.WriteWithOptions(w, *chunk, opts.saveOptions(lineEnding))
+		if err != nil {
+			return paths, fmt.Errorf("Error writing yaml file %s: %w", file.EncryptedPath, err)
+		}
+	}
+	return paths, nil
+}
+
+// Encrypt a decrypted document read from a Reader, writing the encrypted document to a Writer. With no encrypted version of the document to compare against, existing ciphertexts can only be reused if they're in the cache.
+func EncryptStream(r io.Reader, w io.Writer, context string, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	opts = opts.withDefaults()
+	return forEachContext([]*File{{Context: context}}, cache, provider, func(_ []*File, provider *crypto.Provider) error {
+		return encryptStream(r, w, cache, provider, threads, opts)
+	})
+}
+
+func encryptStream(r io.Reader, w io.Writer, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	node, err := opts.read(r)
+	if err != nil {
+		return fmt.Errorf("Error reading yaml: %w", err)
+	}
+	recipients, err := opts.declaredRecipients(&node, *provider)
+	if err != nil {
+		return err
+	}
+	paths, err := opts.encryptPaths()
+	if err != nil {
+		return err
+	}
+	opts.tagPathsToEncrypt(&node, paths)
+	err = opts.checkValueSizes(&node)
+	if err != nil {
+		return err
+	}
+	nodes := []yamlv3.Node{node}
+	err = encryptNodes(nodes, []string{""}, []string{recipients}, nil, nil, cache, provider, threads, false, opts)
+	if err != nil {
+		return err
+	}
+	yaml.SetHeader(&nodes[0], newHeader(&nodes[0], *provider, recipients))
+	return yaml.WriteWithOptions(w, nodes[0], yaml.SaveOptions{EncryptedTag: opts.EncryptedTag})
+}
@@ -0,0 +1,164 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecryptStream(t *testing.T) {
+	opts := &Options{}
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if repo.Skip() {
+			continue
+		}
+		err := repo.Setup()
+		defer repo.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Checkout(repo.Provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := config.LoadConfig(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := cache.Setup(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make([]*File, len(repo.Files))
+		expected := map[bool][]byte{}
+		for i, f := range repo.Files {
+			file, err := NewFile(f.TmpPath(repo.Provider), &config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[i] = &file
+			for _, plain := range []bool{false, true} {
+				kind := "original"
+				if plain {
+					kind = "plain"
+				}
+				data, err := ioutil.ReadFile(f.SrcPath(kind))
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected[plain] = append(expected[plain], data...)
+			}
+		}
+		// run it a few times, in order to catch any nondeterminism in the output order
+		for i := 0; i < 5; i++ {
+			for _, plain := range []bool{false, true} {
+				out := bytes.Buffer{}
+				err = DecryptStream(files, &out, plain, c, &config.Provider, 4, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.Bytes(), expected[plain]) {
+					t.Errorf("Streamed output in repo %s (plain: %t) is incorrect:\n%s", repo, plain, out.String())
+				}
+			}
+		}
+		err = c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncryptStream(t *testing.T) {
+	opts := &Options{}
+	opts.EncryptPaths = []string{"db.password"}
+	var provider crypto.Provider = &testProvider{}
+	_, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	original := "db:\n  user: app\n  password: hunter2\ntoken: !secret abc\n"
+	var encrypted bytes.Buffer
+	err := EncryptStream(strings.NewReader(original), &encrypted, "", cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "abc"} {
+		if strings.Contains(encrypted.String(), secret) {
+			t.Errorf("Encrypted output contains secret %q:\n%s", secret, encrypted.String())
+		}
+	}
+	if !strings.Contains(encrypted.String(), "user: app\n") {
+		t.Errorf("Encrypted output is missing unencrypted values:\n%s", encrypted.String())
+	}
+
+	err = ioutil.WriteFile("stream.encrypted.yaml", encrypted.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decrypted bytes.Buffer
+	err = DecryptStream([]*File{{EncryptedPath: "stream.encrypted.yaml"}}, &decrypted, true, cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "db:\n  user: app\n  password: hunter2\ntoken: abc\n"
+	if decrypted.String() != expected {
+		t.Errorf("Round-trip through stdin gave:\n%s\nexpected:\n%s", decrypted.String(), expected)
+	}
+}
+
+func TestEncryptStreamSingleProvider(t *testing.T) {
+	opts := &Options{SingleProvider: true}
+	opts.ProviderPaths = []config.ProviderPath{{Path: "db.*", Provider: "prod"}}
+	key := &testProvider{}
+	var provider crypto.Provider = key
+	_, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	// neither the path mapped to another provider nor the declared recipients are encrypted with anything but the provider given
+	original := "# yaml-crypt: recipients=ops\n\ndb:\n  password: !secret hunter2\ntoken: !secret abc\n"
+	var encrypted bytes.Buffer
+	err := EncryptStream(strings.NewReader(original), &encrypted, "", cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.encryptCalls != 2 {
+		t.Errorf("Expected both values to be encrypted with the provider given, got %d calls", key.encryptCalls)
+	}
+	if strings.Contains(encrypted.String(), "recipients=") {
+		t.Errorf("Encrypted output still declares recipients:\n%s", encrypted.String())
+	}
+}
+
+func TestDecryptStreamSaveOptions(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	_, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	var encrypted bytes.Buffer
+	err := EncryptStream(strings.NewReader("db:\n  user: app\n  password: !secret hunter2\ntoken: !secret abc\n"), &encrypted, "", cache, &provider, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("stream.encrypted.yaml", bytes.ReplaceAll(encrypted.Bytes(), []byte("\n"), []byte("\r\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the line ending of the encrypted file, the output style, and verifying are all honoured, as when decrypting to a file
+	opts := &Options{OutputStyle: yaml.FlowStyle, VerifyOutput: true}
+	var decrypted bytes.Buffer
+	err = DecryptStream([]*File{{EncryptedPath: "stream.encrypted.yaml"}}, &decrypted, true, cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "{db: {user: app, password: hunter2}, token: abc}\r\n"
+	if decrypted.String() != expected {
+		t.Errorf("Streaming with save options gave %q, expected %q", decrypted.String(), expected)
+	}
+}
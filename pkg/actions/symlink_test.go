@@ -0,0 +1,84 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSymlinkedFiles(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	err := os.Mkdir("real", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewFile("link.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join("real", filepath.Base(file.DecryptedPath)), []byte("a: !secret one\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the encrypted file's link is dangling to start with
+	for _, path := range []string{file.DecryptedPath, file.EncryptedPath} {
+		err = os.Symlink(filepath.Join("real", filepath.Base(path)), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "symlink to a file that doesn't exist") {
+		t.Errorf("Encrypting to a dangling symlink did not fail clearly: %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join("real", filepath.Base(file.EncryptedPath)), []byte("{}\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join("real", filepath.Base(file.DecryptedPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join("real", filepath.Base(file.DecryptedPath)), []byte{}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{file.DecryptedPath, file.EncryptedPath} {
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Symlink %s was replaced with a regular file", path)
+		}
+	}
+	encrypted, err := ioutil.ReadFile(filepath.Join("real", filepath.Base(file.EncryptedPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encrypted), "\na: !encrypted ") {
+		t.Errorf("Symlink target was not encrypted:\n%s", encrypted)
+	}
+	decrypted, err := ioutil.ReadFile(filepath.Join("real", filepath.Base(file.DecryptedPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "a: !secret one\n" {
+		t.Errorf("Symlink target was not decrypted:\n%s", decrypted)
+	}
+}
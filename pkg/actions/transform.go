@@ -0,0 +1,22 @@
+package actions
+
+import (
+	"fmt"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Transforms a decrypted document before it's written, eg. to inject a computed value, or to strip comments, for embedding yaml-crypt in a pipeline without forking it. The path is the encrypted version's, or the Document's Name.
+//
+// The node is the document node of that document's own tree, which the transform may mutate freely, but only for the duration of the call: the tree is written out once it returns, and mustn't be retained or mutated afterwards. Transforms may be called concurrently, each with a different tree, so any state they share must be safe for concurrent use. Secrets are tagged !secret, unless only the plain version is being written; values the transform adds aren't encrypted, or tagged, unless it tags them !secret itself, and aren't checked by Options.VerifyOutput. Returning an error fails the document, which isn't written.
+type DecryptTransform func(path string, node *yamlv3.Node) error
+
+// Run the DecryptTransforms on a decrypted document.
+func (o *Options) transformDocument(path string, node *yamlv3.Node) error {
+	for _, t := range o.DecryptTransforms {
+		err := t(path, node)
+		if err != nil {
+			return fmt.Errorf("Error transforming file %s: %w", path, err)
+		}
+	}
+	return nil
+}
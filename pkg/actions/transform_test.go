@@ -0,0 +1,88 @@
+package actions
+
+import (
+	"errors"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecryptTransform(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("transform.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("host: db\npassword: !secret hunter2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{}
+	opts.DecryptTransforms = append(opts.DecryptTransforms, func(path string, node *yamlv3.Node) error {
+		if path != file.EncryptedPath {
+			t.Errorf("Expected transform to be called with %s, got %s", file.EncryptedPath, path)
+		}
+		root := node.Content[0]
+		for i := 0; i < len(root.Content); i += 2 {
+			if root.Content[i].Value == "host" {
+				root.Content[i+1].Value = "db.internal"
+			}
+		}
+		root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "url"}, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "postgres://db.internal"})
+		return nil
+	})
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "host: db.internal\npassword: !secret hunter2\nurl: postgres://db.internal\n"
+	if !strings.HasSuffix(string(data), expected) {
+		t.Errorf("Expected transformed output to end with %q, got %q", expected, data)
+	}
+
+	// the transformed output is what decrypting writes, so it's clean
+	results, err := Status([]*File{&file}, cache, &provider, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusClean {
+		t.Errorf("Expected transformed file to be clean, got %s", results[0].Status)
+	}
+
+	// a failing transform fails the file, without writing it
+	err = os.Remove(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("transform failed")
+	opts.DecryptTransforms = append(opts.DecryptTransforms, func(path string, node *yamlv3.Node) error {
+		return failure
+	})
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected decrypting to fail with the transform's error, got %v", err)
+	}
+	if exists(file.DecryptedPath) {
+		t.Error("Decrypted file was written despite the transform failing")
+	}
+
+	// streaming can't transform documents, so it refuses to rather than skipping them
+	err = DecryptStream([]*File{&file}, ioutil.Discard, false, cache, &provider, 4, opts)
+	if err == nil {
+		t.Error("Streaming with decrypt transforms did not fail")
+	}
+}
@@ -0,0 +1,122 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestTypedValues(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("typed.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "port: !secret 8080\nflag: !secret true\nratio: !secret 1.5\nquoted: !secret \"8080\"\n"
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// typed values are written in the version 2 layout, and strings in the version 1 layout
+	encrypted, err := yaml.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	for n := range yaml.GetTaggedChildren(&encrypted, yaml.EncryptedTag) {
+		values[n.Path.Dotted()] = n.YamlNode.Value
+	}
+	expectedTags := map[string]string{"port": "!!int", "flag": "!!bool", "ratio": "!!float", "quoted": "!!str"}
+	for path, tag := range expectedTags {
+		value := yaml.EncryptedValue(values[path])
+		actual, err := value.Tag()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tag {
+			t.Errorf("Encrypted value at %s has tag %s, expected %s", path, actual, tag)
+		}
+		if (tag == "!!str") != (value.Version() == 1) {
+			t.Errorf("Encrypted value at %s has layout version %d", path, value.Version())
+		}
+	}
+
+	// decrypted values keep their type
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != original {
+		t.Errorf("Decrypting typed values gave:\n%s", decrypted)
+	}
+
+	// plain output resolves to the original types
+	err = Decrypt([]*File{&file}, true, false, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadFile(file.PlainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node yamlv3.Node
+	err = yamlv3.Unmarshal(plain, &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := node.Content[0]
+	for i := 0; i < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i].Value, mapping.Content[i+1]
+		if value.ShortTag() != expectedTags[key] {
+			t.Errorf("Plain value at %s has tag %s, expected %s", key, value.ShortTag(), expectedTags[key])
+		}
+	}
+	if !strings.Contains(string(plain), "port: 8080\n") {
+		t.Errorf("Plain output quoted a typed value:\n%s", plain)
+	}
+
+	// a value written before values kept their type keeps its layout when its ciphertext is reused
+	ciphertext, err := yaml.EncryptedValue(values["port"]).Ciphertext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	untyped := string(yaml.NewEncryptedValue(ciphertext))
+	content, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = []byte(strings.Replace(string(content), values["port"], untyped, 1))
+	err = ioutil.WriteFile(file.EncryptedPath, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, cache, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reencrypted) != string(content) {
+		t.Errorf("Re-encrypting rewrote a version 1 value:\n%s\nexpected:\n%s", reencrypted, content)
+	}
+}
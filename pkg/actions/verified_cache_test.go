@@ -0,0 +1,109 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifiedCache(t *testing.T) {
+	defer func() { cache.Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	cache.Warnings = warnings
+	inner := &testProvider{}
+	var provider crypto.Provider = inner
+	config, unverified, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("poisoned.decrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file.DecryptedPath, []byte("a: !secret one\nb: !secret two\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, unverified, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// poison an entry, as a hash collision or a stale key could
+	ciphertext := []byte(encryptedValues(t, file.EncryptedPath)["b"])
+	err = unverified.Remove(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = unverified.Add("poison", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, unverified, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decrypted), "poison") {
+		t.Fatalf("Unverified cache didn't serve the poisoned entry:\n%s", decrypted)
+	}
+	err = unverified.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// in verified mode, the provider confirms each entry before it's served
+	config.CacheVerify = true
+	verified, err := cache.Setup(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer verified.Close()
+	inner.decryptCalls = 0
+	err = Decrypt([]*File{&file}, false, false, verified, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err = ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "a: !secret one\nb: !secret two\n" {
+		t.Errorf("Verified cache served a poisoned entry:\n%s", decrypted)
+	}
+	if inner.decryptCalls != 2 {
+		t.Errorf("Verified cache called the provider %d times, expected once per value", inner.decryptCalls)
+	}
+	if !strings.Contains(warnings.String(), "replacing a cached plaintext") {
+		t.Errorf("Replacing the poisoned entry didn't warn: %q", warnings.String())
+	}
+
+	// entries are only confirmed once per session, and existing ciphertexts are still reused
+	err = Decrypt([]*File{&file}, false, false, verified, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Encrypt([]*File{&file}, verified, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.decryptCalls != 2 || inner.encryptCalls != 2 {
+		t.Errorf("Provider was called again for confirmed entries: %d decrypts, %d encrypts in total", inner.decryptCalls, inner.encryptCalls)
+	}
+	reencrypted, err := ioutil.ReadFile(file.EncryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encrypted, reencrypted) {
+		t.Errorf("Re-encrypting in verified mode changed the ciphertexts:\n%s\nexpected:\n%s", reencrypted, encrypted)
+	}
+}
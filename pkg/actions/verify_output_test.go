@@ -0,0 +1,61 @@
+package actions
+
+import (
+	"encoding/base64"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestVerifyOutput(t *testing.T) {
+	opts := &Options{}
+	var provider crypto.Provider = &testProvider{}
+	config, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	file, err := NewFile("verify.encrypted.yaml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := func(plaintext string) string {
+		return base64.StdEncoding.EncodeToString([]byte("1:" + plaintext))
+	}
+	opts.VerifyOutput = true
+
+	// control characters need quoting, but survive
+	err = ioutil.WriteFile(file.EncryptedPath, []byte("a: !encrypted "+encrypted("\x1b[0m\x00\t")+"\nb: !encrypted "+encrypted("fine")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Errorf("Verifying a value with control characters failed: %s", err)
+	}
+	err = Decrypt([]*File{&file}, true, false, cache, &provider, 4, false, opts)
+	if err != nil {
+		t.Errorf("Verifying a plain value with control characters failed: %s", err)
+	}
+
+	// invalid UTF-8 can't be represented as a yaml string
+	err = ioutil.WriteFile(file.EncryptedPath, []byte("a: !encrypted "+encrypted("fine")+"\nb:\n  c: !encrypted "+encrypted("\xff\xfe")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Decrypt([]*File{&file}, false, false, cache, &provider, 4, false, opts)
+	if err == nil {
+		t.Error("Verifying a value that can't be represented in yaml did not fail")
+	} else if !strings.Contains(err.Error(), "b.c") {
+		t.Errorf("Verification error does not name the offending path: %s", err)
+	}
+	after, err := ioutil.ReadFile(file.DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("Failed verification still wrote the decrypted file:\n%s", after)
+	}
+}
@@ -0,0 +1,66 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Check that every file under a directory is fully encrypted, and can be decrypted: that each decrypted file has an encrypted version, that no value in an encrypted version looks like a secret without being encrypted, as with CheckEncrypted, and that each encrypted version passes Verify. Files are checked in parallel, opts.FileThreads at a time. Every file gets a result, in one consolidated report, even if it can't be read at all.
+func VerifyTree(dir string, c *config.Config, cache *cache.Cache, provider *crypto.Provider, opts *Options) ([]VerifyResult, error) {
+	opts = opts.withDefaults()
+	files := []*File{}
+	seen := map[string]bool{}
+	for _, list := range []func(string) ([]string, error){c.AllEncryptedFiles, c.AllDecryptedFiles} {
+		paths, err := list(dir)
+		if err != nil {
+			return []VerifyResult{}, err
+		}
+		for _, path := range paths {
+			file, err := NewFile(path, c)
+			if err != nil {
+				return []VerifyResult{}, err
+			}
+			if !seen[file.EncryptedPath] {
+				seen[file.EncryptedPath] = true
+				files = append(files, &file)
+			}
+		}
+	}
+	if err := checkCanDecrypt(provider); err != nil {
+		return []VerifyResult{}, err
+	}
+	indices := map[*File]int{}
+	for i, file := range files {
+		indices[file] = i
+	}
+	results := make([]VerifyResult, len(files))
+	err := forEachContext(files, cache, provider, func(group []*File, provider *crypto.Provider) error {
+		return parallelFiles(len(group), opts.FileThreads, func(i int) error {
+			results[indices[group[i]]] = verifyTreeFile(group[i], c, cache, provider, opts)
+			return nil
+		})
+	})
+	return results, err
+}
+
+// Verify a single file, as VerifyTree does, reporting any error reading it in its result.
+func verifyTreeFile(file *File, c *config.Config, cache *cache.Cache, provider *crypto.Provider, opts *Options) VerifyResult {
+	if !exists(file.EncryptedPath) {
+		return VerifyResult{File: file.EncryptedPath, Errors: []VerifyError{{"", "File has no encrypted version; encrypt " + file.DecryptedPath}}}
+	}
+	result, err := verifyFile(file, cache, provider, opts)
+	if err == nil && c.SecretKeyPattern != nil {
+		var node yamlv3.Node
+		node, err = opts.readFile(file.EncryptedPath)
+		for _, path := range opts.unencryptedSecretPaths(&node, c.SecretKeyPattern) {
+			result.Errors = append(result.Errors, VerifyError{path, "Value looks like a secret, but isn't encrypted"})
+		}
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, VerifyError{"", err.Error()})
+	}
+	result.Ok = len(result.Errors) == 0
+	return result
+}
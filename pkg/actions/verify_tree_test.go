@@ -0,0 +1,99 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/yaml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyTree(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	c, ca, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	dir := filepath.Join(c.Root, "tree")
+	err := os.MkdirAll(filepath.Join(dir, "nested"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	newFile := func(name string) *File {
+		file, err := NewFile(filepath.Join(dir, name), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &file
+	}
+	// compliant files, encrypted and up to date, with or without a decrypted version
+	encrypted := []*File{}
+	for i, name := range []string{"clean.decrypted.yaml", "nested/clean.decrypted.yaml", "stale.decrypted.yaml", "leaky.decrypted.yaml", "only-encrypted.decrypted.yaml"} {
+		write(name, fmt.Sprintf("name: app\npassword: !secret hunter%d\n", i))
+		encrypted = append(encrypted, newFile(name))
+	}
+	err = Encrypt(encrypted, ca, &provider, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(encrypted[4].DecryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// non-compliant files
+	write("stale.decrypted.yaml", "name: app\npassword: !secret changed\n")
+	write("leaky.encrypted.yaml", "name: app\napi_token: plain\n")
+	write("new.decrypted.yaml", "password: !secret new\n")
+	write("broken.encrypted.yaml", fmt.Sprintf("password: !encrypted %s\n", yaml.NewEncryptedValue([]byte("garbage"))))
+	write("invalid.encrypted.yaml", "password: [\n")
+
+	// without a cache to fall back on, so that every value is decrypted by the provider
+	c.CacheEnabled = false
+	empty, err := cache.Setup(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	results, err := VerifyTree(dir, c, empty, &provider, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := map[string][]string{}
+	for _, result := range results {
+		rel, err := filepath.Rel(dir, result.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, e := range result.Errors {
+			paths = append(paths, e.Path)
+		}
+		if result.Ok != (len(paths) == 0) {
+			t.Errorf("Result for %s is inconsistent: %+v", rel, result)
+		}
+		if !result.Ok {
+			failures[rel] = paths
+		}
+	}
+	if len(results) != 8 {
+		t.Errorf("Verifying the tree gave %d results, expected one for each of its 8 files: %+v", len(results), results)
+	}
+	expected := map[string][]string{
+		"stale.encrypted.yaml":   {"password"},
+		"leaky.encrypted.yaml":   {"password", "api_token"},
+		"new.encrypted.yaml":     {""},
+		"broken.encrypted.yaml":  {"password"},
+		"invalid.encrypted.yaml": {""},
+	}
+	if !reflect.DeepEqual(failures, expected) {
+		t.Errorf("Verifying the tree found failures %v, expected %v", failures, expected)
+	}
+}
@@ -0,0 +1,128 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Watch the decrypted files under a directory, re-encrypting each one whenever it's saved, until stop is closed. A file is only encrypted once it has gone unchanged for the debounce duration, so that a save made up of several writes only encrypts it once, and only if its contents differ from when it was last encrypted, or from when watching started. Report is called after each file is encrypted, with the error if it couldn't be; a file that fails to encrypt is tried again on its next save.
+func Watch(dir string, debounce time.Duration, stop <-chan struct{}, report func(*File, error), config *config.Config, cache *cache.Cache, provider *crypto.Provider, threads int, opts *Options) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Error starting to watch files: %w", err)
+	}
+	defer watcher.Close()
+	// contents of each decrypted file as of when it was last encrypted, or when watching started
+	contents := map[string][]byte{}
+	paths, err := watchTree(watcher, dir, config)
+	if err != nil {
+		return fmt.Errorf("Error starting to watch files: %w", err)
+	}
+	for _, path := range paths {
+		contents[path], err = ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Error starting to watch files: %w", err)
+		}
+	}
+	// closed on return, so that pending timers don't block forever
+	done := make(chan nothing)
+	defer close(done)
+	ready := make(chan string)
+	timers := map[string]*time.Timer{}
+	// encrypt a file once it has gone unchanged for the debounce duration
+	schedule := func(path string) {
+		if timer, ok := timers[path]; ok {
+			timer.Stop()
+		}
+		timers[path] = time.AfterFunc(debounce, func() {
+			select {
+			case ready <- path:
+			case <-done:
+			}
+		})
+	}
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-watcher.Errors:
+			return fmt.Errorf("Error watching files: %w", err)
+		case event := <-watcher.Events:
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// files may have been written to it before it was watched
+					paths, err := watchTree(watcher, event.Name, config)
+					if err != nil {
+						return fmt.Errorf("Error watching new directory %s: %w", event.Name, err)
+					}
+					for _, path := range paths {
+						schedule(path)
+					}
+				}
+			}
+			// directories are watched rather than files, so that editors which save by writing a new file and renaming it over the old one are still seen, as a create or rename of the decrypted file's name
+			if event.Op == fsnotify.Chmod || !strings.HasSuffix(event.Name, config.Suffixes.Decrypted) {
+				continue
+			}
+			schedule(event.Name)
+		case path := <-ready:
+			delete(timers, path)
+			data, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				// it was removed, or renamed away partway through a save, in which case the new version's event follows
+				continue
+			}
+			if previous, ok := contents[path]; ok && err == nil && bytes.Equal(previous, data) {
+				continue
+			}
+			file, fileErr := NewFile(path, config)
+			if err == nil {
+				err = fileErr
+			}
+			if err == nil {
+				err = Encrypt([]*File{&file}, cache, provider, threads, false, opts)
+			}
+			if err == nil {
+				contents[path] = data
+			}
+			report(&file, err)
+		}
+	}
+}
+
+// Watch a directory and all of its subdirectories, other than the cache and git directories, returning the paths of the decrypted files in them.
+func watchTree(watcher *fsnotify.Watcher, dir string, config *config.Config) ([]string, error) {
+	paths := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && (info.Name() == cache.CacheDirName || info.Name() == ".git") {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		}
+		if strings.HasSuffix(path, config.Suffixes.Decrypted) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
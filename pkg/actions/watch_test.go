@@ -0,0 +1,118 @@
+package actions
+
+import (
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	var provider crypto.Provider = &testProvider{}
+	c, cache, cleanup := setupTestRepo(t, provider)
+	defer cleanup()
+	path := filepath.Join(c.Root, "watched"+c.Suffixes.Decrypted)
+	err := ioutil.WriteFile(path, []byte("a: !secret one\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const debounce = 50 * time.Millisecond
+	encrypted := make(chan *File, 10)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- Watch(c.Root, debounce, stop, func(file *File, err error) {
+			if err != nil {
+				t.Errorf("Error encrypting %s: %s", file.DecryptedPath, err)
+			}
+			encrypted <- file
+		}, c, cache, &provider, 4, nil)
+	}()
+	// give the watcher time to start
+	time.Sleep(debounce)
+	// wait for the given number of files to be encrypted, and then for long enough that any more would have been
+	expect := func(what string, n int) []*File {
+		files := []*File{}
+		timeout := time.After(5 * time.Second)
+		for len(files) < n {
+			select {
+			case file := <-encrypted:
+				files = append(files, file)
+			case <-timeout:
+				t.Fatalf("%s: expected %d files to be encrypted, got %d", what, n, len(files))
+			}
+		}
+		select {
+		case file := <-encrypted:
+			t.Errorf("%s: expected %d files to be encrypted, got another: %s", what, n, file.DecryptedPath)
+		case <-time.After(4 * debounce):
+		}
+		return files
+	}
+	expectValue := func(what string, file *File, path string, plaintext string) {
+		if value := encryptedValues(t, file.EncryptedPath)[path]; !strings.HasSuffix(value, ":"+plaintext) {
+			t.Errorf("%s: expected %s to hold %q encrypted at %s, got %q", what, file.EncryptedPath, plaintext, path, value)
+		}
+	}
+
+	// a save made up of several writes encrypts once
+	for _, content := range []string{"a: !secret", "a: !secret t", "a: !secret two\n"} {
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := expect("several writes", 1)
+	expectValue("several writes", files[0], "a", "two")
+
+	// saving the same contents again doesn't
+	err = ioutil.WriteFile(path, []byte("a: !secret two\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("unchanged contents", 0)
+
+	// saving by writing a temporary file and renaming it over the decrypted file
+	tmp := filepath.Join(c.Root, ".watched.tmp")
+	err = ioutil.WriteFile(tmp, []byte("a: !secret three\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue("rename over", expect("rename over", 1)[0], "a", "three")
+
+	// saving by moving the decrypted file aside as a backup, and writing a new one in its place
+	err = os.Rename(path, path+"~")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte("a: !secret four\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue("backup and write", expect("backup and write", 1)[0], "a", "four")
+
+	// files in directories created while watching are watched too, even if they're written before the directory is watched
+	dir := filepath.Join(c.Root, "new")
+	err = os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "nested"+c.Suffixes.Decrypted), []byte("b: !secret five\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue("new directory", expect("new directory", 1)[0], "b", "five")
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Identifies a file as a cache artifact, in its header.
+const artifactFormat = "yaml-crypt-cache"
+
+// Version of the artifact format written by Export. Version 2 artifacts hold values in envelopes, as they're stored in the cache; version 1 ones hold bare values, which can still be restored, since the cache reads them just as well.
+const artifactVersion = 2
+
+// The first line of a cache artifact, describing the cache it was exported from. Each line after it is an artifactEntry.
+type artifactHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// The scheme the keys were hashed with.
+	Scheme string `json:"scheme"`
+	// Fingerprint of the provider and keys the entries were cached under, as given by crypto.Fingerprint.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// An entry in a cache artifact, as it was stored in the cache.
+type artifactEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// The outcome of restoring a cache artifact.
+type RestoreReport struct {
+	// Number of entries added to the cache.
+	Restored int `json:"restored"`
+	// Number of entries the cache already had, which were kept as they were.
+	Skipped int `json:"skipped"`
+}
+
+// Write every entry the cache can serve to an artifact, eg. to be saved by a CI job, and restored with Restore on a fresh runner. The artifact holds plaintexts, just as the cache does, so it needs protecting just as well. Returns the number of entries written. Protected with a mutex.
+func (c *Cache) Export(w io.Writer) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	err := encoder.Encode(artifactHeader{artifactFormat, artifactVersion, c.scheme.name, c.fingerprint})
+	if err != nil {
+		return 0, fmt.Errorf("Error writing cache artifact: %w", err)
+	}
+	// newer generations win, so each key is only written from the newest one that has it
+	seen := map[string]bool{}
+	for _, s := range []store{c.fallbackStore(), c.young, c.old} {
+		if s == nil {
+			continue
+		}
+		keys, err := s.Keys()
+		if err != nil {
+			return len(seen), fmt.Errorf("Error reading cache: %w", err)
+		}
+		for _, key := range keys {
+			if seen[string(key)] {
+				continue
+			}
+			value, err := s.Get(key)
+			if err != nil {
+				return len(seen), fmt.Errorf("Error reading cache: %w", err)
+			}
+			err = encoder.Encode(artifactEntry{key, value})
+			if err != nil {
+				return len(seen), fmt.Errorf("Error writing cache artifact: %w", err)
+			}
+			seen[string(key)] = true
+		}
+	}
+	err = out.Flush()
+	if err != nil {
+		return len(seen), fmt.Errorf("Error writing cache artifact: %w", err)
+	}
+	return len(seen), nil
+}
+
+// Add the entries of an artifact written by Export to the young cache, keeping any entries the cache already has. The artifact is only trusted if it was exported under the same provider and keys as this cache, and with the same key scheme; otherwise nothing is restored. Protected with a mutex.
+func (c *Cache) Restore(r io.Reader) (RestoreReport, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	report := RestoreReport{}
+	if c.temporary {
+		return report, fmt.Errorf("Can't restore a cache artifact into a cache that's only kept in memory")
+	}
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	var header artifactHeader
+	err := decoder.Decode(&header)
+	if err != nil || header.Format != artifactFormat {
+		return report, fmt.Errorf("Not a cache artifact")
+	}
+	if header.Version < 1 || header.Version > artifactVersion {
+		return report, fmt.Errorf("Cache artifact has version %d, but only versions up to %d are supported", header.Version, artifactVersion)
+	}
+	if header.Scheme != c.scheme.name {
+		return report, fmt.Errorf("Cache artifact was written with key scheme %s rather than %s", header.Scheme, c.scheme.name)
+	}
+	if header.Fingerprint != c.fingerprint {
+		return report, fmt.Errorf("Cache artifact was exported for provider fingerprint %s, but the configured provider's is %s", header.Fingerprint, c.fingerprint)
+	}
+	// read every entry before adding any, so that a truncated or corrupt artifact doesn't leave the cache half restored
+	entries := []artifactEntry{}
+	for decoder.More() {
+		var entry artifactEntry
+		err = decoder.Decode(&entry)
+		if err != nil {
+			return report, fmt.Errorf("Error reading cache artifact: %w", err)
+		}
+		if _, _, ok := c.scheme.parseKey(entry.Key); !ok || len(entry.Value) == 0 {
+			return report, fmt.Errorf("Invalid entry in cache artifact")
+		}
+		if _, err = decodeEnvelope(entry.Value); err != nil {
+			return report, fmt.Errorf("Invalid entry in cache artifact: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	for _, entry := range entries {
+		if _, ok, err := c.lookup(entry.Key); err != nil {
+			return report, err
+		} else if ok {
+			report.Skipped++
+			continue
+		}
+		err = c.putRaw(entry.Key, entry.Value)
+		if err != nil {
+			return report, fmt.Errorf("Error adding item to cache: %w", err)
+		}
+		report.Restored++
+	}
+	if report.Restored > 0 {
+		c.touch(true)
+	}
+	return report, nil
+}
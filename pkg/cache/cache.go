@@ -1,22 +1,25 @@
 package cache
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
-	"github.com/prologic/bitcask"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"syscall"
+	"time"
 )
 
 const (
 	// Length to hash plaintext and ciphertext keys.
 	hashLength = 16
-	// Prefix for keys containing a hashed plaintext, used to look up ciphertext.
-	plaintextKeyPrefix = 'p'
-	// Prefix for keys containing a hashed ciphertext, used to look up plaintext.
-	ciphertextKeyPrefix = 'c'
+	// Length to hash the provider fingerprint that namespaces keys.
+	namespaceLength = 4
 	// Name of the directory to store the caches in
 	CacheDirName = ".yamlcrypt.cache"
 )
@@ -24,6 +27,9 @@ const (
 // Max young cache size: 100MiB by default (can be shrunk for tests)
 var YoungCacheSize int64 = 1024 * 1024 * 100
 
+// Where warnings are written.
+var Warnings io.Writer = os.Stderr
+
 // A quick and dirty "LRU-ish" cache.
 // Maintains a read/write "young" cache, and a read-only "old" cache.
 // New values are added to the "young" cache.
@@ -32,48 +38,200 @@ var YoungCacheSize int64 = 1024 * 1024 * 100
 // Getting and inserting values are protected with a mutex, making this safe for parallel access, if a bit of a drag.
 type Cache struct {
 	parentPath string
-	young      *bitcask.Bitcask
-	youngPath  string
-	old        *bitcask.Bitcask
-	oldPath    string
-	mutex      sync.Mutex
+	// If set, the cache is only kept for the duration of the session.
+	temporary bool
+	backend   string
+	maxSize   int64
+	// Prepended to every key, so that entries cached under a different provider or key aren't used.
+	namespace []byte
+	// The namespace for the provider alone, before any encryption context is mixed in.
+	providerNamespace []byte
+	// Fingerprint of the provider and keys that the provider's namespace is hashed from.
+	fingerprint string
+	// The scheme that keys are hashed with.
+	scheme    keyScheme
+	young     store
+	youngPath string
+	old       store
+	oldPath   string
+	mutex     sync.Mutex
+	// Number of shards the young bitcask store is split into.
+	shards int
+	// Held for reading while adding values to the young cache, and for writing while it's merged or closed.
+	storeMutex sync.RWMutex
+	// Set once the cache has been closed, so that closing it again is a no-op.
+	closed bool
+	// Set when the young cache has been written to since it was last merged.
+	dirty bool
+	// How long the cache must go unused before it's merged in the background, or zero for never.
+	mergeIdle time.Duration
+	lastUsed  time.Time
+	// Closed to stop the background merge, once it's been started.
+	stopMerge chan struct{}
+	// Holds new entries for the rest of the session, once writing to the young cache has failed.
+	fallback      store
+	fallbackMutex sync.Mutex
+	// If set, entries are only served once the provider has produced or confirmed them during this session.
+	verify bool
+	// Namespaced ciphertexts whose entries the provider has confirmed during this session.
+	verified map[string]bool
+	// Namespaced ciphertexts that decrypted to an empty plaintext during this session.
+	emptyPlaintexts map[string]bool
+	// Cache directory of another checkout to read entries from, if any.
+	sharedDir string
+	// The entries of the shared cache, once loaded.
+	shared store
 }
 
 // Initialize the cache.
-func Setup(config config.Config) (Cache, error) {
+func Setup(config config.Config) (*Cache, error) {
 	parentPath := filepath.Join(config.Root, CacheDirName)
-	cache := Cache{
-		parentPath: parentPath,
-		youngPath:  filepath.Join(parentPath, "young"),
-		oldPath:    filepath.Join(parentPath, CacheDirName, "old"),
+	fingerprint := crypto.Fingerprint(config.ProviderName, config.Provider)
+	scheme, err := configuredScheme(config)
+	if err != nil {
+		return nil, err
+	}
+	cache := &Cache{
+		parentPath:        parentPath,
+		backend:           config.CacheBackend,
+		maxSize:           YoungCacheSize,
+		providerNamespace: providerNamespace(fingerprint),
+		fingerprint:       fingerprint,
+		scheme:            scheme,
+		youngPath:         youngPath(parentPath),
+		oldPath:           oldPath(parentPath),
+		verify:            config.CacheVerify,
+		verified:          map[string]bool{},
+		emptyPlaintexts:   map[string]bool{},
+		shards:            int(config.CacheShards),
+		sharedDir:         config.CacheSharedDir,
+	}
+	cache.namespace = cache.providerNamespace
+	if cache.backend == "" {
+		cache.backend = BitcaskBackend
+	}
+	if cache.shards < 1 {
+		cache.shards = 1
+	}
+	if config.CacheMaxSize > 0 {
+		cache.maxSize = config.CacheMaxSize
+	}
+	cache.mergeIdle = config.CacheMergeIdle
+	if !config.CacheEnabled {
+		// the cache is still needed during the session, so keep it in memory, where it'll disappear afterwards
+		cache.useMemory()
+		return cache, nil
+	}
+	switch cache.backend {
+	case MemoryBackend:
+		generations := getMemoryGenerations(cache.parentPath)
+		cache.young = generations.young
+		cache.old = generations.old
+		cache.useShared()
+		return cache, nil
+	case BitcaskBackend:
+		err := cache.openBitcask()
+		if err != nil && readOnly(err) {
+			// the cache is only an optimization, so a read-only checkout can still be decrypted without one
+			fmt.Fprintf(Warnings, "Warning: can't write to the cache, so it'll only be kept in memory for this session: %s\n", err)
+			cache.useMemory()
+			return cache, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cache.useShared()
+		return cache, nil
+	default:
+		return nil, fmt.Errorf("No cache backend named %s", cache.backend)
 	}
-	err := os.Mkdir(cache.parentPath, 0o700)
+}
+
+// Open the young and old bitcask caches.
+func (c *Cache) openBitcask() error {
+	err := os.Mkdir(c.parentPath, 0o700)
 	if err != nil && !os.IsExist(err) {
-		return cache, fmt.Errorf("Error creating new cache: %w", err)
+		return fmt.Errorf("Error creating new cache: %w", err)
+	}
+	scheme, recorded, err := readScheme(c.parentPath, c.scheme)
+	if err != nil {
+		return fmt.Errorf("Error reading cache scheme: %w", err)
+	}
+	if scheme != c.scheme.name {
+		// none of its keys would match, and mixing in keys hashed differently would make it impossible to migrate, so leave it alone for this session
+		fmt.Fprintf(Warnings, "Warning: the cache was written with key scheme %s rather than %s, so it won't be used until it's migrated with `yaml-crypt cache migrate`\n", scheme, c.scheme.name)
+		c.useMemory()
+		return nil
+	}
+	if !recorded {
+		err = writeScheme(c.parentPath, c.scheme)
+		if err != nil {
+			return fmt.Errorf("Error recording cache scheme: %w", err)
+		}
+	}
+	err = finishRotation(c.youngPath, c.oldPath)
+	if err != nil {
+		return fmt.Errorf("Error finishing interrupted cache rotation: %w", err)
+	}
+	// a young cache split into a different number of shards is rotated early, so that the configured number takes effect right away, without losing its entries
+	youngShards, err := bitcaskShards(c.youngPath)
+	if err != nil {
+		return fmt.Errorf("Error opening \"young\" cache: %w", err)
+	}
+	if youngShards != 0 && youngShards != c.shards {
+		err = rotate(c.youngPath, c.oldPath)
+		if err != nil {
+			return err
+		}
 	}
-	cache.young, err = bitcask.Open(
-		cache.youngPath,
-		bitcask.WithAutoRecovery(true),
-	)
+	c.young, err = openShardedBitcaskStore(c.youngPath, c.shards)
 	if err != nil {
-		return cache, fmt.Errorf("Error opening \"young\" cache: %w", err)
+		return fmt.Errorf("Error opening \"young\" cache: %w", err)
+	}
+	// the old cache is read as it was written, whatever the number of shards is now
+	oldShards, err := bitcaskShards(c.oldPath)
+	if err == nil {
+		c.old, err = openShardedBitcaskStore(c.oldPath, oldShards)
 	}
-	cache.old, err = bitcask.Open(
-		cache.oldPath,
-		bitcask.WithAutoRecovery(true),
-	)
 	if err != nil {
-		return cache, fmt.Errorf("Error opening \"old\" cache: %w", err)
+		c.young.Close()
+		return fmt.Errorf("Error opening \"old\" cache: %w", err)
 	}
-	return cache, err
+	return nil
+}
+
+// Keep the cache in memory only, for the rest of the session.
+func (c *Cache) useMemory() {
+	c.temporary = true
+	c.young = newMemoryStore()
+	c.old = newMemoryStore()
 }
 
-// Close the cache, doing some cleanup as well. Must be called before exiting
+// Whether an error means that the on-disk cache can't be written to at all, eg. because the repo was checked out read-only.
+func readOnly(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// Close the cache, doing some cleanup as well. Must be called before exiting. Closing an already-closed cache does nothing.
 func (c *Cache) Close() error {
-	// we only need to merge young, because old is read-only
-	mergeErr := c.young.Merge()
-	stats, statsErr := c.young.Stats()
-	// we want to close if at all possible, so we'll handle merge/stats errors later
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.stopMerge != nil {
+		close(c.stopMerge)
+	}
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	// we only need to merge young, because old is read-only. If it was merged in the background since it was last written to, there's nothing left to reclaim.
+	var mergeErr error
+	if c.dirty {
+		mergeErr = c.young.Merge()
+	}
+	size, sizeErr := c.young.Size()
+	// we want to close if at all possible, so we'll handle merge/size errors later
 	err := c.young.Close()
 	if err != nil {
 		return fmt.Errorf("Error closing \"young\" cache: %w", err)
@@ -82,110 +240,452 @@ func (c *Cache) Close() error {
 	if err != nil {
 		return fmt.Errorf("Error closing \"old\" cache: %w", err)
 	}
+	if c.temporary {
+		return nil
+	}
 	if mergeErr != nil {
 		return fmt.Errorf("Error merging \"young\" cache: %w", mergeErr)
 	}
-	if statsErr != nil {
-		return fmt.Errorf("Error getting cache stats: %w", mergeErr)
+	if sizeErr != nil {
+		return fmt.Errorf("Error getting cache size: %w", sizeErr)
 	}
 	// if the young cache size is too big, get rid of the old cache and make the young cache take its place.
-	if stats.Size > YoungCacheSize {
-		err := os.RemoveAll(c.oldPath)
-		if err != nil {
-			return fmt.Errorf("Error deleting \"old\" cache: %w", err)
-		}
-		err = os.Rename(c.youngPath, c.oldPath)
-		if err != nil {
-			return fmt.Errorf("Error demoting \"young\" to \"old\" cache: %w", err)
+	if size > c.maxSize {
+		if c.backend == MemoryBackend {
+			rotateMemoryGenerations(c.parentPath)
+			return nil
 		}
+		return rotate(c.youngPath, c.oldPath)
+	}
+	return nil
+}
+
+// Get rid of the old bitcask cache, and make the young cache take its place, along with all of its shards.
+func rotate(youngPath, oldPath string) error {
+	err := os.RemoveAll(oldPath)
+	if err != nil {
+		return fmt.Errorf("Error deleting \"old\" cache: %w", err)
+	}
+	err = os.Rename(youngPath, oldPath)
+	if err != nil {
+		return fmt.Errorf("Error demoting \"young\" to \"old\" cache: %w", err)
 	}
 	return nil
 }
 
-// Look up the ciphertext for a given plaintext. Protected with a mutex.
-func (c *Cache) Encrypt(plaintext string, potentialCiphertext []byte) ([]byte, bool, error) {
+// The outcome of trimming a cache.
+type TrimReport struct {
+	// Size of the young cache, in bytes, before and after it was merged.
+	Size   int64 `json:"size"`
+	Merged int64 `json:"merged"`
+	// Size above which the young cache is rotated.
+	MaxSize int64 `json:"max_size"`
+	// Whether the young cache took the old cache's place, with a new, empty young cache started.
+	Rotated bool `json:"rotated"`
+}
+
+// Merge and, if needed, rotate the young cache right away, as Close would. Protected with a mutex.
+func (c *Cache) Trim() (TrimReport, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	report := TrimReport{MaxSize: c.maxSize}
+	if c.closed {
+		return report, fmt.Errorf("Can't trim a closed cache")
+	}
+	var err error
+	report.Size, err = c.young.Size()
+	if err != nil {
+		return report, fmt.Errorf("Error getting cache size: %w", err)
+	}
+	err = c.young.Merge()
+	if err != nil {
+		return report, fmt.Errorf("Error merging \"young\" cache: %w", err)
+	}
+	c.dirty = false
+	report.Merged, err = c.young.Size()
+	if err != nil {
+		return report, fmt.Errorf("Error getting cache size: %w", err)
+	}
+	if c.temporary || report.Merged <= c.maxSize {
+		return report, nil
+	}
+	if c.backend == MemoryBackend {
+		rotateMemoryGenerations(c.parentPath)
+		generations := getMemoryGenerations(c.parentPath)
+		c.young = generations.young
+		c.old = generations.old
+		c.useShared()
+		report.Rotated = true
+		return report, nil
+	}
+	// the stores can't be used again until they're reopened, so the cache is closed for good if anything goes wrong
+	c.closed = true
+	err = c.young.Close()
+	if err != nil {
+		return report, fmt.Errorf("Error closing \"young\" cache: %w", err)
+	}
+	err = c.old.Close()
+	if err != nil {
+		return report, fmt.Errorf("Error closing \"old\" cache: %w", err)
+	}
+	err = rotate(c.youngPath, c.oldPath)
+	if err != nil {
+		return report, err
+	}
+	c.young, err = openShardedBitcaskStore(c.youngPath, c.shards)
+	if err != nil {
+		return report, fmt.Errorf("Error opening \"young\" cache: %w", err)
+	}
+	oldShards, err := bitcaskShards(c.oldPath)
+	if err == nil {
+		c.old, err = openShardedBitcaskStore(c.oldPath, oldShards)
+	}
+	if err != nil {
+		c.young.Close()
+		return report, fmt.Errorf("Error opening \"old\" cache: %w", err)
+	}
+	c.useShared()
+	c.closed = false
+	report.Rotated = true
+	return report, nil
+}
+
+// Get the path of the young bitcask cache, in the given cache directory.
+func youngPath(parentPath string) string {
+	return filepath.Join(parentPath, "young")
+}
+
+// Get the path of the old bitcask cache, in the given cache directory.
+func oldPath(parentPath string) string {
+	return filepath.Join(parentPath, CacheDirName, "old")
+}
 
-	// if the potentialCiphertext is in the cache, and has a plaintext equal to the plaintext being encrypted, that's the ciphertext!
-	if len(potentialCiphertext) > 0 {
-		potentialCiphertextPlaintext, ok, err := c.get(ciphertextToKey(potentialCiphertext))
+// Finish a rotation that was interrupted after the old cache was deleted, but before the young cache took its place.
+func finishRotation(youngPath, oldPath string) error {
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(youngPath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintln(Warnings, "Warning: finishing a cache rotation that was interrupted")
+	err := os.MkdirAll(filepath.Dir(oldPath), 0o700)
+	if err != nil {
+		return err
+	}
+	return os.Rename(youngPath, oldPath)
+}
+
+// Merge the young cache whenever the cache has gone unused for mergeIdle. Must be called with the mutex held.
+func (c *Cache) startBackgroundMerge() {
+	if c.mergeIdle <= 0 || c.stopMerge != nil {
+		return
+	}
+	c.stopMerge = make(chan struct{})
+	go func(stop chan struct{}) {
+		for {
+			c.mutex.Lock()
+			wait := c.mergeIdle - time.Since(c.lastUsed)
+			if wait <= 0 && c.dirty && !c.closed {
+				// an error here isn't fatal, since the cache is merged again on close
+				c.storeMutex.Lock()
+				if c.young.Merge() == nil {
+					c.dirty = false
+				}
+				c.storeMutex.Unlock()
+				wait = c.mergeIdle
+			} else if wait <= 0 {
+				wait = c.mergeIdle
+			}
+			c.mutex.Unlock()
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}(c.stopMerge)
+}
+
+// Note that the cache was just used, and whether the young cache was written to. Must be called with the mutex held.
+func (c *Cache) touch(written bool) {
+	c.lastUsed = time.Now()
+	if written {
+		c.dirty = true
+		c.startBackgroundMerge()
+	}
+}
+
+// Scope all further lookups and additions to the given encryption context, or the provider's own namespace if it's empty.
+func (c *Cache) SetContext(context string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if context == "" {
+		c.namespace = c.providerNamespace
+		return
+	}
+	c.namespace = deriveNamespace(c.providerNamespace, contextNamespace, context)
+}
+
+// Get the namespace of plaintext keys for ciphertexts encrypted by the named provider, in the given scope.
+func (c *Cache) plaintextNamespace(providerName string, scope string) []byte {
+	namespace := plaintextNamespace(c.namespace, providerName)
+	if scope != "" {
+		namespace = deriveNamespace(namespace, fileNamespace, scope)
+	}
+	return namespace
+}
+
+// Get the namespace of plaintext keys for ciphertexts encrypted by the named provider, given the namespace of ciphertext keys.
+func plaintextNamespace(namespace []byte, providerName string) []byte {
+	if providerName == "" {
+		return namespace
+	}
+	return deriveNamespace(namespace, providerNameNamespace, providerName)
+}
+
+// Look up the ciphertext for a given plaintext, encrypted by the named provider, in the given scope. Protected with a mutex.
+func (c *Cache) Encrypt(plaintext string, potentialCiphertext []byte, providerName string, scope string) ([]byte, bool, error) {
+	// empty values are never cached, see add
+	if plaintext == "" {
+		return []byte{}, false, nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// if the potentialCiphertext is in the cache, was encrypted by the current provider, and has a plaintext equal to the plaintext being encrypted, that's the ciphertext!
+	if len(potentialCiphertext) > 0 && crypto.Marker(potentialCiphertext) == providerName {
+		potentialCiphertextPlaintext, ok, err := c.get(c.ciphertextToKey(c.namespace, potentialCiphertext), potentialCiphertext)
 		if err != nil {
 			return []byte{}, false, fmt.Errorf("Error looking up potentialCiphertext in cache: %w", err)
 		}
-		if ok && string(potentialCiphertextPlaintext) == plaintext {
+		if ok && string(potentialCiphertextPlaintext) == plaintext && c.trusted(potentialCiphertext) {
 			return potentialCiphertext, ok, nil
 		}
 	}
 	// potentialCiphertext wasn't it, so return an arbitrary ciphertext that encrypts the given plaintext.
-	ciphertext, ok, err := c.get(plaintextToKey(plaintext))
+	ciphertext, ok, err := c.get(c.plaintextToKey(c.plaintextNamespace(providerName, scope), plaintext), []byte(plaintext))
 	if err != nil {
 		return []byte{}, false, fmt.Errorf("Error looking up plaintext in cache: %w", err)
 	}
+	if ok && !c.trusted(ciphertext) {
+		return []byte{}, false, nil
+	}
 	return ciphertext, ok, err
 }
 
-// Look up the plaintext for a given ciphertext. Protected with a mutex.
+// Look up the plaintext for a given ciphertext, if it can be served. Protected with a mutex.
 func (c *Cache) Decrypt(ciphertext []byte) (string, bool, error) {
+	plaintext, ok, err := c.Cached(ciphertext)
+	if ok && !c.trusted(ciphertext) {
+		return "", false, err
+	}
+	return plaintext, ok, err
+}
+
+// Look up the plaintext cached for a given ciphertext, even if it's unconfirmed. Protected with a mutex.
+func (c *Cache) Cached(ciphertext []byte) (string, bool, error) {
+	// empty values are never cached, see add
+	if len(ciphertext) == 0 {
+		return "", false, nil
+	}
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	plaintext, ok, err := c.get(ciphertextToKey(ciphertext))
+	if c.emptyPlaintexts[string(c.namespace)+string(ciphertext)] {
+		return "", true, nil
+	}
+	plaintext, ok, err := c.get(c.ciphertextToKey(c.namespace, ciphertext), ciphertext)
 	if err != nil {
 		err = fmt.Errorf("Error looking up ciphertext in cache: %w", err)
 	}
 	return string(plaintext), ok, err
 }
 
-// Add a (plaintext, ciphertext) pair to the young cache. Protected with a mutex.
-func (c *Cache) Add(plaintext string, ciphertext []byte) error {
+// Whether an entry for a ciphertext can be served, given verify mode.
+func (c *Cache) trusted(ciphertext []byte) bool {
+	return !c.verify || c.verified[string(c.namespace)+string(ciphertext)]
+}
+
+// Remove a ciphertext and its plaintext's entry from every generation of the cache. Protected with a mutex.
+func (c *Cache) Remove(ciphertext []byte) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	err := c.add(plaintext, ciphertext)
+	ciphertextKey := c.ciphertextToKey(c.namespace, ciphertext)
+	plaintext, ok, err := c.get(ciphertextKey, ciphertext)
 	if err != nil {
-		return fmt.Errorf("Error adding item to cache: %w", err)
+		return fmt.Errorf("Error looking up ciphertext in cache: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	keys := [][]byte{ciphertextKey}
+	plaintextKey := c.plaintextToKey(c.plaintextNamespace(crypto.Marker(ciphertext), ""), string(plaintext))
+	if value, ok, err := c.get(plaintextKey, plaintext); err == nil && ok && string(value) == string(ciphertext) {
+		keys = append(keys, plaintextKey)
 	}
+	for _, s := range []store{c.fallbackStore(), c.young, c.old} {
+		if s == nil {
+			continue
+		}
+		for _, key := range keys {
+			if s.Has(key) {
+				err = s.Delete(key)
+				if err != nil {
+					return fmt.Errorf("Error removing item from cache: %w", err)
+				}
+			}
+		}
+	}
+	c.touch(true)
 	return nil
 }
 
 // Add a (plaintext, ciphertext) pair to the young cache.
-func (c *Cache) add(plaintext string, ciphertext []byte) error {
-	err := c.young.Put(plaintextToKey(plaintext), ciphertext)
+func (c *Cache) Add(plaintext string, ciphertext []byte) error {
+	return c.AddScoped(plaintext, ciphertext, "")
+}
+
+// Add a (plaintext, ciphertext) pair to the young cache, with the plaintext's entry in the given scope.
+func (c *Cache) AddScoped(plaintext string, ciphertext []byte, scope string) error {
+	c.mutex.Lock()
+	entries := c.add(plaintext, ciphertext, scope)
+	c.mutex.Unlock()
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	for _, entry := range entries {
+		err := c.put(entry.key, entry.data, entry.value)
+		if err != nil {
+			return fmt.Errorf("Error adding item to cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// Remember for the rest of the session that a ciphertext decrypts to an empty plaintext. Protected with a mutex.
+func (c *Cache) AddEmpty(ciphertext []byte) {
+	if len(ciphertext) == 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.emptyPlaintexts[string(c.namespace)+string(ciphertext)] = true
+	if c.verify {
+		c.verified[string(c.namespace)+string(ciphertext)] = true
+	}
+}
+
+// A key and value to write to the cache, along with the data the key was hashed from.
+type entry struct {
+	key   []byte
+	data  []byte
+	value []byte
+}
+
+// Get the entries to write to the young cache for a (plaintext, ciphertext) pair, skipping empty values. Must be called with the mutex held.
+func (c *Cache) add(plaintext string, ciphertext []byte, scope string) []entry {
+	if plaintext == "" || len(ciphertext) == 0 {
+		return nil
+	}
+	ciphertextKey := c.ciphertextToKey(c.namespace, ciphertext)
+	if c.verify {
+		cached, ok, err := c.get(ciphertextKey, ciphertext)
+		if err == nil && ok && string(cached) != plaintext {
+			fmt.Fprintln(Warnings, "Warning: replacing a cached plaintext that the provider doesn't decrypt its ciphertext to")
+		}
+		c.verified[string(c.namespace)+string(ciphertext)] = true
+	}
+	c.touch(true)
+	return []entry{
+		{c.plaintextToKey(c.plaintextNamespace(crypto.Marker(ciphertext), scope), plaintext), []byte(plaintext), ciphertext},
+		{ciphertextKey, ciphertext, []byte(plaintext)},
+	}
+}
+
+// Look up the value of an entry, unwrapped from its envelope, given the data its key was hashed from.
+func (c *Cache) get(key, data []byte) ([]byte, bool, error) {
+	raw, ok, err := c.lookup(key)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	e, err := decodeEnvelope(raw)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+	if c.scheme.checked && !bytes.Equal(e.Data, data) {
+		return nil, false, nil
 	}
-	return c.young.Put(ciphertextToKey(ciphertext), []byte(plaintext))
+	return e.Value, true, nil
 }
 
-func (c *Cache) get(key []byte) (value []byte, ok bool, err error) {
-	if c.young.Has(key) {
-		value, err = c.young.Get(key)
+// Look up an entry's value as it's stored, copying it from the old cache to the young cache if needed.
+func (c *Cache) lookup(key []byte) (raw []byte, ok bool, err error) {
+	c.touch(false)
+	if fallback := c.fallbackStore(); fallback != nil && fallback.Has(key) {
+		raw, err = fallback.Get(key)
+		ok = true
+	} else if c.young.Has(key) {
+		raw, err = c.young.Get(key)
 		ok = true
 	} else if c.old.Has(key) {
-		value, err = c.old.Get(key)
+		raw, err = c.old.Get(key)
 		if err != nil {
 			err = fmt.Errorf("Error getting cache entry: %w", err)
 			return
 		}
 		ok = true
-		err = c.young.Put(key, value)
+		c.touch(true)
+		err = c.putRaw(key, raw)
 	}
 	return
 }
 
+// Write an entry to the young cache, wrapping its value in an envelope.
+func (c *Cache) put(key, data, value []byte) error {
+	e := envelope{Written: time.Now(), Value: value}
+	if c.scheme.checked {
+		e.Data = data
+	}
+	return c.putRaw(key, encodeEnvelope(e))
+}
+
+// Write an entry to the young cache as it's to be stored, falling back to memory if that fails.
+func (c *Cache) putRaw(key, value []byte) error {
+	if fallback := c.fallbackStore(); fallback != nil {
+		return fallback.Put(key, value)
+	}
+	err := c.young.Put(key, value)
+	if err == nil {
+		return nil
+	}
+	c.fallbackMutex.Lock()
+	if c.fallback == nil {
+		fmt.Fprintf(Warnings, "Warning: error writing to cache, new entries won't be persisted: %s\n", err)
+		c.fallback = newMemoryStore()
+	}
+	fallback := c.fallback
+	c.fallbackMutex.Unlock()
+	return fallback.Put(key, value)
+}
+
+// Get the store holding new entries in place of the young cache, or nil if writing to the young cache hasn't failed.
+func (c *Cache) fallbackStore() store {
+	c.fallbackMutex.Lock()
+	defer c.fallbackMutex.Unlock()
+	return c.fallback
+}
+
 // Convert a ciphertext to the key used to lookup its plaintext.
-func ciphertextToKey(data []byte) []byte {
-	key := make([]byte, 1, hashLength+1)
-	key[0] = ciphertextKeyPrefix
-	key = append(key, hash(data)...)
-	return key
+func (c *Cache) ciphertextToKey(namespace []byte, data []byte) []byte {
+	return c.scheme.key(ciphertextKind, namespace, data)
 }
 
 // Convert a plaintext to the key used to lookup its ciphertext.
-func plaintextToKey(data string) []byte {
-	key := make([]byte, 1, hashLength+1)
-	key[0] = plaintextKeyPrefix
-	key = append(key, hash([]byte(data))...)
-	return key
+func (c *Cache) plaintextToKey(namespace []byte, data string) []byte {
+	return c.scheme.key(plaintextKind, namespace, []byte(data))
 }
 
 // Hash some bytes, truncating the length to the hashLength constant.
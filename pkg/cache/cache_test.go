@@ -2,14 +2,41 @@ package cache
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
+	"github.com/prologic/bitcask"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestCache(t *testing.T) {
+	// the memory backend should behave the same as the bitcask backend within a process
+	for _, backend := range []string{BitcaskBackend, MemoryBackend} {
+		t.Run(backend, func(t *testing.T) {
+			testCache(t, backend, "")
+		})
+	}
+	// and so should every key scheme
+	t.Run(XXHKeyScheme, func(t *testing.T) {
+		testCache(t, BitcaskBackend, XXHKeyScheme)
+	})
+}
+
+func testCache(t *testing.T, backend, scheme string) {
 	// make the cache a lot smaller to make it quicker to test LRU behavior
 	YoungCacheSize = 100000
 	// check out an arbitrary repo in order to provide a directory and config for the cache
@@ -27,12 +54,14 @@ func TestCache(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	config.CacheBackend = backend
+	config.CacheKeyScheme = scheme
 	// setup cache, check for non-existent items
 	cache, err := Setup(config)
 	if err != nil {
 		t.Fatal(err)
 	}
-	getItems(t, &cache, 0, false)
+	getItems(t, cache, 0, false)
 	err = cache.Close()
 	if err != nil {
 		t.Fatal(err)
@@ -44,10 +73,10 @@ func TestCache(t *testing.T) {
 			t.Fatal(err)
 		}
 		// put this round's items
-		putItems(t, &cache, round)
+		putItems(t, cache, round)
 		// get this round's and the 4 previous rounds' items
 		for prevRound := round; prevRound >= round-4 && prevRound >= 0; prevRound-- {
-			getItems(t, &cache, prevRound, true)
+			getItems(t, cache, prevRound, true)
 		}
 		err = cache.Close()
 		if err != nil {
@@ -59,9 +88,9 @@ func TestCache(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	getItems(t, &cache, 0, false)
-	getItems(t, &cache, 1, false)
-	getItems(t, &cache, 19, true)
+	getItems(t, cache, 0, false)
+	getItems(t, cache, 1, false)
+	getItems(t, cache, 19, true)
 	err = cache.Close()
 	if err != nil {
 		t.Fatal(err)
@@ -102,7 +131,7 @@ func putItems(t *testing.T, cache *Cache, round int) {
 func getItems(t *testing.T, cache *Cache, round int, shouldSucceed bool) {
 	for item := 0; item < 100; item++ {
 		for version := 0; item < 3; item++ {
-			ct, ok, err := cache.Encrypt(plaintext(round, item), versionedCiphertext(round, item, version))
+			ct, ok, err := cache.Encrypt(plaintext(round, item), versionedCiphertext(round, item, version), "", "")
 			if err != nil {
 				t.Error(err.Error())
 			}
@@ -134,7 +163,7 @@ func getItems(t *testing.T, cache *Cache, round int, shouldSucceed bool) {
 		}
 		if shouldSucceed {
 			// try encrypting with an invalid possibleCiphertext. Result should be an arbitrary valid ciphertext.
-			ct, ok, err := cache.Encrypt(plaintext(round, item), []byte("invalid ciphertext"))
+			ct, ok, err := cache.Encrypt(plaintext(round, item), []byte("invalid ciphertext"), "", "")
 			if err != nil {
 				t.Error(err.Error())
 			}
@@ -146,3 +175,1593 @@ func getItems(t *testing.T, cache *Cache, round int, shouldSucceed bool) {
 		}
 	}
 }
+
+func TestDisabledCache(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheEnabled = false
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// values must still be available for the duration of the session
+	putItems(t, cache, 0)
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(config.Root, CacheDirName)); !os.IsNotExist(err) {
+		t.Errorf("Cache directory was created despite the cache being disabled")
+	}
+	// nothing should be persisted for the next session either
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, false)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same provider and key: hits
+	cache, err = Setup(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// different key: misses
+	changedKey := original
+	changedKey.ProviderName = "google"
+	changedKey.Provider = crypto.GoogleProvider{Project: "project", Location: "global", Keyring: "keyring", Key: "key"}
+	cache, err = Setup(changedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, false)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedKey.Provider = crypto.GoogleProvider{Project: "project", Location: "global", Keyring: "keyring", Key: "other key"}
+	cache, err = Setup(changedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, false)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the original entries are still there for the original provider
+	cache, err = Setup(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheBackend = MemoryBackend
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(config.Root, CacheDirName)); !os.IsNotExist(err) {
+		t.Errorf("Cache directory was created despite using the memory backend")
+	}
+
+	config.CacheBackend = "invalid"
+	_, err = Setup(config)
+	if err == nil {
+		t.Errorf("Setting up a cache with an invalid backend did not fail")
+	}
+}
+
+func TestDoubleClose(t *testing.T) {
+	for _, backend := range []string{BitcaskBackend, MemoryBackend} {
+		t.Run(backend, func(t *testing.T) {
+			repos, err := fixtures.Repos()
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo := repos[0]
+			err = repo.Setup()
+			defer repo.Destroy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := config.LoadConfig(".")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.CacheBackend = backend
+			cache, err := Setup(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putItems(t, cache, 0)
+			err = cache.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cache.Close()
+			if err != nil {
+				t.Errorf("Closing the cache a second time failed: %s", err)
+			}
+		})
+	}
+}
+
+// A store that counts how many times it's been merged.
+type mergeCountingStore struct {
+	store
+	mutex  sync.Mutex
+	merges int
+}
+
+func (s *mergeCountingStore) Merge() error {
+	s.mutex.Lock()
+	s.merges++
+	s.mutex.Unlock()
+	return s.store.Merge()
+}
+
+func (s *mergeCountingStore) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.merges
+}
+
+func TestBackgroundMerge(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheMergeIdle = 10 * time.Millisecond
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	young := &mergeCountingStore{store: cache.young}
+	cache.young = young
+	putItems(t, cache, 0)
+	// wait for the cache to go idle and get merged in the background
+	for i := 0; young.count() == 0; i++ {
+		if i > 100 {
+			cache.Close()
+			t.Fatal("Cache was not merged in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	getItems(t, cache, 0, true)
+	start := time.Now()
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Closed cache in %s", time.Since(start))
+	if young.count() != 1 {
+		t.Errorf("Cache was merged again on close, despite being merged ahead of time")
+	}
+}
+
+func TestEmptyValues(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for _, pair := range []struct {
+		plaintext  string
+		ciphertext []byte
+	}{
+		{"", []byte("ciphertext of empty")},
+		{"plaintext of empty", []byte{}},
+	} {
+		err = cache.Add(pair.plaintext, pair.ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err := cache.Encrypt(pair.plaintext, []byte{}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Errorf("Plaintext %q was served from the cache, despite being paired with an empty value", pair.plaintext)
+		}
+		_, ok, err = cache.Decrypt(pair.ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Errorf("Ciphertext %q was served from the cache, despite being paired with an empty value", pair.ciphertext)
+		}
+	}
+}
+
+// A store that fails every write, like one on a full disk.
+type failingStore struct {
+	store
+}
+
+func (s failingStore) Put(key, value []byte) error {
+	return fmt.Errorf("no space left on device")
+}
+
+func TestWriteFailure(t *testing.T) {
+	defer func() { Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	Warnings = warnings
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.young = failingStore{cache.young}
+	// values must still be available for the rest of the session
+	putItems(t, cache, 0)
+	getItems(t, cache, 0, true)
+	if !strings.Contains(warnings.String(), "no space left on device") {
+		t.Errorf("Failing to write to the cache did not warn: %q", warnings)
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterruptedRotation(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheBackend = BitcaskBackend
+	defer func() { Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	Warnings = warnings
+
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupted after the old cache was deleted, before the young cache was renamed
+	err = os.RemoveAll(cache.oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatalf("Setting up a half-rotated cache failed: %s", err)
+	}
+	if !strings.Contains(warnings.String(), "interrupted") {
+		t.Errorf("Finishing an interrupted rotation did not warn: %q", warnings)
+	}
+	if _, err := os.Stat(cache.oldPath); err != nil {
+		t.Errorf("Old cache was not restored: %s", err)
+	}
+	// the young cache's entries were kept, by it taking the old cache's place
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupted partway through deleting the old cache, leaving it unreadable
+	err = os.RemoveAll(cache.oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(cache.oldPath, []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings.Reset()
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatalf("Setting up a cache with an unreadable old cache failed: %s", err)
+	}
+	if !strings.Contains(warnings.String(), "starting the cache at") {
+		t.Errorf("Starting the old cache over did not warn: %q", warnings)
+	}
+	putItems(t, cache, 1)
+	getItems(t, cache, 1, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a half-written config is corrupt too
+	err = ioutil.WriteFile(filepath.Join(cache.oldPath, "config.json"), []byte("{"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings.Reset()
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatalf("Setting up a cache with a half-written config failed: %s", err)
+	}
+	if !strings.Contains(warnings.String(), "starting the cache at") {
+		t.Errorf("Starting a cache with a half-written config over did not warn: %q", warnings)
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// but a store that can't be opened for any other reason is left alone
+	for _, err := range []error{
+		bitcask.ErrDatabaseLocked,
+		&os.PathError{Op: "open", Path: cache.oldPath, Err: syscall.EACCES},
+		&os.PathError{Op: "mkdir", Path: cache.oldPath, Err: syscall.EROFS},
+	} {
+		if isCorruptBitcask(err) {
+			t.Errorf("Error %q was taken to mean the store is corrupt", err)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheBackend = BitcaskBackend
+	config.CacheShards = 4
+
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := bitcaskShards(cache.youngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shards != 4 {
+		t.Errorf("Expected the young cache to be split into 4 shards, got %d", shards)
+	}
+
+	// changing the number of shards rotates the young cache into the old one, keeping its entries
+	config.CacheShards = 2
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]int{cache.youngPath: 2, cache.oldPath: 4} {
+		shards, err := bitcaskShards(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shards != expected {
+			t.Errorf("Expected %s to be split into %d shards, got %d", path, expected, shards)
+		}
+	}
+
+	// rotating by size demotes every shard along with the young cache
+	config.CacheMaxSize = 1
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 1)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheMaxSize = 0
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	getItems(t, cache, 1, true)
+}
+
+func TestXXH64(t *testing.T) {
+	// from the reference implementation, covering inputs shorter and longer than a stripe, and every length of tail
+	for data, expected := range map[string]uint64{
+		"":             0xef46db3751d8e999,
+		"hello, world": 0xb33a384e6d1b1242,
+		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$": 0x1032d841e824f998,
+	} {
+		if sum := binary.BigEndian.Uint64(xxh64([]byte(data))); sum != expected {
+			t.Errorf("XXH64 of %q is %#x, expected %#x", data, sum, expected)
+		}
+	}
+}
+
+func TestCheckedScheme(t *testing.T) {
+	// every key collides under these schemes, as keys might by chance, or by design, under a fast hash
+	for _, checked := range []bool{true, false} {
+		colliding := keyScheme{fmt.Sprintf("colliding-%t", checked), func([]byte) []byte { return []byte{0} }, checked}
+		knownSchemes[colliding.name] = colliding
+		defer delete(knownSchemes, colliding.name)
+		cache, err := Setup(config.Config{Provider: crypto.NoopProvider{}, CacheKeyScheme: colliding.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cache.Add("first plaintext", []byte("first ciphertext"))
+		if err == nil {
+			err = cache.Add("second plaintext", []byte("second ciphertext"))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrong := 0
+		if ciphertext, ok, _ := cache.Encrypt("first plaintext", nil, "", ""); ok && string(ciphertext) != "first ciphertext" {
+			wrong++
+		}
+		if plaintext, ok, _ := cache.Decrypt([]byte("first ciphertext")); ok && plaintext != "first plaintext" {
+			wrong++
+		}
+		if checked && wrong > 0 {
+			t.Errorf("Cache served %d wrong values for colliding keys, though its key scheme is checked", wrong)
+		} else if !checked && wrong == 0 {
+			t.Errorf("Cache served no wrong values for colliding keys with an unchecked key scheme, so the test doesn't test anything")
+		}
+		// the entries the collisions overwrote are served as ever
+		if ciphertext, ok, _ := cache.Encrypt("second plaintext", nil, "", ""); !ok || string(ciphertext) != "second ciphertext" {
+			t.Errorf("Cache gave ciphertext %q, %t for the last plaintext added", ciphertext, ok)
+		}
+		if plaintext, ok, _ := cache.Decrypt([]byte("second ciphertext")); !ok || plaintext != "second plaintext" {
+			t.Errorf("Cache gave plaintext %q, %t for the last ciphertext added", plaintext, ok)
+		}
+		cache.Close()
+	}
+
+	if _, err := Setup(config.Config{Provider: crypto.NoopProvider{}, CacheKeyScheme: "md5"}); err == nil {
+		t.Error("Setting up a cache with an unknown key scheme didn't fail")
+	}
+}
+
+func TestMigrateCheckedScheme(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// there and back again, checking the entries each time
+	for _, scheme := range []string{XXHKeyScheme, SHA256KeyScheme} {
+		config.CacheKeyScheme = scheme
+		migration, err := Migrate(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration.To != scheme || migration.Migrated != 100 {
+			t.Errorf("Migrating to %s gave %+v", scheme, migration)
+		}
+		cache, err = Setup(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for item := 0; item < 100; item++ {
+			// only the latest ciphertext of each plaintext is carried over
+			latest := versionedCiphertext(0, item, 2)
+			if ciphertext, ok, err := cache.Encrypt(plaintext(0, item), nil, "", ""); err != nil || !ok || !bytes.Equal(ciphertext, latest) {
+				t.Errorf("After migrating to %s, cache gave ciphertext %q, %t, %v for %q", scheme, ciphertext, ok, err, plaintext(0, item))
+			}
+			if pt, ok, err := cache.Decrypt(latest); err != nil || !ok || pt != plaintext(0, item) {
+				t.Errorf("After migrating to %s, cache gave plaintext %q, %t, %v for %q", scheme, pt, ok, err, latest)
+			}
+		}
+		err = cache.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Compare the time taken to hash keys under each key scheme, for values the size of a typical secret, and of a certificate, along with a cache lookup of each. Run with eg. `go test -bench Scheme ./pkg/cache`.
+func BenchmarkKeyScheme(b *testing.B) {
+	for _, size := range []int{32, 4096} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		for _, name := range []string{SHA256KeyScheme, XXHKeyScheme} {
+			scheme := knownSchemes[name]
+			b.Run(fmt.Sprintf("hash/%s/%d", name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					scheme.key(plaintextKind, make([]byte, namespaceLength), data)
+				}
+			})
+			b.Run(fmt.Sprintf("lookup/%s/%d", name, size), func(b *testing.B) {
+				cache, err := Setup(config.Config{Provider: crypto.NoopProvider{}, CacheKeyScheme: name})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer cache.Close()
+				err = cache.Add(string(data), []byte("ciphertext"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, ok, _ := cache.Encrypt(string(data), nil, "", ""); !ok {
+						b.Fatal("Value wasn't cached")
+					}
+				}
+			})
+		}
+	}
+}
+
+// Compare adding values from parallel workers to a single young store and to a sharded one. Run with eg. `go test -race -bench Add ./pkg/cache`.
+func BenchmarkAdd(b *testing.B) {
+	for _, shards := range []uint{1, 8} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "yamlcrypt-bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			cache, err := Setup(config.Config{
+				Root:         dir,
+				Provider:     crypto.NoopProvider{},
+				CacheEnabled: true,
+				CacheBackend: BitcaskBackend,
+				CacheShards:  shards,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer cache.Close()
+			var counter int64
+			var mutex sync.Mutex
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					mutex.Lock()
+					counter++
+					i := counter
+					mutex.Unlock()
+					err := cache.Add(plaintext(int(i), 0), ciphertext(int(i), 0))
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheBackend = BitcaskBackend
+	defer func() { Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	Warnings = warnings
+
+	// a scheme with shorter keys, standing in for one used by an older version
+	legacy := keyScheme{"sha256-8", func(data []byte) []byte { return hash(data)[:8] }, false}
+	knownSchemes[legacy.name] = legacy
+	defer delete(knownSchemes, legacy.name)
+	defer func() { currentScheme = sha256Scheme }()
+	currentScheme = legacy
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := map[string]string{"one": "ciphertext one", "two": "ciphertext two", "three": "yamlcrypt:other:ciphertext three"}
+	// only the latest ciphertext of a plaintext can be migrated
+	err = cache.Add("one", []byte("stale ciphertext one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for plaintext, ciphertext := range pairs {
+		err = cache.Add(plaintext, []byte(ciphertext))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = cache.Close()
+	currentScheme = sha256Scheme
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// until it's migrated, the cache is left alone, with a warning
+	err = ioutil.WriteFile(filepath.Join(cache.parentPath, schemeFileName), []byte(legacy.name+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), "cache migrate") {
+		t.Errorf("Expected a warning to migrate the cache, got %q", warnings)
+	}
+	if _, ok, _ := cache.Decrypt([]byte("ciphertext one")); ok {
+		t.Error("Expected an unmigrated cache not to be used")
+	}
+	cache.Close()
+
+	migration, err := Migrate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Migration{From: legacy.name, To: sha256Scheme.name, Migrated: 3, Dropped: 1}
+	if migration != expected {
+		t.Errorf("Expected migration %+v, got %+v", expected, migration)
+	}
+	warnings.Reset()
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings.Len() > 0 {
+		t.Errorf("Expected no warnings after migrating, got %q", warnings)
+	}
+	for plaintext, ciphertext := range pairs {
+		decrypted, ok, err := cache.Decrypt([]byte(ciphertext))
+		if err != nil || !ok || decrypted != plaintext {
+			t.Errorf("Expected %q to decrypt to %q after migrating, got %q (found: %t, error: %v)", ciphertext, plaintext, decrypted, ok, err)
+		}
+		encrypted, ok, err := cache.Encrypt(plaintext, nil, crypto.Marker([]byte(ciphertext)), "")
+		if err != nil || !ok || string(encrypted) != ciphertext {
+			t.Errorf("Expected %q to encrypt to %q after migrating, got %q (found: %t, error: %v)", plaintext, ciphertext, encrypted, ok, err)
+		}
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// migrating again does nothing
+	migration, err = Migrate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration != (Migration{From: sha256Scheme.name, To: sha256Scheme.name}) {
+		t.Errorf("Expected nothing to migrate, got %+v", migration)
+	}
+
+	// a cache written with an unknown scheme is purged
+	err = ioutil.WriteFile(filepath.Join(cache.parentPath, schemeFileName), []byte("unknown\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration, err = Migrate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migration.Purged {
+		t.Errorf("Expected the cache to be purged, got %+v", migration)
+	}
+	cache, err = Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if _, ok, _ := cache.Decrypt([]byte("ciphertext one")); ok {
+		t.Error("Expected a purged cache to be empty")
+	}
+}
+
+func TestTrim(t *testing.T) {
+	for _, backend := range []string{BitcaskBackend, MemoryBackend} {
+		t.Run(backend, func(t *testing.T) {
+			repos, err := fixtures.Repos()
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo := repos[0]
+			err = repo.Setup()
+			defer repo.Destroy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := config.LoadConfig(".")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.CacheBackend = backend
+			config.CacheMaxSize = 1024 * 1024 * 100
+			// rewriting each value a few times leaves space for merging to reclaim
+			cache, err := Setup(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putItems(t, cache, 0)
+			putItems(t, cache, 0)
+
+			// within the max size, the young cache is only merged
+			report, err := cache.Trim()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Rotated {
+				t.Errorf("Trimming a cache within its max size rotated it: %+v", report)
+			}
+			if report.Merged == 0 || report.Merged > report.Size {
+				t.Errorf("Trimming a cache gave unexpected sizes: %+v", report)
+			}
+			if backend == BitcaskBackend && report.Merged == report.Size {
+				t.Errorf("Merging the young cache reclaimed no space: %+v", report)
+			}
+			err = cache.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// over the max size, it's rotated, without waiting for the cache to be closed
+			config.CacheMaxSize = 1000
+			cache, err = Setup(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err = cache.Trim()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.Rotated || report.MaxSize != 1000 {
+				t.Errorf("Trimming an oversized cache did not rotate it: %+v", report)
+			}
+			size, err := cache.young.Size()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size >= report.Merged {
+				t.Errorf("Young cache is %d bytes after trimming, expected less than %d", size, report.Merged)
+			}
+			// the entries are still served, from the old cache, and new ones can be added to the new young cache
+			getItems(t, cache, 0, true)
+			putItems(t, cache, 1)
+			getItems(t, cache, 1, true)
+			err = cache.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.Trim(); err == nil {
+				t.Errorf("Trimming a closed cache did not fail")
+			}
+		})
+	}
+}
+
+func TestReadOnlyRoot(t *testing.T) {
+	defer func() { Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	Warnings = warnings
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheBackend = BitcaskBackend
+	parentPath := filepath.Join(config.Root, CacheDirName)
+
+	// a cache that was created before the checkout was made read-only, and one that never was
+	for _, existing := range []bool{true, false} {
+		if existing {
+			cache, err := Setup(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putItems(t, cache, 0)
+			err = cache.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			err = os.RemoveAll(parentPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, dir := range []string{config.Root, parentPath, youngPath(parentPath)} {
+			os.Chmod(dir, 0o500)
+			defer os.Chmod(dir, 0o700)
+		}
+		if f, err := os.Create(filepath.Join(config.Root, "probe")); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+			t.Skip("Can't make the root read-only, eg. since tests are running as root")
+		}
+		warnings.Reset()
+		cache, err := Setup(config)
+		if err != nil {
+			t.Fatalf("Setting up a cache in a read-only root (existing: %t) failed: %s", existing, err)
+		}
+		if !strings.Contains(warnings.String(), "only be kept in memory") {
+			t.Errorf("Falling back to an in-memory cache (existing: %t) did not warn: %q", existing, warnings)
+		}
+		// the cache still works for the rest of the session
+		putItems(t, cache, 1)
+		getItems(t, cache, 1, true)
+		err = cache.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(parentPath); existing == os.IsNotExist(err) {
+			t.Errorf("Cache directory (existing: %t) was created or removed in a read-only root: %v", existing, err)
+		}
+		for _, dir := range []string{config.Root, parentPath, youngPath(parentPath)} {
+			os.Chmod(dir, 0o700)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	// the same plaintext, encrypted under two contexts
+	ciphertexts := map[string][]byte{"": []byte("ciphertext"), "env=prod": []byte("prod ciphertext"), "env=dev": []byte("dev ciphertext")}
+	for context, ciphertext := range ciphertexts {
+		cache.SetContext(context)
+		err = cache.Add("plaintext", ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for context, ciphertext := range ciphertexts {
+		cache.SetContext(context)
+		cached, ok, err := cache.Encrypt("plaintext", nil, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !bytes.Equal(cached, ciphertext) {
+			t.Errorf("Cache gave ciphertext %q for context %q, expected %q", cached, context, ciphertext)
+		}
+		// a ciphertext from another context isn't served either
+		for otherContext, other := range ciphertexts {
+			if otherContext == context {
+				continue
+			}
+			if _, ok, _ := cache.Decrypt(other); ok {
+				t.Errorf("Cache served the plaintext of a ciphertext from context %q under context %q", otherContext, context)
+			}
+		}
+	}
+	cache.SetContext("staging")
+	if _, ok, _ := cache.Encrypt("plaintext", nil, "", ""); ok {
+		t.Errorf("Cache served a ciphertext for a context it was never added under")
+	}
+}
+
+func TestFileScope(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	// the same plaintext, encrypted for two files, and across every file
+	ciphertexts := map[string][]byte{"": []byte("ciphertext"), "app": []byte("app ciphertext"), "db": []byte("db ciphertext")}
+	for file, ciphertext := range ciphertexts {
+		err = cache.AddScoped("plaintext", ciphertext, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, ciphertext := range ciphertexts {
+		cached, ok, err := cache.Encrypt("plaintext", nil, "", file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !bytes.Equal(cached, ciphertext) {
+			t.Errorf("Cache gave ciphertext %q for file %q, expected %q", cached, file, ciphertext)
+		}
+		// ciphertexts are still looked up across every file
+		for otherFile, other := range ciphertexts {
+			if plaintext, ok, _ := cache.Decrypt(other); !ok || plaintext != "plaintext" {
+				t.Errorf("Cache didn't serve the plaintext of a ciphertext added for file %q while scoped to file %q", otherFile, file)
+			}
+		}
+	}
+	if _, ok, _ := cache.Encrypt("plaintext", nil, "", "other"); ok {
+		t.Errorf("Cache served a ciphertext for a file it was never added for")
+	}
+	// a ciphertext a file already has is still kept
+	cached, ok, err := cache.Encrypt("plaintext", []byte("app ciphertext"), "", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(cached) != "app ciphertext" {
+		t.Errorf("Cache gave ciphertext %q for an existing ciphertext, expected it to be kept", cached)
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	defer func() { Warnings = os.Stderr }()
+	warnings := &bytes.Buffer{}
+	Warnings = warnings
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.CacheBackend = BitcaskBackend
+	// the cache of a checkout maintained elsewhere, with entries in both its generations
+	central := local
+	central.Root, err = ioutil.TempDir("", "yamlcrypt-central-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(central.Root)
+	central.CacheMaxSize = 1000
+	cache, err := Setup(central)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	central.CacheMaxSize = 0
+	cache, err = Setup(central)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 1)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedDir := filepath.Join(central.Root, CacheDirName)
+
+	local.CacheSharedDir = sharedDir
+	cache, err = Setup(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hits come from the shared cache, and are promoted to the local young cache
+	for _, round := range []int{0, 1} {
+		key := cache.ciphertextToKey(cache.namespace, versionedCiphertext(round, 0, 0))
+		if cache.young.Has(key) {
+			t.Fatalf("Local young cache has an entry from round %d before it was used", round)
+		}
+		getItems(t, cache, round, true)
+		if !cache.young.Has(key) {
+			t.Errorf("Entry from round %d in the shared cache was not promoted to the local young cache", round)
+		}
+	}
+	putItems(t, cache, 2)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings.Len() > 0 {
+		t.Errorf("Using the shared cache warned: %q", warnings)
+	}
+	// the shared cache wasn't written to
+	cache, err = Setup(central)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 2, false)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the promoted entries are kept locally, without the shared cache
+	local.CacheSharedDir = ""
+	cache, err = Setup(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a shared cache that's missing is skipped, with a warning
+	local.CacheSharedDir = filepath.Join(central.Root, "missing")
+	cache, err = Setup(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), "not using the shared cache") {
+		t.Errorf("Missing shared cache did not warn: %q", warnings)
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// so is one that other users can write to, since they could have anything served
+	if runtime.GOOS == "windows" {
+		return
+	}
+	err = os.Chmod(sharedDir, 0o777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings.Reset()
+	local.CacheSharedDir = sharedDir
+	local.Root, err = ioutil.TempDir("", "yamlcrypt-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local.Root)
+	cache, err = Setup(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 1, false)
+	if !strings.Contains(warnings.String(), "writable by other users") {
+		t.Errorf("Shared cache writable by other users did not warn: %q", warnings)
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyspace(t *testing.T) {
+	// keys are laid out as kind, namespace, hash; changing that would orphan every existing cache, so it takes a new scheme
+	namespace := providerNamespace("fingerprint")
+	sum := sha256.Sum256([]byte("fingerprint"))
+	if !bytes.Equal(namespace, sum[:namespaceLength]) {
+		t.Errorf("Provider namespace is %x, expected %x", namespace, sum[:namespaceLength])
+	}
+	sum = sha256.Sum256(append(append(append([]byte{}, namespace...), 0), "env=prod"...))
+	if derived := deriveNamespace(namespace, contextNamespace, "env=prod"); !bytes.Equal(derived, sum[:namespaceLength]) {
+		t.Errorf("Context namespace is %x, expected %x", derived, sum[:namespaceLength])
+	}
+	sum = sha256.Sum256([]byte("data"))
+	expected := append(append([]byte{'p'}, namespace...), sum[:hashLength]...)
+	if key := sha256Scheme.key(plaintextKind, namespace, []byte("data")); !bytes.Equal(key, expected) {
+		t.Errorf("Plaintext key is %x, expected %x", key, expected)
+	}
+	expected[0] = 'c'
+	if key := sha256Scheme.key(ciphertextKind, namespace, []byte("data")); !bytes.Equal(key, expected) {
+		t.Errorf("Ciphertext key is %x, expected %x", key, expected)
+	}
+
+	// every kind of key has a prefix of its own
+	prefixes := map[keyKind]string{}
+	for name, kind := range keyKinds {
+		if other, ok := prefixes[kind]; ok {
+			t.Errorf("Keys of kinds %s and %s share prefix %q", name, other, kind)
+		}
+		prefixes[kind] = name
+	}
+
+	// the same data gets a different key for every kind and namespace, including namespaces derived in different ways from the same label
+	namespaces := map[string][]byte{"provider": namespace}
+	for _, label := range []string{"", "ops", "env=prod"} {
+		namespaces["context "+label] = deriveNamespace(namespace, contextNamespace, label)
+		namespaces["provider name "+label] = deriveNamespace(namespace, providerNameNamespace, label)
+		namespaces["provider name "+label+" in context"] = deriveNamespace(namespaces["context "+label], providerNameNamespace, label)
+	}
+	keys := map[string]string{}
+	for namespaceName, namespace := range namespaces {
+		for kindName, kind := range keyKinds {
+			for _, data := range []string{"", "data", string(namespace)} {
+				name := fmt.Sprintf("%s key for %q in namespace %s", kindName, data, namespaceName)
+				key := currentScheme.key(kind, namespace, []byte(data))
+				if other, ok := keys[string(key)]; ok {
+					t.Errorf("The %s is the same as the %s", name, other)
+				}
+				keys[string(key)] = name
+				parsedKind, parsedNamespace, ok := currentScheme.parseKey(key)
+				if !ok || parsedKind != kind || !bytes.Equal(parsedNamespace, namespace) {
+					t.Errorf("The %s parsed as kind %q in namespace %x", name, parsedKind, parsedNamespace)
+				}
+			}
+		}
+	}
+	for _, key := range [][]byte{nil, []byte("lock"), append([]byte{'x'}, expected[1:]...), expected[:len(expected)-1]} {
+		if _, _, ok := currentScheme.parseKey(key); ok {
+			t.Errorf("Parsed %q as a key", key)
+		}
+	}
+
+	// a value cached as a plaintext is never served as a ciphertext, nor the other way around
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	err = cache.Add("secret", []byte("ciphertext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, ok, _ := cache.Decrypt([]byte("secret")); ok {
+		t.Errorf("Cache decrypted a plaintext to %q", plaintext)
+	}
+	if ciphertext, ok, _ := cache.Encrypt("ciphertext", nil, "", ""); ok {
+		t.Errorf("Cache encrypted a ciphertext to %q", ciphertext)
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.CacheBackend = BitcaskBackend
+	// the cache of an earlier CI run, with entries in both its generations
+	previous := local
+	previous.Root, err = ioutil.TempDir("", "yamlcrypt-previous-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(previous.Root)
+	previous.CacheMaxSize = 1000
+	cache, err := Setup(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 0)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.CacheMaxSize = 0
+	cache, err = Setup(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putItems(t, cache, 1)
+	artifact := bytes.Buffer{}
+	count, err := cache.Export(&artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each round caches 300 ciphertexts, and 100 plaintexts
+	if count != 800 {
+		t.Errorf("Exported %d entries, expected 800", count)
+	}
+
+	// a fresh runner only hits once the artifact is restored
+	cache, err = Setup(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 0, false)
+	report, err := cache.Restore(bytes.NewReader(artifact.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 800 || report.Skipped != 0 {
+		t.Errorf("Restoring into an empty cache gave %+v, expected 800 entries restored", report)
+	}
+	getItems(t, cache, 0, true)
+	getItems(t, cache, 1, true)
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// restored entries are persisted, so restoring again adds nothing
+	cache, err = Setup(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getItems(t, cache, 1, true)
+	report, err = cache.Restore(bytes.NewReader(artifact.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 0 || report.Skipped != 800 {
+		t.Errorf("Restoring into a cache that already has every entry gave %+v, expected 800 entries skipped", report)
+	}
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an artifact exported under another provider or key isn't trusted, and neither is a truncated one
+	_, key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := local
+	other.Root, err = ioutil.TempDir("", "yamlcrypt-other-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other.Root)
+	for name, c := range map[string]struct {
+		config   config.Config
+		artifact []byte
+	}{
+		"another key": {func() config.Config { c := other; c.Provider = key; return c }(), artifact.Bytes()},
+		"truncated":   {other, artifact.Bytes()[:artifact.Len()-10]},
+		"empty":       {other, []byte{}},
+	} {
+		cache, err = Setup(c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err = cache.Restore(bytes.NewReader(c.artifact))
+		if err == nil {
+			t.Errorf("Restoring a cache artifact with %s didn't fail", name)
+		}
+		if report.Restored != 0 {
+			t.Errorf("Restoring a cache artifact with %s restored %d entries", name, report.Restored)
+		}
+		if _, ok, _ := cache.Decrypt(versionedCiphertext(0, 0, 0)); ok {
+			t.Errorf("Restoring a cache artifact with %s left its entries in the cache", name)
+		}
+		err = cache.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	written := time.Unix(1700000000, 123)
+	for _, e := range []envelope{
+		{Written: written, Value: []byte("plaintext")},
+		{Written: written, Flags: 0x80, Value: []byte{0xff, 'y', 'c', 'v', 0, 1, 2}},
+		{Value: []byte{}},
+		{Written: written, Data: []byte("ciphertext"), Value: []byte("plaintext")},
+		{Flags: 0x80, Data: []byte{}, Value: []byte{}},
+	} {
+		decoded, err := decodeEnvelope(encodeEnvelope(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.Written.Equal(e.Written) || decoded.Flags != e.Flags || !bytes.Equal(decoded.Value, e.Value) || !bytes.Equal(decoded.Data, e.Data) || (decoded.Data == nil) != (e.Data == nil) {
+			t.Errorf("Round-tripping envelope %+v gave %+v", e, decoded)
+		}
+	}
+
+	// values that aren't envelopes, even ones that start like one, are bare values written by an older version
+	corrupt := encodeEnvelope(envelope{Written: written, Value: []byte("plaintext")})
+	corrupt[len(corrupt)-1]++
+	for _, raw := range [][]byte{[]byte("plaintext"), []byte{}, envelopeMagic, corrupt} {
+		decoded, err := decodeEnvelope(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.Written.IsZero() || decoded.Flags != 0 || !bytes.Equal(decoded.Value, raw) {
+			t.Errorf("Decoding bare value %q gave %+v", raw, decoded)
+		}
+	}
+
+	// an envelope from a later version can't be read
+	future := encodeEnvelope(envelope{Written: written, Value: []byte("plaintext")})
+	future[8] = envelopeVersion + 1
+	binary.BigEndian.PutUint32(future[4:], crc32.ChecksumIEEE(future[8:]))
+	if _, err := decodeEnvelope(future); err == nil {
+		t.Error("Decoding an envelope with an unsupported version didn't fail")
+	}
+}
+
+func TestLegacyValues(t *testing.T) {
+	repos, err := fixtures.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	err = repo.Setup()
+	defer repo.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := config.LoadConfig(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheBackend = BitcaskBackend
+	cache, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	// a pair cached by an older version, with bare values
+	legacyPlaintext, legacyCiphertext := "legacy plaintext", []byte("legacy ciphertext")
+	err = cache.young.Put(cache.ciphertextToKey(cache.namespace, legacyCiphertext), []byte(legacyPlaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.young.Put(cache.plaintextToKey(cache.plaintextNamespace(crypto.Marker(legacyCiphertext), ""), legacyPlaintext), legacyCiphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	err = cache.Add("new plaintext", []byte("new ciphertext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range []struct {
+		plaintext  string
+		ciphertext []byte
+	}{
+		{legacyPlaintext, legacyCiphertext},
+		{"new plaintext", []byte("new ciphertext")},
+	} {
+		plaintext, ok, err := cache.Decrypt(pair.ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok || plaintext != pair.plaintext {
+			t.Errorf("Decrypting %q gave %q, %v, expected %q", pair.ciphertext, plaintext, ok, pair.plaintext)
+		}
+		ciphertext, ok, err := cache.Encrypt(pair.plaintext, []byte{}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok || !bytes.Equal(ciphertext, pair.ciphertext) {
+			t.Errorf("Encrypting %q gave %q, %v, expected %q", pair.plaintext, ciphertext, ok, pair.ciphertext)
+		}
+	}
+
+	// new values are stored in envelopes, recording when they were written, and bare values are left as they are
+	raw, err := cache.young.Get(cache.ciphertextToKey(cache.namespace, []byte("new ciphertext")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := decodeEnvelope(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(e.Value) != "new plaintext" || e.Written.Before(before) || e.Written.After(time.Now()) {
+		t.Errorf("New value was stored as %+v", e)
+	}
+	raw, err = cache.young.Get(cache.ciphertextToKey(cache.namespace, legacyCiphertext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != legacyPlaintext {
+		t.Errorf("Bare value was rewritten as %q", raw)
+	}
+
+	// an artifact exported by an older version holds bare values too
+	artifact := bytes.Buffer{}
+	json.NewEncoder(&artifact).Encode(artifactHeader{artifactFormat, 1, currentScheme.name, cache.fingerprint})
+	json.NewEncoder(&artifact).Encode(artifactEntry{cache.ciphertextToKey(cache.namespace, []byte("restored ciphertext")), []byte("restored plaintext")})
+	report, err := cache.Restore(&artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 1 {
+		t.Errorf("Restoring a version 1 artifact gave %+v, expected 1 entry restored", report)
+	}
+	plaintext, ok, err := cache.Decrypt([]byte("restored ciphertext"))
+	if err != nil || !ok || plaintext != "restored plaintext" {
+		t.Errorf("Decrypting a restored bare value gave %q, %v, %v", plaintext, ok, err)
+	}
+}
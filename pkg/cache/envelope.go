@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// Marks a cache value as an envelope. 0xff never appears in UTF-8, so no plaintext can start with it; a ciphertext could, which is what the checksum is for.
+var envelopeMagic = []byte{0xff, 'y', 'c', 'v'}
+
+const (
+	envelopeVersion = 1
+	// Magic, checksum, version, flags, and the time it was written, in nanoseconds since the epoch.
+	envelopeHeaderSize = 4 + 4 + 1 + 1 + 8
+	// Flag set when the value is preceded by the data its key was hashed from, as a uvarint length followed by the data.
+	dataFlag byte = 1 << 0
+)
+
+// A value as it's stored in the cache, along with metadata about it. Values written before envelopes were introduced are stored bare, and are read back with no metadata.
+type envelope struct {
+	// When the value was written to the cache, or the zero time for a bare value.
+	Written time.Time
+	// Reserved for metadata to come, eg. an expiry. Unknown flags are kept as they are.
+	Flags byte
+	// The plaintext or ciphertext that the entry's key was hashed from, for key schemes that check it, or nil.
+	Data []byte
+	// The plaintext or ciphertext itself.
+	Value []byte
+}
+
+// Wrap a value in an envelope, to be stored in the cache.
+func encodeEnvelope(e envelope) []byte {
+	out := make([]byte, envelopeHeaderSize, envelopeHeaderSize+binary.MaxVarintLen64+len(e.Data)+len(e.Value))
+	copy(out, envelopeMagic)
+	out[8] = envelopeVersion
+	out[9] = e.Flags &^ dataFlag
+	var written int64
+	if !e.Written.IsZero() {
+		written = e.Written.UnixNano()
+	}
+	binary.BigEndian.PutUint64(out[10:], uint64(written))
+	if e.Data != nil {
+		out[9] |= dataFlag
+		var length [binary.MaxVarintLen64]byte
+		out = append(out, length[:binary.PutUvarint(length[:], uint64(len(e.Data)))]...)
+		out = append(out, e.Data...)
+	}
+	out = append(out, e.Value...)
+	binary.BigEndian.PutUint32(out[4:], crc32.ChecksumIEEE(out[8:]))
+	return out
+}
+
+// Unwrap a value stored in the cache. A value that isn't an envelope, ie. that doesn't start with the magic followed by a checksum of the rest, is a bare value written by an older version, and is returned as it is.
+func decodeEnvelope(raw []byte) (envelope, error) {
+	if len(raw) < envelopeHeaderSize || !bytes.HasPrefix(raw, envelopeMagic) || binary.BigEndian.Uint32(raw[4:]) != crc32.ChecksumIEEE(raw[8:]) {
+		return envelope{Value: raw}, nil
+	}
+	if raw[8] != envelopeVersion {
+		return envelope{}, fmt.Errorf("Cache entry has version %d, but only version %d is supported", raw[8], envelopeVersion)
+	}
+	e := envelope{Flags: raw[9] &^ dataFlag, Value: raw[envelopeHeaderSize:]}
+	if written := int64(binary.BigEndian.Uint64(raw[10:])); written != 0 {
+		e.Written = time.Unix(0, written)
+	}
+	if raw[9]&dataFlag != 0 {
+		length, n := binary.Uvarint(e.Value)
+		if n <= 0 || length > uint64(len(e.Value)-n) {
+			return envelope{}, fmt.Errorf("Cache entry is malformed")
+		}
+		e.Data = e.Value[n : n+int(length)]
+		e.Value = e.Value[n+int(length):]
+	}
+	return e, nil
+}
+
+// Record the data that an entry's key was hashed from in its value, as it's stored.
+func withData(raw, data []byte) ([]byte, error) {
+	e, err := decodeEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	e.Data = data
+	return encodeEnvelope(e), nil
+}
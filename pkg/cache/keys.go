@@ -0,0 +1,64 @@
+package cache
+
+// The kind of entry a cache key looks up. Every key starts with its kind, followed by a namespace of namespaceLength and a hash whose length depends on the scheme, so keys of different kinds can never be equal, however their data and namespaces were chosen, and a key's parts can always be told apart again.
+type keyKind byte
+
+const (
+	// Keys containing a hashed plaintext, used to look up ciphertext.
+	plaintextKind keyKind = 'p'
+	// Keys containing a hashed ciphertext, used to look up plaintext.
+	ciphertextKind keyKind = 'c'
+)
+
+// Every kind of key, by name. A new kind of entry needs a kind of its own, with a prefix no other kind has.
+var keyKinds = map[string]keyKind{
+	"plaintext":  plaintextKind,
+	"ciphertext": ciphertextKind,
+}
+
+// The way a namespace is derived from the one it's nested in. Each derived namespace is hashed from its kind along with its parent and label, so namespaces derived in different ways from the same parent and label are unrelated.
+type namespaceKind byte
+
+const (
+	// Namespaces of ciphertext keys for an encryption context, derived from the provider's namespace. See Cache.SetContext.
+	contextNamespace namespaceKind = 0
+	// Namespaces of plaintext keys for the ciphertexts of a named provider, derived from a namespace of ciphertext keys. See crypto.Router.
+	providerNameNamespace namespaceKind = 1
+	// Namespaces of plaintext keys for the values of a single file, derived from a namespace of plaintext keys. See Cache.AddScoped.
+	fileNamespace namespaceKind = 2
+)
+
+// Get the namespace for a provider, from its fingerprint. Every other namespace is derived from one of these.
+func providerNamespace(fingerprint string) []byte {
+	return hash([]byte(fingerprint))[:namespaceLength]
+}
+
+// Derive a namespace nested in another. The parent is always namespaceLength long, so the kind and label that follow it can't be mistaken for part of it.
+func deriveNamespace(parent []byte, kind namespaceKind, label string) []byte {
+	data := make([]byte, 0, len(parent)+1+len(label))
+	data = append(data, parent...)
+	data = append(data, byte(kind))
+	return hash(append(data, label...))[:namespaceLength]
+}
+
+// Build the key for some data, of the given kind, in the given namespace, which must be namespaceLength long, with a scheme's hash.
+func (s keyScheme) key(kind keyKind, namespace, data []byte) []byte {
+	key := make([]byte, 1, 1+len(namespace)+hashLength)
+	key[0] = byte(kind)
+	key = append(key, namespace...)
+	return append(key, s.hash(data)...)
+}
+
+// Split a key built with the scheme into its kind and namespace. Returns false if it isn't a key of a known kind.
+func (s keyScheme) parseKey(key []byte) (keyKind, []byte, bool) {
+	if len(key) != 1+namespaceLength+len(s.hash(nil)) {
+		return 0, nil, false
+	}
+	kind := keyKind(key[0])
+	for _, k := range keyKinds {
+		if kind == k {
+			return kind, key[1 : 1+namespaceLength], true
+		}
+	}
+	return 0, nil, false
+}
@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/config"
+	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the file in the cache directory that records the scheme its keys were hashed with.
+const schemeFileName = "scheme"
+
+// Names of the key schemes that can be configured.
+const (
+	// SHA-256, truncated to hashLength. The default.
+	SHA256KeyScheme = "sha256-16"
+	// XXH64, which is several times quicker to hash with than SHA-256, but isn't collision resistant, so entries record what their key was hashed from, and are checked against it.
+	XXHKeyScheme = "xxh64"
+)
+
+// A scheme for hashing plaintexts and ciphertexts into cache keys. Every key depends on the scheme, so a cache written with one scheme has to be migrated before it can be read with another. Namespaces are hashed the same way whatever the scheme, so that they carry over as they are.
+type keyScheme struct {
+	name string
+	hash func([]byte) []byte
+	// Set if keys can collide, ie. two plaintexts or two ciphertexts can hash to the same key, whether by chance or by design. Each entry then records the plaintext or ciphertext its key was hashed from, and is only served for that one.
+	checked bool
+}
+
+// SHA-256, truncated to hashLength. Caches that don't record their scheme were written with this one, since it's the only one there was before schemes were recorded.
+var sha256Scheme = keyScheme{SHA256KeyScheme, hash, false}
+
+// XXH64, for repos where hashing keys takes a noticeable share of a run, eg. files with thousands of values.
+var xxhScheme = keyScheme{XXHKeyScheme, xxh64, true}
+
+// The scheme that keys are hashed with, unless the config picks another.
+var currentScheme = sha256Scheme
+
+// Schemes that can be configured, and that caches can be migrated from, by name.
+var knownSchemes = map[string]keyScheme{sha256Scheme.name: sha256Scheme, xxhScheme.name: xxhScheme}
+
+// Get the scheme that the cache of the repo with the given config hashes its keys with.
+func configuredScheme(config config.Config) (keyScheme, error) {
+	if config.CacheKeyScheme == "" {
+		return currentScheme, nil
+	}
+	scheme, ok := knownSchemes[config.CacheKeyScheme]
+	if !ok {
+		return keyScheme{}, fmt.Errorf("No cache key scheme named %s", config.CacheKeyScheme)
+	}
+	return scheme, nil
+}
+
+// Get the name of the scheme recorded in the given cache directory, and whether one was recorded at all. A cache that doesn't record its scheme is taken to use the given one if it holds nothing yet.
+func readScheme(parentPath string, current keyScheme) (string, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(parentPath, schemeFileName))
+	if os.IsNotExist(err) {
+		for _, path := range []string{youngPath(parentPath), oldPath(parentPath)} {
+			shards, err := bitcaskShards(path)
+			if err != nil {
+				return "", false, err
+			}
+			if shards > 0 {
+				return sha256Scheme.name, false, nil
+			}
+		}
+		return current.name, false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// Record a scheme in the given cache directory.
+func writeScheme(parentPath string, scheme keyScheme) error {
+	return ioutil.WriteFile(filepath.Join(parentPath, schemeFileName), []byte(scheme.name+"\n"), 0600)
+}
+
+// The outcome of migrating a cache to the current key scheme.
+type Migration struct {
+	// Schemes the cache was migrated from and to. They're the same if there was nothing to migrate.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Number of (plaintext, ciphertext) pairs migrated.
+	Migrated int `json:"migrated"`
+	// Number of entries that couldn't be migrated, and were dropped.
+	Dropped int `json:"dropped"`
+	// Whether the whole cache was purged, since its scheme isn't known.
+	Purged bool `json:"purged"`
+}
+
+// Rewrite the on-disk cache of the repo with the given config under its configured key scheme, if it was written with another. Only the latest ciphertext of each plaintext can be migrated, since the cache only holds hashes of the others; any other entries are dropped. A cache written with a scheme that isn't known is purged instead. The cache mustn't be open while it's migrated.
+func Migrate(config config.Config) (Migration, error) {
+	parentPath := filepath.Join(config.Root, CacheDirName)
+	to, err := configuredScheme(config)
+	if err != nil {
+		return Migration{}, err
+	}
+	migration := Migration{From: to.name, To: to.name}
+	if _, err := os.Stat(parentPath); os.IsNotExist(err) {
+		return migration, nil
+	}
+	migration.From, _, err = readScheme(parentPath, to)
+	if err != nil {
+		return migration, fmt.Errorf("Error reading cache scheme: %w", err)
+	}
+	if migration.From == to.name {
+		return migration, nil
+	}
+	from, ok := knownSchemes[migration.From]
+	if !ok {
+		for _, path := range []string{youngPath(parentPath), oldPath(parentPath)} {
+			err = os.RemoveAll(path)
+			if err != nil {
+				return migration, fmt.Errorf("Error purging cache: %w", err)
+			}
+		}
+		migration.Purged = true
+		return migration, writeScheme(parentPath, to)
+	}
+	err = finishRotation(youngPath(parentPath), oldPath(parentPath))
+	if err != nil {
+		return migration, fmt.Errorf("Error finishing interrupted cache rotation: %w", err)
+	}
+	for _, path := range []string{youngPath(parentPath), oldPath(parentPath)} {
+		shards, err := bitcaskShards(path)
+		if err != nil {
+			return migration, err
+		}
+		if shards == 0 {
+			continue
+		}
+		s, err := openShardedBitcaskStore(path, shards)
+		if err != nil {
+			return migration, fmt.Errorf("Error opening cache: %w", err)
+		}
+		migrated, dropped, err := migrateStore(s, from, to)
+		if err == nil {
+			err = s.Merge()
+		}
+		closeErr := s.Close()
+		if err != nil {
+			return migration, fmt.Errorf("Error migrating cache: %w", err)
+		}
+		if closeErr != nil {
+			return migration, fmt.Errorf("Error closing cache: %w", closeErr)
+		}
+		migration.Migrated += migrated
+		migration.Dropped += dropped
+	}
+	return migration, writeScheme(parentPath, to)
+}
+
+// Rewrite the entries of a store from one key scheme to another, returning the number of (plaintext, ciphertext) pairs migrated, and the number of entries dropped. Each plaintext entry holds its latest ciphertext, from which the key of the ciphertext entry holding the plaintext can be found again. Values are carried over as they're stored, envelopes and all, other than recording what their keys were hashed from, if the scheme they're migrated to checks it.
+func migrateStore(s store, from, to keyScheme) (int, int, error) {
+	keys, err := s.Keys()
+	if err != nil {
+		return 0, 0, err
+	}
+	ciphertextKeys := map[string]bool{}
+	namespaces := map[string]bool{}
+	plaintextKeys := [][]byte{}
+	for _, key := range keys {
+		kind, namespace, ok := from.parseKey(key)
+		if !ok {
+			continue
+		}
+		switch kind {
+		case ciphertextKind:
+			ciphertextKeys[string(key)] = true
+			namespaces[string(namespace)] = true
+		case plaintextKind:
+			plaintextKeys = append(plaintextKeys, key)
+		}
+	}
+	entries := []entry{}
+	for _, plaintextKey := range plaintextKeys {
+		rawCiphertext, ciphertext, err := getEnvelope(s, plaintextKey)
+		if err != nil {
+			return 0, 0, err
+		}
+		_, plaintextNS, _ := from.parseKey(plaintextKey)
+		marker := crypto.Marker(ciphertext)
+		// the plaintext namespace is derived from the ciphertext namespace, so find the one it was derived from
+		for namespace := range namespaces {
+			if !bytes.Equal(plaintextNamespace([]byte(namespace), marker), plaintextNS) {
+				continue
+			}
+			ciphertextKey := from.key(ciphertextKind, []byte(namespace), ciphertext)
+			if !ciphertextKeys[string(ciphertextKey)] {
+				continue
+			}
+			rawPlaintext, plaintext, err := getEnvelope(s, ciphertextKey)
+			if err != nil {
+				return 0, 0, err
+			}
+			if !bytes.Equal(from.key(plaintextKind, plaintextNS, plaintext), plaintextKey) {
+				continue
+			}
+			if to.checked {
+				rawCiphertext, err = withData(rawCiphertext, plaintext)
+				if err == nil {
+					rawPlaintext, err = withData(rawPlaintext, ciphertext)
+				}
+				if err != nil {
+					return 0, 0, err
+				}
+			}
+			entries = append(entries,
+				entry{to.key(plaintextKind, plaintextNS, plaintext), plaintext, rawCiphertext},
+				entry{to.key(ciphertextKind, []byte(namespace), ciphertext), ciphertext, rawPlaintext},
+			)
+			break
+		}
+	}
+	for _, key := range keys {
+		err = s.Delete(key)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, e := range entries {
+		err = s.Put(e.key, e.value)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(entries) / 2, len(keys) - len(entries), nil
+}
+
+// Get the value of an entry in a store both as it's stored, and unwrapped from its envelope.
+func getEnvelope(s store, key []byte) ([]byte, []byte, error) {
+	raw, err := s.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := decodeEnvelope(raw)
+	return raw, e.Value, err
+}
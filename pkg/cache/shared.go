@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// A store that serves entries from a shared store behind one of its own, eg. those of a cache maintained centrally behind the local old cache. Only its own store is ever written to; deleting an entry only hides the shared one for the rest of the session.
+type layeredStore struct {
+	store
+	shared store
+}
+
+func (s layeredStore) Has(key []byte) bool {
+	return s.store.Has(key) || s.shared.Has(key)
+}
+
+func (s layeredStore) Get(key []byte) ([]byte, error) {
+	if s.store.Has(key) {
+		return s.store.Get(key)
+	}
+	return s.shared.Get(key)
+}
+
+func (s layeredStore) Delete(key []byte) error {
+	err := s.store.Delete(key)
+	if err != nil {
+		return err
+	}
+	return s.shared.Delete(key)
+}
+
+func (s layeredStore) Keys() ([][]byte, error) {
+	keys, err := s.store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	sharedKeys, err := s.shared.Keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range sharedKeys {
+		if !s.store.Has(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Serve the entries of the shared cache behind the old cache, if there is one. Its entries are served without the provider confirming them, unless in verify mode, so whoever can write to the shared cache can have any plaintext served for a ciphertext; it must be as trusted as the keys themselves. The cache is only an optimization, so a shared cache that can't be loaded, or that other users can write to, is skipped, with a warning.
+func (c *Cache) useShared() {
+	if c.sharedDir == "" || c.temporary {
+		return
+	}
+	if c.shared == nil {
+		shared, err := loadSharedCache(c.sharedDir, c.scheme)
+		if err != nil {
+			fmt.Fprintf(Warnings, "Warning: not using the shared cache at %s: %s\n", c.sharedDir, err)
+			c.sharedDir = ""
+			return
+		}
+		c.shared = shared
+	}
+	c.old = layeredStore{c.old, c.shared}
+}
+
+// Load the entries of the cache directory of another checkout, written with the given key scheme, into memory. A bitcask store can only be opened by one process at a time, and takes a lock in its directory to make sure of it, so the stores are copied, and read from the copies, leaving the shared cache free for other checkouts, even on a read-only filesystem.
+func loadSharedCache(parentPath string, current keyScheme) (store, error) {
+	info, err := os.Stat(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotShared(parentPath, info); err != nil {
+		return nil, err
+	}
+	scheme, _, err := readScheme(parentPath, current)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading cache scheme: %w", err)
+	}
+	if scheme != current.name {
+		return nil, fmt.Errorf("it was written with key scheme %s rather than %s", scheme, current.name)
+	}
+	tmp, err := ioutil.TempDir("", "yamlcrypt-shared-cache-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	shared := newMemoryStore()
+	// the young cache's entries are newer, so they win
+	for i, path := range []string{oldPath(parentPath), youngPath(parentPath)} {
+		shards, err := bitcaskShards(path)
+		if err != nil {
+			return nil, err
+		}
+		if shards == 0 {
+			continue
+		}
+		copyPath := filepath.Join(tmp, fmt.Sprint(i))
+		err = copyStore(path, copyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Error copying cache: %w", err)
+		}
+		s, err := openShardedBitcaskStore(copyPath, shards)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening cache: %w", err)
+		}
+		err = copyEntries(s, shared)
+		closeErr := s.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading cache: %w", err)
+		}
+		if closeErr != nil {
+			return nil, fmt.Errorf("Error closing cache: %w", closeErr)
+		}
+	}
+	return shared, nil
+}
+
+// Copy the files of a bitcask store, or a sharded one, leaving out their lock files.
+func copyStore(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if err := checkNotShared(path, info); err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		if info.Name() == "lock" {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
+
+// Make sure that only its owner can write to a file or directory of the shared cache, since anyone else who can could have any plaintext served for a ciphertext. Windows doesn't have permission bits to check.
+func checkNotShared(path string, info os.FileInfo) error {
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by other users, so its entries can't be trusted", path)
+	}
+	return nil
+}
+
+// Copy every entry of one store into another.
+func copyEntries(from, to store) error {
+	keys, err := from.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value, err := from.Get(key)
+		if err != nil {
+			return err
+		}
+		err = to.Put(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
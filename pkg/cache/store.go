@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prologic/bitcask"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// Cache backed by bitcask databases on disk, persisted between sessions.
+	BitcaskBackend = "bitcask"
+	// Cache backed by maps in memory, only persisted between sessions in the same process.
+	MemoryBackend = "memory"
+	// Prefix of the directories holding each shard of a sharded bitcask store.
+	shardDirPrefix = "shard-"
+)
+
+// A key-value store holding one generation ("young" or "old") of the cache.
+type store interface {
+	Has(key []byte) bool
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// Reclaim any space used by overwritten values.
+	Merge() error
+	// Total size of the store, in bytes.
+	Size() (int64, error)
+	// Every key in the store.
+	Keys() ([][]byte, error)
+	Close() error
+}
+
+type bitcaskStore struct {
+	*bitcask.Bitcask
+}
+
+func openBitcaskStore(path string) (store, error) {
+	db, err := bitcask.Open(path, bitcask.WithAutoRecovery(true))
+	if err != nil {
+		return nil, err
+	}
+	return bitcaskStore{db}, nil
+}
+
+// Open a bitcask store, starting it over if it's corrupt, eg. if it was only partly deleted when a rotation was interrupted. Its entries are only a cache, so they can be rebuilt. Any other error, eg. the store being locked by another process or not being readable, is returned as it is.
+func openOrResetBitcaskStore(path string) (store, error) {
+	s, err := openBitcaskStore(path)
+	if err == nil || !isCorruptBitcask(err) {
+		return s, err
+	}
+	fmt.Fprintf(Warnings, "Warning: starting the cache at %s over, since it couldn't be opened: %s\n", path, err)
+	err = os.RemoveAll(path)
+	if err != nil {
+		return nil, err
+	}
+	return openBitcaskStore(path)
+}
+
+// Whether an error opening a bitcask store means that the store itself is corrupt. Bitcask doesn't export most of its corruption errors, so some have to be recognized by their messages.
+func isCorruptBitcask(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	message := err.Error()
+	return errors.Is(err, syscall.ENOTDIR) ||
+		errors.Is(err, bitcask.ErrChecksumFailed) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &syntaxErr) ||
+		errors.As(err, &typeErr) ||
+		errors.As(err, &numErr) ||
+		strings.HasPrefix(message, "recovering database: ") ||
+		strings.HasSuffix(message, "is truncated") ||
+		strings.HasSuffix(message, "key size too large")
+}
+
+func (s bitcaskStore) Keys() ([][]byte, error) {
+	keys := [][]byte{}
+	err := s.Fold(func(key []byte) error {
+		keys = append(keys, append([]byte{}, key...))
+		return nil
+	})
+	return keys, err
+}
+
+func (s bitcaskStore) Size() (int64, error) {
+	stats, err := s.Stats()
+	return stats.Size, err
+}
+
+// Open a bitcask store split into the given number of shards, each in its own directory under path. A single shard is just a bitcask store at path itself.
+func openShardedBitcaskStore(path string, shards int) (store, error) {
+	if shards <= 1 {
+		return openOrResetBitcaskStore(path)
+	}
+	err := os.MkdirAll(path, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	s := make(shardedStore, 0, shards)
+	for i := 0; i < shards; i++ {
+		shard, err := openOrResetBitcaskStore(filepath.Join(path, fmt.Sprintf("%s%d", shardDirPrefix, i)))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = append(s, shard)
+	}
+	return s, nil
+}
+
+// Get the number of shards that the bitcask store at path was created with, or zero if there's no store there.
+func bitcaskShards(path string) (int, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		// not a store at all, so it's started over when it's opened
+		return 1, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+	shards := 0
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), shardDirPrefix) {
+			shards++
+		}
+	}
+	if shards == 0 {
+		return 1, nil
+	}
+	return shards, nil
+}
+
+// A store split into shards, with each key kept in the shard picked by its hash. Each shard has its own lock, so that writes to different shards don't wait on each other.
+type shardedStore []store
+
+func (s shardedStore) shard(key []byte) store {
+	h := fnv.New32a()
+	h.Write(key)
+	return s[h.Sum32()%uint32(len(s))]
+}
+
+func (s shardedStore) Has(key []byte) bool {
+	return s.shard(key).Has(key)
+}
+
+func (s shardedStore) Get(key []byte) ([]byte, error) {
+	return s.shard(key).Get(key)
+}
+
+func (s shardedStore) Put(key, value []byte) error {
+	return s.shard(key).Put(key, value)
+}
+
+func (s shardedStore) Delete(key []byte) error {
+	return s.shard(key).Delete(key)
+}
+
+// Merge the shards in parallel, since each is merged independently.
+func (s shardedStore) Merge() error {
+	errs := make([]error, len(s))
+	var wg sync.WaitGroup
+	for i, shard := range s {
+		wg.Add(1)
+		go func(i int, shard store) {
+			defer wg.Done()
+			errs[i] = shard.Merge()
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s shardedStore) Size() (int64, error) {
+	var total int64
+	for _, shard := range s {
+		size, err := shard.Size()
+		if err != nil {
+			return total, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+func (s shardedStore) Keys() ([][]byte, error) {
+	keys := [][]byte{}
+	for _, shard := range s {
+		shardKeys, err := shard.Keys()
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, shardKeys...)
+	}
+	return keys, nil
+}
+
+// Close every shard, even if closing one of them fails.
+func (s shardedStore) Close() error {
+	var err error
+	for _, shard := range s {
+		if closeErr := shard.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type memoryStore struct {
+	data  map[string][]byte
+	size  int64
+	mutex sync.RWMutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: map[string][]byte{}}
+}
+
+func (s *memoryStore) Has(key []byte) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.data[string(key)]
+	return ok
+}
+
+func (s *memoryStore) Get(key []byte) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.data[string(key)], nil
+}
+
+func (s *memoryStore) Put(key, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if old, ok := s.data[string(key)]; ok {
+		s.size -= int64(len(key) + len(old))
+	}
+	s.data[string(key)] = append([]byte{}, value...)
+	s.size += int64(len(key) + len(value))
+	return nil
+}
+
+func (s *memoryStore) Delete(key []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if old, ok := s.data[string(key)]; ok {
+		s.size -= int64(len(key) + len(old))
+		delete(s.data, string(key))
+	}
+	return nil
+}
+
+func (s *memoryStore) Merge() error {
+	return nil
+}
+
+func (s *memoryStore) Size() (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.size, nil
+}
+
+func (s *memoryStore) Keys() ([][]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([][]byte, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, []byte(key))
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// The generations of a memory-backed cache.
+type memoryGenerations struct {
+	young *memoryStore
+	old   *memoryStore
+}
+
+// Memory-backed caches, by cache path, so that they persist between sessions in the same process.
+var memoryCaches = struct {
+	m     map[string]*memoryGenerations
+	mutex sync.Mutex
+}{m: map[string]*memoryGenerations{}}
+
+// Get the generations of the memory-backed cache for the given cache path, creating them if needed.
+func getMemoryGenerations(path string) *memoryGenerations {
+	memoryCaches.mutex.Lock()
+	defer memoryCaches.mutex.Unlock()
+	generations, ok := memoryCaches.m[path]
+	if !ok {
+		generations = &memoryGenerations{young: newMemoryStore(), old: newMemoryStore()}
+		memoryCaches.m[path] = generations
+	}
+	return generations
+}
+
+// Discard the old generation of the memory-backed cache for the given cache path, making the young generation take its place.
+func rotateMemoryGenerations(path string) {
+	memoryCaches.mutex.Lock()
+	defer memoryCaches.mutex.Unlock()
+	generations := memoryCaches.m[path]
+	memoryCaches.m[path] = &memoryGenerations{young: newMemoryStore(), old: generations.young}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Primes of XXH64. They're variables rather than constants, since the accumulators are seeded with sums that overflow.
+var (
+	xxhPrime1 uint64 = 0x9e3779b185ebca87
+	xxhPrime2 uint64 = 0xc2b2ae3d27d4eb4f
+	xxhPrime3 uint64 = 0x165667b19e3779f9
+	xxhPrime4 uint64 = 0x85ebca77c2b2ae63
+	xxhPrime5 uint64 = 0x27d4eb2f165667c5
+)
+
+// Hash some bytes with XXH64, with a seed of zero, as the reference implementation does. It reads 32 bytes at a time, so it's several times quicker than SHA-256, but it's not collision resistant, so it's only used for keys by a checked scheme.
+func xxh64(data []byte) []byte {
+	n := len(data)
+	var h uint64
+	if n >= 32 {
+		v1 := xxhPrime1 + xxhPrime2
+		v2 := xxhPrime2
+		v3 := uint64(0)
+		v4 := -xxhPrime1
+		for len(data) >= 32 {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint64(data[24:]))
+			data = data[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range []uint64{v1, v2, v3, v4} {
+			h ^= xxhRound(0, v)
+			h = h*xxhPrime1 + xxhPrime4
+		}
+	} else {
+		h = xxhPrime5
+	}
+	h += uint64(n)
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, h)
+	return out
+}
+
+// Mix 8 bytes of input into one of XXH64's accumulators.
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	return bits.RotateLeft64(acc, 31) * xxhPrime1
+}
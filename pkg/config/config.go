@@ -2,14 +2,46 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/crypto"
 	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 )
 
-const ConfigFilename = ".yamlcrypt.yaml"
+const (
+	ConfigFilename = ".yamlcrypt.yaml"
+	// Number of crypto operations to run in parallel, if not otherwise configured.
+	DefaultThreads = 16
+	// Number of files to read and write in parallel, if not otherwise configured.
+	DefaultFileThreads = 4
+	// Largest plaintext value that will be encrypted, if not otherwise configured: 1MiB.
+	DefaultMaxValueSize = 1024 * 1024
+)
+
+// What happens to secrets that were removed from a decrypted file, when encrypting it.
+const (
+	// Remove them from the encrypted version as well, with a warning.
+	RemovedSecretsDrop = "drop"
+	// Keep them in the encrypted version, as they were.
+	RemovedSecretsKeep = "keep"
+	// Fail to encrypt the file.
+	RemovedSecretsError = "error"
+)
+
+// What happens when a provider decrypts a ciphertext to an empty plaintext.
+const (
+	// Fail to decrypt it, to catch a broken provider returning nothing, in repos that have no empty secrets.
+	EmptyPlaintextsError = "error"
+	// Decrypt it to an empty value, since the secret may really be empty. The default.
+	EmptyPlaintextsAllow = "allow"
+)
+
+// Mapping keys that look like they hold secrets, if not otherwise configured.
+var DefaultSecretKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|private_?key|api_?key|credential)`)
 
 type SuffixesConfig struct {
 	Encrypted string
@@ -54,17 +86,113 @@ var DefaultSuffixesConfig = SuffixesConfig{
 	Plain:     "plain.yaml",
 }
 
+// Maps the values at a dotted path pattern to a named provider from the providers section of the config.
+type ProviderPath struct {
+	Path     string
+	Provider string
+}
+
 type Config struct {
-	Provider crypto.Provider
-	Suffixes SuffixesConfig
-	Root     string
+	Provider     crypto.Provider
+	ProviderName string
+	Suffixes     SuffixesConfig
+	Root         string
+	Threads      uint
+	// Number of files to read and write in parallel, independently of Threads.
+	FileThreads uint
+	// Max size of the young cache in bytes. Zero means use the cache package's default.
+	CacheMaxSize int64
+	// Whether the cache is persisted between runs.
+	CacheEnabled bool
+	// Name of the cache backend to use. Empty means use the cache package's default.
+	CacheBackend string
+	// How long the cache must go unused before it's merged in the background, so that closing it is quick. Zero means only merge on close.
+	CacheMergeIdle time.Duration
+	// Whether cached plaintexts are only served once the provider has confirmed them, once per session.
+	CacheVerify bool
+	// Number of bitcask stores the young cache is split into, so that parallel workers don't all contend for the one store. Zero means a single store.
+	CacheShards uint
+	// Cache directory of another checkout, eg. one maintained centrally, whose entries are served alongside the old cache, without ever being written to. Its entries are trusted like the local cache's, so it must be as trusted as the keys. Empty means there's none.
+	CacheSharedDir string
+	// Name of the scheme that cache keys are hashed with. Empty means use the cache package's default.
+	CacheKeyScheme string
+	// Whether plaintexts are cached per file, rather than across every file, so that a value moved to another file is encrypted again, rather than given the ciphertext it had in the file it came from.
+	CachePerFile bool
+	// Largest plaintext value, in bytes, that will be encrypted.
+	MaxValueSize int64
+	// Dotted path patterns of values to encrypt, whether or not they're tagged.
+	EncryptPaths []string
+	// Dotted path patterns of values never encrypted because they match EncryptPaths or the schema file, eg. public keys.
+	PlaintextPaths []string
+	// Whether to encrypt every value except for those in PlaintextPaths, whether or not they're tagged.
+	EncryptAll bool
+	// Path of a schema file listing more paths of values to encrypt, relative to the root. Empty means there's no schema.
+	SchemaFile string
+	// Tag marking encrypted values in encrypted files, eg. "!vault". Empty means the default, "!encrypted".
+	EncryptedTag string
+	// Mapping keys whose values should never be left unencrypted.
+	SecretKeyPattern *regexp.Regexp
+	// Context that ciphertexts are bound to, with any "{path}" replaced by the file's path relative to the root. Empty means ciphertexts aren't bound to a context.
+	EncryptionContext string
+	// What happens to secrets that were removed from a decrypted file, when encrypting it. One of the RemovedSecrets constants.
+	RemovedSecrets string
+	// What happens when a provider decrypts a ciphertext to an empty plaintext. One of the EmptyPlaintexts constants.
+	EmptyPlaintexts string
+	// Whether to record who last changed each secret's value, in a comment alongside its encrypted value.
+	RecordRotatedBy bool
+	// Who is recorded as having changed secrets. Empty means use git's user.email.
+	Identity string
+	// Values encrypted with one of the named providers rather than the default, in order of precedence.
+	ProviderPaths []ProviderPath
+	// Whether reading a file with the same key twice in a mapping fails.
+	StrictKeys bool
+	// Whether decrypted and plain files are added to the .gitignore as they're written, unless git already ignores them.
+	GitignoreDecrypted bool
+	// Directory that temporary files holding plaintexts are created in, eg. one on tmpfs rather than a shared filesystem. Relative paths in the config file are relative to the root. Empty means the OS's default temporary directory.
+	TempDir string
+	// Path of a file that a JSON line is appended to for every file decrypted, relative to the root. Empty means decrypting isn't audited.
+	AuditLog string
 }
 
 func (c *Config) UnmarshalYAML(node *yaml.Node) error {
 	type tmp struct {
-		Provider string
-		Config   map[string]interface{}
-		Suffixes SuffixesConfig
+		Provider           string
+		Config             map[string]interface{}
+		Suffixes           SuffixesConfig
+		Threads            *uint
+		FileThreads        *uint    `yaml:"fileThreads"`
+		MaxValueSize       int64    `yaml:"maxValueSize"`
+		EncryptPaths       []string `yaml:"encryptPaths"`
+		PlaintextPaths     []string `yaml:"plaintextPaths"`
+		EncryptAll         bool     `yaml:"encryptAll"`
+		SchemaFile         string   `yaml:"schemaFile"`
+		TempDir            string   `yaml:"tempDir"`
+		AuditLog           string   `yaml:"auditLog"`
+		GitignoreDecrypted bool     `yaml:"gitignoreDecrypted"`
+		SecretKeyPattern   string   `yaml:"secretKeyPattern"`
+		EncryptedTag       string   `yaml:"encryptedTag"`
+		EncryptionContext  string   `yaml:"encryptionContext"`
+		RemovedSecrets     string   `yaml:"removedSecrets"`
+		EmptyPlaintexts    string   `yaml:"emptyPlaintexts"`
+		StrictKeys         bool     `yaml:"strictKeys"`
+		RecordRotatedBy    bool     `yaml:"recordRotatedBy"`
+		Identity           string
+		Providers          map[string]struct {
+			Provider string
+			Config   map[string]interface{}
+		}
+		ProviderPaths []ProviderPath `yaml:"providerPaths"`
+		Cache         struct {
+			Enabled        *bool
+			Backend        string
+			MaxSize        int64  `yaml:"maxSize"`
+			MergeAfterIdle string `yaml:"mergeAfterIdle"`
+			Verify         bool
+			Shards         uint
+			SharedDir      string `yaml:"sharedDir"`
+			PerFile        bool   `yaml:"perFile"`
+			KeyScheme      string `yaml:"keyScheme"`
+		}
 	}
 	var t tmp
 	err := node.Decode(&t)
@@ -77,7 +205,89 @@ func (c *Config) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	c.Provider = provider
+	c.ProviderName = t.Provider
+	if len(t.Providers) > 0 {
+		router := crypto.Router{Default: provider, Named: map[string]crypto.Provider{}}
+		for name, p := range t.Providers {
+			// the name marks the provider's ciphertexts, followed by a colon, see crypto.Router
+			if name == "" || strings.Contains(name, ":") {
+				return fmt.Errorf("Invalid provider name %q: must be non-empty, without colons", name)
+			}
+			router.Named[name], err = crypto.NewProvider(p.Provider, p.Config)
+			if err != nil {
+				return fmt.Errorf("Invalid provider %s: %w", name, err)
+			}
+		}
+		c.Provider = router
+	}
+	for _, providerPath := range t.ProviderPaths {
+		if _, ok := t.Providers[providerPath.Provider]; !ok {
+			return fmt.Errorf("Invalid providerPaths: no provider named %s in providers", providerPath.Provider)
+		}
+	}
+	c.ProviderPaths = t.ProviderPaths
 	c.Suffixes = t.Suffixes
+	c.Threads = DefaultThreads
+	if t.Threads != nil {
+		c.Threads = *t.Threads
+	}
+	c.FileThreads = DefaultFileThreads
+	if t.FileThreads != nil {
+		c.FileThreads = *t.FileThreads
+	}
+	c.CacheEnabled = t.Cache.Enabled == nil || *t.Cache.Enabled
+	c.CacheMaxSize = t.Cache.MaxSize
+	c.CacheBackend = t.Cache.Backend
+	c.CacheVerify = t.Cache.Verify
+	c.CacheShards = t.Cache.Shards
+	c.CacheSharedDir = t.Cache.SharedDir
+	c.CachePerFile = t.Cache.PerFile
+	c.CacheKeyScheme = t.Cache.KeyScheme
+	if t.Cache.MergeAfterIdle != "" {
+		c.CacheMergeIdle, err = time.ParseDuration(t.Cache.MergeAfterIdle)
+		if err != nil {
+			return fmt.Errorf("Invalid cache.mergeAfterIdle: %w", err)
+		}
+	}
+	c.MaxValueSize = DefaultMaxValueSize
+	if t.MaxValueSize > 0 {
+		c.MaxValueSize = t.MaxValueSize
+	}
+	c.EncryptPaths = t.EncryptPaths
+	c.PlaintextPaths = t.PlaintextPaths
+	c.EncryptAll = t.EncryptAll
+	c.EncryptedTag = t.EncryptedTag
+	c.SchemaFile = t.SchemaFile
+	c.TempDir = t.TempDir
+	c.AuditLog = t.AuditLog
+	c.GitignoreDecrypted = t.GitignoreDecrypted
+	c.EncryptionContext = t.EncryptionContext
+	c.StrictKeys = t.StrictKeys
+	c.RecordRotatedBy = t.RecordRotatedBy
+	c.Identity = t.Identity
+	switch t.RemovedSecrets {
+	case "":
+		c.RemovedSecrets = RemovedSecretsDrop
+	case RemovedSecretsDrop, RemovedSecretsKeep, RemovedSecretsError:
+		c.RemovedSecrets = t.RemovedSecrets
+	default:
+		return fmt.Errorf("Invalid removedSecrets %q: must be one of %s, %s, or %s", t.RemovedSecrets, RemovedSecretsDrop, RemovedSecretsKeep, RemovedSecretsError)
+	}
+	switch t.EmptyPlaintexts {
+	case "":
+		c.EmptyPlaintexts = EmptyPlaintextsAllow
+	case EmptyPlaintextsError, EmptyPlaintextsAllow:
+		c.EmptyPlaintexts = t.EmptyPlaintexts
+	default:
+		return fmt.Errorf("Invalid emptyPlaintexts %q: must be %s or %s", t.EmptyPlaintexts, EmptyPlaintextsError, EmptyPlaintextsAllow)
+	}
+	c.SecretKeyPattern = DefaultSecretKeyPattern
+	if t.SecretKeyPattern != "" {
+		c.SecretKeyPattern, err = regexp.Compile(t.SecretKeyPattern)
+		if err != nil {
+			return fmt.Errorf("Invalid secretKeyPattern: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,155 @@
+package crypto
+
+import (
+	kms "cloud.google.com/go/kms/apiv1"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Run with -race: every goroutine shares one provider, as the workers encrypting and decrypting a file's values do.
+func TestSharedProviderConcurrency(t *testing.T) {
+	provider := NewPassphraseProvider("correct horse", testSalt, 1, 1024, 1)
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// copies bound to a context share the provider's derived keys
+			bound := provider.WithContext(fmt.Sprintf("context %d", i%4))
+			plaintext := fmt.Sprintf("value %d", i)
+			ciphertext, err := bound.Encrypt(plaintext)
+			if err != nil {
+				errs <- err
+				return
+			}
+			decrypted, err := bound.Decrypt(ciphertext)
+			if err != nil {
+				errs <- err
+			} else if decrypted != plaintext {
+				errs <- fmt.Errorf("Expected %q, got %q", plaintext, decrypted)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(provider.keys.keys) != 1 {
+		t.Errorf("Expected the key to be derived once and shared, got %d keys", len(provider.keys.keys))
+	}
+}
+
+func TestGoogleClientOnce(t *testing.T) {
+	original := newKMSClient
+	defer func() { newKMSClient = original }()
+	var created int32
+	newKMSClient = func(ctx context.Context, options ...option.ClientOption) (*kms.KeyManagementClient, error) {
+		atomic.AddInt32(&created, 1)
+		// never connected to, since the client is only created
+		return kms.NewKeyManagementClient(ctx, append(options, option.WithEndpoint("localhost:0"), option.WithoutAuthentication(), option.WithGRPCDialOption(grpc.WithInsecure()))...)
+	}
+	provider := NewGoogleProvider("project", "global", "keyring", "key")
+	clients := make([]*kms.KeyManagementClient, 64)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bound := provider.WithContext(fmt.Sprintf("context %d", i)).(GoogleProvider)
+			client, done, err := bound.kms(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer done()
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("Expected one KMS client to be created, got %d", created)
+	}
+	for i, client := range clients {
+		if client != clients[0] {
+			t.Errorf("Goroutine %d got a different client from the first", i)
+		}
+	}
+	err := Close(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new credentials need a new client
+	withIdentity, err := provider.WithIdentity("/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withIdentity.(GoogleProvider).client == provider.client {
+		t.Error("Expected a provider with new credentials not to share the old client")
+	}
+}
+
+func TestGoogleRefresh(t *testing.T) {
+	original := newKMSClient
+	defer func() { newKMSClient = original }()
+	var created int32
+	newKMSClient = func(ctx context.Context, options ...option.ClientOption) (*kms.KeyManagementClient, error) {
+		atomic.AddInt32(&created, 1)
+		return kms.NewKeyManagementClient(ctx, append(options, option.WithEndpoint("localhost:0"), option.WithoutAuthentication(), option.WithGRPCDialOption(grpc.WithInsecure()))...)
+	}
+	provider := NewGoogleProvider("project", "global", "keyring", "key")
+	bound := provider.WithContext("context").(GoogleProvider)
+	before, _, err := provider.kms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// refreshing a copy bound to another context gets every copy a new client, with credentials loaded afresh
+	ok, err := Refresh(bound)
+	if !ok || err != nil {
+		t.Fatalf("Refreshing gave %t, %v", ok, err)
+	}
+	after, _, err := provider.kms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before || created != 2 {
+		t.Errorf("Expected refreshing to create a new client, got %d clients", created)
+	}
+	if len(provider.client.stale) != 1 {
+		t.Errorf("Expected the old client to be kept until closing, got %d", len(provider.client.stale))
+	}
+	err = Close(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// KMS rejecting the credentials means they've expired, while other errors don't
+	err = kmsError(status.Error(codes.Unauthenticated, "token expired"))
+	if !errors.Is(err, ErrCredentialsRejected) || !strings.Contains(err.Error(), "token expired") {
+		t.Errorf("Unauthenticated error gave %v", err)
+	}
+	if errors.Is(kmsError(status.Error(codes.PermissionDenied, "denied")), ErrCredentialsRejected) {
+		t.Error("Permission denied error was taken for expired credentials")
+	}
+
+	// a router refreshes whichever of its providers can
+	router := Router{Default: NoopProvider{}, Named: map[string]Provider{"kms": NewGoogleProvider("project", "global", "keyring", "key")}}
+	if ok, err := Refresh(router); !ok || err != nil {
+		t.Errorf("Refreshing a router gave %t, %v", ok, err)
+	}
+	if _, err := Refresh(Router{Default: NoopProvider{}}); err == nil {
+		t.Error("Refreshing a router whose providers can't refresh didn't fail")
+	}
+}
@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Seconds a helper command may run for, if not otherwise configured.
+	DefaultExecTimeout = 30
+	// Bytes a helper command may write to stdout, if not otherwise configured.
+	DefaultExecMaxOutput = 1024 * 1024
+	maxExecTimeout       = 60 * 60
+	maxExecMaxOutput     = 1024 * 1024 * 1024
+)
+
+// A provider that delegates to an external helper command. The command is run with an extra argument, "encrypt" or "decrypt", with the input on stdin, and must write the output to stdout. A helper that runs for longer than Timeout, or writes more than MaxOutput bytes, is killed along with any processes it started.
+type ExecProvider struct {
+	Command []string
+	// Zero means DefaultExecTimeout seconds.
+	Timeout time.Duration
+	// Zero means DefaultExecMaxOutput bytes.
+	MaxOutput int
+	// Limits how many helpers run at once, if set.
+	limiter callLimiter
+}
+
+func (p ExecProvider) Encrypt(plaintext string) ([]byte, error) {
+	return p.run("encrypt", []byte(plaintext))
+}
+
+func (p ExecProvider) Decrypt(ciphertext []byte) (string, error) {
+	plaintext, err := p.run("decrypt", ciphertext)
+	return string(plaintext), err
+}
+
+func (p ExecProvider) Recipients() []string {
+	return []string{"exec:" + strings.Join(p.Command, " ")}
+}
+
+// Whatever the command has access to is up to it, so its key is taken to be available.
+func (p ExecProvider) AvailableKeys() []string {
+	return p.Recipients()
+}
+
+// Run the helper command for an operation, returning its stdout.
+func (p ExecProvider) run(operation string, input []byte) ([]byte, error) {
+	if len(p.Command) == 0 || p.Command[0] == "" {
+		return nil, fmt.Errorf("Required setting: .config.command")
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultExecTimeout * time.Second
+	}
+	if p.MaxOutput <= 0 {
+		p.MaxOutput = DefaultExecMaxOutput
+	}
+	p.limiter.acquire()
+	defer p.limiter.release()
+	// copy the arguments, rather than appending to a Command shared between concurrent calls
+	args := append(append([]string{}, p.Command[1:]...), operation)
+	name := strings.Join(append([]string{p.Command[0]}, args...), " ")
+	cmd := exec.Command(p.Command[0], args...)
+	setProcessGroup(cmd)
+	cmd.Stdin = bytes.NewReader(input)
+	stderr := &cappedBuffer{max: p.MaxOutput}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("Error running %s: %w", name, err)
+	}
+
+	var mutex sync.Mutex
+	timedOut := false
+	timer := time.AfterFunc(p.Timeout, func() {
+		mutex.Lock()
+		timedOut = true
+		mutex.Unlock()
+		killProcessGroup(cmd)
+	})
+	output, readErr := ioutil.ReadAll(io.LimitReader(stdout, int64(p.MaxOutput)+1))
+	oversized := len(output) > p.MaxOutput
+	if oversized {
+		killProcessGroup(cmd)
+	}
+	waitErr := cmd.Wait()
+	timer.Stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	switch {
+	case timedOut:
+		return nil, fmt.Errorf("%s timed out after %s%s", name, p.Timeout, stderr.describe())
+	case oversized:
+		return nil, fmt.Errorf("%s wrote more than %d bytes of output%s", name, p.MaxOutput, stderr.describe())
+	case readErr != nil:
+		return nil, fmt.Errorf("Error reading output of %s: %w", name, readErr)
+	case waitErr != nil:
+		return nil, fmt.Errorf("%s failed: %w%s", name, waitErr, stderr.describe())
+	}
+	return output, nil
+}
+
+// A buffer that keeps at most max bytes, silently discarding the rest so that the writer is never blocked.
+type cappedBuffer struct {
+	buffer bytes.Buffer
+	max    int
+}
+
+func (b *cappedBuffer) Write(data []byte) (int, error) {
+	if room := b.max - b.buffer.Len(); room > 0 {
+		if len(data) > room {
+			b.buffer.Write(data[:room])
+		} else {
+			b.buffer.Write(data)
+		}
+	}
+	return len(data), nil
+}
+
+// Describe the captured output for the end of an error message, if there is any.
+func (b *cappedBuffer) describe() string {
+	output := strings.TrimSpace(b.buffer.String())
+	if output == "" {
+		return ""
+	}
+	return fmt.Sprintf("; stderr: %s", output)
+}
+
+func newExecProvider(config map[string]interface{}) (Provider, error) {
+	var command []string
+	switch value := config["command"].(type) {
+	// a string is the path of the helper alone, never split on whitespace, so that arguments can't be mis-split; arguments need a list
+	case string:
+		if value != "" {
+			command = []string{value}
+		}
+	case []interface{}:
+		for _, arg := range value {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf(".config.command must be a string or a list of strings")
+			}
+			command = append(command, s)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf(".config.command must be a string or a list of strings")
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("Required setting: .config.command")
+	}
+	timeout, err := getUint(config, "timeout", DefaultExecTimeout, maxExecTimeout)
+	if err != nil {
+		return nil, err
+	}
+	maxOutput, err := getUint(config, "maxOutput", DefaultExecMaxOutput, maxExecMaxOutput)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := newCallLimiter(config)
+	if err != nil {
+		return nil, err
+	}
+	return ExecProvider{
+		Command:   command,
+		Timeout:   time.Duration(timeout) * time.Second,
+		MaxOutput: int(maxOutput),
+		limiter:   limiter,
+	}, nil
+}
@@ -0,0 +1,159 @@
+package crypto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Get an exec provider running a shell script, which gets the operation as $1.
+func shellProvider(t *testing.T, script string) ExecProvider {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	return ExecProvider{Command: []string{"sh", "-c", script, "sh"}}
+}
+
+func TestExecRoundTrip(t *testing.T) {
+	// rot13 is its own inverse
+	provider := shellProvider(t, "tr a-zA-Z n-za-mN-ZA-M")
+	ciphertext, err := provider.Encrypt("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ciphertext) != "Uryyb" {
+		t.Errorf("Encrypting gave %q, expected %q", ciphertext, "Uryyb")
+	}
+	plaintext, err := provider.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "Hello" {
+		t.Errorf("Decrypting gave %q, expected %q", plaintext, "Hello")
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	provider := shellProvider(t, `echo "no key for $1" >&2; exit 3`)
+	_, err := provider.Encrypt("test")
+	if err == nil || !strings.Contains(err.Error(), "no key for encrypt") {
+		t.Errorf("Failing helper gave error %v, expected it to include stderr", err)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	// the helper's child keeps stdout open, so only killing the whole process group ends it
+	provider := shellProvider(t, "echo stuck >&2; sleep 60 & wait")
+	provider.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := provider.Encrypt("test")
+	if err == nil {
+		t.Fatal("Hanging helper did not fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Hanging helper took %s to be killed", elapsed)
+	}
+	if !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), "stuck") {
+		t.Errorf("Hanging helper gave error %q, expected a timeout including stderr", err)
+	}
+}
+
+func TestExecMaxOutput(t *testing.T) {
+	provider := shellProvider(t, "yes")
+	provider.MaxOutput = 1024
+	_, err := provider.Decrypt([]byte("test"))
+	if err == nil || !strings.Contains(err.Error(), "more than 1024 bytes") {
+		t.Errorf("Oversized output gave error %v, expected it to be rejected", err)
+	}
+}
+
+func TestExecConfig(t *testing.T) {
+	provider, err := NewProvider("exec", map[string]interface{}{"command": []interface{}{"helper", "--key", "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := provider.(ExecProvider)
+	if strings.Join(p.Command, "|") != "helper|--key|a" || p.Timeout != DefaultExecTimeout*time.Second || p.MaxOutput != DefaultExecMaxOutput {
+		t.Errorf("Unexpected provider from config: %+v", p)
+	}
+	// a string isn't split into arguments
+	provider, err = NewProvider("exec", map[string]interface{}{"command": "/opt/my helpers/helper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := provider.(ExecProvider); len(p.Command) != 1 || p.Command[0] != "/opt/my helpers/helper" {
+		t.Errorf("Command string was split into %q", p.Command)
+	}
+	for _, config := range []map[string]interface{}{
+		{},
+		{"command": 3},
+		{"command": []interface{}{}},
+		{"command": "helper", "timeout": 0},
+		{"command": "helper", "maxOutput": -1},
+		{"command": "helper", "maxConcurrency": 0},
+	} {
+		_, err = NewProvider("exec", config)
+		if err == nil {
+			t.Errorf("Invalid config %v was accepted", config)
+		}
+	}
+}
+
+func TestExecMaxConcurrency(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	dir, err := ioutil.TempDir("", "yamlcrypt-concurrency-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// each helper counts the helpers running alongside it, itself included
+	script := `input=$(cat); touch "$1/running/$$"; sleep 0.1; ls "$1/running" | wc -l >> "$1/counts"; rm "$1/running/$$"; printf %s "$input"`
+	err = os.Mkdir(filepath.Join(dir, "running"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewProvider("exec", map[string]interface{}{"command": []interface{}{"sh", "-c", script, "sh", dir}, "maxConcurrency": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := provider.Encrypt(fmt.Sprint(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	data, err := ioutil.ReadFile(filepath.Join(dir, "counts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	most := 0
+	for _, line := range strings.Fields(string(data)) {
+		count, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count > most {
+			most = count
+		}
+	}
+	if most > 3 {
+		t.Errorf("%d helpers ran at once, expected at most 3", most)
+	}
+	if most < 2 {
+		t.Errorf("At most %d helper ran at once, expected them to run in parallel", most)
+	}
+}
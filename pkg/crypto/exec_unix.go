@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package crypto
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Start the command in its own process group, so that it can be killed along with anything it starts.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,12 @@
+package crypto
+
+import "os/exec"
+
+// Windows has no process groups to start the command in; only the command itself is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"google.golang.org/api/option"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
 )
 
 type GoogleProvider struct {
@@ -14,25 +17,84 @@ type GoogleProvider struct {
 	Keyring  string
 	Key      string
 	Options  []option.ClientOption
+	// Passed to KMS as additional authenticated data, binding ciphertexts to it.
+	Context string
+	client  *kmsClient
+	// Limits how many calls to KMS are in flight at once, if set.
+	limiter callLimiter
+}
+
+// A KMS client, created the first time it's needed and then shared by every goroutine using the provider, and by copies of the provider bound to other contexts.
+type kmsClient struct {
+	mutex   sync.Mutex
+	created bool
+	client  *kms.KeyManagementClient
+	err     error
+	// Clients replaced by refreshing the credentials. Calls still in flight may be using them, so they're only closed along with the current one.
+	stale []*kms.KeyManagementClient
+}
+
+// Creates KMS clients. Tests can replace it to avoid connecting to KMS.
+var newKMSClient = kms.NewKeyManagementClient
+
+func NewGoogleProvider(project, location, keyring, key string, options ...option.ClientOption) GoogleProvider {
+	return GoogleProvider{
+		Project:  project,
+		Location: location,
+		Keyring:  keyring,
+		Key:      key,
+		Options:  options,
+		client:   &kmsClient{},
+	}
 }
 
 func (p GoogleProvider) keyName() string {
 	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", p.Project, p.Location, p.Keyring, p.Key)
 }
 
+// Get a KMS client, along with a function to call once done with it. A provider made with NewGoogleProvider creates its client once, the first time it's needed, and keeps it until it's closed; if creating it fails, every call gets the error. Otherwise, each call gets a client of its own.
+func (p GoogleProvider) kms(ctx context.Context) (*kms.KeyManagementClient, func(), error) {
+	if p.client == nil {
+		client, err := newKMSClient(ctx, p.Options...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() { client.Close() }, nil
+	}
+	p.client.mutex.Lock()
+	defer p.client.mutex.Unlock()
+	if !p.client.created {
+		// the shared client outlives any one call, so it mustn't be tied to the call's context
+		p.client.client, p.client.err = newKMSClient(context.Background(), p.Options...)
+		p.client.created = true
+	}
+	return p.client.client, func() {}, p.client.err
+}
+
+// Mark errors from KMS rejecting the credentials, eg. because a short-lived token expired, as ErrCredentialsRejected.
+func kmsError(err error) error {
+	if status.Code(err) == codes.Unauthenticated {
+		return CredentialsRejected(err)
+	}
+	return err
+}
+
 func (p GoogleProvider) Encrypt(plaintext string) ([]byte, error) {
 	ctx := context.Background()
-	client, err := kms.NewKeyManagementClient(ctx, p.Options...)
+	client, done, err := p.kms(ctx)
 	if err != nil {
 		return []byte{}, err
 	}
-	defer client.Close()
+	defer done()
+	p.limiter.acquire()
+	defer p.limiter.release()
 	result, err := client.Encrypt(ctx, &kmspb.EncryptRequest{
-		Name:      p.keyName(),
-		Plaintext: []byte(plaintext),
+		Name:                        p.keyName(),
+		Plaintext:                   []byte(plaintext),
+		AdditionalAuthenticatedData: []byte(p.Context),
 	})
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, kmsError(err)
 	} else {
 		return result.Ciphertext, err
 	}
@@ -40,18 +102,86 @@ func (p GoogleProvider) Encrypt(plaintext string) ([]byte, error) {
 
 func (p GoogleProvider) Decrypt(ciphertext []byte) (string, error) {
 	ctx := context.Background()
-	client, err := kms.NewKeyManagementClient(ctx, p.Options...)
+	client, done, err := p.kms(ctx)
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
+	defer done()
+	p.limiter.acquire()
+	defer p.limiter.release()
 	result, err := client.Decrypt(ctx, &kmspb.DecryptRequest{
-		Name:       p.keyName(),
-		Ciphertext: ciphertext,
+		Name:                        p.keyName(),
+		Ciphertext:                  ciphertext,
+		AdditionalAuthenticatedData: []byte(p.Context),
 	})
 	if err != nil {
-		return "", err
+		return "", kmsError(err)
 	} else {
 		return string(result.Plaintext), err
 	}
 }
+
+func (p GoogleProvider) Recipients() []string {
+	return []string{p.keyName()}
+}
+
+// Whether the credentials are allowed to decrypt with the key is only known once KMS is asked, so the key is taken to be available.
+func (p GoogleProvider) AvailableKeys() []string {
+	return p.Recipients()
+}
+
+// Use the credentials in the given file, rather than the application default credentials.
+func (p GoogleProvider) WithIdentity(path string) (Provider, error) {
+	options := make([]option.ClientOption, len(p.Options), len(p.Options)+1)
+	copy(options, p.Options)
+	p.Options = append(options, option.WithCredentialsFile(path))
+	// the shared client was created with the old credentials, if it was created at all
+	if p.client != nil {
+		p.client = &kmsClient{}
+	}
+	return p, nil
+}
+
+// Bind ciphertexts to the given context, using it as additional authenticated data.
+func (p GoogleProvider) WithContext(context string) Provider {
+	p.Context = context
+	return p
+}
+
+// Create a new KMS client the next time one is needed, so that credentials are loaded afresh, eg. once a short-lived token has been renewed. Copies of the provider bound to other contexts share the client, so it's replaced for them too.
+func (p GoogleProvider) Refresh() error {
+	if p.client == nil {
+		// every call creates a client of its own anyways
+		return nil
+	}
+	p.client.mutex.Lock()
+	defer p.client.mutex.Unlock()
+	if p.client.client != nil {
+		p.client.stale = append(p.client.stale, p.client.client)
+	}
+	p.client.created = false
+	p.client.client, p.client.err = nil, nil
+	return nil
+}
+
+// Close the shared KMS client, if it was created, along with any it replaced. Copies of the provider bound to other contexts share it, so it's closed for them too. The provider can't be used afterwards.
+func (p GoogleProvider) Close() error {
+	if p.client == nil {
+		return nil
+	}
+	p.client.mutex.Lock()
+	defer p.client.mutex.Unlock()
+	// make sure no client is created after closing
+	p.client.created = true
+	var err error
+	for _, client := range append(p.client.stale, p.client.client) {
+		if client == nil {
+			continue
+		}
+		if closeErr := client.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	p.client.stale = nil
+	return err
+}
@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Sizes of the nonce and authentication tag that AES-GCM adds to each plaintext.
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// What can be told about a ciphertext without decrypting it.
+type CiphertextInfo struct {
+	// Name of the configured provider the ciphertext is marked as encrypted by, or empty for the default provider. See Router.
+	Marker string `json:"marker"`
+	// Name and algorithm of the provider that decrypts the ciphertext, as given by Describe. Empty if unknown.
+	Provider  string `json:"provider"`
+	Algorithm string `json:"algorithm"`
+	// Version of the provider's ciphertext format, for providers that record one in each ciphertext, or zero.
+	Version int `json:"version"`
+	// Parameters recorded in the ciphertext, eg. the argon2id settings of the passphrase provider.
+	Parameters map[string]string `json:"parameters"`
+	// Recipients of the provider that decrypts the ciphertext, identifying the keys it encrypts to, never the keys themselves.
+	Recipients []string `json:"recipients"`
+	// Whether the ciphertext is known to be authenticated, so that tampering with it is detected when it's decrypted.
+	Authenticated bool `json:"authenticated"`
+	// Size of the ciphertext in bytes, not counting its marker.
+	Size int `json:"size"`
+	// Size of the plaintext in bytes, if it can be told from the size of the ciphertext, or -1.
+	PlaintextSize int `json:"plaintext_size"`
+}
+
+// Tell what can be told about a ciphertext from the ciphertext itself and the configured provider, without decrypting it, so that no key is needed.
+func Inspect(provider Provider, ciphertext []byte) CiphertextInfo {
+	info := CiphertextInfo{Parameters: map[string]string{}, Recipients: []string{}, PlaintextSize: -1}
+	info.Marker, ciphertext = splitMarker(ciphertext)
+	info.Size = len(ciphertext)
+	if r, ok := provider.(Router); ok {
+		provider = r.Default
+		if info.Marker != "" {
+			provider = r.Named[info.Marker]
+		}
+	} else if info.Marker != "" {
+		provider = nil
+	}
+	if provider == nil {
+		return info
+	}
+	info.Provider, info.Algorithm = Describe(provider)
+	info.Recipients = append(info.Recipients, provider.Recipients()...)
+	switch provider.(type) {
+	case NoopProvider:
+		info.PlaintextSize = len(ciphertext)
+	case KeyProvider:
+		info.Authenticated = true
+		info.PlaintextSize = gcmPlaintextSize(len(ciphertext))
+	case PassphraseProvider:
+		info.Authenticated = true
+		if len(ciphertext) < passphraseHeaderLength {
+			break
+		}
+		info.Version = int(ciphertext[0])
+		if info.Version != passphraseVersion {
+			break
+		}
+		info.Parameters["time"] = fmt.Sprint(binary.BigEndian.Uint32(ciphertext[1:5]))
+		info.Parameters["memory"] = fmt.Sprintf("%dKiB", binary.BigEndian.Uint32(ciphertext[5:9]))
+		info.Parameters["threads"] = fmt.Sprint(ciphertext[9])
+		info.Parameters["salt_length"] = fmt.Sprint(ciphertext[10])
+		info.PlaintextSize = gcmPlaintextSize(len(ciphertext) - passphraseHeaderLength - int(ciphertext[10]))
+	case PKCS11Provider:
+		info.Authenticated = true
+		if len(ciphertext) < 1 {
+			break
+		}
+		info.Version = int(ciphertext[0])
+		if info.Version == pkcs11Version {
+			info.PlaintextSize = gcmPlaintextSize(len(ciphertext) - 1)
+		}
+	case GoogleProvider:
+		// Cloud KMS encrypts symmetric keys with AES-256-GCM, but its ciphertexts are opaque
+		info.Authenticated = true
+	}
+	return info
+}
+
+// Get the size of the plaintext sealed in an AES-GCM ciphertext of the given size, with its nonce prepended, or -1 if it's too short to be one.
+func gcmPlaintextSize(size int) int {
+	if size < gcmNonceSize+gcmTagSize {
+		return -1
+	}
+	return size - gcmNonceSize - gcmTagSize
+}
@@ -0,0 +1,50 @@
+package crypto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	_, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := NewPassphraseProvider("hunter2", []byte("0123456789abcdef"), 1, 1024, 1)
+	plaintext := "seventeen bytes!!"
+	router := Router{Default: key, Named: map[string]Provider{"ops": passphrase}}
+	ops, err := ForName(router, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name       string
+		encrypt    Provider
+		inspect    Provider
+		expected   CiphertextInfo
+		parameters map[string]string
+	}{
+		{"noop", NoopProvider{}, NoopProvider{}, CiphertextInfo{Provider: "noop", Algorithm: "none", Recipients: []string{}, Size: 17, PlaintextSize: 17}, map[string]string{}},
+		{"key", key, key, CiphertextInfo{Provider: "key", Algorithm: "aes-256-gcm", Recipients: key.Recipients(), Authenticated: true, Size: 45, PlaintextSize: 17}, map[string]string{}},
+		{"passphrase", passphrase, passphrase, CiphertextInfo{Provider: "passphrase", Algorithm: "aes-256-gcm-argon2id", Version: 1, Recipients: passphrase.Recipients(), Authenticated: true, Size: 72, PlaintextSize: 17}, map[string]string{"time": "1", "memory": "1024KiB", "threads": "1", "salt_length": "16"}},
+		// values encrypted by a named provider are inspected as that provider's
+		{"default", router, router, CiphertextInfo{Provider: "key", Algorithm: "aes-256-gcm", Recipients: key.Recipients(), Authenticated: true, Size: 45, PlaintextSize: 17}, map[string]string{}},
+		{"named", ops, router, CiphertextInfo{Marker: "ops", Provider: "passphrase", Algorithm: "aes-256-gcm-argon2id", Version: 1, Recipients: passphrase.Recipients(), Authenticated: true, Size: 72, PlaintextSize: 17}, map[string]string{"time": "1", "memory": "1024KiB", "threads": "1", "salt_length": "16"}},
+		// a provider that isn't configured can't be told anything about
+		{"unconfigured", ops, key, CiphertextInfo{Marker: "ops", Recipients: []string{}, Size: 72, PlaintextSize: -1}, map[string]string{}},
+	} {
+		ciphertext, err := c.encrypt.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.expected.Parameters = c.parameters
+		info := Inspect(c.inspect, ciphertext)
+		if !reflect.DeepEqual(info, c.expected) {
+			t.Errorf("Inspecting a %s ciphertext gave:\n%+v\nexpected:\n%+v", c.name, info, c.expected)
+		}
+	}
+	// a truncated ciphertext doesn't have a plaintext size
+	if info := Inspect(key, []byte("short")); info.PlaintextSize != -1 {
+		t.Errorf("Inspecting a truncated ciphertext gave a plaintext size of %d", info.PlaintextSize)
+	}
+}
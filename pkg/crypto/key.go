@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Prefix of an encoded symmetric key, so that a file holding one can be told apart from other credentials.
+const KeyPrefix = "YAMLCRYPT-KEY-"
+
+// A provider that encrypts with AES-GCM, using a symmetric key given directly rather than held by a cloud service. Used to share secrets ad hoc, with a freshly generated key handed over separately.
+type KeyProvider struct {
+	Key []byte
+	// Source of nonces. Nil means crypto/rand; tests can set a seeded source to get the same ciphertexts on every run.
+	Rand io.Reader
+}
+
+// Generate a random key, returning its encoded form along with a provider using it.
+func GenerateKey() (string, KeyProvider, error) {
+	key := make([]byte, keyLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", KeyProvider{}, err
+	}
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(key), KeyProvider{Key: key}, nil
+}
+
+// Get a provider using an encoded key, as returned by GenerateKey.
+func ParseKey(encoded string) (KeyProvider, error) {
+	encoded = strings.TrimSpace(encoded)
+	if !strings.HasPrefix(encoded, KeyPrefix) {
+		return KeyProvider{}, fmt.Errorf("Key must start with %s", KeyPrefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, KeyPrefix))
+	if err != nil {
+		return KeyProvider{}, fmt.Errorf("Key is not validly encoded: %w", err)
+	}
+	if len(key) != keyLength {
+		return KeyProvider{}, fmt.Errorf("Key must be %d bytes, not %d", keyLength, len(key))
+	}
+	return KeyProvider{Key: key}, nil
+}
+
+// Get a provider using the encoded key held in a file. The second return value is false if the file doesn't hold a key at all, eg. because it holds credentials for another provider.
+func ReadKeyFile(path string) (KeyProvider, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return KeyProvider{}, false, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(data)), KeyPrefix) {
+		return KeyProvider{}, false, nil
+	}
+	provider, err := ParseKey(string(data))
+	wipe(data)
+	return provider, true, err
+}
+
+func (p KeyProvider) Encrypt(plaintext string) ([]byte, error) {
+	aead, err := p.aead()
+	if err != nil {
+		return []byte{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(randomSource(p.Rand), nonce)
+	if err != nil {
+		return []byte{}, err
+	}
+	return aead.Seal(nonce, nonce, []byte(plaintext), nil), nil
+}
+
+func (p KeyProvider) Decrypt(ciphertext []byte) (string, error) {
+	aead, err := p.aead()
+	if err != nil {
+		return "", err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return "", errors.New("Ciphertext is truncated")
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Wrong key, or the ciphertext was modified")
+	}
+	return string(plaintext), nil
+}
+
+// Identify the key by a hash, never by the key itself.
+func (p KeyProvider) Recipients() []string {
+	sum := sha256.Sum256(p.Key)
+	return []string{"key:" + hex.EncodeToString(sum[:8])}
+}
+
+// The key is available until it's been wiped.
+func (p KeyProvider) AvailableKeys() []string {
+	if wiped(p.Key) {
+		return []string{}
+	}
+	return p.Recipients()
+}
+
+// Zero the key. The provider refuses to encrypt or decrypt afterwards, rather than using an all-zero key.
+func (p KeyProvider) Wipe() {
+	wipe(p.Key)
+}
+
+func (p KeyProvider) aead() (cipher.AEAD, error) {
+	if wiped(p.Key) {
+		return nil, errors.New("Key has been wiped")
+	}
+	block, err := aes.NewCipher(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
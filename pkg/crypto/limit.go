@@ -0,0 +1,30 @@
+package crypto
+
+// Largest maxConcurrency a provider can be configured with.
+const maxConcurrencyLimit = 1024
+
+// A semaphore limiting how many calls to a provider are in flight at once, independently of how many threads make them, eg. to stay under a remote service's rate limit. Copies of the provider, eg. those bound to other contexts, share it. A nil limiter doesn't limit anything.
+type callLimiter chan struct{}
+
+// Wait for a slot to make a call in.
+func (l callLimiter) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+// Give back the slot of a call that's done.
+func (l callLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// Get a limiter for a provider from the maxConcurrency setting in its config, the most calls to it that can be in flight at once. Unset means no limit beyond the number of threads.
+func newCallLimiter(config map[string]interface{}) (callLimiter, error) {
+	limit, err := getUint(config, "maxConcurrency", 0, maxConcurrencyLimit)
+	if err != nil || limit == 0 {
+		return nil, err
+	}
+	return make(callLimiter, limit), nil
+}
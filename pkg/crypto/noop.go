@@ -19,3 +19,11 @@ func (p NoopProvider) Decrypt(ciphertext []byte) (string, error) {
 	}
 	return string(ciphertext), nil
 }
+
+func (p NoopProvider) Recipients() []string {
+	return []string{}
+}
+
+func (p NoopProvider) AvailableKeys() []string {
+	return []string{}
+}
@@ -0,0 +1,259 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// Environment variable holding the passphrase for the passphrase provider.
+	PassphraseEnv = "YAMLCRYPT_PASSPHRASE"
+	// Argon2id parameters, if not otherwise configured. Memory is in KiB.
+	DefaultPassphraseTime    = 1
+	DefaultPassphraseMemory  = 64 * 1024
+	DefaultPassphraseThreads = 4
+	// Largest parameters that can be configured. A ciphertext's header can't ask for more than the configured parameters, or the defaults if they're larger, so that a tampered header can't exhaust time or memory.
+	maxPassphraseTime   = 16
+	maxPassphraseMemory = 1024 * 1024
+
+	passphraseVersion = 1
+	saltLength        = 16
+	keyLength         = 32
+	// Version, time, memory, threads and salt length, followed by the salt.
+	passphraseHeaderLength = 1 + 4 + 4 + 1 + 1
+)
+
+// A provider that encrypts with AES-GCM, using a key derived from a passphrase with argon2id. Each ciphertext starts with a header holding the salt and argon2id parameters it was encrypted with, so that the parameters can be tuned without breaking existing ciphertexts. The header is authenticated along with the ciphertext.
+type PassphraseProvider struct {
+	Passphrase string
+	Salt       []byte
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	// Authenticated along with each ciphertext, binding ciphertexts to it.
+	Context string
+	// Source of nonces. Nil means crypto/rand; tests can set a seeded source to get the same ciphertexts on every run.
+	Rand io.Reader
+	keys *keyCache
+}
+
+// Derived keys, by header, since deriving a key is deliberately slow.
+type keyCache struct {
+	keys  map[string][]byte
+	mutex sync.Mutex
+	// Set once the provider has been wiped, forgetting its passphrase.
+	wiped bool
+}
+
+func NewPassphraseProvider(passphrase string, salt []byte, time, memory uint32, threads uint8) PassphraseProvider {
+	return PassphraseProvider{
+		Passphrase: passphrase,
+		Salt:       salt,
+		Time:       time,
+		Memory:     memory,
+		Threads:    threads,
+		keys:       &keyCache{keys: map[string][]byte{}},
+	}
+}
+
+// Generate a random salt for a passphrase provider, base64-encoded as in its config.
+func NewSalt() string {
+	salt := make([]byte, saltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(salt)
+}
+
+func (p PassphraseProvider) Encrypt(plaintext string) ([]byte, error) {
+	header := make([]byte, passphraseHeaderLength, passphraseHeaderLength+len(p.Salt))
+	header[0] = passphraseVersion
+	binary.BigEndian.PutUint32(header[1:5], p.Time)
+	binary.BigEndian.PutUint32(header[5:9], p.Memory)
+	header[9] = p.Threads
+	header[10] = uint8(len(p.Salt))
+	header = append(header, p.Salt...)
+	aead, err := p.aead(header)
+	if err != nil {
+		return []byte{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(randomSource(p.Rand), nonce)
+	if err != nil {
+		return []byte{}, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, []byte(plaintext), p.additionalData(header)), nil
+}
+
+func (p PassphraseProvider) Decrypt(ciphertext []byte) (string, error) {
+	if len(ciphertext) < passphraseHeaderLength || ciphertext[0] != passphraseVersion {
+		return "", errors.New("Ciphertext was not encrypted by the passphrase provider")
+	}
+	headerLength := passphraseHeaderLength + int(ciphertext[10])
+	if len(ciphertext) < headerLength {
+		return "", errors.New("Ciphertext is truncated")
+	}
+	header := ciphertext[:headerLength]
+	aead, err := p.aead(header)
+	if err != nil {
+		return "", err
+	}
+	rest := ciphertext[headerLength:]
+	if len(rest) < aead.NonceSize() {
+		return "", errors.New("Ciphertext is truncated")
+	}
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], p.additionalData(header))
+	if err != nil {
+		return "", errors.New("Wrong passphrase, or the ciphertext was modified")
+	}
+	return string(plaintext), nil
+}
+
+func (p PassphraseProvider) Recipients() []string {
+	return []string{"passphrase:" + hex.EncodeToString(p.Salt)}
+}
+
+// The key is available if a passphrase is set, though whether it's the right one is only known once a value is decrypted.
+func (p PassphraseProvider) AvailableKeys() []string {
+	if p.passphrase() == "" {
+		return []string{}
+	}
+	return p.Recipients()
+}
+
+// Bind ciphertexts to the given context, authenticating it along with them.
+func (p PassphraseProvider) WithContext(context string) Provider {
+	p.Context = context
+	return p
+}
+
+// Zero and forget the derived keys, and forget the passphrase. Copies of the provider share them, so they're wiped along with it. A wiped provider can't be used again.
+func (p PassphraseProvider) Wipe() {
+	if p.keys == nil {
+		return
+	}
+	p.keys.mutex.Lock()
+	defer p.keys.mutex.Unlock()
+	for header, key := range p.keys.keys {
+		wipe(key)
+		delete(p.keys.keys, header)
+	}
+	p.keys.wiped = true
+}
+
+// Get the passphrase, or an empty string once the provider has been wiped.
+func (p PassphraseProvider) passphrase() string {
+	if p.keys != nil {
+		p.keys.mutex.Lock()
+		defer p.keys.mutex.Unlock()
+		if p.keys.wiped {
+			return ""
+		}
+	}
+	return p.Passphrase
+}
+
+func (p PassphraseProvider) additionalData(header []byte) []byte {
+	return append(append([]byte{}, header...), p.Context...)
+}
+
+// Get the AEAD for the key derived with the salt and parameters in a ciphertext header.
+func (p PassphraseProvider) aead(header []byte) (cipher.AEAD, error) {
+	if p.Passphrase == "" {
+		return nil, fmt.Errorf("No passphrase set: set it in the %s environment variable", PassphraseEnv)
+	}
+	time := binary.BigEndian.Uint32(header[1:5])
+	memory := binary.BigEndian.Uint32(header[5:9])
+	threads := header[9]
+	salt := header[passphraseHeaderLength:]
+	if time == 0 || memory == 0 || threads == 0 {
+		return nil, fmt.Errorf("Invalid argon2id parameters: time %d, memory %dKiB, threads %d", time, memory, threads)
+	}
+	if maxTime, maxMemory := atLeast(p.Time, DefaultPassphraseTime), atLeast(p.Memory, DefaultPassphraseMemory); time > maxTime || memory > maxMemory {
+		return nil, fmt.Errorf("Ciphertext asks for argon2id parameters of time %d and memory %dKiB, more than the configured time %d and memory %dKiB", time, memory, maxTime, maxMemory)
+	}
+	if len(salt) < saltLength {
+		return nil, fmt.Errorf("Salt must be at least %d bytes", saltLength)
+	}
+	keys := p.keys
+	if keys == nil {
+		keys = &keyCache{keys: map[string][]byte{}}
+	}
+	keys.mutex.Lock()
+	if keys.wiped {
+		keys.mutex.Unlock()
+		return nil, errors.New("Passphrase has been wiped")
+	}
+	key, ok := keys.keys[string(header)]
+	if !ok {
+		passphrase := []byte(p.Passphrase)
+		key = argon2.IDKey(passphrase, salt, time, memory, threads, keyLength)
+		wipe(passphrase)
+		keys.keys[string(header)] = key
+	}
+	keys.mutex.Unlock()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newPassphraseProvider(config map[string]interface{}) (Provider, error) {
+	encodedSalt, err := getString(config, "salt")
+	if err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, fmt.Errorf(".config.salt must be base64-encoded: %w", err)
+	}
+	if len(salt) < saltLength {
+		return nil, fmt.Errorf(".config.salt must be at least %d bytes", saltLength)
+	}
+	time, err := getUint(config, "time", DefaultPassphraseTime, maxPassphraseTime)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := getUint(config, "memory", DefaultPassphraseMemory, maxPassphraseMemory)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := getUint(config, "threads", DefaultPassphraseThreads, 1<<8-1)
+	if err != nil {
+		return nil, err
+	}
+	return NewPassphraseProvider(os.Getenv(PassphraseEnv), salt, uint32(time), uint32(memory), uint8(threads)), nil
+}
+
+func atLeast(value, min uint32) uint32 {
+	if value < min {
+		return min
+	}
+	return value
+}
+
+// Get an optional positive integer setting, no larger than max.
+func getUint(config map[string]interface{}, key string, defaultValue, max uint64) (uint64, error) {
+	value, ok := config[key]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+	intValue, ok := value.(int)
+	if !ok || intValue <= 0 || uint64(intValue) > max {
+		return 0, fmt.Errorf(".config.%s must be an integer between 1 and %d", key, max)
+	}
+	return uint64(intValue), nil
+}
@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	// Environment variable holding the PIN of the token for the pkcs11 provider. Never read from the config, so that it isn't committed along with it.
+	PKCS11PinEnv = "YAMLCRYPT_PKCS11_PIN"
+
+	pkcs11Version = 1
+	// Size of the nonce each value is encrypted with, and of the authentication tag, in bits, that the token appends to it.
+	pkcs11NonceSize = gcmNonceSize
+	pkcs11TagBits   = gcmTagSize * 8
+)
+
+// A provider that encrypts with AES-GCM on a PKCS#11 token, eg. a hardware security module or smart card, using a secret key held by the token, so that the key never leaves it, let alone touches disk. Each ciphertext starts with a version, followed by the nonce it was encrypted with.
+type PKCS11Provider struct {
+	// Path of the PKCS#11 module, ie. the shared library talking to the token.
+	Module string
+	// Label of the token holding the key. If it's empty, the token in Slot is used instead.
+	TokenLabel string
+	Slot       uint
+	// Label of the AES key on the token.
+	Key string
+	// PIN to log in to the token with. Empty skips logging in, eg. for tokens with a PIN pad.
+	Pin string
+	// Authenticated along with each ciphertext, binding ciphertexts to it.
+	Context string
+	// Source of nonces. Nil means crypto/rand.
+	Rand  io.Reader
+	token *pkcs11Token
+}
+
+func NewPKCS11Provider(module, tokenLabel string, slot uint, key, pin string) PKCS11Provider {
+	return PKCS11Provider{
+		Module:     module,
+		TokenLabel: tokenLabel,
+		Slot:       slot,
+		Key:        key,
+		Pin:        pin,
+		token:      &pkcs11Token{},
+	}
+}
+
+func (p PKCS11Provider) Encrypt(plaintext string) ([]byte, error) {
+	nonce := make([]byte, pkcs11NonceSize)
+	_, err := io.ReadFull(randomSource(p.Rand), nonce)
+	if err != nil {
+		return []byte{}, err
+	}
+	token, done := p.session()
+	defer done()
+	nonce, sealed, err := token.seal(p, nonce, []byte(plaintext), []byte(p.Context))
+	if err != nil {
+		return []byte{}, fmt.Errorf("Error encrypting with PKCS#11 key %s: %w", p.Key, err)
+	}
+	out := append([]byte{pkcs11Version}, nonce...)
+	return append(out, sealed...), nil
+}
+
+func (p PKCS11Provider) Decrypt(ciphertext []byte) (string, error) {
+	if len(ciphertext) < 1 || ciphertext[0] != pkcs11Version {
+		return "", errors.New("Ciphertext was not encrypted by the pkcs11 provider")
+	}
+	if len(ciphertext) < 1+pkcs11NonceSize+gcmTagSize {
+		return "", errors.New("Ciphertext is truncated")
+	}
+	nonce, sealed := ciphertext[1:1+pkcs11NonceSize], ciphertext[1+pkcs11NonceSize:]
+	token, done := p.session()
+	defer done()
+	plaintext, err := token.unseal(p, nonce, sealed, []byte(p.Context))
+	if err != nil {
+		return "", fmt.Errorf("Error decrypting with PKCS#11 key %s: %w", p.Key, err)
+	}
+	return string(plaintext), nil
+}
+
+// Identify the key by the token holding it and its label.
+func (p PKCS11Provider) Recipients() []string {
+	token := p.TokenLabel
+	if token == "" {
+		token = "slot-" + strconv.FormatUint(uint64(p.Slot), 10)
+	}
+	return []string{"pkcs11:" + token + "/" + p.Key}
+}
+
+// The key is assumed to be available if the module is installed, though whether the token is plugged in, and the PIN is right, is only known once a value is decrypted.
+func (p PKCS11Provider) AvailableKeys() []string {
+	if _, err := os.Stat(p.Module); err != nil {
+		return []string{}
+	}
+	return p.Recipients()
+}
+
+// Bind ciphertexts to the given context, authenticating it along with them.
+func (p PKCS11Provider) WithContext(context string) Provider {
+	p.Context = context
+	return p
+}
+
+// Log out of the token and unload the module. Copies of the provider share its session, so it's closed for them too.
+func (p PKCS11Provider) Close() error {
+	if p.token == nil {
+		return nil
+	}
+	return p.token.close()
+}
+
+// Get a session with the token, along with a function to call once done with it. A provider made with NewPKCS11Provider opens its session once, the first time it's needed, and keeps it until it's closed. Otherwise, each call gets a session of its own.
+func (p PKCS11Provider) session() (*pkcs11Token, func()) {
+	if p.token == nil {
+		token := &pkcs11Token{}
+		return token, func() { token.close() }
+	}
+	return p.token, func() {}
+}
+
+func newPKCS11Provider(config map[string]interface{}) (Provider, error) {
+	module, err := getString(config, "module")
+	if err != nil {
+		return nil, err
+	}
+	key, err := getString(config, "key")
+	if err != nil {
+		return nil, err
+	}
+	tokenLabel := ""
+	if value, ok := config["tokenLabel"]; ok && value != nil && value != "" {
+		tokenLabel, ok = value.(string)
+		if !ok {
+			return nil, errors.New(".config.tokenLabel must be of type string")
+		}
+	}
+	slot := -1
+	if value, ok := config["slot"]; ok && value != nil {
+		slot, ok = value.(int)
+		if !ok || slot < 0 {
+			return nil, errors.New(".config.slot must be a non-negative integer")
+		}
+	}
+	if (tokenLabel == "") == (slot < 0) {
+		return nil, errors.New("Exactly one of .config.tokenLabel and .config.slot must be set")
+	}
+	if slot < 0 {
+		slot = 0
+	}
+	return NewPKCS11Provider(module, tokenLabel, uint(slot), key, os.Getenv(PKCS11PinEnv)), nil
+}
@@ -0,0 +1,180 @@
+//go:build cgo
+// +build cgo
+
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/pkcs11"
+	"strings"
+	"sync"
+)
+
+// A session with the token of a pkcs11 provider, opened the first time it's needed, and shared by copies of the provider. A PKCS#11 session can only run one operation at a time, so operations are serialized with a mutex; the token would serialize them anyways.
+type pkcs11Token struct {
+	mutex   sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+}
+
+// Encrypt a plaintext with the provider's key, returning the nonce it was actually encrypted with, since some tokens pick their own, along with the ciphertext and its tag.
+func (t *pkcs11Token) seal(p PKCS11Provider, nonce, plaintext, additionalData []byte) ([]byte, []byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	err := t.openSession(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := pkcs11.NewGCMParams(nonce, additionalData, pkcs11TagBits)
+	defer params.Free()
+	err = t.ctx.EncryptInit(t.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, t.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed, err := t.ctx.Encrypt(t.session, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if iv := params.IV(); len(iv) == len(nonce) {
+		nonce = iv
+	}
+	return nonce, sealed, nil
+}
+
+// Decrypt a ciphertext and its tag with the provider's key.
+func (t *pkcs11Token) unseal(p PKCS11Provider, nonce, sealed, additionalData []byte) ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	err := t.openSession(p)
+	if err != nil {
+		return nil, err
+	}
+	params := pkcs11.NewGCMParams(nonce, additionalData, pkcs11TagBits)
+	defer params.Free()
+	err = t.ctx.DecryptInit(t.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, t.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := t.ctx.Decrypt(t.session, sealed)
+	if err != nil {
+		return nil, errors.New("Wrong key, or the ciphertext was modified")
+	}
+	return plaintext, nil
+}
+
+// Load the module, open a session with the token, log in, and find the key, unless that's already been done. Must be called with the mutex held. Anything set up is torn down again if a later step fails, so that the next call starts afresh.
+func (t *pkcs11Token) openSession(p PKCS11Provider) (err error) {
+	if t.ctx != nil {
+		return nil
+	}
+	ctx := pkcs11.New(p.Module)
+	if ctx == nil {
+		return fmt.Errorf("Error loading PKCS#11 module %s", p.Module)
+	}
+	err = ctx.Initialize()
+	if err != nil {
+		ctx.Destroy()
+		return fmt.Errorf("Error initializing PKCS#11 module %s: %w", p.Module, err)
+	}
+	t.ctx = ctx
+	defer func() {
+		if err != nil {
+			t.closeSession()
+		}
+	}()
+	slot, err := findSlot(ctx, p)
+	if err != nil {
+		return err
+	}
+	t.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("Error opening a session with PKCS#11 token %s: %w", p.Recipients()[0], err)
+	}
+	if p.Pin != "" {
+		err = ctx.Login(t.session, pkcs11.CKU_USER, p.Pin)
+		if err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			return fmt.Errorf("Error logging in to PKCS#11 token %s: %w", p.Recipients()[0], err)
+		}
+	}
+	t.key, err = findKey(ctx, t.session, p.Key)
+	return err
+}
+
+// Close the session, if any, and unload the module. Must be called with the mutex held.
+func (t *pkcs11Token) closeSession() error {
+	if t.ctx == nil {
+		return nil
+	}
+	// logging out fails harmlessly if the session never logged in
+	t.ctx.Logout(t.session)
+	t.ctx.CloseSession(t.session)
+	err := t.ctx.Finalize()
+	t.ctx.Destroy()
+	t.ctx = nil
+	return err
+}
+
+func (t *pkcs11Token) close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.closeSession()
+}
+
+// Find the slot of the provider's token: the one whose token has its TokenLabel, if it has one, or else its Slot, as long as a token is present in it.
+func findSlot(ctx *pkcs11.Ctx, p PKCS11Provider) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("Error listing PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		if p.TokenLabel == "" {
+			if slot == p.Slot {
+				return slot, nil
+			}
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("Error reading PKCS#11 token in slot %d: %w", slot, err)
+		}
+		// labels are padded with spaces to a fixed length
+		if strings.TrimRight(info.Label, " \x00") == p.TokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("No PKCS#11 token %s found; is it plugged in?", p.Recipients()[0])
+}
+
+// Find the AES key with the given label on a token.
+func findKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (pkcs11.ObjectHandle, error) {
+	err := ctx.FindObjectsInit(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error finding PKCS#11 key %s: %w", label, err)
+	}
+	keys, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error finding PKCS#11 key %s: %w", label, err)
+	}
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("No AES key labelled %s found on the PKCS#11 token; is the PIN set in %s?", label, PKCS11PinEnv)
+	}
+	if len(keys) > 1 {
+		return 0, fmt.Errorf("More than one AES key labelled %s found on the PKCS#11 token", label)
+	}
+	return keys[0], nil
+}
+
+// Whether an error from a PKCS#11 module has the given return value.
+func isPKCS11Error(err error, code uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == code
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPKCS11Config(t *testing.T) {
+	os.Setenv(PKCS11PinEnv, "1234")
+	defer os.Unsetenv(PKCS11PinEnv)
+	for _, c := range []struct {
+		config     map[string]interface{}
+		recipients []string
+	}{
+		{map[string]interface{}{"module": "/lib/token.so", "tokenLabel": "ops", "key": "yaml-crypt"}, []string{"pkcs11:ops/yaml-crypt"}},
+		{map[string]interface{}{"module": "/lib/token.so", "slot": 0, "key": "yaml-crypt"}, []string{"pkcs11:slot-0/yaml-crypt"}},
+	} {
+		provider, err := NewProvider("pkcs11", c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := provider.(PKCS11Provider)
+		if p.Module != "/lib/token.so" || p.Key != "yaml-crypt" || p.Pin != "1234" || !reflect.DeepEqual(p.Recipients(), c.recipients) {
+			t.Errorf("Unexpected provider from config %v: %+v", c.config, p)
+		}
+		// the key can't be available without the module
+		if len(p.AvailableKeys()) != 0 {
+			t.Errorf("Provider without its module has available keys %v", p.AvailableKeys())
+		}
+	}
+	for _, config := range []map[string]interface{}{
+		{"tokenLabel": "ops", "key": "yaml-crypt"},
+		{"module": "/lib/token.so", "tokenLabel": "ops"},
+		{"module": "/lib/token.so", "key": "yaml-crypt"},
+		{"module": "/lib/token.so", "tokenLabel": "ops", "slot": 0, "key": "yaml-crypt"},
+		{"module": "/lib/token.so", "slot": -1, "key": "yaml-crypt"},
+		{"module": "/lib/token.so", "tokenLabel": 3, "key": "yaml-crypt"},
+	} {
+		_, err := NewProvider("pkcs11", config)
+		if err == nil {
+			t.Errorf("Invalid config %v was accepted", config)
+		}
+	}
+}
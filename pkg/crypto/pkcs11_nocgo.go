@@ -0,0 +1,25 @@
+//go:build !cgo
+// +build !cgo
+
+package crypto
+
+import (
+	"errors"
+)
+
+// The pkcs11 provider loads the module with cgo, so it's unavailable in builds without it.
+type pkcs11Token struct{}
+
+var errNoPKCS11 = errors.New("This build of yaml-crypt was built without cgo, which the pkcs11 provider needs")
+
+func (t *pkcs11Token) seal(p PKCS11Provider, nonce, plaintext, additionalData []byte) ([]byte, []byte, error) {
+	return nil, nil, errNoPKCS11
+}
+
+func (t *pkcs11Token) unseal(p PKCS11Provider, nonce, sealed, additionalData []byte) ([]byte, error) {
+	return nil, errNoPKCS11
+}
+
+func (t *pkcs11Token) close() error {
+	return nil
+}
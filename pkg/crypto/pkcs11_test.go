@@ -0,0 +1,183 @@
+//go:build softhsm
+// +build softhsm
+
+package crypto
+
+import (
+	"fmt"
+	"github.com/miekg/pkcs11"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Run with `go test -tags softhsm ./pkg/crypto`, with SoftHSM installed. The module is looked for at the path in YAMLCRYPT_TEST_SOFTHSM_MODULE, or else where Debian and Ubuntu install it.
+const defaultSoftHSMModule = "/usr/lib/softhsm/libsofthsm2.so"
+
+const (
+	testTokenLabel = "yaml-crypt-test"
+	testPin        = "1234"
+	testKeyLabel   = "yaml-crypt"
+)
+
+// Set up a fresh SoftHSM token, in a temporary directory, holding an AES key that can't be extracted. Returns the path of the module.
+func setupSoftHSM(t *testing.T) string {
+	module := os.Getenv("YAMLCRYPT_TEST_SOFTHSM_MODULE")
+	if module == "" {
+		module = defaultSoftHSMModule
+	}
+	if _, err := os.Stat(module); err != nil {
+		t.Skipf("SoftHSM module not found: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "yaml-crypt-softhsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	conf := filepath.Join(dir, "softhsm2.conf")
+	err = ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", dir)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("Error loading %s", module)
+	}
+	defer ctx.Destroy()
+	check := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(ctx.Initialize())
+	defer ctx.Finalize()
+	slots, err := ctx.GetSlotList(false)
+	check(err)
+	check(ctx.InitToken(slots[0], "so-pin", testTokenLabel))
+	// SoftHSM moves an initialized token to a new slot
+	slot, err := findSlot(ctx, PKCS11Provider{TokenLabel: testTokenLabel})
+	check(err)
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	check(err)
+	defer ctx.CloseSession(session)
+	check(ctx.Login(session, pkcs11.CKU_SO, "so-pin"))
+	check(ctx.InitPIN(session, testPin))
+	check(ctx.Logout(session))
+	check(ctx.Login(session, pkcs11.CKU_USER, testPin))
+	defer ctx.Logout(session)
+	_, err = ctx.GenerateKey(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, testKeyLabel),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+	})
+	check(err)
+	return module
+}
+
+func TestPKCS11(t *testing.T) {
+	module := setupSoftHSM(t)
+	provider := NewPKCS11Provider(module, testTokenLabel, 0, testKeyLabel, testPin)
+	defer provider.Close()
+	ciphertext, err := provider.Encrypt("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := provider.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "test" {
+		t.Errorf("Decrypting gave %q, expected %q", plaintext, "test")
+	}
+	if info := Inspect(provider, ciphertext); info.Version != pkcs11Version || info.PlaintextSize != len("test") || !info.Authenticated {
+		t.Errorf("Inspecting a ciphertext gave %+v", info)
+	}
+
+	// ciphertexts are authenticated
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1]++
+	_, err = provider.Decrypt(tampered)
+	if err == nil {
+		t.Error("Decrypting a tampered ciphertext did not fail")
+	}
+	testContext(t, provider)
+
+	// the session is shared by every goroutine using the provider
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprint(i)
+			ciphertext, err := provider.Encrypt(value)
+			if err == nil {
+				var plaintext string
+				plaintext, err = provider.Decrypt(ciphertext)
+				if err == nil && plaintext != value {
+					err = fmt.Errorf("Decrypting gave %q, expected %q", plaintext, value)
+				}
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the token can also be found by slot, and a provider that isn't made with NewPKCS11Provider opens a session per call
+	ctx := pkcs11.New(module)
+	ctx.Initialize()
+	slot, err := findSlot(ctx, PKCS11Provider{TokenLabel: testTokenLabel})
+	ctx.Finalize()
+	ctx.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Close()
+	bySlot := PKCS11Provider{Module: module, Slot: slot, Key: testKeyLabel, Pin: testPin}
+	plaintext, err = bySlot.Decrypt(ciphertext)
+	if err != nil || plaintext != "test" {
+		t.Errorf("Decrypting with the token found by slot gave %q, %v", plaintext, err)
+	}
+}
+
+func TestPKCS11Errors(t *testing.T) {
+	module := setupSoftHSM(t)
+	for _, c := range []struct {
+		name     string
+		provider PKCS11Provider
+		expected string
+	}{
+		{"wrong PIN", NewPKCS11Provider(module, testTokenLabel, 0, testKeyLabel, "4321"), "logging in"},
+		// the key is private, so it can't be found without logging in
+		{"no PIN", NewPKCS11Provider(module, testTokenLabel, 0, testKeyLabel, ""), "No AES key"},
+		{"wrong key", NewPKCS11Provider(module, testTokenLabel, 0, "other", testPin), "No AES key"},
+		{"wrong token", NewPKCS11Provider(module, "other", 0, testKeyLabel, testPin), "No PKCS#11 token"},
+		{"wrong module", NewPKCS11Provider(filepath.Join(os.TempDir(), "missing.so"), testTokenLabel, 0, testKeyLabel, testPin), "Error loading"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			defer c.provider.Close()
+			_, err := c.provider.Encrypt("test")
+			if err == nil || !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("Encrypting gave error %v, expected it to contain %q", err, c.expected)
+			}
+		})
+	}
+}
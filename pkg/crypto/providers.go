@@ -1,12 +1,35 @@
 package crypto
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 )
 
+// Returned when trying to decrypt with a provider that can only encrypt.
+var ErrCannotDecrypt = errors.New("No private key available for decryption")
+
+// Encrypts and decrypts values. Providers are shared by every goroutine encrypting or decrypting values in parallel, so they must be safe for concurrent use; anything expensive to set up, like a KMS client, should be set up once, the first time it's needed, and then reused.
 type Provider interface {
 	Encrypt(string) ([]byte, error)
 	Decrypt([]byte) (string, error)
+	// The identifiers of the keys values are encrypted to.
+	Recipients() []string
+	// The identifiers of the keys, of those in Recipients, that the provider is able to decrypt with, so that whether a file can be decrypted can be told before trying.
+	AvailableKeys() []string
+}
+
+// Get the given source of randomness, or crypto/rand if there's none.
+func randomSource(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
 }
 
 func getString(config map[string]interface{}, key string) (string, error) {
@@ -45,12 +68,18 @@ func NewProvider(name string, config map[string]interface{}) (Provider, error) {
 		if err != nil {
 			err = err
 		}
-		provider = GoogleProvider{
-			Project:  project,
-			Location: location,
-			Keyring:  keyring,
-			Key:      key,
+		google := NewGoogleProvider(project, location, keyring, key)
+		google.limiter, err = newCallLimiter(config)
+		if err != nil {
+			return nil, err
 		}
+		provider = google
+	case "passphrase":
+		provider, err = newPassphraseProvider(config)
+	case "exec":
+		provider, err = newExecProvider(config)
+	case "pkcs11":
+		provider, err = newPKCS11Provider(config)
 	default:
 		err = fmt.Errorf("No provider named %s", name)
 	}
@@ -67,4 +96,200 @@ var BlankConfigs map[string]interface{} = map[string]interface{}{
 		"keyring":  "",
 		"key":      "",
 	},
+	// the salt is generated afresh for each repo by BlankConfig
+	"passphrase": map[string]interface{}{
+		"salt": "",
+	},
+	"exec": map[string]interface{}{
+		"command":   []interface{}{},
+		"timeout":   DefaultExecTimeout,
+		"maxOutput": DefaultExecMaxOutput,
+	},
+	"pkcs11": map[string]interface{}{
+		"module":     "",
+		"tokenLabel": "",
+		"key":        "",
+	},
+}
+
+// Get the blank config for a new repo using the named provider, with anything that must be unique to the repo, eg. a passphrase salt, generated afresh.
+func BlankConfig(provider string) (map[string]interface{}, bool) {
+	blank, ok := BlankConfigs[provider].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	config := make(map[string]interface{}, len(blank))
+	for key, value := range blank {
+		config[key] = value
+	}
+	if provider == "passphrase" {
+		config["salt"] = NewSalt()
+	}
+	return config, true
+}
+
+// Implemented by providers that can encrypt or decrypt many values in one round trip. Outputs are in the same order as inputs.
+type BatchProvider interface {
+	EncryptBatch(plaintexts []string) ([][]byte, error)
+	DecryptBatch(ciphertexts [][]byte) ([]string, error)
+}
+
+// Implemented by providers that can be told to use a specific key or credentials file, rather than discovering one on their own.
+type IdentityProvider interface {
+	WithIdentity(path string) (Provider, error)
+}
+
+// Get a copy of a provider that uses exactly the key or credentials in the given file. A file holding a symmetric key, eg. one handed over along with a shared file, gives a KeyProvider using it instead.
+func WithIdentity(provider Provider, path string) (Provider, error) {
+	if key, ok, err := ReadKeyFile(path); ok || err != nil && !os.IsNotExist(err) {
+		if err != nil {
+			return provider, fmt.Errorf("Error reading identity file: %w", err)
+		}
+		return key, nil
+	}
+	p, ok := provider.(IdentityProvider)
+	if !ok {
+		return provider, fmt.Errorf("Provider %T does not support specifying an identity", provider)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return provider, fmt.Errorf("Error reading identity file: %w", err)
+	}
+	return p.WithIdentity(path)
+}
+
+// Implemented by providers that may only be able to encrypt, eg. when only a public key is configured.
+type DecryptCapableProvider interface {
+	CanDecrypt() bool
+}
+
+// Whether a provider is able to decrypt. Providers that don't say otherwise are assumed to be able to.
+func CanDecrypt(provider Provider) bool {
+	p, ok := provider.(DecryptCapableProvider)
+	return !ok || p.CanDecrypt()
+}
+
+// Implemented by providers that can bind ciphertexts to a context, such that a ciphertext only decrypts under the same context it was encrypted with.
+type ContextProvider interface {
+	WithContext(context string) Provider
+}
+
+// Get a copy of a provider that binds ciphertexts to the given context. An empty context leaves the provider as-is.
+func WithContext(provider Provider, context string) (Provider, error) {
+	if context == "" {
+		return provider, nil
+	}
+	p, ok := provider.(ContextProvider)
+	if !ok {
+		return provider, fmt.Errorf("Provider %T does not support encryption contexts", provider)
+	}
+	return p.WithContext(context), nil
+}
+
+// Implemented by providers that hold keys in memory, so that they can be zeroed once they're no longer needed.
+type WipeableProvider interface {
+	Wipe()
+}
+
+// Zero any keys a provider holds in memory. Call once processing is done; a provider shouldn't be used after it's been wiped.
+func Wipe(provider Provider) {
+	if p, ok := provider.(WipeableProvider); ok {
+		p.Wipe()
+	}
+}
+
+// Returned, wrapped, by providers whose credentials were rejected, eg. because a short-lived token expired partway through a long run, so that they can be refreshed and the call tried again.
+var ErrCredentialsRejected = errors.New("Credentials rejected")
+
+// Mark an error from a provider's backend as being caused by its credentials being rejected, eg. since they expired or were revoked. The error is kept, so that the backend's own explanation is still reported.
+func CredentialsRejected(err error) error {
+	return &credentialsRejectedError{err}
+}
+
+type credentialsRejectedError struct {
+	err error
+}
+
+func (e *credentialsRejectedError) Error() string {
+	return "Credentials rejected: " + e.err.Error()
+}
+
+func (e *credentialsRejectedError) Unwrap() error {
+	return e.err
+}
+
+func (e *credentialsRejectedError) Is(target error) bool {
+	return target == ErrCredentialsRejected
+}
+
+// Returned by providers that only wrap others, eg. a Router, when none of them can refresh their credentials.
+var errCannotRefresh = errors.New("Provider can't refresh its credentials")
+
+// Implemented by providers whose credentials can expire, so that they can be refreshed without starting over.
+type RefreshableProvider interface {
+	Refresh() error
+}
+
+// Refresh a provider's credentials, eg. after they've been rejected, returning whether the provider is able to. Copies of the provider, eg. ones bound to other contexts, get the refreshed credentials too.
+func Refresh(provider Provider) (bool, error) {
+	p, ok := provider.(RefreshableProvider)
+	if !ok {
+		return false, nil
+	}
+	return true, p.Refresh()
+}
+
+// Release anything a provider holds open, eg. connections to KMS. Call once processing is done; a provider shouldn't be used after it's been closed.
+func Close(provider Provider) error {
+	if p, ok := provider.(io.Closer); ok {
+		return p.Close()
+	}
+	return nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Whether a buffer has been wiped, ie. is all zeroes.
+func wiped(b []byte) bool {
+	return subtle.ConstantTimeCompare(b, make([]byte, len(b))) == 1
+}
+
+// Get the name a provider is configured by, eg. "google", and the algorithm it encrypts with, to be recorded in the files it encrypts. Both are empty for providers yaml-crypt doesn't define, eg. ones supplied by programs embedding it. A Router is described by its default provider, and a named provider it gives by the provider configured under that name.
+func Describe(provider Provider) (name string, algorithm string) {
+	switch p := provider.(type) {
+	case Router:
+		return Describe(p.Default)
+	case markedProvider:
+		return Describe(p.router.Named[p.name])
+	case NoopProvider:
+		return "noop", "none"
+	case GoogleProvider:
+		return "google", "google-kms"
+	case PassphraseProvider:
+		return "passphrase", "aes-256-gcm-argon2id"
+	case KeyProvider:
+		return "key", "aes-256-gcm"
+	case ExecProvider:
+		return "exec", "external"
+	case PKCS11Provider:
+		return "pkcs11", "aes-gcm-pkcs11"
+	}
+	return "", ""
+}
+
+// Get a short fingerprint identifying a provider and the keys it encrypts to. Changing the provider or its keys changes the fingerprint.
+func Fingerprint(name string, provider Provider) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	if provider == nil {
+		return hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	for _, recipient := range provider.Recipients() {
+		h.Write([]byte{0})
+		h.Write([]byte(recipient))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
 }
@@ -1,12 +1,18 @@
 package crypto
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"github.com/farmersedgeinc/yaml-crypt/pkg/fixtures"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
+	"io/ioutil"
+	mathrand "math/rand"
+	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -39,8 +45,16 @@ var providers = []ProviderMeta{
 		},
 		true,
 	},
+	ProviderMeta{
+		NewPassphraseProvider("correct horse", testSalt, 1, 1024, 1),
+		NewPassphraseProvider("", testSalt, 1, 1024, 1),
+		func() bool { return false },
+		true,
+	},
 }
 
+var testSalt = []byte("0123456789abcdef")
+
 func TestRoundTrip(t *testing.T) {
 	for _, meta := range providers {
 		provider := meta.Provider
@@ -108,3 +122,188 @@ func TestErrorHandling(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentity(t *testing.T) {
+	t.Run("NoopProvider", func(t *testing.T) {
+		_, err := WithIdentity(NoopProvider{}, "/dev/null")
+		if err == nil {
+			t.Error("NoopProvider accepted an identity, despite not supporting them")
+		}
+	})
+	t.Run("GoogleProvider", func(t *testing.T) {
+		meta := providers[1]
+		if meta.Skip() {
+			t.Skip()
+		}
+		// write the default credentials out to a file to use as the correct identity
+		creds, err := google.FindDefaultCredentials(context.Background())
+		if err != nil || len(creds.JSON) == 0 {
+			t.Skip()
+		}
+		f, err := ioutil.TempFile("", "yamlcrypt-identity-*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(creds.JSON)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := meta.Provider.Encrypt("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		correct, err := WithIdentity(meta.Provider, f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := correct.Decrypt(ciphertext)
+		if err != nil {
+			t.Errorf("Decrypting with the correct identity failed: %s", err)
+		} else if plaintext != "test" {
+			t.Errorf("Decrypting with the correct identity gave %q, expected %q", plaintext, "test")
+		}
+
+		incorrect, err := WithIdentity(meta.Provider, "/dev/null")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = incorrect.Decrypt(ciphertext)
+		if err == nil {
+			t.Error("Decrypting with an incorrect identity did not fail")
+		}
+
+		_, err = WithIdentity(meta.Provider, "/nonexistent/identity.json")
+		if err == nil {
+			t.Error("Specifying a nonexistent identity file did not fail")
+		}
+	})
+}
+
+func TestContext(t *testing.T) {
+	t.Run("NoopProvider", func(t *testing.T) {
+		_, err := WithContext(NoopProvider{}, "")
+		if err != nil {
+			t.Errorf("NoopProvider rejected an empty context: %s", err)
+		}
+		_, err = WithContext(NoopProvider{}, "a")
+		if err == nil {
+			t.Error("NoopProvider accepted a context, despite not supporting them")
+		}
+	})
+	for _, meta := range providers[1:] {
+		meta := meta
+		t.Run(reflect.TypeOf(meta.Provider).Name(), func(t *testing.T) {
+			if meta.Skip() {
+				t.Skip()
+			}
+			testContext(t, meta.Provider)
+		})
+	}
+}
+
+func testContext(t *testing.T, provider Provider) {
+	a, err := WithContext(provider, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := WithContext(provider, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := a.Encrypt("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := a.Decrypt(ciphertext)
+	if err != nil {
+		t.Errorf("Decrypting under the same context failed: %s", err)
+	} else if plaintext != "test" {
+		t.Errorf("Decrypting under the same context gave %q, expected %q", plaintext, "test")
+	}
+	_, err = b.Decrypt(ciphertext)
+	if err == nil {
+		t.Error("Decrypting under a different context did not fail")
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	provider := NewPassphraseProvider("correct horse", testSalt, 1, 1024, 1)
+	ciphertext, err := provider.Encrypt("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := NewPassphraseProvider("battery staple", testSalt, 1, 1024, 1)
+	_, err = wrong.Decrypt(ciphertext)
+	if err == nil {
+		t.Error("Decrypting with the wrong passphrase did not fail")
+	}
+	// the parameters are read from the ciphertext, so they can be tuned without breaking existing ciphertexts
+	tuned := NewPassphraseProvider("correct horse", testSalt, 2, 2048, 2)
+	plaintext, err := tuned.Decrypt(ciphertext)
+	if err != nil {
+		t.Errorf("Decrypting after tuning the parameters failed: %s", err)
+	} else if plaintext != "test" {
+		t.Errorf("Decrypting after tuning the parameters gave %q, expected %q", plaintext, "test")
+	}
+	// the parameters are authenticated, so they can't be tampered with
+	tampered := append([]byte{}, ciphertext...)
+	tampered[4]++
+	_, err = provider.Decrypt(tampered)
+	if err == nil {
+		t.Error("Decrypting with tampered parameters did not fail")
+	}
+	// a header can't ask for more memory than is configured, so it's rejected before any key is derived
+	tampered = append([]byte{}, ciphertext...)
+	binary.BigEndian.PutUint32(tampered[5:9], 1024*1024)
+	_, err = provider.Decrypt(tampered)
+	if err == nil || !strings.Contains(err.Error(), "more than the configured") {
+		t.Errorf("Decrypting with a header asking for too much memory gave error %v", err)
+	}
+	blank, ok := BlankConfig("passphrase")
+	other, _ := BlankConfig("passphrase")
+	if !ok || blank["salt"] == "" || blank["salt"] == other["salt"] {
+		t.Errorf("Blank passphrase configs got salts %v and %v, expected distinct ones", blank["salt"], other["salt"])
+	}
+}
+
+func TestSeededRand(t *testing.T) {
+	withSeed := map[string]func(seed int64) Provider{
+		"PassphraseProvider": func(seed int64) Provider {
+			p := NewPassphraseProvider("correct horse", testSalt, 1, 1024, 1)
+			p.Rand = mathrand.New(mathrand.NewSource(seed))
+			return p
+		},
+		"KeyProvider": func(seed int64) Provider {
+			return KeyProvider{Key: []byte("0123456789abcdef0123456789abcdef"), Rand: mathrand.New(mathrand.NewSource(seed))}
+		},
+	}
+	for name, provider := range withSeed {
+		t.Run(name, func(t *testing.T) {
+			first, err := provider(1).Encrypt("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := provider(1).Encrypt("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Errorf("Two runs with the same seed gave different ciphertexts:\n%x\n%x", first, second)
+			}
+			other, err := provider(2).Encrypt("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(first, other) {
+				t.Error("Runs with different seeds gave the same ciphertext")
+			}
+			plaintext, err := provider(3).Decrypt(first)
+			if err != nil || plaintext != "test" {
+				t.Errorf("Decrypting a seeded ciphertext gave %q, %v", plaintext, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Prefix of ciphertexts encrypted by a named provider, followed by the provider's name and a colon. Ciphertexts of the default provider that happen to start with the prefix, eg. those of the noop provider, are marked with an empty name, so that they can't be mistaken for those of a named provider.
+const markerPrefix = "yamlcrypt:"
+
+// A provider that encrypts with a default provider, and decrypts with whichever provider a ciphertext is marked as having been encrypted by. Use For to encrypt with one of the named providers.
+type Router struct {
+	Default Provider
+	Named   map[string]Provider
+}
+
+func (r Router) Encrypt(plaintext string) ([]byte, error) {
+	return Encrypt(r.Default, plaintext)
+}
+
+func (r Router) Decrypt(ciphertext []byte) (string, error) {
+	name, rest := splitMarker(ciphertext)
+	if name == "" {
+		return r.Default.Decrypt(rest)
+	}
+	provider, ok := r.Named[name]
+	if !ok {
+		return "", fmt.Errorf("Ciphertext was encrypted by provider %s, which isn't configured", name)
+	}
+	return provider.Decrypt(rest)
+}
+
+func (r Router) Recipients() []string {
+	recipients := r.Default.Recipients()
+	for _, name := range r.names() {
+		recipients = append(recipients, r.Named[name].Recipients()...)
+	}
+	return recipients
+}
+
+func (r Router) AvailableKeys() []string {
+	keys := r.Default.AvailableKeys()
+	for _, name := range r.names() {
+		keys = append(keys, r.Named[name].AvailableKeys()...)
+	}
+	return keys
+}
+
+func (r Router) CanDecrypt() bool {
+	return CanDecrypt(r.Default)
+}
+
+// Bind the default and named providers to the given context, where they support it.
+func (r Router) WithContext(context string) Provider {
+	bound := Router{Default: r.Default, Named: map[string]Provider{}}
+	if p, ok := r.Default.(ContextProvider); ok {
+		bound.Default = p.WithContext(context)
+	}
+	for name, provider := range r.Named {
+		bound.Named[name] = provider
+		if p, ok := provider.(ContextProvider); ok {
+			bound.Named[name] = p.WithContext(context)
+		}
+	}
+	return bound
+}
+
+// Zero the keys held by the default and named providers.
+func (r Router) Wipe() {
+	Wipe(r.Default)
+	for _, provider := range r.Named {
+		Wipe(provider)
+	}
+}
+
+// Close the default and named providers, returning the first error.
+func (r Router) Close() error {
+	err := Close(r.Default)
+	for _, name := range r.names() {
+		if closeErr := Close(r.Named[name]); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Refresh the credentials of whichever of the default and named providers can, returning the first error.
+func (r Router) Refresh() error {
+	refreshed, err := Refresh(r.Default)
+	for _, name := range r.names() {
+		ok, refreshErr := Refresh(r.Named[name])
+		refreshed = refreshed || ok
+		if err == nil {
+			err = refreshErr
+		}
+	}
+	if !refreshed {
+		return errCannotRefresh
+	}
+	return err
+}
+
+func (r Router) marksCiphertexts() {}
+
+func (r Router) names() []string {
+	names := make([]string, 0, len(r.Named))
+	for name := range r.Named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A named provider, whose ciphertexts are marked with its name.
+type markedProvider struct {
+	name   string
+	router Router
+}
+
+func (p markedProvider) Encrypt(plaintext string) ([]byte, error) {
+	ciphertext, err := p.router.Named[p.name].Encrypt(plaintext)
+	if err != nil {
+		return []byte{}, err
+	}
+	return mark(p.name, ciphertext), nil
+}
+
+func (p markedProvider) marksCiphertexts() {}
+
+func (p markedProvider) Decrypt(ciphertext []byte) (string, error) {
+	return p.router.Decrypt(ciphertext)
+}
+
+func (p markedProvider) Refresh() error {
+	ok, err := Refresh(p.router.Named[p.name])
+	if !ok {
+		return errCannotRefresh
+	}
+	return err
+}
+
+func (p markedProvider) Recipients() []string {
+	return p.router.Named[p.name].Recipients()
+}
+
+func (p markedProvider) AvailableKeys() []string {
+	return p.router.Named[p.name].AvailableKeys()
+}
+
+// Get the provider that encrypts with the named provider, if the given provider is a Router. An empty name gets the provider itself.
+func ForName(provider Provider, name string) (Provider, error) {
+	if name == "" {
+		return provider, nil
+	}
+	r, ok := provider.(Router)
+	if !ok {
+		return provider, fmt.Errorf("No provider named %s configured", name)
+	}
+	if _, ok := r.Named[name]; !ok {
+		return provider, fmt.Errorf("No provider named %s configured", name)
+	}
+	return markedProvider{name, r}, nil
+}
+
+// Get a provider as a BatchProvider, if it can encrypt and decrypt many values in one round trip. A Router, or a named provider it gives, can if the provider it encrypts with can; ciphertexts of any provider that can't are still decrypted, one at a time.
+func AsBatch(provider Provider) (BatchProvider, bool) {
+	switch p := provider.(type) {
+	case Router:
+		_, ok := p.Default.(BatchProvider)
+		return routerBatch{p, ""}, ok
+	case markedProvider:
+		_, ok := p.router.Named[p.name].(BatchProvider)
+		return routerBatch{p.router, p.name}, ok
+	}
+	batch, ok := provider.(BatchProvider)
+	return batch, ok
+}
+
+// Encrypts in batches with the named provider of a Router, or its default provider if the name is empty, and decrypts in batches with whichever providers the ciphertexts are marked with.
+type routerBatch struct {
+	router Router
+	name   string
+}
+
+func (b routerBatch) EncryptBatch(plaintexts []string) ([][]byte, error) {
+	provider := b.router.Default
+	if b.name != "" {
+		provider = b.router.Named[b.name]
+	}
+	ciphertexts, err := provider.(BatchProvider).EncryptBatch(plaintexts)
+	if err != nil {
+		return nil, err
+	}
+	for i, ciphertext := range ciphertexts {
+		ciphertexts[i] = mark(b.name, ciphertext)
+	}
+	return ciphertexts, nil
+}
+
+func (b routerBatch) DecryptBatch(ciphertexts [][]byte) ([]string, error) {
+	// group the ciphertexts by the provider they're marked with, keeping track of where each came from
+	names := []string{}
+	groups := map[string][][]byte{}
+	indices := map[string][]int{}
+	for i, ciphertext := range ciphertexts {
+		name, rest := splitMarker(ciphertext)
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], rest)
+		indices[name] = append(indices[name], i)
+	}
+	plaintexts := make([]string, len(ciphertexts))
+	for _, name := range names {
+		provider := b.router.Default
+		if name != "" {
+			var ok bool
+			provider, ok = b.router.Named[name]
+			if !ok {
+				return nil, fmt.Errorf("Ciphertext was encrypted by provider %s, which isn't configured", name)
+			}
+		}
+		group, err := decryptGroup(provider, groups[name])
+		if err != nil {
+			return nil, err
+		}
+		if len(group) != len(groups[name]) {
+			return nil, fmt.Errorf("Provider returned %d plaintexts for %d ciphertexts", len(group), len(groups[name]))
+		}
+		for i, plaintext := range group {
+			plaintexts[indices[name][i]] = plaintext
+		}
+	}
+	return plaintexts, nil
+}
+
+func (b routerBatch) marksCiphertexts() {}
+
+// Decrypt ciphertexts in one round trip, if the provider can, or else one at a time.
+func decryptGroup(provider Provider, ciphertexts [][]byte) ([]string, error) {
+	if batch, ok := provider.(BatchProvider); ok {
+		return batch.DecryptBatch(ciphertexts)
+	}
+	plaintexts := make([]string, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		var err error
+		plaintexts[i], err = provider.Decrypt(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plaintexts, nil
+}
+
+// Implemented by providers that mark their ciphertexts themselves, ie. Routers and the named providers they give.
+type markingProvider interface {
+	marksCiphertexts()
+}
+
+// Encrypt a plaintext, marking the ciphertext with an empty name if it could otherwise be mistaken for one marked as encrypted by a named provider. Use it rather than Provider.Encrypt wherever the provider might not be a Router, so that Marker is never misled by a ciphertext.
+func Encrypt(provider Provider, plaintext string) ([]byte, error) {
+	ciphertext, err := provider.Encrypt(plaintext)
+	if _, ok := provider.(markingProvider); ok || err != nil {
+		return ciphertext, err
+	}
+	return mark("", ciphertext), nil
+}
+
+// Decrypt a ciphertext given by Encrypt, removing any empty marker it was given. A ciphertext marked by a named provider can only be decrypted by a Router.
+func Decrypt(provider Provider, ciphertext []byte) (string, error) {
+	if _, ok := provider.(markingProvider); ok {
+		return provider.Decrypt(ciphertext)
+	}
+	name, rest := splitMarker(ciphertext)
+	if name != "" {
+		return "", fmt.Errorf("Ciphertext was encrypted by provider %s, which isn't configured", name)
+	}
+	return provider.Decrypt(rest)
+}
+
+// Encrypt plaintexts in one round trip, marking the ciphertexts as Encrypt does.
+func EncryptBatch(provider BatchProvider, plaintexts []string) ([][]byte, error) {
+	ciphertexts, err := provider.EncryptBatch(plaintexts)
+	if _, ok := provider.(markingProvider); ok || err != nil {
+		return ciphertexts, err
+	}
+	for i, ciphertext := range ciphertexts {
+		ciphertexts[i] = mark("", ciphertext)
+	}
+	return ciphertexts, nil
+}
+
+// Decrypt ciphertexts given by EncryptBatch in one round trip, as Decrypt does.
+func DecryptBatch(provider BatchProvider, ciphertexts [][]byte) ([]string, error) {
+	if _, ok := provider.(markingProvider); ok {
+		return provider.DecryptBatch(ciphertexts)
+	}
+	unmarked := make([][]byte, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		var name string
+		name, unmarked[i] = splitMarker(ciphertext)
+		if name != "" {
+			return nil, fmt.Errorf("Ciphertext was encrypted by provider %s, which isn't configured", name)
+		}
+	}
+	return provider.DecryptBatch(unmarked)
+}
+
+// Get the name of the provider a ciphertext is marked as having been encrypted by, or an empty string if it was encrypted by the default provider.
+func Marker(ciphertext []byte) string {
+	name, _ := splitMarker(ciphertext)
+	return name
+}
+
+// Mark a ciphertext as encrypted by the named provider. Ciphertexts of the default provider are only marked, with an empty name, if they start with the marker prefix.
+func mark(name string, ciphertext []byte) []byte {
+	if name == "" && !bytes.HasPrefix(ciphertext, []byte(markerPrefix)) {
+		return ciphertext
+	}
+	return append([]byte(markerPrefix+name+":"), ciphertext...)
+}
+
+// Split a ciphertext into the name of the provider it's marked with, and the provider's own ciphertext.
+func splitMarker(ciphertext []byte) (string, []byte) {
+	if !bytes.HasPrefix(ciphertext, []byte(markerPrefix)) {
+		return "", ciphertext
+	}
+	rest := ciphertext[len(markerPrefix):]
+	end := bytes.IndexByte(rest, ':')
+	if end < 0 {
+		return "", ciphertext
+	}
+	return string(rest[:end]), rest[end+1:]
+}
@@ -0,0 +1,176 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+// A provider that reverses plaintexts, so that different providers can be told apart.
+type reverseProvider struct{}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func (p reverseProvider) Encrypt(plaintext string) ([]byte, error) {
+	return []byte(reverse(plaintext)), nil
+}
+
+func (p reverseProvider) Decrypt(ciphertext []byte) (string, error) {
+	return reverse(string(ciphertext)), nil
+}
+
+func (p reverseProvider) Recipients() []string {
+	return []string{"reverse"}
+}
+
+func (p reverseProvider) AvailableKeys() []string {
+	return p.Recipients()
+}
+
+func TestRouter(t *testing.T) {
+	router := Router{Default: NoopProvider{}, Named: map[string]Provider{"reverse": reverseProvider{}}}
+
+	ciphertext, err := router.Encrypt("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ciphertext) != "abc" || Marker(ciphertext) != "" {
+		t.Errorf("Router did not encrypt with the default provider: %q", ciphertext)
+	}
+
+	named, err := ForName(router, "reverse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err = named.Encrypt("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ciphertext) != "yamlcrypt:reverse:cba" || Marker(ciphertext) != "reverse" {
+		t.Errorf("Named provider gave unexpected ciphertext %q", ciphertext)
+	}
+	plaintext, err := router.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "abc" {
+		t.Errorf("Router decrypted a marked ciphertext to %q, expected %q", plaintext, "abc")
+	}
+
+	_, err = router.Decrypt([]byte("yamlcrypt:missing:abc"))
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Decrypting a ciphertext marked with an unconfigured provider gave error %v", err)
+	}
+	_, err = ForName(router, "missing")
+	if err == nil {
+		t.Error("Getting an unconfigured named provider did not fail")
+	}
+	_, err = ForName(NoopProvider{}, "reverse")
+	if err == nil {
+		t.Error("Getting a named provider from a provider that isn't a Router did not fail")
+	}
+
+	// a ciphertext of the default provider that looks marked is marked as the default provider's, whether or not it's a Router
+	lookalike := "yamlcrypt:reverse:abc"
+	for _, provider := range []Provider{router, NoopProvider{}} {
+		ciphertext, err = Encrypt(provider, lookalike)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Marker(ciphertext) != "" {
+			t.Errorf("Default provider's ciphertext %q was read as marked by provider %q", ciphertext, Marker(ciphertext))
+		}
+		plaintext, err = Decrypt(provider, ciphertext)
+		if err != nil || plaintext != lookalike {
+			t.Errorf("Decrypting %q gave %q, %v, expected %q", ciphertext, plaintext, err, lookalike)
+		}
+	}
+	_, err = Decrypt(NoopProvider{}, []byte("yamlcrypt:reverse:cba"))
+	if err == nil || !strings.Contains(err.Error(), "reverse") {
+		t.Errorf("Decrypting a marked ciphertext without a Router gave error %v", err)
+	}
+}
+
+// A reverseProvider that encrypts and decrypts in batches.
+type reverseBatchProvider struct {
+	reverseProvider
+}
+
+func (p reverseBatchProvider) EncryptBatch(plaintexts []string) ([][]byte, error) {
+	ciphertexts := make([][]byte, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		ciphertexts[i], _ = p.Encrypt(plaintext)
+	}
+	return ciphertexts, nil
+}
+
+func (p reverseBatchProvider) DecryptBatch(ciphertexts [][]byte) ([]string, error) {
+	plaintexts := make([]string, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		plaintexts[i], _ = p.Decrypt(ciphertext)
+	}
+	return plaintexts, nil
+}
+
+func TestAsBatch(t *testing.T) {
+	router := Router{Default: reverseBatchProvider{}, Named: map[string]Provider{"batch": reverseBatchProvider{}, "single": NoopProvider{}}}
+	if _, ok := AsBatch(NoopProvider{}); ok {
+		t.Error("A provider that can't batch was given as a BatchProvider")
+	}
+	single, err := ForName(router, "single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := AsBatch(single); ok {
+		t.Error("A named provider that can't batch was given as a BatchProvider")
+	}
+
+	batch, ok := AsBatch(router)
+	if !ok {
+		t.Fatal("A Router with a default provider that can batch wasn't given as a BatchProvider")
+	}
+	ciphertexts, err := EncryptBatch(batch, []string{"abc", "yamlcrypt:batch:abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ciphertexts[0]) != "cba" || Marker(ciphertexts[1]) != "" {
+		t.Errorf("Router encrypted a batch to %q", ciphertexts)
+	}
+	named, err := ForName(router, "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, ok = AsBatch(named)
+	if !ok {
+		t.Fatal("A named provider that can batch wasn't given as a BatchProvider")
+	}
+	namedCiphertexts, err := EncryptBatch(batch, []string{"def"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(namedCiphertexts[0]) != "yamlcrypt:batch:fed" {
+		t.Errorf("Named provider encrypted a batch to %q", namedCiphertexts)
+	}
+
+	// a batch can mix providers, including ones that can't batch
+	singleCiphertext, err := single.Encrypt("ghi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintexts, err := DecryptBatch(batch, [][]byte{ciphertexts[0], namedCiphertexts[0], singleCiphertext, ciphertexts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(plaintexts, ",") != "abc,def,ghi,yamlcrypt:batch:abc" {
+		t.Errorf("Router decrypted a batch to %q", plaintexts)
+	}
+	_, err = DecryptBatch(batch, [][]byte{[]byte("yamlcrypt:missing:abc")})
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Decrypting a batch with a ciphertext marked with an unconfigured provider gave error %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWipeKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, keyLength)
+	provider := KeyProvider{Key: key}
+	ciphertext, err := provider.Encrypt("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Wipe(provider)
+	if !bytes.Equal(key, make([]byte, keyLength)) {
+		t.Errorf("Key buffer not zeroed after wiping: %x", key)
+	}
+	if _, err := provider.Encrypt("hello"); err == nil {
+		t.Error("Expected encrypting with a wiped key to fail")
+	}
+	if _, err := provider.Decrypt(ciphertext); err == nil {
+		t.Error("Expected decrypting with a wiped key to fail")
+	}
+}
+
+func TestWipePassphrase(t *testing.T) {
+	provider := NewPassphraseProvider("correct horse", testSalt, 1, 1024, 1)
+	bound := provider.WithContext("context")
+	ciphertext, err := bound.Encrypt("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys [][]byte
+	for _, key := range provider.keys.keys {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		t.Fatal("Expected a derived key to be cached")
+	}
+	Wipe(provider)
+	for _, key := range keys {
+		if !bytes.Equal(key, make([]byte, keyLength)) {
+			t.Errorf("Derived key not zeroed after wiping: %x", key)
+		}
+	}
+	if len(provider.keys.keys) != 0 {
+		t.Errorf("Expected no derived keys cached after wiping, got %d", len(provider.keys.keys))
+	}
+	// the passphrase is forgotten too, so neither the provider nor its copies can be used again
+	if _, err := bound.Decrypt(ciphertext); err == nil {
+		t.Error("Expected decrypting with a wiped passphrase to fail")
+	}
+	if _, err := provider.Encrypt("hello"); err == nil {
+		t.Error("Expected encrypting with a wiped passphrase to fail")
+	}
+	if keys := provider.AvailableKeys(); len(keys) != 0 {
+		t.Errorf("Expected no available keys after wiping, got %v", keys)
+	}
+}
+
+func TestWipeRouter(t *testing.T) {
+	defaultKey := bytes.Repeat([]byte{0x42}, keyLength)
+	namedKey := bytes.Repeat([]byte{0x24}, keyLength)
+	router := Router{
+		Default: KeyProvider{Key: defaultKey},
+		Named:   map[string]Provider{"other": KeyProvider{Key: namedKey}},
+	}
+	Wipe(router)
+	for _, key := range [][]byte{defaultKey, namedKey} {
+		if !bytes.Equal(key, make([]byte, keyLength)) {
+			t.Errorf("Key buffer not zeroed after wiping: %x", key)
+		}
+	}
+	// providers without keys in memory are left alone
+	Wipe(NoopProvider{})
+}
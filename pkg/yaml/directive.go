@@ -0,0 +1,60 @@
+package yaml
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"io"
+	"regexp"
+)
+
+// Matches a %YAML directive declaring a 1.x version. yaml.v3 only accepts version 1.1, and rejects documents declaring 1.2, though it reads them just the same.
+var yamlDirective = regexp.MustCompile(`^%YAML[ \t]+1\.[0-9]+[ \t]*(#.*)?$`)
+
+// Split the %YAML directive, if any, off the start of a yaml document, so that it can be read whichever 1.x version it declares, and the directive written back out as it was. The directive is blanked out of the document to parse, rather than removed, so that line numbers in errors still match, and so is the document start marker after it, unless other directives, eg. %TAG, need it, or it carries a document tag, so that the yaml-crypt header comment is still read as the document's.
+func splitDirective(data []byte) (string, []byte) {
+	lines := bytes.SplitAfter(data, []byte(LF))
+	directive, marker := -1, -1
+	otherDirectives := false
+	for i, line := range lines {
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		if yamlDirective.Match(trimmed) && directive < 0 {
+			directive = i
+			continue
+		}
+		if trimmed[0] == '%' {
+			otherDirectives = true
+			continue
+		}
+		if bytes.HasPrefix(trimmed, []byte("---")) {
+			marker = i
+		}
+		break
+	}
+	// a directive without a document start marker is invalid, so leave it for the parser to complain about
+	if directive < 0 || marker < 0 {
+		return "", data
+	}
+	text := string(bytes.TrimRight(lines[directive], " \t\r\n"))
+	out := make([]byte, 0, len(data))
+	for i, line := range lines {
+		bare := i == marker && !otherDirectives && string(bytes.TrimRight(line, " \t\r\n")) == "---"
+		if i == directive || bare {
+			// keep the line ending, so that it's still detected
+			line = line[len(bytes.TrimRight(line, "\r\n")):]
+		}
+		out = append(out, line...)
+	}
+	return text, out
+}
+
+// Write the %YAML directive a document was read with, if any, followed by a document start marker, as yaml.v3 never writes directives itself.
+func writeDirective(w io.Writer, node *yaml.Node) error {
+	if node.Kind != yaml.DocumentNode || node.Value == "" {
+		return nil
+	}
+	_, err := io.WriteString(w, node.Value+LF+"---"+LF)
+	return err
+}
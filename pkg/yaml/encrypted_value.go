@@ -0,0 +1,119 @@
+package yaml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Latest version of the layout of encrypted values.
+const EncryptedValueVersion = 2
+
+// Prefix giving the version of an encrypted value's layout, eg. "v2:". Base64 never contains a colon, so it can't be mistaken for part of a version 1 value.
+var versionPrefix = regexp.MustCompile(`^v([0-9]+):`)
+
+// Types of plaintext that keep their type through encryption, by yaml tag. Any other plaintext is a string. Nulls have no plaintext, so their ciphertext is always empty. Mappings and sequences are encrypted as a whole, with the yaml they serialize to as their plaintext.
+var typeNames = map[string]string{
+	"!!int":   "int",
+	"!!bool":  "bool",
+	"!!float": "float",
+	"!!null":  "null",
+	"!!map":   "map",
+	"!!seq":   "seq",
+}
+
+// The value of an !encrypted node. Values can be prefixed with the version of their layout, eg. "v2:..."; values without a prefix are version 1, the layout that predates versioning.
+//
+// Version 1 is just the base64-encoded ciphertext, of a string plaintext. Strings are still written in this layout, so that existing files don't change.
+//
+// Version 2 is the name of the plaintext's type, followed by a colon and the base64-encoded ciphertext, eg. "v2:int:...", or "v2:map:..." for a whole mapping.
+type EncryptedValue string
+
+// Get the value holding a ciphertext of a string plaintext.
+func NewEncryptedValue(ciphertext []byte) EncryptedValue {
+	return EncryptedValue(base64.StdEncoding.EncodeToString(ciphertext))
+}
+
+// Get the value holding a ciphertext of a plaintext with the given yaml tag, eg. "!!int". Plaintexts with any tag not in typeNames are strings.
+func NewTypedEncryptedValue(ciphertext []byte, tag string) EncryptedValue {
+	name, ok := typeNames[tag]
+	if !ok {
+		return NewEncryptedValue(ciphertext)
+	}
+	return EncryptedValue(fmt.Sprintf("v2:%s:%s", name, base64.StdEncoding.EncodeToString(ciphertext)))
+}
+
+// Get the version of the value's layout.
+func (v EncryptedValue) Version() int {
+	match := versionPrefix.FindStringSubmatch(string(v))
+	if match == nil {
+		return 1
+	}
+	version, err := strconv.Atoi(match[1])
+	if err != nil {
+		// too many digits to be a version anyone has written
+		return -1
+	}
+	return version
+}
+
+// Get the yaml tag of the value's plaintext, eg. "!!int".
+func (v EncryptedValue) Tag() (string, error) {
+	switch v.Version() {
+	case 1:
+		return "!!str", nil
+	case 2:
+		name, _, err := v.splitType()
+		if err != nil {
+			return "", err
+		}
+		for tag, n := range typeNames {
+			if n == name {
+				return tag, nil
+			}
+		}
+		return "", fmt.Errorf("Encrypted value has unknown type %s", name)
+	default:
+		return "", v.unknownVersion()
+	}
+}
+
+// Get the ciphertext held in the value, according to the version of its layout.
+func (v EncryptedValue) Ciphertext() ([]byte, error) {
+	switch v.Version() {
+	case 1:
+		return base64.StdEncoding.DecodeString(versionPrefix.ReplaceAllString(string(v), ""))
+	case 2:
+		_, encoded, err := v.splitType()
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	default:
+		return nil, v.unknownVersion()
+	}
+}
+
+// Get a value holding a different ciphertext of the same type of plaintext, in the latest layout for that type.
+func (v EncryptedValue) WithCiphertext(ciphertext []byte) (EncryptedValue, error) {
+	tag, err := v.Tag()
+	if err != nil {
+		return "", err
+	}
+	return NewTypedEncryptedValue(ciphertext, tag), nil
+}
+
+// Split a version 2 value into the name of its type, and its encoded ciphertext.
+func (v EncryptedValue) splitType() (string, string, error) {
+	parts := strings.SplitN(versionPrefix.ReplaceAllString(string(v), ""), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Encrypted value is missing its type")
+	}
+	return parts[0], parts[1], nil
+}
+
+func (v EncryptedValue) unknownVersion() error {
+	return fmt.Errorf("Encrypted value has layout version %s, but this version of yaml-crypt only understands up to version %d; try upgrading yaml-crypt", versionPrefix.FindStringSubmatch(string(v))[1], EncryptedValueVersion)
+}
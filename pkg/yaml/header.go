@@ -0,0 +1,121 @@
+package yaml
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+// Latest version of the format of encrypted files.
+const FormatVersion = 1
+
+// Start of the comment holding an encrypted file's header.
+const headerPrefix = "# yaml-crypt:"
+
+// Describes how an encrypted file was written, so that a version of yaml-crypt that can't read it can say so up front, rather than failing on its first value. Kept as the first line of the document's head comment, eg. "# yaml-crypt: format=1 provider=google algorithm=google-kms". Fields that aren't understood are ignored, so that later versions can add more.
+type Header struct {
+	// Version of the file's format.
+	Format int
+	// Names of the kinds of provider the file's values were encrypted with, separated by commas, eg. "google" or "google,passphrase". Empty if unknown.
+	Provider string
+	// Algorithms those providers encrypt with, in the same order, eg. "aes-256-gcm". Empty if unknown.
+	Algorithm string
+	// Name of the configured provider that every value in the file is encrypted with, overriding the default provider and providerPaths, eg. "ops". Unlike the rest of the header, it's declared in the decrypted version, eg. "# yaml-crypt: recipients=ops", and kept in both versions. Empty means the file has no recipients of its own.
+	Recipients string
+}
+
+// Get the header for a file encrypted by this version of yaml-crypt.
+func NewHeader(provider, algorithm string) Header {
+	return Header{Format: FormatVersion, Provider: provider, Algorithm: algorithm}
+}
+
+func (h Header) String() string {
+	out := headerPrefix
+	if h.Format != 0 {
+		out += fmt.Sprintf(" format=%d", h.Format)
+	}
+	if h.Provider != "" {
+		out += " provider=" + h.Provider
+	}
+	if h.Algorithm != "" {
+		out += " algorithm=" + h.Algorithm
+	}
+	if h.Recipients != "" {
+		out += " recipients=" + h.Recipients
+	}
+	return out
+}
+
+// Check that the file's format is one this version of yaml-crypt understands.
+func (h Header) Check() error {
+	if h.Format > FormatVersion {
+		return fmt.Errorf("File was written by a newer yaml-crypt, in format version %d, but this version only understands up to version %d; try upgrading yaml-crypt", h.Format, FormatVersion)
+	}
+	return nil
+}
+
+// Get the header of an encrypted document, and whether it has one. Files encrypted before headers were added don't.
+func GetHeader(node *yaml.Node) (Header, bool, error) {
+	header, ok, err := GetDecryptedHeader(node)
+	if err == nil && ok && header.Format == 0 {
+		return Header{}, true, fmt.Errorf("yaml-crypt header %q is missing its format version", strings.SplitN(node.HeadComment, "\n", 2)[0])
+	}
+	return header, ok, err
+}
+
+// Get the header of a decrypted document, and whether it has one. Unlike an encrypted document's, it has no format version, and only declares its recipients, if any.
+func GetDecryptedHeader(node *yaml.Node) (Header, bool, error) {
+	line := strings.SplitN(node.HeadComment, "\n", 2)[0]
+	if node.Kind != yaml.DocumentNode || !strings.HasPrefix(line, headerPrefix) {
+		return Header{}, false, nil
+	}
+	header := Header{}
+	for _, field := range strings.Fields(strings.TrimPrefix(line, headerPrefix)) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return Header{}, true, fmt.Errorf("Invalid yaml-crypt header %q", line)
+		}
+		switch parts[0] {
+		case "format":
+			format, err := strconv.Atoi(parts[1])
+			if err != nil || format < 1 {
+				return Header{}, true, fmt.Errorf("Invalid format version %q in yaml-crypt header", parts[1])
+			}
+			header.Format = format
+		case "provider":
+			header.Provider = parts[1]
+		case "algorithm":
+			header.Algorithm = parts[1]
+		case "recipients":
+			header.Recipients = parts[1]
+		}
+	}
+	return header, true, nil
+}
+
+// Set the header of a document, replacing any it already has. The rest of the document's head comment is kept, after a blank line.
+func SetHeader(node *yaml.Node, header Header) {
+	if node.Kind != yaml.DocumentNode {
+		return
+	}
+	RemoveHeader(node)
+	if node.HeadComment == "" {
+		node.HeadComment = header.String()
+	} else {
+		node.HeadComment = header.String() + "\n\n" + node.HeadComment
+	}
+}
+
+// Remove a document's header, if it has one, eg. so that it isn't written out to its decrypted version.
+func RemoveHeader(node *yaml.Node) {
+	lines := strings.SplitN(node.HeadComment, "\n", 2)
+	if node.Kind != yaml.DocumentNode || !strings.HasPrefix(lines[0], headerPrefix) {
+		return
+	}
+	if len(lines) == 1 {
+		node.HeadComment = ""
+	} else {
+		node.HeadComment = strings.TrimLeft(lines[1], "\n")
+	}
+}
@@ -1,12 +1,17 @@
 package yaml
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
 
+// Final path segment used to refer to a mapping key itself, rather than its value.
+const keySegment = "<key>"
+
 type Path struct {
 	isInt  bool
+	isKey  bool
 	i      int
 	s      string
 	parent *Path
@@ -32,13 +37,20 @@ func (p *Path) AddString(val string) *Path {
 	return &newPath
 }
 
+// Get the path of a mapping key, as opposed to its value. p must be the path of the mapping entry.
+func (p *Path) AddKey() *Path {
+	return &Path{isKey: true, parent: p}
+}
+
 func (p *Path) String() string {
 	if p == nil {
 		return ""
 	}
 	var out []string
 	for entry := p; entry.parent != nil; entry = entry.parent {
-		if entry.isInt {
+		if entry.isKey {
+			out = append([]string{keySegment}, out...)
+		} else if entry.isInt {
 			out = append([]string{strconv.Itoa(entry.i)}, out...)
 		} else {
 			out = append([]string{strconv.Quote(entry.s)}, out...)
@@ -46,3 +58,141 @@ func (p *Path) String() string {
 	}
 	return strings.Join(out, ".")
 }
+
+// Split a user-supplied dotted path (eg. "a.b.0.c") into its segments. A segment can be written as a double-quoted string, eg. `labels."app.kubernetes.io/name"`, to include dots, or to match a key literally named "*".
+func SplitPath(path string) ([]string, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(segments))
+	for i, segment := range segments {
+		out[i] = segment.value
+	}
+	return out, nil
+}
+
+// Join segments into a dotted path, as accepted by SplitPath, quoting any that need it.
+func JoinPath(segments []string) string {
+	out := make([]string, len(segments))
+	for i, segment := range segments {
+		out[i] = quoteSegment(segment)
+	}
+	return strings.Join(out, ".")
+}
+
+type pathSegment struct {
+	value  string
+	quoted bool
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	segments := []pathSegment{}
+	if path == "" {
+		return segments, nil
+	}
+	for i := 0; ; i++ {
+		var segment pathSegment
+		if i < len(path) && path[i] == '"' {
+			end := i + 1
+			for ; end < len(path) && path[end] != '"'; end++ {
+				if path[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(path) {
+				return nil, fmt.Errorf("Unterminated quoted segment in path %s", path)
+			}
+			value, err := strconv.Unquote(path[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid quoted segment in path %s: %w", path, err)
+			}
+			segment = pathSegment{value: value, quoted: true}
+			i = end + 1
+			if i < len(path) && path[i] != '.' {
+				return nil, fmt.Errorf("Quoted segment in path %s must be followed by a dot", path)
+			}
+		} else {
+			end := strings.IndexByte(path[i:], '.')
+			if end < 0 {
+				end = len(path) - i
+			}
+			segment = pathSegment{value: path[i : i+end]}
+			i += end
+		}
+		segments = append(segments, segment)
+		if i >= len(path) {
+			return segments, nil
+		}
+	}
+}
+
+// Quote a mapping key for a dotted path if it would otherwise be read as something else.
+func quoteSegment(s string) string {
+	if s == "" || s == "*" || s == keySegment || strings.HasPrefix(s, `"`) || strings.Contains(s, ".") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Format the path as a dotted path, as accepted by SplitPath, omitting the leading document index.
+func (p *Path) Dotted() string {
+	if p == nil {
+		return ""
+	}
+	var out []string
+	for entry := p; entry.parent != nil; entry = entry.parent {
+		if entry.isKey {
+			out = append([]string{keySegment}, out...)
+		} else if entry.isInt {
+			out = append([]string{strconv.Itoa(entry.i)}, out...)
+		} else {
+			out = append([]string{quoteSegment(entry.s)}, out...)
+		}
+	}
+	if len(out) > 0 {
+		out = out[1:]
+	}
+	return strings.Join(out, ".")
+}
+
+// Check whether a dotted path matches a dotted path pattern, in which an unquoted "*" segment matches any single segment. Invalid paths match nothing.
+func MatchPath(pattern, path string) bool {
+	patternSegments, err := parsePath(pattern)
+	if err != nil {
+		return false
+	}
+	pathSegments, err := parsePath(path)
+	if err != nil || len(patternSegments) != len(pathSegments) {
+		return false
+	}
+	for i, segment := range patternSegments {
+		if !(segment.value == "*" && !segment.quoted) && segment.value != pathSegments[i].value {
+			return false
+		}
+	}
+	return true
+}
+
+// Check whether a dotted path matches any of the given patterns.
+func MatchAnyPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if MatchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the path refers to a mapping key itself, rather than a value.
+func (p *Path) IsKey() bool {
+	return p != nil && p.isKey
+}
+
+// Get the mapping key that the path ends with, if any.
+func (p *Path) LastKey() (string, bool) {
+	if p == nil || p.parent == nil || p.isKey || p.isInt {
+		return "", false
+	}
+	return p.s, true
+}
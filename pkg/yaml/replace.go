@@ -0,0 +1,72 @@
+package yaml
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A file being written in place of an existing one. It's written to a temporary file alongside it, which only replaces it once it's been written in full and closed, so that the file is never left half written, eg. if writing fails partway through, or the process is killed.
+type ReplacementFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// Start writing a file to replace the one at the given path, with the given permissions, or with the file's own if perm is zero. A symlink is followed, so that the file it points to is replaced, rather than the symlink itself.
+func ReplaceFile(path string, perm os.FileMode) (*ReplacementFile, error) {
+	path, err := RealPath(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	// the temporary file has to be on the same filesystem to be renamed over the file, so keep it in the same directory
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	if perm == 0 {
+		perm = info.Mode().Perm()
+	}
+	err = f.Chmod(perm)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &ReplacementFile{File: f, path: path}, nil
+}
+
+// Finish writing the file, replacing the original with it. Only the first call has any effect, as with Abort.
+func (f *ReplacementFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	err := f.File.Sync()
+	if err == nil {
+		err = f.File.Close()
+	} else {
+		f.File.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// Give up writing the file, leaving the original as it was.
+func (f *ReplacementFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
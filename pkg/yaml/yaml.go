@@ -1,20 +1,60 @@
 package yaml
 
 import (
-	"encoding/base64"
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
-	"github.com/farmersedgeinc/yaml-crypt/pkg/cache"
 	"gopkg.in/yaml.v3"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
 	EncryptedTag = "!encrypted"
 	DecryptedTag = "!secret"
+	// Marks a value as managed by something other than yaml-crypt, when found in its line comment.
+	ManagedMarker = "yamlcrypt:managed"
+	// Records who last changed a secret's value, when found at the end of its line comment, followed by their identity, eg. "# yamlcrypt:rotatedBy=alice@example.com".
+	RotatedByMarker = "yamlcrypt:rotatedBy="
 )
 
+// Local tags that can mark encrypted values: a single "!" followed by a name.
+var localTag = regexp.MustCompile(`^![A-Za-z0-9][A-Za-z0-9_.:/-]*$`)
+
+// Check that a tag can mark encrypted values in place of EncryptedTag: it must be a local tag, eg. "!vault", other than the tag of decrypted values.
+func CheckEncryptedTag(tag string) error {
+	if !localTag.MatchString(tag) {
+		return fmt.Errorf("%q is not a local tag, like \"!vault\"", tag)
+	}
+	if tag == DecryptedTag {
+		return fmt.Errorf("%s already marks decrypted values", tag)
+	}
+	return nil
+}
+
+// Swap two tags throughout a yaml Node, so that files marking their encrypted values with another tag, eg. "!vault", can be handled as if they used EncryptedTag once read, and written back out with their own tag. Swapping, rather than just replacing one with the other, keeps any values already tagged with the other tag apart; swapping them again restores the Node.
+func SwapTags(node *yaml.Node, a, b string) {
+	if a == b || a == "" || b == "" {
+		return
+	}
+	switch node.Tag {
+	case a:
+		node.Tag = b
+	case b:
+		node.Tag = a
+	}
+	for _, child := range node.Content {
+		SwapTags(child, a, b)
+	}
+}
+
 // these relations need to be stored to produce "paths" for encrypted values, which is needed for encrypted item reuse
 type nodeNode struct {
 	YamlNode *yaml.Node
@@ -26,33 +66,44 @@ func recursiveNodeIter(node *yaml.Node) <-chan *nodeNode {
 	go func() {
 		defer close(out)
 
-		var recurse func(*yaml.Node, *nodeNode, int)
-		recurse = func(node *yaml.Node, parent *nodeNode, index int) {
-			var path *Path
-			if parent != nil {
-				if parent.YamlNode.Kind == yaml.MappingNode {
-					if index > 0 && index%2 == 1 {
-						path = parent.Path.AddString(parent.YamlNode.Content[index-1].Value)
-					}
-				} else {
-					path = parent.Path.AddInt(index)
-				}
-			} else {
-				path = &Path{isInt: true, i: index}
-			}
+		var recurse func(*yaml.Node, *Path)
+		recurse = func(node *yaml.Node, path *Path) {
 			current := &nodeNode{YamlNode: node, Path: path}
-
+			// the consumer may replace the node's contents once it's yielded, eg. encrypting a !secret mapping as a whole, so its children, and their paths, are taken from before then
+			children := node.Content
+			paths := make([]*Path, len(children))
+			for index := range children {
+				paths[index] = childPath(node, path, index)
+			}
 			out <- current
-			parent = current
-			for index, childNode := range node.Content {
-				recurse(childNode, current, index)
+			for index, childNode := range children {
+				recurse(childNode, paths[index])
 			}
 		}
-		recurse(node, nil, 0)
+		recurse(node, &Path{isInt: true, i: 0})
 	}()
 	return out
 }
 
+// Get the path of a Node's child at the given index in its contents.
+func childPath(node *yaml.Node, path *Path, index int) *Path {
+	if node.Kind != yaml.MappingNode {
+		return path.AddInt(index)
+	}
+	var childPath *Path
+	key := node.Content[index-index%2]
+	if key.Tag == EncryptedTag || key.Tag == DecryptedTag {
+		// an encrypted key's value differs between the encrypted and decrypted files, so identify the entry by its position instead
+		childPath = path.AddInt(index / 2)
+	} else {
+		childPath = path.AddString(key.Value)
+	}
+	if index%2 == 0 {
+		childPath = childPath.AddKey()
+	}
+	return childPath
+}
+
 // A Channel-based iterator that yields all descendents of a yaml Node that match a given tag.
 func GetTaggedChildren(node *yaml.Node, tag string) <-chan *nodeNode {
 	out := make(chan *nodeNode)
@@ -88,34 +139,299 @@ func ReadFile(path string) (node yaml.Node, err error) {
 	if err != nil {
 		return
 	}
-	err = yaml.NewDecoder(f).Decode(&node)
+	return Read(f)
+}
+
+// Read a yaml document from a Reader, and return its root yaml Node. A %YAML directive at the start of the document is kept in the Value of the document Node, so that it's written back out along with the document.
+func Read(r io.Reader) (node yaml.Node, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	directive, data := splitDirective(data)
+	err = yaml.NewDecoder(bytes.NewReader(data)).Decode(&node)
+	if err == nil && node.Kind == yaml.DocumentNode {
+		node.Value = directive
+	}
 	return
 }
 
-// Save a yaml Node to a file.
-func SaveFile(path string, node yaml.Node) error {
-	var w io.Writer
-	var err error
+// Check that no mapping in a yaml Node has the same key twice. yaml-crypt would otherwise keep both, and whichever a consumer reads wins, so a secret can silently disappear. Keys are compared by value, ignoring tags, since a !secret key and a plain key with the same value are the same key once decrypted.
+func CheckDuplicateKeys(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		seen := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Kind != yaml.ScalarNode {
+				continue
+			}
+			if first, ok := seen[key.Value]; ok {
+				return fmt.Errorf("Duplicate key %q at line %d, first defined at line %d", key.Value, key.Line, first.Line)
+			}
+			seen[key.Value] = key
+		}
+	}
+	for _, child := range node.Content {
+		err := CheckDuplicateKeys(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Line endings that files can be written with.
+const (
+	LF   = "\n"
+	CRLF = "\r\n"
+)
+
+// Detect the line ending a file uses, from its first line. Files that don't exist or don't contain a line break are taken to use LF.
+func DetectLineEnding(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return LF, nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return LineEndingOf([]byte(line)), nil
+}
+
+// Detect the line ending yaml data uses, from its first line. Data without a line break is taken to use LF.
+func LineEndingOf(data []byte) string {
+	end := bytes.IndexByte(data, '\n')
+	if end > 0 && data[end-1] == '\r' {
+		return CRLF
+	}
+	return LF
+}
+
+// Styles that a whole document can be forced into when it's saved.
+const (
+	BlockStyle = "block"
+	FlowStyle  = "flow"
+)
+
+// How a yaml Node is written out by SaveFile.
+type SaveOptions struct {
+	// Line ending to write with. Empty means LF.
+	LineEnding string
+	// Style to force every mapping and sequence into, overriding their own styles. Empty means keep their own styles.
+	Style string
+	// Whether to sort the keys of every mapping, for canonical output. Sequences keep their order.
+	SortKeys bool
+	// Tag to write encrypted values under, in place of EncryptedTag. Empty means EncryptedTag.
+	EncryptedTag string
+}
+
+// Save a yaml Node to a file. An empty path means stdout.
+func SaveFile(path string, node yaml.Node, options SaveOptions) error {
 	if path == "" {
-		w = os.Stdout
-	} else {
-		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+		return WriteWithOptions(os.Stdout, node, options)
+	}
+	f, err := CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = WriteWithOptions(f, node, options)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Write a yaml Node to a Writer, as SaveFile would write it to a file.
+func WriteWithOptions(w io.Writer, node yaml.Node, options SaveOptions) error {
+	// the node's children are shared with the caller's, so they're swapped back once written
+	SwapTags(&node, EncryptedTag, options.EncryptedTag)
+	defer SwapTags(&node, EncryptedTag, options.EncryptedTag)
+	forceStyle(&node, options.Style)
+	if options.SortKeys {
+		sortKeys(&node)
+		err := checkAliases(&node, map[string]bool{})
 		if err != nil {
 			return err
 		}
-		err = f.Truncate(0)
+	}
+	return writeWithLineEnding(w, node, options.LineEnding)
+}
+
+// Open a file for writing a yaml document to, creating it if it doesn't exist and truncating it if it does. A symlink is followed, rather than being replaced with a regular file.
+func CreateFile(path string) (*os.File, error) {
+	path, err := RealPath(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Force every mapping and sequence in a Node into the given style. An empty style leaves them as they are.
+func forceStyle(node *yaml.Node, style string) {
+	if style == "" {
+		return
+	}
+	for n := range recursiveNodeIter(node) {
+		if n.YamlNode.Kind != yaml.MappingNode && n.YamlNode.Kind != yaml.SequenceNode {
+			continue
+		}
+		if style == FlowStyle {
+			n.YamlNode.Style |= yaml.FlowStyle
+		} else {
+			n.YamlNode.Style &^= yaml.FlowStyle
+		}
+	}
+}
+
+// Sort the keys of every mapping in a Node, keeping each key's value and comments with it. Mappings with a key that isn't a scalar are left in their own order, as are sequences.
+func sortKeys(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode && len(node.Content) > 2 {
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		scalar := true
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+			scalar = scalar && node.Content[i].Kind == yaml.ScalarNode
+		}
+		if scalar {
+			sort.SliceStable(pairs, func(i, j int) bool {
+				return pairs[i][0].Value < pairs[j][0].Value
+			})
+			for i, pair := range pairs {
+				node.Content[2*i], node.Content[2*i+1] = pair[0], pair[1]
+			}
+		}
+	}
+	for _, child := range node.Content {
+		sortKeys(child)
+	}
+}
+
+// Check that every alias in a Node comes after the anchor it refers to, which sorting keys can break, given the anchors already passed.
+func checkAliases(node *yaml.Node, anchors map[string]bool) error {
+	if node.Anchor != "" {
+		anchors[node.Anchor] = true
+	}
+	if node.Kind == yaml.AliasNode {
+		if !anchors[node.Value] {
+			return fmt.Errorf("Can't sort keys: alias *%s would come before its anchor &%s", node.Value, node.Value)
+		}
+		return nil
+	}
+	for _, child := range node.Content {
+		err := checkAliases(child, anchors)
 		if err != nil {
 			return err
 		}
-		w = f
+	}
+	return nil
+}
+
+func writeWithLineEnding(w io.Writer, node yaml.Node, lineEnding string) error {
+	if lineEnding == CRLF {
+		w = crlfWriter{w}
+	}
+	return Write(w, node)
+}
+
+// Converts the LF line endings written to it to CRLF.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (w crlfWriter) Write(p []byte) (int, error) {
+	_, err := w.w.Write(bytes.ReplaceAll(p, []byte(LF), []byte(CRLF)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Get the path of the file that a path refers to, following any symlinks, so that writing a file doesn't replace a symlink with a regular file. Paths that don't exist yet are returned as-is, but symlinks to files that don't exist are an error.
+func RealPath(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return path, nil
+	} else if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s is a symlink to a file that doesn't exist", path)
+	}
+	return realPath, err
+}
+
+// Write a yaml Node to a Writer.
+func Write(w io.Writer, node yaml.Node) error {
+	clearMergeTags(&node)
+	err := writeDirective(w, &node)
+	if err != nil {
+		return err
 	}
 	e := yaml.NewEncoder(w)
 	e.SetIndent(2)
-	err = e.Encode(&node)
-	return err
+	return e.Encode(&node)
+}
+
+// Check that a Node reads back with the expected values after being written out, so that a value that can't be represented in yaml doesn't silently end up corrupted. Expected values are keyed by the Nodes holding them. Returns an error naming the path of the first value that doesn't survive.
+func CheckRoundTrip(node yaml.Node, expected map[*yaml.Node]string) error {
+	var b bytes.Buffer
+	err := Write(&b, node)
+	if err != nil {
+		return err
+	}
+	parsed, err := Read(&b)
+	if err != nil {
+		return fmt.Errorf("Output is not valid yaml: %w", err)
+	}
+	var original, reparsed []*nodeNode
+	for n := range recursiveNodeIter(&node) {
+		original = append(original, n)
+	}
+	for n := range recursiveNodeIter(&parsed) {
+		reparsed = append(reparsed, n)
+	}
+	if len(original) != len(reparsed) {
+		return errors.New("Output does not have the same structure when read back")
+	}
+	for i, n := range original {
+		value, ok := expected[n.YamlNode]
+		if !ok {
+			continue
+		}
+		var actual string
+		if isGroup(reparsed[i].YamlNode) {
+			actual, err = groupValue(reparsed[i].YamlNode)
+		} else if reparsed[i].YamlNode.Tag == DecryptedTag {
+			actual, err = GetValue(reparsed[i].YamlNode)
+		} else {
+			err = reparsed[i].YamlNode.Decode(&actual)
+		}
+		if err != nil || actual != value {
+			return fmt.Errorf("Value at path %s does not read back as the same value", n.Path.Dotted())
+		}
+	}
+	return nil
 }
 
-// Get the decoded value of an !encrypted or !secret Node, as a String. !encrypted Nodes are base64-decoded.
+// Get the decoded value of an !encrypted or !secret Node, as a String. The ciphertexts of !encrypted Nodes are decoded from their EncryptedValues. A !secret null, eg. "!secret ~", or "!secret" with nothing after it, has an empty value, so that it's never encrypted. A !secret mapping or sequence is encrypted as a whole, so its value is the yaml it serializes to.
 func GetValue(node *yaml.Node) (value string, err error) {
 	if node.Tag == EncryptedTag {
 		var encodedCiphertext string
@@ -124,9 +440,15 @@ func GetValue(node *yaml.Node) (value string, err error) {
 			return
 		}
 		var bytes []byte
-		bytes, err = base64.StdEncoding.DecodeString(encodedCiphertext)
+		bytes, err = EncryptedValue(encodedCiphertext).Ciphertext()
 		value = string(bytes)
 	} else if node.Tag == DecryptedTag {
+		if isGroup(node) {
+			return groupValue(node)
+		}
+		if plaintextTag(node) == "!!null" {
+			return "", nil
+		}
 		err = node.Decode(&value)
 	} else {
 		err = fmt.Errorf("Node must be tagged %s or %s", EncryptedTag, DecryptedTag)
@@ -134,8 +456,8 @@ func GetValue(node *yaml.Node) (value string, err error) {
 	return
 }
 
-// Turn a yaml Node tagged !encrypted into a yaml Node tagged !secret, by looking up its values in a give mapping of ciphertexts to plaintexts.
-func DecryptNode(node *yaml.Node, cache *cache.Cache, tag bool) error {
+// Turn a yaml Node tagged !encrypted into a yaml Node tagged !secret, by looking up its values in a given mapping of ciphertexts to plaintexts.
+func DecryptNode(node *yaml.Node, plaintexts map[string]string, tag bool) error {
 	// validate, read in data
 	if node.Tag != EncryptedTag {
 		return fmt.Errorf("Cannot decrypt a node not tagged %s", EncryptedTag)
@@ -145,19 +467,60 @@ func DecryptNode(node *yaml.Node, cache *cache.Cache, tag bool) error {
 	if err != nil {
 		return err
 	}
-	ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+	value := EncryptedValue(encodedCiphertext)
+	ciphertext, err := value.Ciphertext()
 	if err != nil {
 		return err
 	}
-	// decrypt
-	plaintext, ok, err := cache.Decrypt(ciphertext)
+	plaintextTag, err := value.Tag()
+	if err != nil {
+		return err
+	}
+	// decrypt. Nulls aren't encrypted.
+	var plaintext string
+	if plaintextTag == "!!null" {
+		plaintext = "null"
+	} else {
+		var ok bool
+		plaintext, ok = plaintexts[string(ciphertext)]
+		if !ok {
+			return errors.New("Ciphertext was not decrypted. This should never happen.")
+		}
+	}
+	return setPlaintext(node, plaintext, plaintextTag, tag)
+}
+
+// Rewrite a !secret Node the way decrypting it would write it back once it's encrypted, eg. requoting strings as needed and writing nulls out, so that it can be compared with a decrypted Node. Quoting isn't kept in encrypted values, only the value's type is.
+func NormalizeSecret(node *yaml.Node) error {
+	if node.Tag != DecryptedTag {
+		return fmt.Errorf("Cannot normalize a node not tagged %s", DecryptedTag)
+	}
+	plaintext, err := GetValue(node)
 	if err != nil {
 		return err
-	} else if !ok {
-		return errors.New("Ciphertext not found in cache. This should never happen.")
 	}
-	// replace the node contents
-	node.Encode(plaintext)
+	typeTag := plaintextTag(node)
+	if typeTag == "!!null" {
+		plaintext = "null"
+	}
+	return setPlaintext(node, plaintext, typeTag, true)
+}
+
+// Replace the contents of a Node with a decrypted plaintext of the given type, tagging it !secret if tag is set.
+func setPlaintext(node *yaml.Node, plaintext string, plaintextTag string, tag bool) error {
+	if plaintextTag == "!!map" || plaintextTag == "!!seq" {
+		return replaceGroup(node, plaintext, plaintextTag, tag)
+	}
+	// replace the node contents, restoring the block style the value was encrypted from
+	style := node.Style & blockStyles
+	replaceValue(node, plaintext)
+	if style != 0 {
+		node.Style = node.Style&^(blockStyles|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) | style
+	}
+	if resolvesTo(plaintext, plaintextTag) {
+		// leave typed values unquoted, so they keep their type
+		node.Style = 0
+	}
 	if tag {
 		node.Tag = DecryptedTag
 	} else {
@@ -166,24 +529,297 @@ func DecryptNode(node *yaml.Node, cache *cache.Cache, tag bool) error {
 	return nil
 }
 
-// Turn a yaml Node tagged !secret into a yaml Node tagged !encrypted, looking up its values in a given mapping of plaintexts to ciphertexts.
-func EncryptNode(node *yaml.Node, possibleCiphertext []byte, cache *cache.Cache) error {
-	// validate, read in data
+// Turn a yaml Node tagged !secret into a yaml Node tagged !encrypted, given the ciphertext of its value. Nulls aren't encrypted, so they're written with an empty ciphertext, whatever the one given.
+func EncryptNode(node *yaml.Node, ciphertext []byte) error {
+	// validate
 	if node.Tag != DecryptedTag {
 		return fmt.Errorf("Cannot encrypt a node not tagged %s", DecryptedTag)
 	}
-	var plaintext string
-	err := node.Decode(&plaintext)
-	if err != nil {
-		return err
+	if IsNull(node) {
+		ciphertext = nil
 	}
-	// encrypt
-	ciphertext, ok, err := cache.Encrypt(plaintext, possibleCiphertext)
-	if !ok {
-		return errors.New("Plaintext not found in cache. This should never happen.")
+	// replace the node contents. Block scalars keep their style, so it can be restored when decrypting, unless they're empty, since they decrypt to "" anyway.
+	typeTag, style := plaintextTag(node), node.Style&blockStyles
+	if node.Value == "" {
+		style = 0
 	}
-	// replace the node contents
-	node.Encode(base64.StdEncoding.EncodeToString([]byte(ciphertext)))
+	replaceValue(node, string(NewTypedEncryptedValue(ciphertext, typeTag)))
+	node.Style |= style
 	node.Tag = EncryptedTag
 	return nil
 }
+
+// Whether a !secret Node is null, eg. "!secret ~", which has nothing to hide, so isn't encrypted.
+func IsNull(node *yaml.Node) bool {
+	return !isGroup(node) && plaintextTag(node) == "!!null"
+}
+
+// Styles of block scalars, ie. "|" and ">".
+const blockStyles = yaml.LiteralStyle | yaml.FoldedStyle
+
+// Get the tag that a !secret Node's value would have without the !secret tag, eg. "!!int" for an unquoted number. Quoted values are always strings.
+func plaintextTag(node *yaml.Node) string {
+	if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|blockStyles) != 0 {
+		return "!!str"
+	}
+	untagged := *node
+	untagged.Tag = ""
+	return untagged.ShortTag()
+}
+
+// Whether an unquoted value resolves to the given non-string tag.
+func resolvesTo(value string, tag string) bool {
+	if tag == "!!str" {
+		return false
+	}
+	return plaintextTag(&yaml.Node{Kind: yaml.ScalarNode, Value: value}) == tag
+}
+
+// Whether a Node is a mapping or sequence, which is encrypted as a whole when it's tagged !secret, rather than value by value.
+func isGroup(node *yaml.Node) bool {
+	return node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode
+}
+
+// Serialize a mapping or sequence to the yaml that it's encrypted as. Its own tag, anchor and comments aren't part of it, since they're kept alongside its encrypted value, just like a scalar's.
+func groupValue(node *yaml.Node) (string, error) {
+	for _, child := range node.Content {
+		err := checkGroupMember(child)
+		if err != nil {
+			return "", err
+		}
+	}
+	group := *node
+	group.Tag = ""
+	group.Style &^= yaml.TaggedStyle
+	group.Anchor = ""
+	group.HeadComment, group.LineComment, group.FootComment = "", "", ""
+	var b strings.Builder
+	err := Write(&b, group)
+	return b.String(), err
+}
+
+// Check that a Node can be encrypted as part of a mapping or sequence: it can't be tagged to be encrypted or decrypted on its own, and it can't have an anchor or alias, which would refer across the boundary of the encrypted value.
+func checkGroupMember(node *yaml.Node) error {
+	if node.Tag == DecryptedTag || node.Tag == EncryptedTag {
+		return fmt.Errorf("Values inside a %s mapping or sequence can't be tagged %s, since it's encrypted as a whole", DecryptedTag, node.Tag)
+	}
+	if node.Anchor != "" || node.Kind == yaml.AliasNode {
+		return fmt.Errorf("Values inside a %s mapping or sequence can't have anchors or aliases", DecryptedTag)
+	}
+	for _, child := range node.Content {
+		err := checkGroupMember(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace the contents of an encrypted Node with the mapping or sequence serialized in its plaintext, keeping its anchor and comments.
+func replaceGroup(node *yaml.Node, plaintext string, plaintextTag string, tag bool) error {
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(plaintext), &document)
+	if err != nil {
+		return fmt.Errorf("Error reading decrypted %s: %w", plaintextTag, err)
+	}
+	if len(document.Content) != 1 || document.Content[0].ShortTag() != plaintextTag {
+		return fmt.Errorf("Value encrypted as a %s doesn't decrypt to one", plaintextTag)
+	}
+	group := document.Content[0]
+	node.Kind, node.Style, node.Value, node.Content = group.Kind, group.Style, "", group.Content
+	if tag {
+		node.Tag = DecryptedTag
+	} else {
+		node.Tag = ""
+	}
+	return nil
+}
+
+// Get the set of Nodes inside !secret mappings and sequences, which are encrypted along with them, rather than on their own.
+func groupMembers(node *yaml.Node) map[*yaml.Node]bool {
+	out := map[*yaml.Node]bool{}
+	var add func(*yaml.Node, bool)
+	add = func(node *yaml.Node, member bool) {
+		if member {
+			out[node] = true
+		}
+		member = member || (node.Tag == DecryptedTag && isGroup(node))
+		for _, child := range node.Content {
+			add(child, member)
+		}
+	}
+	add(node, false)
+	return out
+}
+
+// Find the descendent of a yaml Node at the given dotted path. Mapping keys are matched by value, and sequence items are matched by index.
+func GetNodeAtPath(node *yaml.Node, path string) (*yaml.Node, error) {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	segments, err := SplitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		var child *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					child = node.Content[i+1]
+				}
+			}
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(segment)
+			if err == nil && index >= 0 && index < len(node.Content) {
+				child = node.Content[index]
+			}
+		}
+		if child == nil {
+			return nil, fmt.Errorf("No value found at path %s", strconv.Quote(path))
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// Get the dotted paths of all descendents of a yaml Node that match a given tag, in document order.
+func GetTaggedChildrenPaths(node *yaml.Node, tag string) []string {
+	out := []string{}
+	for n := range GetTaggedChildren(node, tag) {
+		out = append(out, n.Path.Dotted())
+	}
+	return out
+}
+
+// Split a document into a list of documents, each holding one of the original document's top-level mapping entries, so that they can be processed and written out one at a time. Writing out all of the returned documents in order produces the same output as writing out the original document. Documents that aren't a block-style mapping can't be split, and are returned as-is.
+func SplitTopLevel(node *yaml.Node) []*yaml.Node {
+	if node.Kind != yaml.DocumentNode || len(node.Content) != 1 {
+		return []*yaml.Node{node}
+	}
+	root := node.Content[0]
+	if root.Kind != yaml.MappingNode || root.Style&yaml.FlowStyle != 0 || len(root.Content) < 2 {
+		return []*yaml.Node{node}
+	}
+	out := make([]*yaml.Node, 0, len(root.Content)/2)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		mapping := *root
+		mapping.Content = root.Content[i : i+2]
+		if i > 0 {
+			mapping.HeadComment = ""
+			mapping.Anchor = ""
+			mapping.Tag = ""
+			mapping.Style &^= yaml.TaggedStyle
+		}
+		if i+2 < len(root.Content) {
+			mapping.FootComment = ""
+		}
+		document := *node
+		document.Content = []*yaml.Node{&mapping}
+		if i > 0 {
+			document.HeadComment = ""
+			document.Value = ""
+		}
+		if i+2 < len(root.Content) {
+			document.FootComment = ""
+		}
+		out = append(out, &document)
+	}
+	return out
+}
+
+// Tag all scalar values in a yaml Node whose dotted paths match any of the given patterns, unless they're already encrypted, are inside a !secret mapping or sequence, or their paths also match one of the except patterns.
+func TagMatchingPaths(node *yaml.Node, patterns []string, except []string, tag string) {
+	if len(patterns) == 0 {
+		return
+	}
+	members := groupMembers(node)
+	for n := range recursiveNodeIter(node) {
+		if n.YamlNode.Kind != yaml.ScalarNode || n.YamlNode.Tag == EncryptedTag || n.Path.isKey || members[n.YamlNode] {
+			continue
+		}
+		path := n.Path.Dotted()
+		if MatchAnyPath(patterns, path) && !MatchAnyPath(except, path) {
+			n.YamlNode.Tag = tag
+		}
+	}
+}
+
+// Set the tag of every scalar value in a yaml Node, except for null values, which have nothing to hide, those inside a !secret mapping or sequence, which is encrypted as a whole, and those whose dotted paths match any of the except patterns.
+func TagAllPaths(node *yaml.Node, except []string, tag string) {
+	members := groupMembers(node)
+	for n := range recursiveNodeIter(node) {
+		if n.YamlNode.Kind != yaml.ScalarNode || n.YamlNode.Tag == EncryptedTag || n.YamlNode.Tag == "!!null" || n.Path.isKey || members[n.YamlNode] {
+			continue
+		}
+		if !MatchAnyPath(except, n.Path.Dotted()) {
+			n.YamlNode.Tag = tag
+		}
+	}
+}
+
+// Get the dotted paths of all unencrypted scalar values in a yaml Node whose mapping keys match the given pattern. Values inside a !secret mapping or sequence will be encrypted along with it.
+func GetPlaintextPathsMatchingKey(node *yaml.Node, pattern *regexp.Regexp) []string {
+	out := []string{}
+	members := groupMembers(node)
+	for n := range recursiveNodeIter(node) {
+		if n.YamlNode.Kind != yaml.ScalarNode || n.YamlNode.Tag == EncryptedTag || members[n.YamlNode] {
+			continue
+		}
+		if key, ok := n.Path.LastKey(); ok && pattern.MatchString(key) {
+			out = append(out, n.Path.Dotted())
+		}
+	}
+	return out
+}
+
+// Replace the value of a scalar Node, keeping its anchor and comments, which Node.Encode would otherwise discard.
+func replaceValue(node *yaml.Node, value string) {
+	anchor := node.Anchor
+	headComment, lineComment, footComment := node.HeadComment, node.LineComment, node.FootComment
+	node.Encode(value)
+	node.Anchor = anchor
+	node.HeadComment, node.LineComment, node.FootComment = headComment, lineComment, footComment
+}
+
+// Clear the explicit !!merge tag from merge keys, which would otherwise be written out as "!!merge <<". The tag is implied by the "<<" key anyways.
+func clearMergeTags(node *yaml.Node) {
+	for n := range recursiveNodeIter(node) {
+		if n.YamlNode.Kind == yaml.ScalarNode && n.YamlNode.Tag == "!!merge" && n.YamlNode.Value == "<<" {
+			n.YamlNode.Tag = ""
+		}
+	}
+}
+
+// Get who a Node's line comment records as having last changed its value, or an empty string if it doesn't record anyone.
+func GetRotatedBy(node *yaml.Node) string {
+	i := strings.Index(node.LineComment, RotatedByMarker)
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(node.LineComment[i+len(RotatedByMarker):])
+}
+
+// Record in a Node's line comment who last changed its value, replacing anyone it recorded before, and keeping the rest of the comment. An empty identity just removes the record.
+func SetRotatedBy(node *yaml.Node, identity string) {
+	comment := node.LineComment
+	if i := strings.Index(comment, RotatedByMarker); i >= 0 {
+		comment = strings.TrimRight(comment[:i], " ")
+	}
+	if identity != "" {
+		if comment == "" {
+			comment = "#"
+		}
+		comment += " " + RotatedByMarker + identity
+	}
+	if comment == "#" {
+		comment = ""
+	}
+	node.LineComment = comment
+}
+
+// Whether a Node's line comment marks it as managed by something other than yaml-crypt, eg. "# yamlcrypt:managed".
+func IsManaged(node *yaml.Node) bool {
+	return strings.Contains(node.LineComment, ManagedMarker)
+}